@@ -174,6 +174,18 @@ const Proposals = () => {
     }
   };
 
+  const getPolicyColor = (policy) => {
+    switch (policy) {
+      case 'BLOCKLIST':
+      case 'SILENT_BLOCKLIST':
+        return 'error';
+      case 'REMOVE':
+        return 'default';
+      default:
+        return 'success';
+    }
+  };
+
   const getStatusColor = (status) => {
     switch (status) {
       case 'PENDING':
@@ -231,7 +243,7 @@ const Proposals = () => {
                         <Chip
                           label={proposal.proposed_policy}
                           size="small"
-                          color={proposal.proposed_policy === 'BLOCKLIST' ? 'error' : 'success'}
+                          color={getPolicyColor(proposal.proposed_policy)}
                           sx={{ mr: 1 }}
                         />
                         <Chip label={proposal.status} size="small" color={getStatusColor(proposal.status)} />
@@ -351,7 +363,10 @@ const Proposals = () => {
             margin="normal"
           >
             <MenuItem value="ALLOWLIST">Allowlist</MenuItem>
+            <MenuItem value="ALLOWLIST_COMPILER">Allowlist (compiler)</MenuItem>
             <MenuItem value="BLOCKLIST">Blocklist</MenuItem>
+            <MenuItem value="SILENT_BLOCKLIST">Blocklist (silent)</MenuItem>
+            <MenuItem value="REMOVE">Remove</MenuItem>
           </TextField>
           <TextField
             fullWidth
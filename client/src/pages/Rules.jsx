@@ -78,6 +78,18 @@ const Rules = () => {
     }
   };
 
+  const getPolicyColor = (policy) => {
+    switch (policy) {
+      case 'BLOCKLIST':
+      case 'SILENT_BLOCKLIST':
+        return 'error';
+      case 'REMOVE':
+        return 'default';
+      default:
+        return 'success';
+    }
+  };
+
   return (
     <Box>
       <Box sx={{ display: 'flex', justifyContent: 'space-between', alignItems: 'center', mb: 3 }}>
@@ -115,7 +127,7 @@ const Rules = () => {
                         <Chip
                           label={rule.policy}
                           size="small"
-                          color={rule.policy === 'BLOCKLIST' ? 'error' : 'success'}
+                          color={getPolicyColor(rule.policy)}
                         />
                       </Box>
                       {rule.comment && (
@@ -178,7 +190,10 @@ const Rules = () => {
             margin="normal"
           >
             <MenuItem value="ALLOWLIST">Allowlist</MenuItem>
+            <MenuItem value="ALLOWLIST_COMPILER">Allowlist (compiler)</MenuItem>
             <MenuItem value="BLOCKLIST">Blocklist</MenuItem>
+            <MenuItem value="SILENT_BLOCKLIST">Blocklist (silent)</MenuItem>
+            <MenuItem value="REMOVE">Remove</MenuItem>
           </TextField>
           <TextField
             fullWidth
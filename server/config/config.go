@@ -28,6 +28,74 @@ type Config struct {
 	SyncBaseURL   string
 	ServerPort    string
 
+	// AutoProposeBlocked opens or joins an access request proposal for
+	// every blocked execution reported through event upload
+	AutoProposeBlocked bool
+
+	// GitOps rules directory; empty disables it
+	RulesDir             string
+	RulesDirPollInterval time.Duration
+
+	// Staged rule rollouts: how often holds and halt thresholds are
+	// checked, and the default number of canary blocks that halts a stage
+	RolloutCheckInterval time.Duration
+	RolloutHaltThreshold int
+
+	// Distinct binaries a Team ID or certificate allowlist must cover before
+	// the ruleset linter reports it as overly broad
+	LintBroadThreshold int
+
+	// How often threat feeds are checked for a due run
+	FeedCheckInterval time.Duration
+
+	// Binary reputation lookups: the provider ("http", "file" or empty for
+	// none), its settings, how long results are cached and the delay between
+	// background lookups
+	ReputationProvider       string
+	ReputationURL            string
+	ReputationAPIKey         string
+	ReputationAPIKeyHeader   string
+	ReputationFile           string
+	ReputationCacheTTL       time.Duration
+	ReputationLookupInterval time.Duration
+
+	// Retention: how often old data is pruned, how many rows each batch
+	// deletes and how long to pause between batches so sync writes get the
+	// database, and per category the age in days and the rows per table to
+	// keep (zero keeps everything)
+	RetentionInterval           time.Duration
+	RetentionBatchSize          int
+	RetentionBatchPause         time.Duration
+	EventRetentionDays          int
+	EventRetentionMaxRows       int
+	SyncHistoryRetentionDays    int
+	SyncHistoryRetentionMaxRows int
+	AuditRetentionDays          int
+	AuditRetentionMaxRows       int
+
+	// Cold archive of events before retention prunes them: the store
+	// ("filesystem", "s3" or empty for none), its settings, how many days
+	// archive objects are kept (zero keeps them) and how many days restored
+	// events stay in the database
+	ArchiveStore         string
+	ArchiveDir           string
+	ArchiveS3Endpoint    string
+	ArchiveS3Bucket      string
+	ArchiveS3Region      string
+	ArchiveS3AccessKey   string
+	ArchiveS3SecretKey   string
+	ArchiveS3Prefix      string
+	ArchiveS3PathStyle   bool
+	ArchiveRetentionDays int
+	ArchiveRestoreDays   int
+
+	// Vacuuming: switch the database to incremental auto-vacuum and return
+	// free pages after each pruning run (opt-in, as switching an existing
+	// database rewrites it at startup), and how often a full VACUUM runs
+	// (zero never)
+	IncrementalVacuum bool
+	VacuumInterval    time.Duration
+
 	// Database Configuration
 	DatabasePath string
 }
@@ -57,6 +125,51 @@ func Load() *Config {
 		SyncBaseURL:   getEnv("SYNC_BASE_URL", "http://localhost:8080"),
 		ServerPort:    getEnv("SERVER_PORT", "8080"),
 
+		AutoProposeBlocked: parseBool(getEnv("AUTO_PROPOSE_BLOCKED", "true")),
+
+		RulesDir:             getEnv("RULES_DIR", ""),
+		RulesDirPollInterval: parseDuration(getEnv("RULES_DIR_POLL_INTERVAL", "30s")),
+
+		RolloutCheckInterval: parseDuration(getEnv("ROLLOUT_CHECK_INTERVAL", "1m")),
+		RolloutHaltThreshold: parseInt(getEnv("ROLLOUT_HALT_THRESHOLD", "10")),
+
+		LintBroadThreshold: parseInt(getEnv("LINT_BROAD_THRESHOLD", "25")),
+
+		FeedCheckInterval: parseDuration(getEnv("FEED_CHECK_INTERVAL", "1m")),
+
+		ReputationProvider:       getEnv("REPUTATION_PROVIDER", ""),
+		ReputationURL:            getEnv("REPUTATION_URL", ""),
+		ReputationAPIKey:         getEnv("REPUTATION_API_KEY", ""),
+		ReputationAPIKeyHeader:   getEnv("REPUTATION_API_KEY_HEADER", "X-API-Key"),
+		ReputationFile:           getEnv("REPUTATION_FILE", ""),
+		ReputationCacheTTL:       parseDuration(getEnv("REPUTATION_CACHE_TTL", "24h")),
+		ReputationLookupInterval: parseDuration(getEnv("REPUTATION_LOOKUP_INTERVAL", "1s")),
+
+		RetentionInterval:           parseDuration(getEnv("RETENTION_INTERVAL", "1h")),
+		RetentionBatchSize:          parseInt(getEnv("RETENTION_BATCH_SIZE", "1000")),
+		RetentionBatchPause:         parseDuration(getEnv("RETENTION_BATCH_PAUSE", "100ms")),
+		EventRetentionDays:          parseInt(getEnv("EVENT_RETENTION_DAYS", "0")),
+		EventRetentionMaxRows:       parseInt(getEnv("EVENT_RETENTION_MAX_ROWS", "0")),
+		SyncHistoryRetentionDays:    parseInt(getEnv("SYNC_HISTORY_RETENTION_DAYS", "0")),
+		SyncHistoryRetentionMaxRows: parseInt(getEnv("SYNC_HISTORY_RETENTION_MAX_ROWS", "0")),
+		AuditRetentionDays:          parseInt(getEnv("AUDIT_RETENTION_DAYS", "0")),
+		AuditRetentionMaxRows:       parseInt(getEnv("AUDIT_RETENTION_MAX_ROWS", "0")),
+
+		ArchiveStore:         getEnv("ARCHIVE_STORE", ""),
+		ArchiveDir:           getEnv("ARCHIVE_DIR", "./database/archive"),
+		ArchiveS3Endpoint:    getEnv("ARCHIVE_S3_ENDPOINT", ""),
+		ArchiveS3Bucket:      getEnv("ARCHIVE_S3_BUCKET", ""),
+		ArchiveS3Region:      getEnv("ARCHIVE_S3_REGION", "us-east-1"),
+		ArchiveS3AccessKey:   getEnv("ARCHIVE_S3_ACCESS_KEY", ""),
+		ArchiveS3SecretKey:   getEnv("ARCHIVE_S3_SECRET_KEY", ""),
+		ArchiveS3Prefix:      getEnv("ARCHIVE_S3_PREFIX", ""),
+		ArchiveS3PathStyle:   parseBool(getEnv("ARCHIVE_S3_PATH_STYLE", "true")),
+		ArchiveRetentionDays: parseInt(getEnv("ARCHIVE_RETENTION_DAYS", "0")),
+		ArchiveRestoreDays:   parseInt(getEnv("ARCHIVE_RESTORE_DAYS", "7")),
+
+		IncrementalVacuum: parseBool(getEnv("INCREMENTAL_VACUUM", "false")),
+		VacuumInterval:    parseDuration(getEnv("VACUUM_INTERVAL", "0")),
+
 		// Database
 		DatabasePath: getEnv("DATABASE_PATH", "./database/krampus.db"),
 	}
@@ -95,6 +208,15 @@ func parseInt(value string) int {
 	return i
 }
 
+func parseBool(value string) bool {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Failed to parse bool from '%s', using false\n", value)
+		return false
+	}
+	return b
+}
+
 func parseDuration(value string) time.Duration {
 	d, err := time.ParseDuration(value)
 	if err != nil {
@@ -31,6 +31,17 @@ func Initialize(dbPath string) error {
 	return nil
 }
 
+// OpenReadOnly opens the database without running migrations, refusing
+// writes, for tools that only read it
+func OpenReadOnly(dbPath string) error {
+	var err error
+	DB, err = sql.Open("sqlite3", "file:"+dbPath+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	return DB.Ping()
+}
+
 // Close closes the database connection
 func Close() error {
 	if DB != nil {
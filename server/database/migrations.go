@@ -1,9 +1,100 @@
 package database
 
 import (
+	"database/sql"
+	"fmt"
 	"log"
+	"strings"
+	"time"
 )
 
+// rulesColumns is the canonical rules definition, covering every policy
+// Santa syncs. A CEL rule carries the expression Santa evaluates for
+// matching executions in cel_expr; other policies leave it NULL.
+const rulesColumns = `
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			identifier TEXT NOT NULL,
+			policy TEXT NOT NULL CHECK(policy IN ('ALLOWLIST', 'ALLOWLIST_COMPILER', 'BLOCKLIST', 'SILENT_BLOCKLIST', 'REMOVE', 'CEL')),
+			rule_type TEXT NOT NULL CHECK(rule_type IN ('BINARY', 'CERTIFICATE', 'SIGNINGID', 'TEAMID', 'CDHASH')),
+			cel_expr TEXT,
+			custom_message TEXT,
+			custom_url TEXT,
+			owner_team TEXT,
+			comment TEXT,
+			created_by INTEGER,
+			proposal_id INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME,
+			superseded_by INTEGER,
+			superseded_at DATETIME,
+			source TEXT NOT NULL DEFAULT 'DATABASE',
+			source_file TEXT,
+			feed_id INTEGER,
+			group_id INTEGER REFERENCES machine_groups(id),
+			expires_at DATETIME,
+			hit_count INTEGER NOT NULL DEFAULT 0,
+			last_hit_at DATETIME,
+			FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL,
+			FOREIGN KEY (proposal_id) REFERENCES proposals(id) ON DELETE SET NULL
+		`
+
+// proposalsColumns is the canonical proposals definition, shared by the
+// initial CREATE and by rebuildTable when an older schema needs upgrading.
+// created_by is NULL for proposals opened automatically from blocked events.
+const proposalsColumns = `
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			identifier TEXT NOT NULL,
+			rule_type TEXT NOT NULL CHECK(rule_type IN ('BINARY', 'CERTIFICATE', 'SIGNINGID', 'TEAMID', 'CDHASH')),
+			proposed_policy TEXT NOT NULL CHECK(proposed_policy IN ('ALLOWLIST', 'ALLOWLIST_COMPILER', 'BLOCKLIST', 'SILENT_BLOCKLIST', 'REMOVE', 'CEL')),
+			cel_expr TEXT,
+			custom_message TEXT,
+			created_by INTEGER,
+			source TEXT NOT NULL DEFAULT 'USER' CHECK(source IN ('USER', 'BLOCKED_EVENT')),
+			status TEXT NOT NULL DEFAULT 'PENDING' CHECK(status IN ('PENDING', 'APPROVED', 'REJECTED')),
+			auto_decision TEXT CHECK(auto_decision IN ('APPROVE', 'REJECT', 'ESCALATE')),
+			auto_policy_id INTEGER,
+			auto_policy_name TEXT,
+			auto_decision_note TEXT,
+			conflict_rule_id INTEGER,
+			allowlist_votes INTEGER DEFAULT 0,
+			blocklist_votes INTEGER DEFAULT 0,
+			request_count INTEGER DEFAULT 0,
+			last_requested_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			finalized_at DATETIME,
+			FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE
+		`
+
+// ruleVersionsColumns is the canonical rule_versions definition. History
+// outlives the rule, so rule_id has no foreign key.
+const ruleVersionsColumns = `
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			rule_id INTEGER NOT NULL,
+			version INTEGER NOT NULL,
+			action TEXT NOT NULL CHECK(action IN ('CREATE', 'UPDATE', 'RESTORE', 'DELETE', 'SUPERSEDE')),
+			identifier TEXT NOT NULL,
+			policy TEXT NOT NULL,
+			rule_type TEXT NOT NULL,
+			cel_expr TEXT,
+			custom_message TEXT,
+			custom_url TEXT,
+			owner_team TEXT,
+			comment TEXT,
+			proposal_id INTEGER,
+			group_id INTEGER,
+			expires_at DATETIME,
+			source TEXT,
+			source_file TEXT,
+			feed_id INTEGER,
+			revision INTEGER,
+			changed_by INTEGER,
+			reason TEXT,
+			diff TEXT NOT NULL DEFAULT '{}',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(rule_id, version),
+			FOREIGN KEY (changed_by) REFERENCES users(id) ON DELETE SET NULL
+		`
+
 // RunMigrations creates all tables and applies schema updates
 func RunMigrations() error {
 	migrations := []string{
@@ -35,20 +126,7 @@ func RunMigrations() error {
 		);`,
 
 		// Create proposals table for voting system
-		`CREATE TABLE IF NOT EXISTS proposals (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			identifier TEXT NOT NULL,
-			rule_type TEXT NOT NULL CHECK(rule_type IN ('BINARY', 'CERTIFICATE', 'SIGNINGID', 'TEAMID', 'CDHASH')),
-			proposed_policy TEXT NOT NULL CHECK(proposed_policy IN ('ALLOWLIST', 'BLOCKLIST')),
-			custom_message TEXT,
-			created_by INTEGER NOT NULL,
-			status TEXT NOT NULL DEFAULT 'PENDING' CHECK(status IN ('PENDING', 'APPROVED', 'REJECTED')),
-			allowlist_votes INTEGER DEFAULT 0,
-			blocklist_votes INTEGER DEFAULT 0,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			finalized_at DATETIME,
-			FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE
-		);`,
+		`CREATE TABLE IF NOT EXISTS proposals (` + proposalsColumns + `);`,
 
 		// Create votes table
 		`CREATE TABLE IF NOT EXISTS votes (
@@ -63,18 +141,7 @@ func RunMigrations() error {
 		);`,
 
 		// Create rules table with proposal tracking
-		`CREATE TABLE IF NOT EXISTS rules (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			identifier TEXT NOT NULL,
-			policy TEXT NOT NULL CHECK(policy IN ('ALLOWLIST', 'BLOCKLIST')),
-			rule_type TEXT NOT NULL CHECK(rule_type IN ('BINARY', 'CERTIFICATE', 'SIGNINGID', 'TEAMID', 'CDHASH')),
-			custom_message TEXT,
-			created_by INTEGER,
-			proposal_id INTEGER,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL,
-			FOREIGN KEY (proposal_id) REFERENCES proposals(id) ON DELETE SET NULL
-		);`,
+		`CREATE TABLE IF NOT EXISTS rules (` + rulesColumns + `);`,
 
 		// Create events table with comprehensive Santa event data
 		`CREATE TABLE IF NOT EXISTS events (
@@ -97,6 +164,320 @@ func RunMigrations() error {
 			FOREIGN KEY (machine_id) REFERENCES machines(machine_id) ON DELETE CASCADE
 		);`,
 
+		// Create proposal_requesters table tracking who hit a block behind an access request
+		`CREATE TABLE IF NOT EXISTS proposal_requesters (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			proposal_id INTEGER NOT NULL,
+			machine_id TEXT NOT NULL,
+			executing_user TEXT NOT NULL DEFAULT '',
+			block_count INTEGER NOT NULL DEFAULT 0,
+			first_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(proposal_id, machine_id, executing_user),
+			FOREIGN KEY (proposal_id) REFERENCES proposals(id) ON DELETE CASCADE
+		);`,
+
+		// Create proposal_comments table for discussion threads on proposals
+		`CREATE TABLE IF NOT EXISTS proposal_comments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			proposal_id INTEGER NOT NULL,
+			user_id INTEGER,
+			body TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME,
+			FOREIGN KEY (proposal_id) REFERENCES proposals(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
+		);`,
+
+		// Create proposal_comment_edits table keeping every previous comment body
+		`CREATE TABLE IF NOT EXISTS proposal_comment_edits (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			comment_id INTEGER NOT NULL,
+			previous_body TEXT NOT NULL,
+			edited_by INTEGER,
+			edited_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (comment_id) REFERENCES proposal_comments(id) ON DELETE CASCADE,
+			FOREIGN KEY (edited_by) REFERENCES users(id) ON DELETE SET NULL
+		);`,
+
+		// Create proposal_evidence table linking events, machines and tickets to proposals
+		`CREATE TABLE IF NOT EXISTS proposal_evidence (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			proposal_id INTEGER NOT NULL,
+			evidence_type TEXT NOT NULL CHECK(evidence_type IN ('EVENT', 'MACHINE', 'TICKET')),
+			reference TEXT NOT NULL,
+			note TEXT,
+			created_by INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(proposal_id, evidence_type, reference),
+			FOREIGN KEY (proposal_id) REFERENCES proposals(id) ON DELETE CASCADE,
+			FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+		);`,
+
+		// Create rule_versions table: a snapshot of a rule after every change
+		`CREATE TABLE IF NOT EXISTS rule_versions (` + ruleVersionsColumns + `);`,
+
+		// Create rule_duplicates table: rules superseded by the one-time
+		// duplicate cleanup, kept as a report for admins
+		`CREATE TABLE IF NOT EXISTS rule_duplicates (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			identifier TEXT NOT NULL,
+			rule_type TEXT NOT NULL,
+			kept_rule_id INTEGER NOT NULL,
+			kept_policy TEXT NOT NULL,
+			superseded_rule_id INTEGER NOT NULL,
+			superseded_policy TEXT NOT NULL,
+			detected_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`,
+
+		// Create rule_reconciles table: one row per applied or failed
+		// reconcile of the GitOps rules directory
+		`CREATE TABLE IF NOT EXISTS rule_reconciles (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			trigger TEXT NOT NULL CHECK(trigger IN ('STARTUP', 'CHANGE', 'MANUAL')),
+			status TEXT NOT NULL CHECK(status IN ('APPLIED', 'FAILED')),
+			fingerprint TEXT NOT NULL,
+			added INTEGER DEFAULT 0,
+			updated INTEGER DEFAULT 0,
+			removed INTEGER DEFAULT 0,
+			unchanged INTEGER DEFAULT 0,
+			error_count INTEGER DEFAULT 0,
+			report TEXT NOT NULL DEFAULT '{}',
+			started_at DATETIME NOT NULL,
+			finished_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`,
+
+		// Create machine_groups table: named sets of machines that rules can
+		// be scoped to
+		`CREATE TABLE IF NOT EXISTS machine_groups (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			description TEXT,
+			custom_message TEXT,
+			custom_url TEXT,
+			owner_team TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`,
+
+		// Create machine_group_members table
+		`CREATE TABLE IF NOT EXISTS machine_group_members (
+			group_id INTEGER NOT NULL,
+			machine_id TEXT NOT NULL,
+			added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (group_id, machine_id),
+			FOREIGN KEY (group_id) REFERENCES machine_groups(id) ON DELETE CASCADE,
+			FOREIGN KEY (machine_id) REFERENCES machines(machine_id) ON DELETE CASCADE
+		);`,
+
+		// Create proposal_batches table: draft rulesets generated from
+		// monitor-mode activity, reviewed and approved as a whole
+		`CREATE TABLE IF NOT EXISTS proposal_batches (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'DRAFT' CHECK(status IN ('DRAFT', 'APPROVED')),
+			since DATETIME NOT NULL,
+			until DATETIME NOT NULL,
+			machine_count INTEGER DEFAULT 0,
+			created_by INTEGER,
+			approved_by INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			approved_at DATETIME,
+			FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL,
+			FOREIGN KEY (approved_by) REFERENCES users(id) ON DELETE SET NULL
+		);`,
+
+		// Create proposal_batch_items table: one proposed rule per cluster
+		`CREATE TABLE IF NOT EXISTS proposal_batch_items (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			batch_id INTEGER NOT NULL,
+			identifier TEXT NOT NULL,
+			rule_type TEXT NOT NULL,
+			policy TEXT NOT NULL DEFAULT 'ALLOWLIST',
+			team_id TEXT,
+			signing_id TEXT,
+			example_path TEXT,
+			binaries INTEGER DEFAULT 0,
+			executions INTEGER DEFAULT 0,
+			machines INTEGER DEFAULT 0,
+			status TEXT NOT NULL DEFAULT 'INCLUDED' CHECK(status IN ('INCLUDED', 'EXCLUDED', 'CREATED', 'SKIPPED')),
+			rule_id INTEGER,
+			FOREIGN KEY (batch_id) REFERENCES proposal_batches(id) ON DELETE CASCADE
+		);`,
+
+		// Create ruleset_revisions table: one numbered revision per
+		// transaction that changes rules, grouping the rule versions it wrote
+		`CREATE TABLE IF NOT EXISTS ruleset_revisions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			changed_by INTEGER,
+			reason TEXT,
+			rolled_back_to INTEGER,
+			changes INTEGER DEFAULT 0,
+			rule_count INTEGER DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (changed_by) REFERENCES users(id) ON DELETE SET NULL
+		);`,
+
+		// Create rule_rollouts table: a rule served to machine groups in
+		// stages before it reaches the whole fleet
+		`CREATE TABLE IF NOT EXISTS rule_rollouts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			rule_id INTEGER NOT NULL UNIQUE,
+			status TEXT NOT NULL DEFAULT 'ACTIVE' CHECK(status IN ('ACTIVE', 'HALTED', 'COMPLETED', 'ABORTED')),
+			current_stage INTEGER NOT NULL DEFAULT 1,
+			halt_threshold INTEGER NOT NULL DEFAULT 0,
+			halt_reason TEXT,
+			stage_started_at DATETIME NOT NULL,
+			created_by INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			completed_at DATETIME,
+			FOREIGN KEY (rule_id) REFERENCES rules(id) ON DELETE CASCADE,
+			FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+		);`,
+
+		// Create rule_rollout_stages table: the groups a rollout reaches, in
+		// order, and how long each stage is held before promotion
+		`CREATE TABLE IF NOT EXISTS rule_rollout_stages (
+			rollout_id INTEGER NOT NULL,
+			position INTEGER NOT NULL,
+			group_id INTEGER NOT NULL,
+			hold_minutes INTEGER,
+			reached_at DATETIME,
+			PRIMARY KEY (rollout_id, position),
+			FOREIGN KEY (rollout_id) REFERENCES rule_rollouts(id) ON DELETE CASCADE,
+			FOREIGN KEY (group_id) REFERENCES machine_groups(id)
+		);`,
+
+		// Create settings table: server-wide options admins change at
+		// runtime, stored as one row per key
+		`CREATE TABLE IF NOT EXISTS settings (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL,
+			updated_by INTEGER,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (updated_by) REFERENCES users(id) ON DELETE SET NULL
+		);`,
+
+		// Create threat_feeds table: known-bad hash lists ingested on a
+		// schedule into BLOCKLIST rules
+		`CREATE TABLE IF NOT EXISTS threat_feeds (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			url TEXT NOT NULL,
+			format TEXT NOT NULL CHECK(format IN ('TEXT', 'CSV', 'STIX')),
+			rule_type TEXT NOT NULL DEFAULT 'BINARY' CHECK(rule_type IN ('BINARY', 'CERTIFICATE', 'CDHASH')),
+			interval_minutes INTEGER NOT NULL DEFAULT 60,
+			enabled BOOLEAN NOT NULL DEFAULT 1,
+			last_run_at DATETIME,
+			created_by INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+		);`,
+
+		// Create threat_feed_runs table: one row per ingestion of a feed
+		// with what it changed
+		`CREATE TABLE IF NOT EXISTS threat_feed_runs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			feed_id INTEGER NOT NULL,
+			trigger TEXT NOT NULL CHECK(trigger IN ('SCHEDULE', 'MANUAL')),
+			status TEXT NOT NULL CHECK(status IN ('SUCCESS', 'FAILED')),
+			entries INTEGER DEFAULT 0,
+			added INTEGER DEFAULT 0,
+			refreshed INTEGER DEFAULT 0,
+			retired INTEGER DEFAULT 0,
+			skipped_allowlisted INTEGER DEFAULT 0,
+			skipped_existing INTEGER DEFAULT 0,
+			invalid INTEGER DEFAULT 0,
+			error TEXT,
+			refusals TEXT NOT NULL DEFAULT '[]',
+			started_at DATETIME NOT NULL,
+			finished_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (feed_id) REFERENCES threat_feeds(id) ON DELETE CASCADE
+		);`,
+
+		// Create proposal_policies table: CEL rules that auto-approve,
+		// auto-reject or escalate proposals as they are opened
+		`CREATE TABLE IF NOT EXISTS proposal_policies (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			description TEXT,
+			expression TEXT NOT NULL,
+			action TEXT NOT NULL CHECK(action IN ('APPROVE', 'REJECT', 'ESCALATE')),
+			priority INTEGER NOT NULL DEFAULT 0,
+			enabled BOOLEAN NOT NULL DEFAULT 1,
+			created_by INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`,
+
+		// Create binary_reputations table: the last result each reputation
+		// provider gave for a binary hash
+		`CREATE TABLE IF NOT EXISTS binary_reputations (
+			identifier TEXT NOT NULL,
+			provider TEXT NOT NULL,
+			found BOOLEAN NOT NULL DEFAULT 0,
+			detections INTEGER NOT NULL DEFAULT 0,
+			engines INTEGER NOT NULL DEFAULT 0,
+			first_seen DATETIME,
+			known_good BOOLEAN NOT NULL DEFAULT 0,
+			link TEXT,
+			checked_at DATETIME NOT NULL,
+			PRIMARY KEY (identifier, provider)
+		);`,
+
+		// Create retention_runs table: one row per pass of pruning and
+		// vacuuming old data
+		`CREATE TABLE IF NOT EXISTS retention_runs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			trigger TEXT NOT NULL CHECK(trigger IN ('SCHEDULE', 'MANUAL')),
+			status TEXT NOT NULL CHECK(status IN ('SUCCESS', 'FAILED')),
+			pruned TEXT NOT NULL DEFAULT '{}',
+			vacuum TEXT NOT NULL DEFAULT 'NONE' CHECK(vacuum IN ('NONE', 'INCREMENTAL', 'FULL')),
+			freed_bytes INTEGER DEFAULT 0,
+			error TEXT,
+			started_at DATETIME NOT NULL,
+			finished_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`,
+
+		// Create retention_stats table: rows retention has pruned from each
+		// table, and for rule history the oldest revision still kept in full
+		`CREATE TABLE IF NOT EXISTS retention_stats (
+			table_name TEXT PRIMARY KEY,
+			pruned_rows INTEGER NOT NULL DEFAULT 0,
+			horizon INTEGER,
+			last_pruned_at DATETIME
+		);`,
+
+		// Create event_daily_stats table: per machine, day and decision
+		// counts of events pruned by retention
+		`CREATE TABLE IF NOT EXISTS event_daily_stats (
+			day TEXT NOT NULL,
+			machine_id TEXT NOT NULL,
+			decision TEXT NOT NULL,
+			events INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (day, machine_id, decision)
+		);`,
+
+		// Create event_archives table: the index of archive objects holding
+		// events pruned by retention, one object per day per pruning batch
+		`CREATE TABLE IF NOT EXISTS event_archives (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			day TEXT NOT NULL,
+			store TEXT NOT NULL,
+			object_key TEXT NOT NULL UNIQUE,
+			events INTEGER NOT NULL DEFAULT 0,
+			first_event_id INTEGER NOT NULL,
+			last_event_id INTEGER NOT NULL,
+			bytes INTEGER NOT NULL DEFAULT 0,
+			machines TEXT NOT NULL DEFAULT '[]',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`,
+
+		// Create schema_migrations table to record one-time data migrations
+		`CREATE TABLE IF NOT EXISTS schema_migrations (
+			name TEXT PRIMARY KEY,
+			applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`,
+
 		// Create sessions table for JWT tracking
 		`CREATE TABLE IF NOT EXISTS sessions (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -106,8 +487,250 @@ func RunMigrations() error {
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
 		);`,
+	}
+
+	// Execute each migration
+	for _, migration := range migrations {
+		if _, err := DB.Exec(migration); err != nil {
+			log.Printf("Migration failed: %v\nQuery: %s\n", err, migration)
+			return err
+		}
+	}
 
-		// Create indices for performance
+	// Rebuild proposals so blocked events can open proposals without a
+	// creator and proposals can target every Santa policy
+	if err := rebuildTable("proposals", "'SILENT_BLOCKLIST'", proposalsColumns); err != nil {
+		log.Printf("Failed to rebuild proposals table: %v", err)
+		return err
+	}
+
+	// Rebuild rule_versions so supersession can be recorded
+	if err := rebuildTable("rule_versions", "'SUPERSEDE'", ruleVersionsColumns); err != nil {
+		log.Printf("Failed to rebuild rule_versions table: %v", err)
+		return err
+	}
+
+	// Rebuild rules so every Santa policy is allowed and CEL rules carry
+	// their expression
+	if err := rebuildTable("rules", "'SILENT_BLOCKLIST'", rulesColumns); err != nil {
+		log.Printf("Failed to rebuild rules table: %v", err)
+		return err
+	}
+
+	// Add comment column to rules table if it doesn't exist
+	if err := addColumnIfNotExists("rules", "comment", "TEXT"); err != nil {
+		log.Printf("Failed to add comment column to rules: %v", err)
+		return err
+	}
+
+	// Track when a rule was last edited
+	if err := addColumnIfNotExists("rules", "updated_at", "DATETIME"); err != nil {
+		log.Printf("Failed to add updated_at column to rules: %v", err)
+		return err
+	}
+
+	// Track which rule replaced a rule; only rules without superseded_at are active
+	if err := addColumnIfNotExists("rules", "superseded_by", "INTEGER"); err != nil {
+		log.Printf("Failed to add superseded_by column to rules: %v", err)
+		return err
+	}
+	if err := addColumnIfNotExists("rules", "superseded_at", "DATETIME"); err != nil {
+		log.Printf("Failed to add superseded_at column to rules: %v", err)
+		return err
+	}
+
+	// Record where a rule is managed: DATABASE (API, votes, imports), GITOPS
+	// (the rules directory) or FEED (a threat feed); the latter two are
+	// read-only through the API
+	if err := addColumnIfNotExists("rules", "source", "TEXT NOT NULL DEFAULT 'DATABASE'"); err != nil {
+		log.Printf("Failed to add source column to rules: %v", err)
+		return err
+	}
+	if err := addColumnIfNotExists("rules", "source_file", "TEXT"); err != nil {
+		log.Printf("Failed to add source_file column to rules: %v", err)
+		return err
+	}
+
+	// Record the threat feed managing a FEED rule
+	if err := addColumnIfNotExists("rules", "feed_id", "INTEGER"); err != nil {
+		log.Printf("Failed to add feed_id column to rules: %v", err)
+		return err
+	}
+
+	// Scope rules to a machine group (NULL applies fleet-wide) and let them
+	// lapse; versions snapshot both
+	if err := addColumnIfNotExists("rules", "group_id", "INTEGER REFERENCES machine_groups(id)"); err != nil {
+		log.Printf("Failed to add group_id column to rules: %v", err)
+		return err
+	}
+	if err := addColumnIfNotExists("rules", "expires_at", "DATETIME"); err != nil {
+		log.Printf("Failed to add expires_at column to rules: %v", err)
+		return err
+	}
+	if err := addColumnIfNotExists("rule_versions", "group_id", "INTEGER"); err != nil {
+		log.Printf("Failed to add group_id column to rule_versions: %v", err)
+		return err
+	}
+	if err := addColumnIfNotExists("rule_versions", "expires_at", "DATETIME"); err != nil {
+		log.Printf("Failed to add expires_at column to rule_versions: %v", err)
+		return err
+	}
+	if err := addColumnIfNotExists("rule_versions", "cel_expr", "TEXT"); err != nil {
+		log.Printf("Failed to add cel_expr column to rule_versions: %v", err)
+		return err
+	}
+
+	// Give rules that predate versioning an initial version so their history
+	// starts from the state they were created in
+	_, err := DB.Exec(
+		`INSERT INTO rule_versions (rule_id, version, action, identifier, policy, rule_type,
+		                            custom_message, comment, proposal_id, changed_by, created_at)
+		 SELECT id, 1, 'CREATE', identifier, policy, rule_type,
+		        custom_message, comment, proposal_id, created_by, created_at
+		 FROM rules WHERE id NOT IN (SELECT rule_id FROM rule_versions)`,
+	)
+	if err != nil {
+		log.Printf("Failed to backfill rule versions: %v", err)
+		return err
+	}
+
+	// Add cdhash column to events so CDHASH rules can be matched against history
+	if err := addColumnIfNotExists("events", "cdhash", "TEXT"); err != nil {
+		log.Printf("Failed to add cdhash column to events: %v", err)
+		return err
+	}
+
+	// Attribute events to the rule behind their decision and keep per-rule
+	// hit counts
+	if err := addColumnIfNotExists("events", "rule_id", "INTEGER"); err != nil {
+		log.Printf("Failed to add rule_id column to events: %v", err)
+		return err
+	}
+	if err := addColumnIfNotExists("rules", "hit_count", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		log.Printf("Failed to add hit_count column to rules: %v", err)
+		return err
+	}
+	if err := addColumnIfNotExists("rules", "last_hit_at", "DATETIME"); err != nil {
+		log.Printf("Failed to add last_hit_at column to rules: %v", err)
+		return err
+	}
+	if err := runOnce("attribute_event_rules", attributeEventRules); err != nil {
+		log.Printf("Failed to attribute events to rules: %v", err)
+		return err
+	}
+
+	// Number ruleset revisions and record which one each machine last
+	// downloaded, so removals can be pushed with a clean sync
+	if err := addColumnIfNotExists("rule_versions", "revision", "INTEGER"); err != nil {
+		log.Printf("Failed to add revision column to rule_versions: %v", err)
+		return err
+	}
+	if err := addColumnIfNotExists("machines", "last_rule_revision", "INTEGER"); err != nil {
+		log.Printf("Failed to add last_rule_revision column to machines: %v", err)
+		return err
+	}
+	// When the machine last downloaded and the groups it was in, which
+	// decide the rules it holds alongside the revision
+	if err := addColumnIfNotExists("machines", "last_rule_download", "DATETIME"); err != nil {
+		log.Printf("Failed to add last_rule_download column to machines: %v", err)
+		return err
+	}
+	if err := addColumnIfNotExists("machines", "last_rule_groups", "TEXT"); err != nil {
+		log.Printf("Failed to add last_rule_groups column to machines: %v", err)
+		return err
+	}
+
+	// Block message and URL templates for rules, with group defaults and
+	// the owning team filled into them
+	if err := addColumnIfNotExists("rules", "custom_url", "TEXT"); err != nil {
+		log.Printf("Failed to add custom_url column to rules: %v", err)
+		return err
+	}
+	if err := addColumnIfNotExists("rules", "owner_team", "TEXT"); err != nil {
+		log.Printf("Failed to add owner_team column to rules: %v", err)
+		return err
+	}
+	if err := addColumnIfNotExists("rule_versions", "custom_url", "TEXT"); err != nil {
+		log.Printf("Failed to add custom_url column to rule_versions: %v", err)
+		return err
+	}
+	if err := addColumnIfNotExists("rule_versions", "owner_team", "TEXT"); err != nil {
+		log.Printf("Failed to add owner_team column to rule_versions: %v", err)
+		return err
+	}
+	if err := addColumnIfNotExists("machine_groups", "custom_message", "TEXT"); err != nil {
+		log.Printf("Failed to add custom_message column to machine_groups: %v", err)
+		return err
+	}
+	if err := addColumnIfNotExists("machine_groups", "custom_url", "TEXT"); err != nil {
+		log.Printf("Failed to add custom_url column to machine_groups: %v", err)
+		return err
+	}
+	if err := addColumnIfNotExists("machine_groups", "owner_team", "TEXT"); err != nil {
+		log.Printf("Failed to add owner_team column to machine_groups: %v", err)
+		return err
+	}
+
+	// Mark events restored from the archive, which retention keeps for a
+	// while and then prunes without archiving them again
+	if err := addColumnIfNotExists("events", "restored_at", "DATETIME"); err != nil {
+		log.Printf("Failed to add restored_at column to events: %v", err)
+		return err
+	}
+
+	// Record the proposal policy that auto-decided a proposal
+	for _, column := range []struct{ name, def string }{
+		{"auto_decision", "TEXT CHECK(auto_decision IN ('APPROVE', 'REJECT', 'ESCALATE'))"},
+		{"auto_policy_id", "INTEGER"},
+		{"auto_policy_name", "TEXT"},
+		{"auto_decision_note", "TEXT"},
+	} {
+		if err := addColumnIfNotExists("proposals", column.name, column.def); err != nil {
+			log.Printf("Failed to add %s column to proposals: %v", column.name, err)
+			return err
+		}
+	}
+
+	// Record the rule that kept a proposal pending at the vote threshold
+	if err := addColumnIfNotExists("proposals", "conflict_rule_id", "INTEGER"); err != nil {
+		log.Printf("Failed to add conflict_rule_id column to proposals: %v", err)
+		return err
+	}
+
+	// Older databases may hold several active rules for one target; keep the
+	// newest of those applying the same policy. Contradicting rules are left
+	// for an admin to resolve.
+	if err := runOnce("supersede_duplicate_rules", supersedeDuplicateRules); err != nil {
+		log.Printf("Failed to supersede duplicate rules: %v", err)
+		return err
+	}
+
+	// Record where each version's rule was managed, so restoring a deleted
+	// rule keeps it managed. Versions of rules that still exist take the
+	// rule's source; those of rules deleted before now stay unknown.
+	for _, column := range []struct{ name, def string }{
+		{"source", "TEXT"},
+		{"source_file", "TEXT"},
+		{"feed_id", "INTEGER"},
+	} {
+		if err := addColumnIfNotExists("rule_versions", column.name, column.def); err != nil {
+			log.Printf("Failed to add %s column to rule_versions: %v", column.name, err)
+			return err
+		}
+	}
+	if err := runOnce("rule_version_sources", ruleVersionSources); err != nil {
+		log.Printf("Failed to record rule version sources: %v", err)
+		return err
+	}
+
+	// History recorded before revisions existed becomes the first revision
+	if err := runOnce("initial_ruleset_revision", initialRulesetRevision); err != nil {
+		log.Printf("Failed to record initial ruleset revision: %v", err)
+		return err
+	}
+
+	// Create indices for performance (after any table rebuilds, which drop them)
+	indexes := []string{
 		`CREATE INDEX IF NOT EXISTS idx_proposals_status ON proposals(status);`,
 		`CREATE INDEX IF NOT EXISTS idx_proposals_created_by ON proposals(created_by);`,
 		`CREATE INDEX IF NOT EXISTS idx_votes_proposal ON votes(proposal_id);`,
@@ -116,23 +739,44 @@ func RunMigrations() error {
 		`CREATE INDEX IF NOT EXISTS idx_rules_policy ON rules(policy);`,
 		`CREATE INDEX IF NOT EXISTS idx_events_machine ON events(machine_id);`,
 		`CREATE INDEX IF NOT EXISTS idx_events_hash ON events(file_hash);`,
+		`CREATE INDEX IF NOT EXISTS idx_events_signing_id ON events(signing_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_events_team_id ON events(team_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_events_cert ON events(cert_sha256);`,
+		`CREATE INDEX IF NOT EXISTS idx_events_cdhash ON events(cdhash);`,
+		`CREATE INDEX IF NOT EXISTS idx_events_rule ON events(rule_id);`,
 		`CREATE INDEX IF NOT EXISTS idx_sessions_token ON sessions(token_hash);`,
 		`CREATE INDEX IF NOT EXISTS idx_sessions_expires ON sessions(expires_at);`,
 		`CREATE INDEX IF NOT EXISTS idx_users_oidc_subject ON users(oidc_subject);`,
 		`CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);`,
+		`CREATE INDEX IF NOT EXISTS idx_proposals_identifier ON proposals(identifier, rule_type);`,
+		`CREATE INDEX IF NOT EXISTS idx_proposal_requesters_proposal ON proposal_requesters(proposal_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_proposal_comments_proposal ON proposal_comments(proposal_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_proposal_comment_edits_comment ON proposal_comment_edits(comment_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_proposal_evidence_proposal ON proposal_evidence(proposal_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_rule_versions_rule ON rule_versions(rule_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_rule_versions_revision ON rule_versions(revision);`,
+		`CREATE INDEX IF NOT EXISTS idx_rules_source ON rules(source);`,
+		`CREATE INDEX IF NOT EXISTS idx_rules_group ON rules(group_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_rules_feed ON rules(feed_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_threat_feed_runs_feed ON threat_feed_runs(feed_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_event_daily_stats_machine ON event_daily_stats(machine_id, day);`,
+		`CREATE INDEX IF NOT EXISTS idx_event_archives_day ON event_archives(day);`,
+		`CREATE INDEX IF NOT EXISTS idx_machine_group_members_machine ON machine_group_members(machine_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_proposal_batch_items_batch ON proposal_batch_items(batch_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_rule_rollouts_status ON rule_rollouts(status);`,
+		`CREATE INDEX IF NOT EXISTS idx_rule_rollout_stages_group ON rule_rollout_stages(group_id);`,
 	}
 
-	// Execute each migration
-	for _, migration := range migrations {
-		if _, err := DB.Exec(migration); err != nil {
-			log.Printf("Migration failed: %v\nQuery: %s\n", err, migration)
+	for _, index := range indexes {
+		if _, err := DB.Exec(index); err != nil {
+			log.Printf("Migration failed: %v\nQuery: %s\n", err, index)
 			return err
 		}
 	}
 
-	// Add comment column to rules table if it doesn't exist
-	if err := addColumnIfNotExists("rules", "comment", "TEXT"); err != nil {
-		log.Printf("Failed to add comment column to rules: %v", err)
+	// Enforce one active rule per target once no contradicting rules remain
+	if err := createActiveTargetIndex(); err != nil {
+		log.Printf("Failed to create active rule index: %v", err)
 		return err
 	}
 
@@ -140,6 +784,239 @@ func RunMigrations() error {
 	return nil
 }
 
+// runOnce applies a data migration in a transaction unless schema_migrations
+// shows it has already run
+func runOnce(name string, migrate func(tx *sql.Tx) error) error {
+	var applied int
+	err := DB.QueryRow(`SELECT COUNT(*) FROM schema_migrations WHERE name = ?`, name).Scan(&applied)
+	if err != nil {
+		return err
+	}
+	if applied > 0 {
+		return nil
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := migrate(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (name) VALUES (?)`, name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// activeTargetKey is what identifies a rule's target and scope; only one
+// active rule may exist per key, so a group may carry its own rule for a
+// target alongside the fleet-wide one
+const activeTargetKey = `identifier, rule_type, COALESCE(group_id, 0)`
+
+// createActiveTargetIndex creates the unique index allowing one active rule
+// per target and scope, replacing the older index that ignored scope. While
+// older databases still hold active rules contradicting each other for a
+// target, it logs them and leaves the index out; admins resolve them (see
+// GET /api/rules/contradictions) and the next start creates it.
+func createActiveTargetIndex() error {
+	if _, err := DB.Exec(`DROP INDEX IF EXISTS idx_rules_active_target;`); err != nil {
+		return err
+	}
+
+	rows, err := DB.Query(
+		`SELECT identifier, rule_type, group_id, GROUP_CONCAT(id || ' (' || policy || ')', ', ') FROM rules
+		 WHERE superseded_at IS NULL
+		 GROUP BY ` + activeTargetKey + ` HAVING COUNT(*) > 1`,
+	)
+	if err != nil {
+		return err
+	}
+	contradictions := 0
+	for rows.Next() {
+		var identifier, ruleType, rules string
+		var groupID sql.NullInt64
+		if err := rows.Scan(&identifier, &ruleType, &groupID, &rules); err != nil {
+			rows.Close()
+			return err
+		}
+		scope := "fleet-wide"
+		if groupID.Valid {
+			scope = fmt.Sprintf("in group %d", groupID.Int64)
+		}
+		log.Printf("WARNING: active rules %s contradict each other for %s %s %s; delete or supersede all but one",
+			rules, ruleType, identifier, scope)
+		contradictions++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if contradictions > 0 {
+		log.Printf("WARNING: %d targets have contradicting active rules; one-active-rule enforcement stays off until they are resolved", contradictions)
+		return nil
+	}
+
+	_, err = DB.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_rules_active_scope ON rules(` + activeTargetKey + `) WHERE superseded_at IS NULL;`)
+	return err
+}
+
+// supersedeDuplicateRules keeps the newest of the active rules applying the
+// same policy to a target in one scope, superseding the others and recording
+// them in rule_duplicates. Rules applying different policies to a target contradict
+// each other and are left active for an admin to decide between.
+func supersedeDuplicateRules(tx *sql.Tx) error {
+	type duplicate struct {
+		id         int64
+		identifier string
+		ruleType   string
+		groupID    int64
+		policy     string
+		celExpr    string
+	}
+
+	rows, err := tx.Query(
+		`SELECT id, identifier, rule_type, COALESCE(group_id, 0), policy, COALESCE(cel_expr, '') FROM rules
+		 WHERE superseded_at IS NULL AND (` + activeTargetKey + `, policy, COALESCE(cel_expr, '')) IN (
+		   SELECT ` + activeTargetKey + `, policy, COALESCE(cel_expr, '') FROM rules WHERE superseded_at IS NULL
+		   GROUP BY ` + activeTargetKey + `, policy, COALESCE(cel_expr, '') HAVING COUNT(*) > 1)
+		 ORDER BY identifier, rule_type, COALESCE(group_id, 0), policy, COALESCE(cel_expr, ''), created_at DESC, id DESC`,
+	)
+	if err != nil {
+		return err
+	}
+	var duplicates []duplicate
+	for rows.Next() {
+		var d duplicate
+		if err := rows.Scan(&d.id, &d.identifier, &d.ruleType, &d.groupID, &d.policy, &d.celExpr); err != nil {
+			rows.Close()
+			return err
+		}
+		duplicates = append(duplicates, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var kept duplicate
+	for _, d := range duplicates {
+		// Rows are ordered newest first within each target, scope and policy
+		if d.identifier != kept.identifier || d.ruleType != kept.ruleType || d.groupID != kept.groupID ||
+			d.policy != kept.policy || d.celExpr != kept.celExpr {
+			kept = d
+			continue
+		}
+
+		log.Printf("Rule %d duplicated rule %d for %s %s; superseding it", d.id, kept.id, d.ruleType, d.identifier)
+
+		if _, err := tx.Exec(
+			`UPDATE rules SET superseded_by = ?, superseded_at = ? WHERE id = ?`, kept.id, now, d.id,
+		); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO rule_duplicates (identifier, rule_type, kept_rule_id, kept_policy, superseded_rule_id, superseded_policy)
+			 VALUES (?, ?, ?, ?, ?, ?)`,
+			d.identifier, d.ruleType, kept.id, kept.policy, d.id, d.policy,
+		); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO rule_versions (rule_id, version, action, identifier, policy, rule_type,
+			                            custom_message, comment, proposal_id, reason, diff)
+			 SELECT r.id, (SELECT COALESCE(MAX(version), 0) + 1 FROM rule_versions WHERE rule_id = r.id),
+			        'SUPERSEDE', r.identifier, r.policy, r.rule_type, r.custom_message, r.comment, r.proposal_id,
+			        'Duplicate of rule ' || ?, json_object('superseded_by', json_object('from', NULL, 'to', ?))
+			 FROM rules r WHERE r.id = ?`,
+			kept.id, kept.id, d.id,
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ruleVersionSources copies each existing rule's source onto its versions
+func ruleVersionSources(tx *sql.Tx) error {
+	_, err := tx.Exec(
+		`UPDATE rule_versions SET
+		   source = (SELECT source FROM rules WHERE id = rule_versions.rule_id),
+		   source_file = (SELECT source_file FROM rules WHERE id = rule_versions.rule_id),
+		   feed_id = (SELECT feed_id FROM rules WHERE id = rule_versions.rule_id)
+		 WHERE source IS NULL AND rule_id IN (SELECT id FROM rules)`,
+	)
+	return err
+}
+
+// attributeEventRules links events stored before attribution existed to the
+// rule their decision names among those scoped to the fleet or one of the
+// machine's groups, preferring the active rule for the target and a group's
+// rule over the fleet-wide one, and derives each rule's hit count and last hit from them. Uploads attribute new
+// events as they arrive (services.EventRule).
+func attributeEventRules(tx *sql.Tx) error {
+	_, err := tx.Exec(
+		`UPDATE events SET rule_id = (
+		   SELECT r.id FROM rules r
+		   WHERE r.rule_type = substr(events.decision, instr(events.decision, '_') + 1)
+		     AND r.policy = CASE WHEN events.decision LIKE 'ALLOW%' THEN 'ALLOWLIST' ELSE 'BLOCKLIST' END
+		     AND r.identifier = CASE substr(events.decision, instr(events.decision, '_') + 1)
+		       WHEN 'BINARY' THEN lower(events.file_hash)
+		       WHEN 'CERTIFICATE' THEN lower(events.cert_sha256)
+		       WHEN 'CDHASH' THEN lower(events.cdhash)
+		       WHEN 'TEAMID' THEN upper(events.team_id)
+		       WHEN 'SIGNINGID' THEN CASE
+		         WHEN instr(events.signing_id, ':') > 0 THEN events.signing_id
+		         WHEN COALESCE(events.team_id, '') != '' THEN events.team_id || ':' || events.signing_id
+		         ELSE 'platform:' || events.signing_id END
+		     END
+		     AND (r.group_id IS NULL OR r.group_id IN (
+		       SELECT group_id FROM machine_group_members WHERE machine_id = events.machine_id))
+		   ORDER BY r.superseded_at IS NOT NULL, r.group_id IS NULL, r.id DESC LIMIT 1)
+		 WHERE rule_id IS NULL AND (decision LIKE 'ALLOW%' OR decision LIKE 'BLOCK%')`,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`UPDATE rules SET
+		   hit_count = (SELECT COUNT(*) FROM events WHERE rule_id = rules.id),
+		   last_hit_at = (SELECT execution_time FROM events WHERE rule_id = rules.id
+		                  ORDER BY execution_time DESC LIMIT 1)`,
+	)
+	return err
+}
+
+// initialRulesetRevision groups the rule versions recorded before ruleset
+// revisions existed into revision 1, so every later revision has a complete
+// history to rebuild the ruleset from
+func initialRulesetRevision(tx *sql.Tx) error {
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM rule_versions WHERE revision IS NULL`).Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		return nil
+	}
+
+	result, err := tx.Exec(
+		`INSERT INTO ruleset_revisions (reason, changes, rule_count)
+		 VALUES ('Ruleset before revisions were recorded', ?, (SELECT COUNT(*) FROM rules WHERE superseded_at IS NULL))`,
+		count,
+	)
+	if err != nil {
+		return err
+	}
+	revision, _ := result.LastInsertId()
+	_, err = tx.Exec(`UPDATE rule_versions SET revision = ? WHERE revision IS NULL`, revision)
+	return err
+}
+
 // Helper function to check if a column exists
 func columnExists(tableName, columnName string) (bool, error) {
 	query := `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?;`
@@ -164,3 +1041,68 @@ func addColumnIfNotExists(tableName, columnName, columnDef string) error {
 	}
 	return nil
 }
+
+// rebuildTable recreates a table with columnDefs when its stored schema does
+// not yet contain marker. SQLite cannot alter CHECK or NOT NULL constraints in
+// place, so the data is copied into a fresh table for every shared column.
+func rebuildTable(tableName, marker, columnDefs string) error {
+	var schema string
+	err := DB.QueryRow(
+		`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?`,
+		tableName,
+	).Scan(&schema)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(schema, marker) {
+		return nil
+	}
+
+	log.Printf("Rebuilding table %s to apply schema changes", tableName)
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	tmpName := tableName + "_rebuild"
+	if _, err := tx.Exec(`DROP TABLE IF EXISTS ` + tmpName + `;`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`CREATE TABLE ` + tmpName + ` (` + columnDefs + `);`); err != nil {
+		return err
+	}
+
+	// Copy only the columns both definitions have in common
+	rows, err := tx.Query(
+		`SELECT name FROM pragma_table_info(?) WHERE name IN (SELECT name FROM pragma_table_info(?));`,
+		tableName, tmpName,
+	)
+	if err != nil {
+		return err
+	}
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		columns = append(columns, name)
+	}
+	rows.Close()
+
+	columnList := strings.Join(columns, ", ")
+	if _, err := tx.Exec(`INSERT INTO ` + tmpName + ` (` + columnList + `) SELECT ` + columnList + ` FROM ` + tableName + `;`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DROP TABLE ` + tableName + `;`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`ALTER TABLE ` + tmpName + ` RENAME TO ` + tableName + `;`); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSupersedeDuplicateRules(t *testing.T) {
+	const (
+		hashA = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+		hashB = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+		hashC = "cccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccc"
+	)
+	if err := Initialize(filepath.Join(t.TempDir(), "krampus.db")); err != nil {
+		t.Fatalf("failed to initialize database: %v", err)
+	}
+	t.Cleanup(func() { Close() })
+
+	// A database from before one active rule was enforced per target
+	exec := func(query string, args ...interface{}) {
+		t.Helper()
+		if _, err := DB.Exec(query, args...); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	exec(`DROP INDEX idx_rules_active_scope`)
+	exec(`DELETE FROM schema_migrations WHERE name = 'supersede_duplicate_rules'`)
+	exec(`INSERT INTO rules (id, identifier, policy, rule_type, group_id, created_at) VALUES
+		(1, ?, 'ALLOWLIST', 'BINARY', NULL, '2026-01-01'),
+		(2, ?, 'ALLOWLIST', 'BINARY', NULL, '2026-01-03'),
+		(3, ?, 'ALLOWLIST', 'BINARY', NULL, '2026-01-02'),
+		(4, ?, 'ALLOWLIST', 'BINARY', NULL, '2026-01-01'),
+		(5, ?, 'BLOCKLIST', 'BINARY', NULL, '2026-01-02'),
+		(6, ?, 'ALLOWLIST', 'BINARY', NULL, '2026-01-01'),
+		(7, ?, 'ALLOWLIST', 'BINARY', 7, '2026-01-02')`,
+		hashA, hashA, hashA, hashB, hashB, hashC, hashC)
+
+	if err := RunMigrations(); err != nil {
+		t.Fatalf("RunMigrations: %v", err)
+	}
+
+	// The newest duplicate is kept; contradicting rules and rules of other
+	// scopes stay active
+	tests := []struct {
+		ruleID       int64
+		supersededBy int64
+	}{
+		{1, 2}, {2, 0}, {3, 2}, {4, 0}, {5, 0}, {6, 0}, {7, 0},
+	}
+	for _, tt := range tests {
+		var supersededBy int64
+		err := DB.QueryRow(`SELECT COALESCE(superseded_by, 0) FROM rules WHERE id = ?`, tt.ruleID).Scan(&supersededBy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if supersededBy != tt.supersededBy {
+			t.Errorf("rule %d superseded by %d, want %d", tt.ruleID, supersededBy, tt.supersededBy)
+		}
+	}
+
+	var duplicates int
+	if err := DB.QueryRow(`SELECT COUNT(*) FROM rule_duplicates WHERE kept_rule_id = 2`).Scan(&duplicates); err != nil {
+		t.Fatal(err)
+	}
+	if duplicates != 2 {
+		t.Errorf("%d duplicates recorded, want 2", duplicates)
+	}
+
+	// Enforcement waits until the contradiction is resolved
+	indexExists := func() bool {
+		t.Helper()
+		var n int
+		err := DB.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'idx_rules_active_scope'`).Scan(&n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n > 0
+	}
+	if indexExists() {
+		t.Error("active rule index created while rules contradict each other")
+	}
+	exec(`DELETE FROM rules WHERE id = 4`)
+	if err := RunMigrations(); err != nil {
+		t.Fatalf("RunMigrations: %v", err)
+	}
+	if !indexExists() {
+		t.Error("active rule index missing after the contradiction was resolved")
+	}
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"errors"
+	"krampus/server/models"
+	"krampus/server/services"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// validArchiveDays checks that since and until, when given, are dates
+func validArchiveDays(c *gin.Context, since, until string) bool {
+	for _, day := range []string{since, until} {
+		if day == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", day); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since and until must be dates (YYYY-MM-DD)"})
+			return false
+		}
+	}
+	return true
+}
+
+// SearchEventArchives lists the archive objects holding pruned events,
+// filtered by since and until (YYYY-MM-DD) and machine_id (admin only)
+func SearchEventArchives(c *gin.Context) {
+	since, until := c.Query("since"), c.Query("until")
+	if !validArchiveDays(c, since, until) {
+		return
+	}
+
+	archives, err := services.SearchEventArchives(since, until, c.Query("machine_id"))
+	if err != nil {
+		log.Printf("Failed to search event archives: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search event archives"})
+		return
+	}
+
+	events := 0
+	for _, a := range archives {
+		events += a.Events
+	}
+	c.JSON(http.StatusOK, gin.H{"archives": archives, "events": events})
+}
+
+// RestoreEventArchives copies archived events of a range of days back into
+// the events table for an investigation (admin only)
+func RestoreEventArchives(c *gin.Context) {
+	var input struct {
+		Since     string `json:"since" binding:"required"`
+		Until     string `json:"until" binding:"required"`
+		MachineID string `json:"machine_id"`
+		FileHash  string `json:"file_hash"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validArchiveDays(c, input.Since, input.Until) {
+		return
+	}
+	if input.Since > input.Until {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "since must not be after until"})
+		return
+	}
+	fileHash := strings.ToLower(strings.TrimSpace(input.FileHash))
+	if fileHash != "" {
+		if verr := services.ValidateIdentifier(string(models.RuleTypeBinary), fileHash); verr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "file_hash must be a SHA-256"})
+			return
+		}
+	}
+
+	result, err := services.RestoreEventArchives(input.Since, input.Until, strings.TrimSpace(input.MachineID), fileHash)
+	switch {
+	case errors.Is(err, services.ErrNoArchiveStore):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No archive store is configured"})
+		return
+	case err != nil:
+		log.Printf("Failed to restore event archives: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to restore event archives", "restore": result})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
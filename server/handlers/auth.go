@@ -7,6 +7,7 @@ import (
 	"krampus/server/services"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -34,6 +35,11 @@ func Login(c *gin.Context) {
 	// For simplicity, we'll use a secure cookie
 	c.SetCookie("oidc_state", state, 600, "/", "", false, true) // 10 minutes
 
+	// Remember where to return after login (local paths only)
+	if redirect := c.Query("redirect"); isLocalPath(redirect) {
+		c.SetCookie("login_redirect", redirect, 600, "/", "", false, true)
+	}
+
 	// Redirect to OIDC provider
 	authURL := services.GetAuthCodeURL(state)
 	c.Redirect(http.StatusTemporaryRedirect, authURL)
@@ -102,10 +108,22 @@ func Callback(c *gin.Context) {
 	// Set token in cookie for security
 	c.SetCookie("token", jwtToken, 86400, "/", "", false, true) // 24 hours
 
+	// Return to the page that started the login, such as the block landing page
+	if redirect, err := c.Cookie("login_redirect"); err == nil && isLocalPath(redirect) {
+		c.SetCookie("login_redirect", "", -1, "/", "", false, true)
+		c.Redirect(http.StatusTemporaryRedirect, redirect)
+		return
+	}
+
 	// Redirect to frontend login page which will handle the token from cookie
 	c.Redirect(http.StatusTemporaryRedirect, "/login?auth=success")
 }
 
+// isLocalPath reports whether a redirect target stays on this server
+func isLocalPath(path string) bool {
+	return strings.HasPrefix(path, "/") && !strings.HasPrefix(path, "//") && !strings.HasPrefix(path, "/\\")
+}
+
 // Logout revokes the current session
 func Logout(c *gin.Context) {
 	// Extract token from header
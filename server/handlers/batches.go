@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"krampus/server/middleware"
+	"krampus/server/services"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultBaselineWindow is how far back baseline generation looks when no
+// start is given
+const defaultBaselineWindow = 30 * 24 * time.Hour
+
+// respondBatchError maps proposal batch errors to responses
+func respondBatchError(c *gin.Context, err error, action string) {
+	switch {
+	case errors.Is(err, services.ErrBatchNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Proposal batch not found"})
+	case errors.Is(err, services.ErrBatchItemNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Proposal batch item not found"})
+	case errors.Is(err, services.ErrBatchNotDraft):
+		c.JSON(http.StatusConflict, gin.H{"error": "Proposal batch has already been approved"})
+	case errors.Is(err, services.ErrRuleManaged):
+		c.JSON(http.StatusConflict, gin.H{"error": ruleManagedMessage})
+	default:
+		log.Printf("Failed to %s proposal batch: %v", action, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action + " proposal batch"})
+	}
+}
+
+// GenerateProposalBatch builds a draft ruleset from what monitor-mode
+// machines ran in a window and saves it as a proposal batch (admin only)
+func GenerateProposalBatch(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var input struct {
+		Name              string `json:"name"`
+		Since             string `json:"since"`
+		Until             string `json:"until"`
+		MinVersions       *int   `json:"min_versions"`
+		MinTeamSigningIDs *int   `json:"min_team_signing_ids"`
+		MinMachines       *int   `json:"min_machines"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req := services.BaselineRequest{
+		Name:              input.Name,
+		Until:             time.Now(),
+		MinVersions:       2,
+		MinTeamSigningIDs: 5,
+		MinMachines:       1,
+		CreatedBy:         userID,
+	}
+	var errs services.ValidationErrors
+	if input.Until != "" {
+		until, err := time.Parse(time.RFC3339, input.Until)
+		if err != nil {
+			errs = append(errs, services.ValidationError{Field: "until", Message: "must be an RFC 3339 timestamp"})
+		}
+		req.Until = until
+	}
+	req.Since = req.Until.Add(-defaultBaselineWindow)
+	if input.Since != "" {
+		since, err := time.Parse(time.RFC3339, input.Since)
+		if err != nil {
+			errs = append(errs, services.ValidationError{Field: "since", Message: "must be an RFC 3339 timestamp"})
+		}
+		req.Since = since
+	}
+	if len(errs) == 0 && !req.Since.Before(req.Until) {
+		errs = append(errs, services.ValidationError{Field: "since", Message: "must be before until"})
+	}
+	if input.MinVersions != nil {
+		if *input.MinVersions < 1 {
+			errs = append(errs, services.ValidationError{Field: "min_versions", Message: "must be at least 1"})
+		}
+		req.MinVersions = *input.MinVersions
+	}
+	if input.MinTeamSigningIDs != nil {
+		if *input.MinTeamSigningIDs < 0 {
+			errs = append(errs, services.ValidationError{Field: "min_team_signing_ids", Message: "must not be negative"})
+		}
+		req.MinTeamSigningIDs = *input.MinTeamSigningIDs
+	}
+	if input.MinMachines != nil {
+		if *input.MinMachines < 1 {
+			errs = append(errs, services.ValidationError{Field: "min_machines", Message: "must be at least 1"})
+		}
+		req.MinMachines = *input.MinMachines
+	}
+	if len(errs) > 0 {
+		respondValidationErrors(c, errs)
+		return
+	}
+	if req.Name == "" {
+		req.Name = fmt.Sprintf("Baseline %s", req.Until.Format("2006-01-02"))
+	}
+
+	batch, err := services.GenerateBaseline(req)
+	if err != nil {
+		respondBatchError(c, err, "generate")
+		return
+	}
+
+	c.JSON(http.StatusCreated, batch)
+}
+
+// ListProposalBatches returns all proposal batches, newest first
+func ListProposalBatches(c *gin.Context) {
+	batches, err := services.ListProposalBatches()
+	if err != nil {
+		respondBatchError(c, err, "fetch")
+		return
+	}
+
+	c.JSON(http.StatusOK, batches)
+}
+
+// GetProposalBatch returns a proposal batch with its proposed rules
+func GetProposalBatch(c *gin.Context) {
+	batchID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid proposal batch ID"})
+		return
+	}
+
+	batch, err := services.GetProposalBatch(batchID)
+	if err != nil {
+		respondBatchError(c, err, "fetch")
+		return
+	}
+
+	c.JSON(http.StatusOK, batch)
+}
+
+// UpdateProposalBatchItem includes or excludes a proposed rule from a draft
+// batch (admin only)
+func UpdateProposalBatchItem(c *gin.Context) {
+	batchID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid proposal batch ID"})
+		return
+	}
+	itemID, err := strconv.ParseInt(c.Param("item_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid proposal batch item ID"})
+		return
+	}
+
+	var input struct {
+		Excluded *bool `json:"excluded" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.SetBatchItemExcluded(batchID, itemID, *input.Excluded); err != nil {
+		respondBatchError(c, err, "update")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Proposal batch item updated successfully"})
+}
+
+// ApproveProposalBatch creates rules for every included item of a draft
+// batch (admin only)
+func ApproveProposalBatch(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	batchID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid proposal batch ID"})
+		return
+	}
+
+	batch, err := services.ApproveProposalBatch(batchID, userID)
+	if err != nil {
+		respondBatchError(c, err, "approve")
+		return
+	}
+
+	c.JSON(http.StatusOK, batch)
+}
+
+// DeleteProposalBatch discards a draft batch (admin only)
+func DeleteProposalBatch(c *gin.Context) {
+	batchID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid proposal batch ID"})
+		return
+	}
+
+	if err := services.DeleteProposalBatch(batchID); err != nil {
+		respondBatchError(c, err, "delete")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Proposal batch deleted successfully"})
+}
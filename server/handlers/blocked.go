@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"io"
+	"krampus/server/middleware"
+	"krampus/server/models"
+	"krampus/server/services"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BlockedPage renders the landing page Santa opens from a block notification
+// (EventDetailURL). Signed-in users see the binary's status and can request
+// access with one click; anonymous users are offered a sign-in link that
+// returns them here.
+func BlockedPage(c *gin.Context) {
+	hash := strings.ToLower(strings.TrimSpace(c.Query("hash")))
+	machineID := c.Query("machine")
+
+	data := gin.H{
+		"Hash":      hash,
+		"MachineID": machineID,
+		"LoginURL":  "/auth/login?redirect=" + url.QueryEscape(c.Request.URL.RequestURI()),
+	}
+
+	_, signedIn := middleware.GetUserID(c)
+	data["SignedIn"] = signedIn
+
+	if signedIn && hash != "" {
+		lookup, err := services.LookupBinary(hash, machineID)
+		if err != nil {
+			log.Printf("Failed to look up blocked binary %s: %v", hash, err)
+			data["Error"] = "Failed to look up this binary"
+		} else {
+			data["Lookup"] = lookup
+			data["Targets"] = requestTargets(lookup)
+		}
+	}
+
+	var page bytes.Buffer
+	if err := blockedPageTemplate.Execute(&page, data); err != nil {
+		log.Printf("Failed to render blocked page: %v", err)
+		c.String(http.StatusInternalServerError, "Failed to render page")
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", page.Bytes())
+}
+
+// LookupBlockedBinary returns metadata, rules, proposals and the suggested
+// rule for a blocked binary hash
+func LookupBlockedBinary(c *gin.Context) {
+	hash := strings.ToLower(c.Param("hash"))
+
+	lookup, err := services.LookupBinary(hash, c.Query("machine"))
+	if err != nil {
+		log.Printf("Failed to look up blocked binary %s: %v", hash, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up binary"})
+		return
+	}
+
+	c.JSON(http.StatusOK, lookup)
+}
+
+// RequestBlockedAccess creates or joins an access request proposal for a
+// blocked binary. The rule type defaults to the suggested one; the identifier
+// is always derived from what the server knows about the hash.
+func RequestBlockedAccess(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	username, _ := middleware.GetUsername(c)
+	hash := strings.ToLower(c.Param("hash"))
+
+	var input struct {
+		RuleType  string  `json:"rule_type"`
+		MachineID string  `json:"machine_id"`
+		Message   *string `json:"message"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	lookup, err := services.LookupBinary(hash, input.MachineID)
+	if err != nil {
+		log.Printf("Failed to look up blocked binary %s: %v", hash, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up binary"})
+		return
+	}
+
+	target := lookup.Suggested
+	if input.RuleType != "" {
+		found := false
+		for _, t := range requestTargets(lookup) {
+			if t.RuleType == input.RuleType {
+				target, found = t, true
+				break
+			}
+		}
+		if !found {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No " + input.RuleType + " identifier is known for this binary"})
+			return
+		}
+	}
+
+	// Name the application so the eventual rule comment identifies it
+	message := input.Message
+	if message == nil {
+		if lookup.BundleName != nil {
+			message = lookup.BundleName
+		} else if lookup.FilePath != nil {
+			message = lookup.FilePath
+		}
+	}
+
+	request, err := services.RequestAccess(userID, hash, target, input.MachineID, username, message)
+	var verrs services.ValidationErrors
+	var applies *services.RuleAppliesError
+	switch {
+	case errors.Is(err, services.ErrMachineNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Machine not found"})
+		return
+	case errors.Is(err, services.ErrBinaryNotSeen):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The machine has not reported executing this binary"})
+		return
+	case errors.As(err, &verrs):
+		respondValidationErrors(c, verrs)
+		return
+	case errors.As(err, &applies):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":            "An existing rule already applies this policy",
+			"existing_rule_id": applies.RuleID,
+		})
+		return
+	case err != nil:
+		log.Printf("Failed to request access for %s: %v", hash, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request access"})
+		return
+	}
+
+	status := http.StatusCreated
+	msg := "Access request created"
+	if request.Joined {
+		status = http.StatusOK
+		msg = "Joined existing access request"
+	}
+	response := gin.H{
+		"proposal_id": request.ProposalID,
+		"joined":      request.Joined,
+		"identifier":  request.Target.Identifier,
+		"rule_type":   request.Target.RuleType,
+		"message":     msg,
+	}
+	if len(request.ConflictingRules) > 0 {
+		response["conflicting_rules"] = request.ConflictingRules
+		response["warning"] = "An existing rule applies the opposite policy; approval will require superseding it"
+	}
+	c.JSON(status, response)
+}
+
+// requestTargets lists the rule targets a user may request for a binary,
+// suggested target first
+func requestTargets(lookup *models.BinaryLookup) []models.RuleTarget {
+	targets := []models.RuleTarget{lookup.Suggested}
+	add := func(identifier, ruleType string) {
+		if identifier != "" && ruleType != lookup.Suggested.RuleType {
+			targets = append(targets, models.RuleTarget{Identifier: identifier, RuleType: ruleType})
+		}
+	}
+	add(lookup.Identifier, string(models.RuleTypeBinary))
+	if lookup.SigningID != nil {
+		teamID := ""
+		if lookup.TeamID != nil {
+			teamID = *lookup.TeamID
+		}
+		add(services.SigningIdentifier(*lookup.SigningID, teamID), string(models.RuleTypeSigningID))
+	}
+	if lookup.CertSHA256 != nil {
+		add(*lookup.CertSHA256, string(models.RuleTypeCertificate))
+	}
+	return targets
+}
+
+var blockedPageTemplate = template.Must(template.New("blocked").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Blocked application - Krampus</title>
+<link rel="icon" href="/krampus.svg">
+<style>
+  body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif; background: #121212; color: #e0e0e0; margin: 0; }
+  main { max-width: 760px; margin: 40px auto; padding: 0 20px; }
+  h1 { display: flex; align-items: center; gap: 12px; font-weight: 500; }
+  h1 img { width: 40px; }
+  section { background: #1e1e1e; border-radius: 8px; padding: 16px 20px; margin-bottom: 16px; }
+  dl { display: grid; grid-template-columns: 160px 1fr; gap: 6px 12px; margin: 0; }
+  dt { color: #9e9e9e; }
+  dd { margin: 0; word-break: break-all; }
+  code { font-size: 0.9em; }
+  .status { display: inline-block; padding: 2px 10px; border-radius: 12px; background: #424242; font-weight: 600; }
+  .ALLOWLISTED { background: #2e7d32; } .BLOCKLISTED { background: #c62828; } .PENDING_REVIEW { background: #ef6c00; }
+  button, .button { background: #90caf9; color: #121212; border: 0; border-radius: 4px; padding: 8px 16px; font-weight: 600; cursor: pointer; text-decoration: none; }
+  select, input { background: #2c2c2c; color: #e0e0e0; border: 1px solid #555; border-radius: 4px; padding: 6px; }
+  ul { padding-left: 20px; }
+  #result { margin-top: 12px; }
+</style>
+</head>
+<body>
+<main>
+  <h1><img src="/krampus.svg" alt="">Application blocked</h1>
+  {{if not .Hash}}
+  <section><p>No binary hash was provided.</p></section>
+  {{else if not .SignedIn}}
+  <section>
+    <p>Santa blocked the binary <code>{{.Hash}}</code>{{if .MachineID}} on <code>{{.MachineID}}</code>{{end}}.</p>
+    <p>Sign in to see its status and request access.</p>
+    <p><a class="button" href="{{.LoginURL}}">Sign in</a></p>
+  </section>
+  {{else if .Error}}
+  <section><p>{{.Error}}</p></section>
+  {{else}}{{with .Lookup}}
+  <section>
+    <p>Status: <span class="status {{.Status}}">{{.Status}}</span></p>
+    <dl>
+      {{if .BundleName}}<dt>Application</dt><dd>{{.BundleName}}</dd>{{end}}
+      {{if .FilePath}}<dt>Path</dt><dd><code>{{.FilePath}}</code></dd>{{end}}
+      <dt>SHA-256</dt><dd><code>{{.Identifier}}</code></dd>
+      {{if .BundleID}}<dt>Bundle ID</dt><dd>{{.BundleID}}</dd>{{end}}
+      {{if .SigningID}}<dt>Signing ID</dt><dd>{{.SigningID}}</dd>{{end}}
+      {{if .TeamID}}<dt>Team ID</dt><dd>{{.TeamID}}</dd>{{end}}
+      {{if .CertCN}}<dt>Certificate</dt><dd>{{.CertCN}}</dd>{{end}}
+      {{if .Seen}}<dt>Seen</dt><dd>{{.ExecutionCount}} executions ({{.BlockCount}} blocked) on {{.MachineCount}} machines</dd>{{end}}
+    </dl>
+  </section>
+  {{if .Rules}}
+  <section>
+    <h3>Rules</h3>
+    <ul>{{range .Rules}}<li>{{.Policy}} {{.RuleType}} <code>{{.Identifier}}</code></li>{{end}}</ul>
+  </section>
+  {{end}}
+  {{if .Proposals}}
+  <section>
+    <h3>Proposals</h3>
+    <ul>{{range .Proposals}}<li><a href="/proposals?hash={{$.Hash}}">#{{.ID}}</a> {{.Status}}: {{.ProposedPolicy}} {{.RuleType}} <code>{{.Identifier}}</code> ({{.AllowlistVotes}} allow / {{.BlocklistVotes}} block votes)</li>{{end}}</ul>
+  </section>
+  {{end}}
+  {{end}}
+  {{if ne .Lookup.Status "ALLOWLISTED"}}
+  <section>
+    <h3>Request access</h3>
+    <p>
+      <select id="rule-type">{{range .Targets}}<option value="{{.RuleType}}">{{.RuleType}}: {{.Identifier}}</option>{{end}}</select>
+    </p>
+    <p><input id="message" size="50" placeholder="Why do you need this application? (optional)"></p>
+    <button id="request">Request access</button>
+    <div id="result"></div>
+  </section>
+  {{end}}
+  {{end}}
+</main>
+{{if .Lookup}}
+<script>
+  const button = document.getElementById('request');
+  if (button) {
+    button.addEventListener('click', async () => {
+      button.disabled = true;
+      const message = document.getElementById('message').value.trim();
+      const body = { rule_type: document.getElementById('rule-type').value, machine_id: {{.MachineID}} };
+      if (message) body.message = message;
+      const result = document.getElementById('result');
+      try {
+        const response = await fetch('/api/blocked/' + encodeURIComponent({{.Hash}}) + '/request', {
+          method: 'POST',
+          credentials: 'same-origin',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify(body),
+        });
+        const data = await response.json();
+        if (!response.ok) throw new Error(data.error || 'Request failed');
+        result.textContent = data.message + ' (proposal #' + data.proposal_id + ')';
+      } catch (err) {
+        result.textContent = err.message;
+        button.disabled = false;
+      }
+    });
+  }
+</script>
+{{end}}
+</body>
+</html>
+`))
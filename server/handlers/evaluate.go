@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"errors"
+	"krampus/server/models"
+	"krampus/server/services"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EvaluateBinary reports the decision Santa would make for a binary under
+// the current ruleset, and the rule behind it. With a machine_id the
+// machine's groups and client mode are taken into account.
+func EvaluateBinary(c *gin.Context) {
+	var input struct {
+		models.BinaryAttributes
+		MachineID string `json:"machine_id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if errs := services.NormalizeBinaryAttributes(&input.BinaryAttributes); len(errs) > 0 {
+		respondValidationErrors(c, errs)
+		return
+	}
+
+	evaluation, err := services.EvaluateBinary(input.BinaryAttributes, input.MachineID)
+	if errors.Is(err, services.ErrMachineNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Machine not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to evaluate binary: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to evaluate binary"})
+		return
+	}
+
+	c.JSON(http.StatusOK, evaluation)
+}
@@ -3,8 +3,11 @@ package handlers
 import (
 	"krampus/server/database"
 	"krampus/server/models"
+	"krampus/server/services"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -21,8 +24,8 @@ func ListEvents(c *gin.Context) {
 
 	query := `SELECT id, machine_id, file_hash, file_path, decision,
 	                 executing_user, cert_sha256, cert_cn, bundle_id, bundle_name,
-	                 bundle_path, signing_id, team_id, quarantine_data_url,
-	                 quarantine_timestamp, execution_time
+	                 bundle_path, signing_id, team_id, cdhash, quarantine_data_url,
+	                 quarantine_timestamp, execution_time, rule_id
 	          FROM events WHERE 1=1`
 	args := []interface{}{}
 
@@ -52,14 +55,29 @@ func ListEvents(c *gin.Context) {
 			&event.ID, &event.MachineID, &event.FileHash, &event.FilePath,
 			&event.Decision, &event.ExecutingUser, &event.CertSHA256,
 			&event.CertCN, &event.BundleID, &event.BundleName, &event.BundlePath,
-			&event.SigningID, &event.TeamID, &event.QuarantineDataURL,
-			&event.QuarantineTimestamp, &event.ExecutionTime,
+			&event.SigningID, &event.TeamID, &event.CDHash, &event.QuarantineDataURL,
+			&event.QuarantineTimestamp, &event.ExecutionTime, &event.RuleID,
 		)
 		if err != nil {
 			continue
 		}
 		events = append(events, event)
 	}
+	rows.Close()
+
+	hashes := make([]string, 0, len(events))
+	for _, event := range events {
+		hashes = append(hashes, event.FileHash)
+	}
+	reputations, err := services.CachedReputations(hashes)
+	if err != nil {
+		log.Printf("Failed to fetch reputations: %v", err)
+	}
+	for i := range events {
+		if r, ok := reputations[strings.ToLower(events[i].FileHash)]; ok {
+			events[i].Reputation = &r
+		}
+	}
 
 	// Get total count
 	countQuery := "SELECT COUNT(*) FROM events WHERE 1=1"
@@ -122,6 +140,8 @@ func ListPrograms(c *gin.Context) {
 		AllowCount     int     `json:"allow_count"`
 		BlockCount     int     `json:"block_count"`
 		LastSeen       string  `json:"last_seen"`
+
+		Reputation *models.Reputation `json:"reputation,omitempty"` // Cached reputation of the binary
 	}
 
 	programs := []Program{}
@@ -137,6 +157,21 @@ func ListPrograms(c *gin.Context) {
 		}
 		programs = append(programs, p)
 	}
+	rows.Close()
+
+	hashes := make([]string, 0, len(programs))
+	for _, p := range programs {
+		hashes = append(hashes, p.FileHash)
+	}
+	reputations, err := services.CachedReputations(hashes)
+	if err != nil {
+		log.Printf("Failed to fetch reputations: %v", err)
+	}
+	for i := range programs {
+		if r, ok := reputations[strings.ToLower(programs[i].FileHash)]; ok {
+			programs[i].Reputation = &r
+		}
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"programs": programs,
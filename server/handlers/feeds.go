@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"errors"
+	"krampus/server/middleware"
+	"krampus/server/models"
+	"krampus/server/services"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListFeeds returns every threat feed with its rule counts and last run
+func ListFeeds(c *gin.Context) {
+	feeds, err := services.ListFeeds()
+	if err != nil {
+		log.Printf("Failed to list threat feeds: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch threat feeds"})
+		return
+	}
+
+	c.JSON(http.StatusOK, feeds)
+}
+
+// GetFeed returns a threat feed and its recent runs (?limit=, default 20)
+func GetFeed(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid feed ID"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+		return
+	}
+
+	feed, err := services.GetFeed(id)
+	if err != nil {
+		respondFeedError(c, err, "fetch")
+		return
+	}
+	runs, err := services.ListFeedRuns(id, limit)
+	if err != nil {
+		respondFeedError(c, err, "fetch")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"feed": feed, "runs": runs})
+}
+
+// CreateFeed adds a threat feed (admin only)
+func CreateFeed(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var input struct {
+		Name            string `json:"name"`
+		URL             string `json:"url"`
+		Format          string `json:"format"`
+		RuleType        string `json:"rule_type"`
+		IntervalMinutes int    `json:"interval_minutes"`
+		Enabled         *bool  `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	feed := services.FeedInput{
+		Name:            strings.TrimSpace(input.Name),
+		URL:             strings.TrimSpace(input.URL),
+		Format:          strings.ToUpper(input.Format),
+		RuleType:        strings.ToUpper(input.RuleType),
+		IntervalMinutes: input.IntervalMinutes,
+		Enabled:         input.Enabled,
+	}
+	if feed.RuleType == "" {
+		feed.RuleType = string(models.RuleTypeBinary)
+	}
+	if feed.IntervalMinutes == 0 {
+		feed.IntervalMinutes = 60
+	}
+	if errs := services.ValidateFeed(feed.Name, feed.URL, feed.Format, feed.RuleType, feed.IntervalMinutes); len(errs) > 0 {
+		respondValidationErrors(c, errs)
+		return
+	}
+
+	id, err := services.CreateFeed(feed, userID)
+	if err != nil {
+		respondFeedError(c, err, "create")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"id": id, "message": "Threat feed created successfully"})
+}
+
+// UpdateFeed changes a threat feed's settings (admin only)
+func UpdateFeed(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid feed ID"})
+		return
+	}
+
+	var input struct {
+		Name            *string `json:"name"`
+		URL             *string `json:"url"`
+		Format          *string `json:"format"`
+		RuleType        *string `json:"rule_type"`
+		IntervalMinutes *int    `json:"interval_minutes"`
+		Enabled         *bool   `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	feed, err := services.UpdateFeed(id, services.FeedUpdate{
+		Name:            input.Name,
+		URL:             input.URL,
+		Format:          input.Format,
+		RuleType:        input.RuleType,
+		IntervalMinutes: input.IntervalMinutes,
+		Enabled:         input.Enabled,
+	})
+	if err != nil {
+		respondFeedError(c, err, "update")
+		return
+	}
+
+	c.JSON(http.StatusOK, feed)
+}
+
+// DeleteFeed deletes a threat feed and retires its rules (admin only)
+func DeleteFeed(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid feed ID"})
+		return
+	}
+
+	if err := services.DeleteFeed(id, userID); err != nil {
+		respondFeedError(c, err, "delete")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Threat feed deleted successfully"})
+}
+
+// RunFeed fetches a threat feed now and applies it to the ruleset (admin
+// only). A feed that can't be fetched or parsed is reported as a FAILED run.
+func RunFeed(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid feed ID"})
+		return
+	}
+
+	run, err := services.RunFeed(id, models.FeedRunTriggerManual)
+	if err != nil {
+		respondFeedError(c, err, "run")
+		return
+	}
+
+	c.JSON(http.StatusOK, run)
+}
+
+// respondFeedError maps threat feed errors to responses
+func respondFeedError(c *gin.Context, err error, action string) {
+	var errs services.ValidationErrors
+	switch {
+	case errors.Is(err, services.ErrFeedNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Threat feed not found"})
+	case errors.Is(err, services.ErrFeedNameTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": "A threat feed with this name already exists"})
+	case errors.As(err, &errs):
+		respondValidationErrors(c, errs)
+	default:
+		log.Printf("Failed to %s threat feed: %v", action, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action + " threat feed"})
+	}
+}
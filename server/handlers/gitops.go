@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"errors"
+	"krampus/server/config"
+	"krampus/server/models"
+	"krampus/server/services"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListRuleReconciles returns recent reconciles of the rules directory,
+// newest first
+func ListRuleReconciles(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if limit < 1 {
+		limit = 20
+	}
+
+	reconciles, err := services.ListRuleReconciles(limit)
+	if err != nil {
+		log.Printf("Failed to list reconciles: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch reconciles"})
+		return
+	}
+
+	c.JSON(http.StatusOK, reconciles)
+}
+
+// GetRuleReconcile returns one reconcile report
+func GetRuleReconcile(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reconcile ID"})
+		return
+	}
+
+	reconcile, err := services.GetRuleReconcile(id)
+	if errors.Is(err, services.ErrReconcileNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reconcile not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to fetch reconcile: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch reconcile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, reconcile)
+}
+
+// ReconcileRules reconciles the rules directory now (admin only). With
+// ?dry_run=true the report shows what would change without applying it.
+func ReconcileRules(c *gin.Context) {
+	if config.AppConfig.RulesDir == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No rules directory is configured (RULES_DIR)"})
+		return
+	}
+	dryRun := c.Query("dry_run") == "true"
+
+	report, err := services.ReconcileRulesDir(config.AppConfig.RulesDir, models.ReconcileTriggerManual, dryRun)
+	if err != nil {
+		log.Printf("Failed to reconcile rules directory: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reconcile rules directory"})
+		return
+	}
+
+	status := http.StatusOK
+	if report.Status == string(models.ReconcileStatusFailed) {
+		status = http.StatusUnprocessableEntity
+	}
+	c.JSON(status, report)
+}
@@ -0,0 +1,383 @@
+package handlers
+
+import (
+	"krampus/server/database"
+	"krampus/server/models"
+	"krampus/server/services"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListGroups returns all machine groups with member and rule counts
+func ListGroups(c *gin.Context) {
+	rows, err := database.DB.Query(
+		`SELECT g.id, g.name, g.description, g.custom_message, g.custom_url, g.owner_team, g.created_at,
+		        (SELECT COUNT(*) FROM machine_group_members m WHERE m.group_id = g.id),
+		        (SELECT COUNT(*) FROM rules r WHERE r.group_id = g.id AND r.` + services.ActiveRule + `)
+		 FROM machine_groups g ORDER BY g.name`,
+	)
+	if err != nil {
+		log.Printf("Failed to query groups: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch groups"})
+		return
+	}
+	defer rows.Close()
+
+	groups := []models.MachineGroup{}
+	for rows.Next() {
+		var g models.MachineGroup
+		if err := rows.Scan(
+			&g.ID, &g.Name, &g.Description, &g.CustomMessage, &g.CustomURL, &g.OwnerTeam,
+			&g.CreatedAt, &g.MemberCount, &g.RuleCount,
+		); err != nil {
+			log.Printf("Failed to scan group: %v", err)
+			continue
+		}
+		groups = append(groups, g)
+	}
+
+	c.JSON(http.StatusOK, groups)
+}
+
+// GetGroup returns a machine group and its members
+func GetGroup(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	var g models.MachineGroup
+	err = database.DB.QueryRow(
+		`SELECT g.id, g.name, g.description, g.custom_message, g.custom_url, g.owner_team, g.created_at,
+		        (SELECT COUNT(*) FROM rules r WHERE r.group_id = g.id AND r.`+services.ActiveRule+`)
+		 FROM machine_groups g WHERE g.id = ?`,
+		id,
+	).Scan(&g.ID, &g.Name, &g.Description, &g.CustomMessage, &g.CustomURL, &g.OwnerTeam, &g.CreatedAt, &g.RuleCount)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+		return
+	}
+
+	rows, err := database.DB.Query(
+		`SELECT m.machine_id, mc.hostname, m.added_at
+		 FROM machine_group_members m
+		 LEFT JOIN machines mc ON mc.machine_id = m.machine_id
+		 WHERE m.group_id = ?
+		 ORDER BY m.machine_id`,
+		id,
+	)
+	if err != nil {
+		log.Printf("Failed to query group members: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch group members"})
+		return
+	}
+	defer rows.Close()
+
+	members := []models.MachineGroupMember{}
+	for rows.Next() {
+		var m models.MachineGroupMember
+		if err := rows.Scan(&m.MachineID, &m.Hostname, &m.AddedAt); err != nil {
+			log.Printf("Failed to scan group member: %v", err)
+			continue
+		}
+		members = append(members, m)
+	}
+	g.MemberCount = len(members)
+
+	c.JSON(http.StatusOK, gin.H{
+		"group":   g,
+		"members": members,
+	})
+}
+
+// CreateGroup creates a machine group (admin only). The group's message
+// templates and owner team apply to its rules that don't set their own.
+func CreateGroup(c *gin.Context) {
+	var input struct {
+		Name          string  `json:"name" binding:"required"`
+		Description   *string `json:"description"`
+		CustomMessage *string `json:"custom_message"`
+		CustomURL     *string `json:"custom_url"`
+		OwnerTeam     *string `json:"owner_team"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	input.Name = strings.TrimSpace(input.Name)
+	errs := services.ValidateMessageTemplates(input.CustomMessage, input.CustomURL)
+	if input.Name == "" {
+		errs = append(services.ValidationErrors{{Field: "name", Message: "must not be empty"}}, errs...)
+	}
+	if len(errs) > 0 {
+		respondValidationErrors(c, errs)
+		return
+	}
+
+	var existing int
+	if err := database.DB.QueryRow(
+		`SELECT COUNT(*) FROM machine_groups WHERE name = ?`, input.Name,
+	).Scan(&existing); err != nil {
+		log.Printf("Failed to check group name: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create group"})
+		return
+	}
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "A group with this name already exists"})
+		return
+	}
+
+	result, err := database.DB.Exec(
+		`INSERT INTO machine_groups (name, description, custom_message, custom_url, owner_team)
+		 VALUES (?, ?, ?, ?, ?)`,
+		input.Name, input.Description, nullIfBlank(input.CustomMessage), nullIfBlank(input.CustomURL),
+		nullIfBlank(input.OwnerTeam),
+	)
+	if err != nil {
+		log.Printf("Failed to create group: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create group"})
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	c.JSON(http.StatusCreated, gin.H{
+		"id":      id,
+		"message": "Group created successfully",
+	})
+}
+
+// UpdateGroup changes a machine group's description, message templates and
+// owner team (admin only). Omitted fields are left alone and empty ones
+// removed; rules pick up the changes on their machines' next rule download.
+func UpdateGroup(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	var input struct {
+		Description   *string `json:"description"`
+		CustomMessage *string `json:"custom_message"`
+		CustomURL     *string `json:"custom_url"`
+		OwnerTeam     *string `json:"owner_team"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errs := services.ValidateMessageTemplates(input.CustomMessage, input.CustomURL); len(errs) > 0 {
+		respondValidationErrors(c, errs)
+		return
+	}
+
+	var sets []string
+	var args []interface{}
+	for _, field := range []struct {
+		column string
+		value  *string
+	}{
+		{"description", input.Description},
+		{"custom_message", input.CustomMessage},
+		{"custom_url", input.CustomURL},
+		{"owner_team", input.OwnerTeam},
+	} {
+		if field.value != nil {
+			sets = append(sets, field.column+" = ?")
+			args = append(args, nullIfBlank(field.value))
+		}
+	}
+
+	exists, err := services.GroupExists(id)
+	if err != nil {
+		log.Printf("Failed to fetch group: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update group"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+		return
+	}
+
+	if len(sets) > 0 {
+		_, err = database.DB.Exec(
+			`UPDATE machine_groups SET `+strings.Join(sets, ", ")+` WHERE id = ?`, append(args, id)...,
+		)
+		if err != nil {
+			log.Printf("Failed to update group: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update group"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Group updated successfully"})
+}
+
+// nullIfBlank stores blank optional text as NULL
+func nullIfBlank(s *string) *string {
+	if s == nil || strings.TrimSpace(*s) == "" {
+		return nil
+	}
+	return s
+}
+
+// DeleteGroup deletes a machine group (admin only). Groups that active rules
+// are scoped to cannot be deleted.
+func DeleteGroup(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	var ruleCount int
+	err = database.DB.QueryRow(
+		`SELECT COUNT(*) FROM rules WHERE group_id = ? AND `+services.ActiveRule, id,
+	).Scan(&ruleCount)
+	if err != nil {
+		log.Printf("Failed to count group rules: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete group"})
+		return
+	}
+	if ruleCount > 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":      "Group still has active rules scoped to it",
+			"rule_count": ruleCount,
+		})
+		return
+	}
+
+	var rolloutCount int
+	err = database.DB.QueryRow(
+		`SELECT COUNT(DISTINCT o.id) FROM rule_rollouts o
+		 JOIN rule_rollout_stages s ON s.rollout_id = o.id
+		 WHERE s.group_id = ? AND o.status IN ('ACTIVE', 'HALTED')`, id,
+	).Scan(&rolloutCount)
+	if err != nil {
+		log.Printf("Failed to count group rollouts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete group"})
+		return
+	}
+	if rolloutCount > 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":         "Group is a stage of unfinished rule rollouts",
+			"rollout_count": rolloutCount,
+		})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete group"})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM machine_group_members WHERE group_id = ?`, id); err != nil {
+		log.Printf("Failed to delete group members: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete group"})
+		return
+	}
+	result, err := tx.Exec(`DELETE FROM machine_groups WHERE id = ?`, id)
+	if err != nil {
+		log.Printf("Failed to delete group: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete group"})
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Failed to commit group deletion: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete group"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Group deleted successfully"})
+}
+
+// AddGroupMember adds an enrolled machine to a group (admin only)
+func AddGroupMember(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	var input struct {
+		MachineID string `json:"machine_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	exists, err := services.GroupExists(id)
+	if err != nil {
+		log.Printf("Failed to fetch group: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add group member"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+		return
+	}
+
+	var machineCount int
+	if err := database.DB.QueryRow(
+		`SELECT COUNT(*) FROM machines WHERE machine_id = ?`, input.MachineID,
+	).Scan(&machineCount); err != nil {
+		log.Printf("Failed to fetch machine: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add group member"})
+		return
+	}
+	if machineCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Machine not found"})
+		return
+	}
+
+	_, err = database.DB.Exec(
+		`INSERT INTO machine_group_members (group_id, machine_id) VALUES (?, ?)
+		 ON CONFLICT(group_id, machine_id) DO NOTHING`,
+		id, input.MachineID,
+	)
+	if err != nil {
+		log.Printf("Failed to add group member: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add group member"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Machine added to group"})
+}
+
+// RemoveGroupMember removes a machine from a group (admin only)
+func RemoveGroupMember(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	result, err := database.DB.Exec(
+		`DELETE FROM machine_group_members WHERE group_id = ? AND machine_id = ?`, id, c.Param("machine_id"),
+	)
+	if err != nil {
+		log.Printf("Failed to remove group member: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove group member"})
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Machine is not a member of this group"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Machine removed from group"})
+}
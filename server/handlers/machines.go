@@ -160,6 +160,16 @@ func DeleteMachine(c *gin.Context) {
 		return
 	}
 
+	// Foreign keys aren't enforced, so drop group memberships explicitly
+	_, err = database.DB.Exec(
+		`DELETE FROM machine_group_members WHERE machine_id = (SELECT machine_id FROM machines WHERE id = ?)`, id,
+	)
+	if err != nil {
+		log.Printf("Failed to delete machine group memberships: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete machine"})
+		return
+	}
+
 	result, err := database.DB.Exec(`DELETE FROM machines WHERE id = ?`, id)
 	if err != nil {
 		log.Printf("Failed to delete machine: %v", err)
@@ -0,0 +1,440 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"krampus/server/database"
+	"krampus/server/middleware"
+	"krampus/server/models"
+	"krampus/server/services"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxCommentLength bounds comment bodies and evidence notes
+const maxCommentLength = 10000
+
+// ListProposalComments returns a proposal's discussion thread, oldest first
+func ListProposalComments(c *gin.Context) {
+	proposalID, ok := proposalIDParam(c)
+	if !ok {
+		return
+	}
+
+	rows, err := database.DB.Query(
+		`SELECT pc.id, pc.proposal_id, pc.user_id, COALESCE(u.username, ''), pc.body,
+		        (SELECT COUNT(*) FROM proposal_comment_edits e WHERE e.comment_id = pc.id),
+		        pc.created_at, pc.updated_at
+		 FROM proposal_comments pc
+		 LEFT JOIN users u ON pc.user_id = u.id
+		 WHERE pc.proposal_id = ?
+		 ORDER BY pc.created_at ASC, pc.id ASC`,
+		proposalID,
+	)
+	if err != nil {
+		log.Printf("Failed to query proposal comments: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch comments"})
+		return
+	}
+	defer rows.Close()
+
+	comments := []models.ProposalComment{}
+	for rows.Next() {
+		var pc models.ProposalComment
+		err := rows.Scan(
+			&pc.ID, &pc.ProposalID, &pc.UserID, &pc.Username, &pc.Body,
+			&pc.EditCount, &pc.CreatedAt, &pc.UpdatedAt,
+		)
+		if err != nil {
+			log.Printf("Failed to scan proposal comment: %v", err)
+			continue
+		}
+		comments = append(comments, pc)
+	}
+
+	c.JSON(http.StatusOK, comments)
+}
+
+// CreateProposalComment adds a comment to a proposal's discussion thread
+func CreateProposalComment(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	proposalID, ok := proposalIDParam(c)
+	if !ok || !requireProposal(c, proposalID) {
+		return
+	}
+
+	body, ok := commentBody(c)
+	if !ok {
+		return
+	}
+
+	result, err := database.DB.Exec(
+		`INSERT INTO proposal_comments (proposal_id, user_id, body) VALUES (?, ?, ?)`,
+		proposalID, userID, body,
+	)
+	if err != nil {
+		log.Printf("Failed to create proposal comment: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create comment"})
+		return
+	}
+
+	commentID, _ := result.LastInsertId()
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":      commentID,
+		"message": "Comment added successfully",
+	})
+}
+
+// UpdateProposalComment edits a comment (author only), keeping its history
+func UpdateProposalComment(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	proposalID, ok := proposalIDParam(c)
+	if !ok {
+		return
+	}
+	commentID, err := strconv.ParseInt(c.Param("comment_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comment ID"})
+		return
+	}
+
+	body, ok := commentBody(c)
+	if !ok {
+		return
+	}
+
+	err = services.EditComment(proposalID, commentID, userID, body)
+	switch {
+	case errors.Is(err, services.ErrCommentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
+		return
+	case errors.Is(err, services.ErrNotCommentAuthor):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		log.Printf("Failed to edit proposal comment: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update comment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Comment updated successfully"})
+}
+
+// GetProposalCommentHistory returns the previous bodies of a comment, newest first
+func GetProposalCommentHistory(c *gin.Context) {
+	proposalID, ok := proposalIDParam(c)
+	if !ok {
+		return
+	}
+	commentID, err := strconv.ParseInt(c.Param("comment_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comment ID"})
+		return
+	}
+
+	rows, err := database.DB.Query(
+		`SELECT e.id, e.comment_id, e.previous_body, e.edited_by, COALESCE(u.username, ''), e.edited_at
+		 FROM proposal_comment_edits e
+		 JOIN proposal_comments pc ON e.comment_id = pc.id
+		 LEFT JOIN users u ON e.edited_by = u.id
+		 WHERE e.comment_id = ? AND pc.proposal_id = ?
+		 ORDER BY e.edited_at DESC, e.id DESC`,
+		commentID, proposalID,
+	)
+	if err != nil {
+		log.Printf("Failed to query comment history: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch comment history"})
+		return
+	}
+	defer rows.Close()
+
+	edits := []models.ProposalCommentEdit{}
+	for rows.Next() {
+		var e models.ProposalCommentEdit
+		if err := rows.Scan(&e.ID, &e.CommentID, &e.PreviousBody, &e.EditedBy, &e.EditedByName, &e.EditedAt); err != nil {
+			log.Printf("Failed to scan comment edit: %v", err)
+			continue
+		}
+		edits = append(edits, e)
+	}
+
+	c.JSON(http.StatusOK, edits)
+}
+
+// DeleteProposalComment deletes a comment (author or admin only)
+func DeleteProposalComment(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	role, _ := middleware.GetRole(c)
+	proposalID, ok := proposalIDParam(c)
+	if !ok {
+		return
+	}
+	commentID, err := strconv.ParseInt(c.Param("comment_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comment ID"})
+		return
+	}
+
+	var authorID *int64
+	err = database.DB.QueryRow(
+		`SELECT user_id FROM proposal_comments WHERE id = ? AND proposal_id = ?`,
+		commentID, proposalID,
+	).Scan(&authorID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch comment"})
+		return
+	}
+
+	isAuthor := authorID != nil && *authorID == userID
+	if !isAuthor && role != string(models.RoleAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the author or admin can delete this comment"})
+		return
+	}
+
+	// Remove the edit history explicitly; foreign keys are not enforced
+	if _, err := database.DB.Exec(`DELETE FROM proposal_comment_edits WHERE comment_id = ?`, commentID); err != nil {
+		log.Printf("Failed to delete comment history: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete comment"})
+		return
+	}
+	if _, err := database.DB.Exec(`DELETE FROM proposal_comments WHERE id = ?`, commentID); err != nil {
+		log.Printf("Failed to delete proposal comment: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete comment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Comment deleted successfully"})
+}
+
+// ListProposalEvidence returns the events, machines and tickets linked to a proposal
+func ListProposalEvidence(c *gin.Context) {
+	proposalID, ok := proposalIDParam(c)
+	if !ok {
+		return
+	}
+
+	rows, err := database.DB.Query(
+		`SELECT pe.id, pe.proposal_id, pe.evidence_type, pe.reference, pe.note,
+		        pe.created_by, COALESCE(u.username, ''), pe.created_at
+		 FROM proposal_evidence pe
+		 LEFT JOIN users u ON pe.created_by = u.id
+		 WHERE pe.proposal_id = ?
+		 ORDER BY pe.created_at ASC, pe.id ASC`,
+		proposalID,
+	)
+	if err != nil {
+		log.Printf("Failed to query proposal evidence: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch evidence"})
+		return
+	}
+	defer rows.Close()
+
+	evidence := []models.ProposalEvidence{}
+	for rows.Next() {
+		var e models.ProposalEvidence
+		err := rows.Scan(
+			&e.ID, &e.ProposalID, &e.EvidenceType, &e.Reference, &e.Note,
+			&e.CreatedBy, &e.CreatorName, &e.CreatedAt,
+		)
+		if err != nil {
+			log.Printf("Failed to scan proposal evidence: %v", err)
+			continue
+		}
+		evidence = append(evidence, e)
+	}
+
+	c.JSON(http.StatusOK, evidence)
+}
+
+// AddProposalEvidence links an event, machine or external ticket to a proposal.
+// Event and machine references must exist on this server.
+func AddProposalEvidence(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	proposalID, ok := proposalIDParam(c)
+	if !ok || !requireProposal(c, proposalID) {
+		return
+	}
+
+	var input struct {
+		EvidenceType string  `json:"evidence_type" binding:"required"`
+		Reference    string  `json:"reference" binding:"required"`
+		Note         *string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	input.Reference = strings.TrimSpace(input.Reference)
+	if input.Note != nil && len(*input.Note) > maxCommentLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Note is too long"})
+		return
+	}
+
+	var count int
+	var err error
+	switch input.EvidenceType {
+	case string(models.EvidenceTypeEvent):
+		eventID, parseErr := strconv.ParseInt(input.Reference, 10, 64)
+		if parseErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Event references must be event IDs"})
+			return
+		}
+		err = database.DB.QueryRow(`SELECT COUNT(*) FROM events WHERE id = ?`, eventID).Scan(&count)
+	case string(models.EvidenceTypeMachine):
+		err = database.DB.QueryRow(`SELECT COUNT(*) FROM machines WHERE machine_id = ?`, input.Reference).Scan(&count)
+	case string(models.EvidenceTypeTicket):
+		count = 1
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid evidence type. Must be EVENT, MACHINE or TICKET"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to validate evidence reference: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add evidence"})
+		return
+	}
+	if count == 0 || input.Reference == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Referenced " + strings.ToLower(input.EvidenceType) + " not found"})
+		return
+	}
+
+	result, err := database.DB.Exec(
+		`INSERT INTO proposal_evidence (proposal_id, evidence_type, reference, note, created_by)
+		 VALUES (?, ?, ?, ?, ?)
+		 ON CONFLICT(proposal_id, evidence_type, reference) DO NOTHING`,
+		proposalID, input.EvidenceType, input.Reference, input.Note, userID,
+	)
+	if err != nil {
+		log.Printf("Failed to add proposal evidence: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add evidence"})
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Evidence is already linked to this proposal"})
+		return
+	}
+
+	evidenceID, _ := result.LastInsertId()
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":      evidenceID,
+		"message": "Evidence added successfully",
+	})
+}
+
+// DeleteProposalEvidence unlinks evidence from a proposal (creator or admin only)
+func DeleteProposalEvidence(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	role, _ := middleware.GetRole(c)
+	proposalID, ok := proposalIDParam(c)
+	if !ok {
+		return
+	}
+	evidenceID, err := strconv.ParseInt(c.Param("evidence_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid evidence ID"})
+		return
+	}
+
+	var createdBy *int64
+	err = database.DB.QueryRow(
+		`SELECT created_by FROM proposal_evidence WHERE id = ? AND proposal_id = ?`,
+		evidenceID, proposalID,
+	).Scan(&createdBy)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Evidence not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch evidence"})
+		return
+	}
+
+	isCreator := createdBy != nil && *createdBy == userID
+	if !isCreator && role != string(models.RoleAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the creator or admin can remove this evidence"})
+		return
+	}
+
+	if _, err := database.DB.Exec(`DELETE FROM proposal_evidence WHERE id = ?`, evidenceID); err != nil {
+		log.Printf("Failed to delete proposal evidence: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove evidence"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Evidence removed successfully"})
+}
+
+// proposalIDParam parses the :id route parameter, responding with 400 if invalid
+func proposalIDParam(c *gin.Context) (int64, bool) {
+	proposalID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid proposal ID"})
+		return 0, false
+	}
+	return proposalID, true
+}
+
+// requireProposal responds with 404 unless the proposal exists
+func requireProposal(c *gin.Context, proposalID int64) bool {
+	var count int
+	if err := database.DB.QueryRow(`SELECT COUNT(*) FROM proposals WHERE id = ?`, proposalID).Scan(&count); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch proposal"})
+		return false
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Proposal not found"})
+		return false
+	}
+	return true
+}
+
+// commentBody binds and validates a {"body": "..."} request
+func commentBody(c *gin.Context) (string, bool) {
+	var input struct {
+		Body string `json:"body" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", false
+	}
+	body := strings.TrimSpace(input.Body)
+	if body == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Comment body cannot be empty"})
+		return "", false
+	}
+	if len(body) > maxCommentLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Comment is too long"})
+		return "", false
+	}
+	return body, true
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"errors"
+	"krampus/server/middleware"
+	"krampus/server/models"
+	"krampus/server/services"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListProposalPolicies returns every proposal policy in evaluation order
+func ListProposalPolicies(c *gin.Context) {
+	policies, err := services.ListProposalPolicies()
+	if err != nil {
+		log.Printf("Failed to list proposal policies: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch proposal policies"})
+		return
+	}
+
+	c.JSON(http.StatusOK, policies)
+}
+
+// CreateProposalPolicy adds a proposal policy (admin only)
+func CreateProposalPolicy(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var input struct {
+		Name        string  `json:"name"`
+		Description *string `json:"description"`
+		Expression  string  `json:"expression"`
+		Action      string  `json:"action"`
+		Priority    int     `json:"priority"`
+		Enabled     *bool   `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	policy := services.ProposalPolicyInput{
+		Name:        strings.TrimSpace(input.Name),
+		Description: nullIfBlank(input.Description),
+		Expression:  input.Expression,
+		Action:      strings.ToUpper(input.Action),
+		Priority:    input.Priority,
+		Enabled:     input.Enabled,
+	}
+	if errs := services.ValidateProposalPolicy(policy.Name, policy.Expression, policy.Action); len(errs) > 0 {
+		respondValidationErrors(c, errs)
+		return
+	}
+
+	created, err := services.CreateProposalPolicy(policy, userID)
+	if err != nil {
+		respondProposalPolicyError(c, err, "create")
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// UpdateProposalPolicy changes a proposal policy (admin only)
+func UpdateProposalPolicy(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+		return
+	}
+
+	var input struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+		Expression  *string `json:"expression"`
+		Action      *string `json:"action"`
+		Priority    *int    `json:"priority"`
+		Enabled     *bool   `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	policy, err := services.UpdateProposalPolicy(id, services.ProposalPolicyUpdate{
+		Name:        input.Name,
+		Description: input.Description,
+		Expression:  input.Expression,
+		Action:      input.Action,
+		Priority:    input.Priority,
+		Enabled:     input.Enabled,
+	})
+	if err != nil {
+		respondProposalPolicyError(c, err, "update")
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+// DeleteProposalPolicy deletes a proposal policy (admin only)
+func DeleteProposalPolicy(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+		return
+	}
+
+	if err := services.DeleteProposalPolicy(id); err != nil {
+		respondProposalPolicyError(c, err, "delete")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Proposal policy deleted successfully"})
+}
+
+// EvaluateProposalPolicies reports which policy would decide a proposal for
+// a target, without opening one (admin only). requester defaults to the
+// caller.
+func EvaluateProposalPolicies(c *gin.Context) {
+	var input struct {
+		Identifier     string `json:"identifier" binding:"required"`
+		RuleType       string `json:"rule_type" binding:"required"`
+		ProposedPolicy string `json:"proposed_policy" binding:"required"`
+		Source         string `json:"source"`
+		Requester      string `json:"requester"`
+		RequesterEmail string `json:"requester_email"`
+		RequesterRole  string `json:"requester_role"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	input.Identifier = services.NormalizeIdentifier(input.RuleType, input.Identifier)
+	if errs := services.ValidateRule(input.Identifier, input.RuleType, input.ProposedPolicy, "", "proposed_policy"); len(errs) > 0 {
+		respondValidationErrors(c, errs)
+		return
+	}
+	if input.Source == "" {
+		input.Source = string(models.ProposalSourceUser)
+	}
+
+	target := models.RuleTarget{Identifier: input.Identifier, RuleType: input.RuleType}
+	policyContext, err := services.ProposalPolicyContextFor(target, input.ProposedPolicy, input.Source)
+	if err != nil {
+		log.Printf("Failed to gather proposal policy context: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to evaluate proposal policies"})
+		return
+	}
+	policyContext.Requester, policyContext.RequesterEmail, policyContext.RequesterRole = input.Requester, input.RequesterEmail, input.RequesterRole
+	if policyContext.Requester == "" {
+		policyContext.Requester, _ = middleware.GetUsername(c)
+		policyContext.RequesterRole, _ = middleware.GetRole(c)
+	}
+
+	result, err := services.EvaluateProposalPolicies(policyContext)
+	if err != nil {
+		log.Printf("Failed to evaluate proposal policies: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to evaluate proposal policies"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// respondProposalPolicyError maps proposal policy errors to responses
+func respondProposalPolicyError(c *gin.Context, err error, action string) {
+	var errs services.ValidationErrors
+	switch {
+	case errors.Is(err, services.ErrProposalPolicyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Proposal policy not found"})
+	case errors.Is(err, services.ErrProposalPolicyNameTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": "A proposal policy with this name already exists"})
+	case errors.As(err, &errs):
+		respondValidationErrors(c, errs)
+	default:
+		log.Printf("Failed to %s proposal policy: %v", action, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action + " proposal policy"})
+	}
+}
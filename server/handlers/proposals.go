@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"krampus/server/database"
 	"krampus/server/middleware"
 	"krampus/server/models"
@@ -13,30 +15,50 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// proposalSelect is the shared projection for proposals joined with their
+// creator. Proposals opened from blocked events have no creator and are
+// attributed to "santa".
+const proposalSelect = `
+	SELECT p.id, p.identifier, p.rule_type, p.proposed_policy, p.cel_expr, p.custom_message,
+	       p.created_by, p.source, p.status, p.auto_decision, p.auto_policy_id, p.auto_policy_name,
+	       p.auto_decision_note, p.conflict_rule_id, p.allowlist_votes, p.blocklist_votes,
+	       p.request_count, p.last_requested_at, p.created_at, p.finalized_at,
+	       COALESCE(u.username, 'santa'), u.email
+	FROM proposals p
+	LEFT JOIN users u ON p.created_by = u.id
+`
+
+// scanProposal scans a row produced by proposalSelect
+func scanProposal(row interface{ Scan(...interface{}) error }, p *models.ProposalWithCreator) error {
+	return row.Scan(
+		&p.ID, &p.Identifier, &p.RuleType, &p.ProposedPolicy, &p.CELExpr, &p.CustomMessage,
+		&p.CreatedBy, &p.Source, &p.Status, &p.AutoDecision, &p.AutoPolicyID, &p.AutoPolicyName,
+		&p.AutoDecisionNote, &p.ConflictRuleID, &p.AllowlistVotes, &p.BlocklistVotes,
+		&p.RequestCount, &p.LastRequestedAt, &p.CreatedAt, &p.FinalizedAt,
+		&p.CreatorUsername, &p.CreatorEmail,
+	)
+}
+
 // ListProposals returns all proposals with optional filtering
 func ListProposals(c *gin.Context) {
 	status := c.Query("status") // Filter by status if provided
+	source := c.Query("source") // Filter by source (USER or BLOCKED_EVENT)
 
-	query := `
-		SELECT p.id, p.identifier, p.rule_type, p.proposed_policy, p.custom_message,
-		       p.created_by, p.status, p.allowlist_votes, p.blocklist_votes,
-		       p.created_at, p.finalized_at,
-		       u.username, u.email
-		FROM proposals p
-		JOIN users u ON p.created_by = u.id
-	`
-
-	var rows *sql.Rows
-	var err error
+	query := proposalSelect + " WHERE 1=1"
+	args := []interface{}{}
 
 	if status != "" {
-		query += " WHERE p.status = ? ORDER BY p.created_at DESC"
-		rows, err = database.DB.Query(query, status)
-	} else {
-		query += " ORDER BY p.created_at DESC"
-		rows, err = database.DB.Query(query)
+		query += " AND p.status = ?"
+		args = append(args, status)
 	}
+	if source != "" {
+		query += " AND p.source = ?"
+		args = append(args, source)
+	}
+
+	query += " ORDER BY p.created_at DESC"
 
+	rows, err := database.DB.Query(query, args...)
 	if err != nil {
 		log.Printf("Failed to query proposals: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch proposals"})
@@ -47,18 +69,17 @@ func ListProposals(c *gin.Context) {
 	proposals := []models.ProposalWithCreator{}
 	for rows.Next() {
 		var p models.ProposalWithCreator
-		err := rows.Scan(
-			&p.ID, &p.Identifier, &p.RuleType, &p.ProposedPolicy, &p.CustomMessage,
-			&p.CreatedBy, &p.Status, &p.AllowlistVotes, &p.BlocklistVotes,
-			&p.CreatedAt, &p.FinalizedAt,
-			&p.CreatorUsername, &p.CreatorEmail,
-		)
-		if err != nil {
+		if err := scanProposal(rows, &p); err != nil {
 			log.Printf("Failed to scan proposal: %v", err)
 			continue
 		}
 		proposals = append(proposals, p)
 	}
+	rows.Close()
+
+	if err := services.EnrichProposals(proposals); err != nil {
+		log.Printf("Failed to enrich proposals: %v", err)
+	}
 
 	c.JSON(http.StatusOK, proposals)
 }
@@ -72,21 +93,7 @@ func GetProposal(c *gin.Context) {
 	}
 
 	var p models.ProposalWithCreator
-	err = database.DB.QueryRow(
-		`SELECT p.id, p.identifier, p.rule_type, p.proposed_policy, p.custom_message,
-		        p.created_by, p.status, p.allowlist_votes, p.blocklist_votes,
-		        p.created_at, p.finalized_at,
-		        u.username, u.email
-		 FROM proposals p
-		 JOIN users u ON p.created_by = u.id
-		 WHERE p.id = ?`,
-		id,
-	).Scan(
-		&p.ID, &p.Identifier, &p.RuleType, &p.ProposedPolicy, &p.CustomMessage,
-		&p.CreatedBy, &p.Status, &p.AllowlistVotes, &p.BlocklistVotes,
-		&p.CreatedAt, &p.FinalizedAt,
-		&p.CreatorUsername, &p.CreatorEmail,
-	)
+	err = scanProposal(database.DB.QueryRow(proposalSelect+" WHERE p.id = ?", id), &p)
 
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Proposal not found"})
@@ -98,6 +105,10 @@ func GetProposal(c *gin.Context) {
 		return
 	}
 
+	if err := services.EnrichProposal(&p); err != nil {
+		log.Printf("Failed to enrich proposal %d: %v", id, err)
+	}
+
 	// Get user's vote if authenticated
 	userID, exists := middleware.GetUserID(c)
 	if exists {
@@ -127,6 +138,7 @@ func CreateProposal(c *gin.Context) {
 		Identifier     string  `json:"identifier" binding:"required"`
 		RuleType       string  `json:"rule_type" binding:"required"`
 		ProposedPolicy string  `json:"proposed_policy" binding:"required"`
+		CELExpr        string  `json:"cel_expr"` // Required when proposing a CEL rule
 		CustomMessage  *string `json:"custom_message"`
 	}
 
@@ -135,44 +147,75 @@ func CreateProposal(c *gin.Context) {
 		return
 	}
 
-	// Validate rule type
-	validRuleTypes := map[string]bool{
-		string(models.RuleTypeBinary):      true,
-		string(models.RuleTypeCertificate): true,
-		string(models.RuleTypeSigningID):   true,
-		string(models.RuleTypeTeamID):      true,
-		string(models.RuleTypeCDHash):      true,
-	}
-	if !validRuleTypes[input.RuleType] {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule type"})
+	input.Identifier = services.NormalizeIdentifier(input.RuleType, input.Identifier)
+	if errs := services.ValidateRule(input.Identifier, input.RuleType, input.ProposedPolicy, input.CELExpr, "proposed_policy"); len(errs) > 0 {
+		respondValidationErrors(c, errs)
 		return
 	}
 
-	// Validate policy
-	if input.ProposedPolicy != string(models.PolicyAllowlist) &&
-		input.ProposedPolicy != string(models.PolicyBlocklist) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy"})
+	// A rule that already applies the proposed policy makes the proposal moot;
+	// a contradicting rule is allowed but reported back to the creator
+	sameRules, oppositeRules, err := services.FindRuleConflicts(input.Identifier, input.RuleType, nil, input.ProposedPolicy, input.CELExpr)
+	if err != nil {
+		log.Printf("Failed to check for conflicting rules: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create proposal"})
+		return
+	}
+	if len(sameRules) > 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":            "An existing rule already applies this policy",
+			"existing_rule_id": sameRules[0].ID,
+		})
 		return
 	}
 
-	// Create proposal
-	result, err := database.DB.Exec(
-		`INSERT INTO proposals (identifier, rule_type, proposed_policy, custom_message, created_by)
-		 VALUES (?, ?, ?, ?, ?)`,
-		input.Identifier, input.RuleType, input.ProposedPolicy, input.CustomMessage, userID,
-	)
+	// Create proposal, or redirect to an existing pending proposal instead of
+	// opening a duplicate
+	var celExpr *string
+	if input.CELExpr != "" {
+		celExpr = &input.CELExpr
+	}
+	proposalID, created, err := services.OpenProposal(models.Proposal{
+		Identifier:     input.Identifier,
+		RuleType:       input.RuleType,
+		ProposedPolicy: input.ProposedPolicy,
+		CELExpr:        celExpr,
+		CustomMessage:  input.CustomMessage,
+		CreatedBy:      &userID,
+		Source:         string(models.ProposalSourceUser),
+	})
 	if err != nil {
 		log.Printf("Failed to create proposal: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create proposal"})
 		return
 	}
+	if !created {
+		c.Header("Location", fmt.Sprintf("/api/proposals/%d", proposalID))
+		c.JSON(http.StatusConflict, gin.H{
+			"error":                "A pending proposal already exists for this identifier, rule type and policy",
+			"existing_proposal_id": proposalID,
+		})
+		return
+	}
 
-	proposalID, _ := result.LastInsertId()
-
-	c.JSON(http.StatusCreated, gin.H{
+	response := gin.H{
 		"id":      proposalID,
 		"message": "Proposal created successfully",
-	})
+	}
+	if len(oppositeRules) > 0 {
+		response["conflicting_rules"] = oppositeRules
+		response["warning"] = "An existing rule applies the opposite policy; approval will require superseding it"
+	}
+
+	// Proposal policies may decide the proposal without a vote
+	decision, err := services.ApplyProposalPolicies(proposalID)
+	if err != nil {
+		log.Printf("Failed to apply proposal policies to proposal %d: %v", proposalID, err)
+	} else if decision.Decision != nil {
+		response["auto_decision"] = decision
+	}
+
+	c.JSON(http.StatusCreated, response)
 }
 
 // VoteOnProposal submits a vote on a proposal
@@ -211,6 +254,12 @@ func VoteOnProposal(c *gin.Context) {
 
 // ApproveProposal allows admin to directly approve a proposal
 func ApproveProposal(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
 	proposalID, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid proposal ID"})
@@ -218,7 +267,8 @@ func ApproveProposal(c *gin.Context) {
 	}
 
 	var input struct {
-		Policy string `json:"policy" binding:"required"`
+		Policy    string `json:"policy" binding:"required"` // CEL accepts a CEL proposal's expression
+		Supersede bool   `json:"supersede"`                 // Replace a rule with the opposite policy
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -227,7 +277,19 @@ func ApproveProposal(c *gin.Context) {
 	}
 
 	// Admin approve
-	err = services.AdminApproveProposal(proposalID, input.Policy)
+	err = services.AdminApproveProposal(proposalID, input.Policy, input.Supersede, userID)
+	var conflict *services.RuleConflictError
+	if errors.As(err, &conflict) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":               conflict.Error(),
+			"conflicting_rule_id": conflict.RuleID,
+		})
+		return
+	}
+	if errors.Is(err, services.ErrRuleManaged) {
+		c.JSON(http.StatusConflict, gin.H{"error": ruleManagedMessage})
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to approve proposal: %v", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -253,7 +315,7 @@ func DeleteProposal(c *gin.Context) {
 	}
 
 	// Check if user is creator or admin
-	var createdBy int64
+	var createdBy *int64
 	err = database.DB.QueryRow(`SELECT created_by FROM proposals WHERE id = ?`, proposalID).Scan(&createdBy)
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Proposal not found"})
@@ -264,14 +326,14 @@ func DeleteProposal(c *gin.Context) {
 		return
 	}
 
-	if createdBy != userID && role != string(models.RoleAdmin) {
+	isCreator := createdBy != nil && *createdBy == userID
+	if !isCreator && role != string(models.RoleAdmin) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Only the creator or admin can delete this proposal"})
 		return
 	}
 
-	// Delete proposal (votes will be cascade deleted)
-	_, err = database.DB.Exec(`DELETE FROM proposals WHERE id = ?`, proposalID)
-	if err != nil {
+	// Delete proposal along with its votes, discussion and evidence
+	if err := services.DeleteProposal(proposalID); err != nil {
 		log.Printf("Failed to delete proposal: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete proposal"})
 		return
@@ -279,3 +341,58 @@ func DeleteProposal(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "Proposal deleted successfully"})
 }
+
+// ListProposalRequesters returns the machines and users whose blocked
+// executions were recorded against a proposal
+func ListProposalRequesters(c *gin.Context) {
+	proposalID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid proposal ID"})
+		return
+	}
+
+	rows, err := database.DB.Query(
+		`SELECT r.id, r.proposal_id, r.machine_id, m.hostname, r.executing_user,
+		        r.block_count, r.first_seen, r.last_seen
+		 FROM proposal_requesters r
+		 LEFT JOIN machines m ON r.machine_id = m.machine_id
+		 WHERE r.proposal_id = ?
+		 ORDER BY r.block_count DESC, r.last_seen DESC`,
+		proposalID,
+	)
+	if err != nil {
+		log.Printf("Failed to query proposal requesters: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch requesters"})
+		return
+	}
+	defer rows.Close()
+
+	requesters := []models.ProposalRequester{}
+	machines := map[string]bool{}
+	users := map[string]bool{}
+	totalBlocks := 0
+	for rows.Next() {
+		var r models.ProposalRequester
+		err := rows.Scan(
+			&r.ID, &r.ProposalID, &r.MachineID, &r.Hostname, &r.ExecutingUser,
+			&r.BlockCount, &r.FirstSeen, &r.LastSeen,
+		)
+		if err != nil {
+			log.Printf("Failed to scan proposal requester: %v", err)
+			continue
+		}
+		requesters = append(requesters, r)
+		machines[r.MachineID] = true
+		if r.ExecutingUser != "" {
+			users[r.ExecutingUser] = true
+		}
+		totalBlocks += r.BlockCount
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"requesters":    requesters,
+		"machine_count": len(machines),
+		"user_count":    len(users),
+		"block_count":   totalBlocks,
+	})
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"errors"
+	"krampus/server/services"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultReadinessWindow is how far back a readiness analysis looks when no
+// start is given
+const defaultReadinessWindow = 30 * 24 * time.Hour
+
+// AnalyzeLockdownReadiness replays recorded executions for the selected
+// machines and groups against the current ruleset, reporting what would be
+// blocked if they were switched to LOCKDOWN
+func AnalyzeLockdownReadiness(c *gin.Context) {
+	var input struct {
+		MachineIDs []string `json:"machine_ids"`
+		GroupIDs   []int64  `json:"group_ids"`
+		Since      string   `json:"since"`
+		Until      string   `json:"until"`
+		Limit      int      `json:"limit"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req := services.ReadinessRequest{
+		MachineIDs: input.MachineIDs,
+		GroupIDs:   input.GroupIDs,
+		Until:      time.Now(),
+		Limit:      input.Limit,
+	}
+	var errs services.ValidationErrors
+	if input.Until != "" {
+		until, err := time.Parse(time.RFC3339, input.Until)
+		if err != nil {
+			errs = append(errs, services.ValidationError{Field: "until", Message: "must be an RFC 3339 timestamp"})
+		}
+		req.Until = until
+	}
+	req.Since = req.Until.Add(-defaultReadinessWindow)
+	if input.Since != "" {
+		since, err := time.Parse(time.RFC3339, input.Since)
+		if err != nil {
+			errs = append(errs, services.ValidationError{Field: "since", Message: "must be an RFC 3339 timestamp"})
+		}
+		req.Since = since
+	}
+	if len(errs) == 0 && !req.Since.Before(req.Until) {
+		errs = append(errs, services.ValidationError{Field: "since", Message: "must be before until"})
+	}
+	if input.Limit < 0 {
+		errs = append(errs, services.ValidationError{Field: "limit", Message: "must not be negative"})
+	}
+	if len(errs) > 0 {
+		respondValidationErrors(c, errs)
+		return
+	}
+	if req.Limit == 0 {
+		req.Limit = 100
+	}
+
+	report, err := services.AnalyzeLockdownReadiness(req)
+	if errors.Is(err, services.ErrMachineNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, services.ErrGroupNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to analyze lockdown readiness: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to analyze lockdown readiness"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"errors"
+	"krampus/server/models"
+	"krampus/server/services"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetReputation returns a binary's reputation from the configured provider,
+// served from the cache while it is fresh (?refresh=true looks it up again)
+func GetReputation(c *gin.Context) {
+	hash := strings.ToLower(strings.TrimSpace(c.Param("hash")))
+	if verr := services.ValidateIdentifier(string(models.RuleTypeBinary), hash); verr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "hash must be a SHA-256"})
+		return
+	}
+
+	reputation, err := services.Reputation(hash, c.Query("refresh") == "true")
+	switch {
+	case errors.Is(err, services.ErrNoReputationProvider):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No reputation provider is configured"})
+		return
+	case errors.Is(err, services.ErrReputationLookup):
+		log.Printf("Failed to look up reputation: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Reputation provider lookup failed"})
+		return
+	case err != nil:
+		log.Printf("Failed to fetch reputation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch reputation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, reputation)
+}
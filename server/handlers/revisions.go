@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"errors"
+	"krampus/server/middleware"
+	"krampus/server/services"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListRulesetRevisions returns recent ruleset revisions, newest first
+func ListRulesetRevisions(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if limit < 1 {
+		limit = 20
+	}
+
+	revisions, err := services.ListRulesetRevisions(limit)
+	if err != nil {
+		log.Printf("Failed to list ruleset revisions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch ruleset revisions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, revisions)
+}
+
+// GetRulesetRevision returns a revision with the ruleset as it stood then
+func GetRulesetRevision(c *gin.Context) {
+	revision, err := strconv.ParseInt(c.Param("revision"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision"})
+		return
+	}
+
+	r, err := services.GetRulesetRevision(revision)
+	if errors.Is(err, services.ErrRevisionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to fetch ruleset revision: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch ruleset revision"})
+		return
+	}
+
+	c.JSON(http.StatusOK, r)
+}
+
+// DiffRulesetRevisions compares the ruleset at ?from= with ?to= (default:
+// the current revision)
+func DiffRulesetRevisions(c *gin.Context) {
+	from, err := strconv.ParseInt(c.Query("from"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from revision"})
+		return
+	}
+	var to int64
+	if c.Query("to") != "" {
+		if to, err = strconv.ParseInt(c.Query("to"), 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to revision"})
+			return
+		}
+	}
+
+	diff, err := services.DiffRulesetRevisions(from, to)
+	if errors.Is(err, services.ErrRevisionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to diff ruleset revisions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to diff ruleset revisions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, diff)
+}
+
+// RollbackRuleset returns the ruleset to an earlier revision (admin only).
+// The rollback is recorded as a new revision and reaches clients on their
+// next sync.
+func RollbackRuleset(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	revision, err := strconv.ParseInt(c.Param("revision"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision"})
+		return
+	}
+
+	reason, ok := optionalReason(c)
+	if !ok {
+		return
+	}
+
+	report, err := services.RollbackRuleset(revision, userID, reason)
+	if errors.Is(err, services.ErrRevisionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		return
+	}
+	if errors.Is(err, services.ErrRulesetUnchanged) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Ruleset already matches this revision"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to roll back ruleset: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to roll back ruleset"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"errors"
+	"krampus/server/middleware"
+	"krampus/server/models"
+	"krampus/server/services"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListRollouts returns staged rule rollouts, unfinished ones first
+// (?status= filters by ACTIVE, HALTED, COMPLETED or ABORTED)
+func ListRollouts(c *gin.Context) {
+	status := c.Query("status")
+	switch models.RolloutStatus(status) {
+	case "", models.RolloutStatusActive, models.RolloutStatusHalted,
+		models.RolloutStatusCompleted, models.RolloutStatusAborted:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be ACTIVE, HALTED, COMPLETED or ABORTED"})
+		return
+	}
+
+	rollouts, err := services.ListRollouts(status)
+	if err != nil {
+		log.Printf("Failed to list rollouts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch rollouts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rollouts)
+}
+
+// GetRuleRollout returns a rule's rollout with the progress of each stage
+func GetRuleRollout(c *gin.Context) {
+	ruleID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+		return
+	}
+
+	rollout, err := services.GetRuleRollout(ruleID)
+	if err != nil {
+		respondRolloutError(c, err, "fetch")
+		return
+	}
+
+	c.JSON(http.StatusOK, rollout)
+}
+
+// PromoteRuleRollout moves a rule's rollout to its next stage, or to the
+// whole fleet from the last stage (admin only)
+func PromoteRuleRollout(c *gin.Context) {
+	ruleID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+		return
+	}
+
+	rollout, err := services.PromoteRollout(ruleID)
+	if err != nil {
+		respondRolloutError(c, err, "promote")
+		return
+	}
+
+	c.JSON(http.StatusOK, rollout)
+}
+
+// HaltRuleRollout stops a rule's rollout at its current stage (admin only)
+func HaltRuleRollout(c *gin.Context) {
+	ruleID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+		return
+	}
+
+	reason, ok := optionalReason(c)
+	if !ok {
+		return
+	}
+
+	rollout, err := services.HaltRollout(ruleID, reason)
+	if err != nil {
+		respondRolloutError(c, err, "halt")
+		return
+	}
+
+	c.JSON(http.StatusOK, rollout)
+}
+
+// ResumeRuleRollout continues a halted rollout from its current stage
+// (admin only)
+func ResumeRuleRollout(c *gin.Context) {
+	ruleID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+		return
+	}
+
+	rollout, err := services.ResumeRollout(ruleID)
+	if err != nil {
+		respondRolloutError(c, err, "resume")
+		return
+	}
+
+	c.JSON(http.StatusOK, rollout)
+}
+
+// AbortRuleRollout ends a rule's rollout and deletes the rule (admin only)
+func AbortRuleRollout(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	ruleID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+		return
+	}
+
+	reason, ok := optionalReason(c)
+	if !ok {
+		return
+	}
+
+	rollout, err := services.AbortRollout(ruleID, userID, reason)
+	if err != nil {
+		respondRolloutError(c, err, "abort")
+		return
+	}
+
+	c.JSON(http.StatusOK, rollout)
+}
+
+// respondRolloutError maps rule rollout errors to responses
+func respondRolloutError(c *gin.Context, err error, action string) {
+	switch {
+	case errors.Is(err, services.ErrRolloutNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rule has no rollout"})
+	case errors.Is(err, services.ErrRolloutFinished):
+		c.JSON(http.StatusConflict, gin.H{"error": "Rollout has already completed or been aborted"})
+	case errors.Is(err, services.ErrRolloutHalted):
+		c.JSON(http.StatusConflict, gin.H{"error": "Rollout is halted; resume it first"})
+	case errors.Is(err, services.ErrRolloutNotHalted):
+		c.JSON(http.StatusConflict, gin.H{"error": "Rollout is not halted"})
+	default:
+		log.Printf("Failed to %s rollout: %v", action, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action + " rollout"})
+	}
+}
@@ -2,29 +2,51 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"krampus/server/config"
 	"krampus/server/database"
 	"krampus/server/middleware"
 	"krampus/server/models"
+	"krampus/server/services"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 // ListRules returns all active rules
 func ListRules(c *gin.Context) {
+	rules, err := queryRules(c)
+	if err == nil {
+		err = services.AttachRollouts(rules)
+	}
+	if err != nil {
+		log.Printf("Failed to query rules: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch rules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rules)
+}
+
+// queryRules fetches the rules matching the request's filters, shared by
+// ListRules and ExportRules
+func queryRules(c *gin.Context) ([]models.Rule, error) {
 	policy := c.Query("policy")     // Filter by policy
 	ruleType := c.Query("rule_type") // Filter by rule type
 
-	query := `
-		SELECT r.id, r.identifier, r.policy, r.rule_type, r.custom_message,
-		       r.comment, r.created_by, r.proposal_id, r.created_at
-		FROM rules r
-		WHERE 1=1
-	`
+	query := `SELECT ` + services.RuleColumns + ` FROM rules r WHERE 1=1`
 	args := []interface{}{}
 
+	// Superseded rules are kept for history but hidden unless requested
+	if c.Query("include_superseded") != "true" {
+		query += " AND r." + services.ActiveRule
+	}
+
 	if policy != "" {
 		query += " AND r.policy = ?"
 		args = append(args, policy)
@@ -38,27 +60,108 @@ func ListRules(c *gin.Context) {
 
 	rows, err := database.DB.Query(query, args...)
 	if err != nil {
-		log.Printf("Failed to query rules: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch rules"})
-		return
+		return nil, err
 	}
 	defer rows.Close()
 
 	rules := []models.Rule{}
 	for rows.Next() {
 		var r models.Rule
-		err := rows.Scan(
-			&r.ID, &r.Identifier, &r.Policy, &r.RuleType, &r.CustomMessage,
-			&r.Comment, &r.CreatedBy, &r.ProposalID, &r.CreatedAt,
-		)
-		if err != nil {
+		if err := services.ScanRule(rows, &r); err != nil {
 			log.Printf("Failed to scan rule: %v", err)
 			continue
 		}
 		rules = append(rules, r)
 	}
+	return rules, rows.Err()
+}
 
-	c.JSON(http.StatusOK, rules)
+// ruleFormatContentTypes lists the import/export formats and their content types
+var ruleFormatContentTypes = map[string]string{
+	services.RuleFormatJSON:     "application/json",
+	services.RuleFormatCSV:      "text/csv",
+	services.RuleFormatSantactl: "application/json",
+}
+
+// maxRuleImportSize caps the size of an uploaded rule file
+const maxRuleImportSize = 10 << 20
+
+// ExportRules downloads the rules matching the ListRules filters as JSON,
+// CSV or santactl JSON (?format=, default json)
+func ExportRules(c *gin.Context) {
+	format := c.DefaultQuery("format", services.RuleFormatJSON)
+	contentType, ok := ruleFormatContentTypes[format]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format; use json, csv or santactl"})
+		return
+	}
+
+	rules, err := queryRules(c)
+	if err != nil {
+		log.Printf("Failed to query rules: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch rules"})
+		return
+	}
+
+	filename := "krampus-rules." + format
+	if format == services.RuleFormatSantactl {
+		filename = "krampus-rules-santactl.json"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Status(http.StatusOK)
+	if err := services.WriteRules(c.Writer, format, rules); err != nil {
+		log.Printf("Failed to export rules: %v", err)
+	}
+}
+
+// ImportRules creates and updates rules from an uploaded JSON, CSV or
+// santactl file (admin only). With ?dry_run=true the report is returned
+// without writing anything; otherwise the whole file is applied in one
+// transaction, or not at all if any row is invalid or conflicts with an
+// active rule of the opposite policy (unless ?supersede=true).
+func ImportRules(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	format := c.DefaultQuery("format", services.RuleFormatJSON)
+	if _, ok := ruleFormatContentTypes[format]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format; use json, csv or santactl"})
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxRuleImportSize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read import: " + err.Error()})
+		return
+	}
+
+	entries, err := services.ParseRuleImport(format, data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse import: " + err.Error()})
+		return
+	}
+	if len(entries) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Import contains no rules"})
+		return
+	}
+
+	dryRun := c.Query("dry_run") == "true"
+	report, err := services.ImportRules(format, entries, userID, dryRun, c.Query("supersede") == "true")
+	if err != nil {
+		log.Printf("Failed to import rules: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import rules"})
+		return
+	}
+
+	if !dryRun && !report.Committed {
+		c.JSON(http.StatusUnprocessableEntity, report)
+		return
+	}
+	c.JSON(http.StatusOK, report)
 }
 
 // GetRule returns a single rule by ID
@@ -70,16 +173,17 @@ func GetRule(c *gin.Context) {
 	}
 
 	var r models.Rule
-	err = database.DB.QueryRow(
-		`SELECT id, identifier, policy, rule_type, custom_message, comment, created_by, proposal_id, created_at
-		 FROM rules WHERE id = ?`,
-		id,
-	).Scan(&r.ID, &r.Identifier, &r.Policy, &r.RuleType, &r.CustomMessage, &r.Comment, &r.CreatedBy, &r.ProposalID, &r.CreatedAt)
+	err = services.ScanRule(database.DB.QueryRow(`SELECT `+services.RuleColumns+` FROM rules WHERE id = ?`, id), &r)
 
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
 		return
 	}
+	if err == nil {
+		rules := []models.Rule{r}
+		err = services.AttachRollouts(rules)
+		r = rules[0]
+	}
 	if err != nil {
 		log.Printf("Failed to fetch rule: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch rule"})
@@ -101,8 +205,18 @@ func CreateRule(c *gin.Context) {
 		Identifier    string  `json:"identifier" binding:"required"`
 		RuleType      string  `json:"rule_type" binding:"required"`
 		Policy        string  `json:"policy" binding:"required"`
-		CustomMessage *string `json:"custom_message"`
+		CELExpr       string  `json:"cel_expr"` // Required for the CEL policy
+		CustomMessage *string `json:"custom_message"` // Template; see services.RenderRuleMessage
+		CustomURL     *string `json:"custom_url"`     // Block URL template
+		OwnerTeam     *string `json:"owner_team"`
 		Comment       *string `json:"comment"`
+		GroupID       *int64  `json:"group_id"`   // Limit the rule to one machine group
+		ExpiresAt     string  `json:"expires_at"` // RFC 3339; empty never expires
+		Reason        *string `json:"reason"`
+		Supersede     bool    `json:"supersede"` // Replace the active rule for the same target
+
+		// Serve the rule to machine groups in stages before the whole fleet
+		Rollout *services.RolloutPlan `json:"rollout"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -110,52 +224,444 @@ func CreateRule(c *gin.Context) {
 		return
 	}
 
-	// Validate policy
-	if input.Policy != string(models.PolicyAllowlist) && input.Policy != string(models.PolicyBlocklist) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy"})
+	input.Identifier = services.NormalizeIdentifier(input.RuleType, input.Identifier)
+	errs := services.ValidateRule(input.Identifier, input.RuleType, input.Policy, input.CELExpr, "policy")
+	errs = append(errs, services.ValidateMessageTemplates(input.CustomMessage, input.CustomURL)...)
+	expiresAt, verr := services.ParseExpiry(input.ExpiresAt)
+	if verr != nil {
+		errs = append(errs, *verr)
+	}
+	if input.GroupID != nil {
+		verr, err := services.ValidateGroup(*input.GroupID)
+		if err != nil {
+			log.Printf("Failed to validate rule group: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create rule"})
+			return
+		}
+		if verr != nil {
+			errs = append(errs, *verr)
+		}
+	}
+	if input.Rollout != nil {
+		verrs, err := services.ValidateRolloutPlan(*input.Rollout)
+		if err != nil {
+			log.Printf("Failed to validate rule rollout: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create rule"})
+			return
+		}
+		errs = append(errs, verrs...)
+		if input.Rollout.HaltThreshold == nil {
+			threshold := config.AppConfig.RolloutHaltThreshold
+			input.Rollout.HaltThreshold = &threshold
+		}
+	}
+	if len(errs) > 0 {
+		respondValidationErrors(c, errs)
 		return
 	}
 
 	// Create rule
-	result, err := database.DB.Exec(
-		`INSERT INTO rules (identifier, policy, rule_type, custom_message, comment, created_by)
-		 VALUES (?, ?, ?, ?, ?, ?)`,
-		input.Identifier, input.Policy, input.RuleType, input.CustomMessage, input.Comment, userID,
-	)
+	rule := models.Rule{
+		Identifier:    input.Identifier,
+		Policy:        input.Policy,
+		RuleType:      input.RuleType,
+		CustomMessage: input.CustomMessage,
+		CustomURL:     input.CustomURL,
+		OwnerTeam:     input.OwnerTeam,
+		Comment:       input.Comment,
+		CreatedBy:     &userID,
+		GroupID:       input.GroupID,
+		ExpiresAt:     expiresAt,
+	}
+	if input.CELExpr != "" {
+		rule.CELExpr = &input.CELExpr
+	}
+	ruleID, err := services.CreateRule(rule, input.Reason, input.Supersede, input.Rollout)
+	var conflict *services.RuleConflictError
+	if errors.As(err, &conflict) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":            "An active rule already exists for this identifier and rule type; pass supersede to replace it",
+			"existing_rule_id": conflict.RuleID,
+			"existing_policy":  conflict.Policy,
+		})
+		return
+	}
+	if errors.Is(err, services.ErrRuleManaged) {
+		c.JSON(http.StatusConflict, gin.H{"error": ruleManagedMessage})
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to create rule: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create rule"})
 		return
 	}
 
-	ruleID, _ := result.LastInsertId()
-
 	c.JSON(http.StatusCreated, gin.H{
 		"id":      ruleID,
 		"message": "Rule created successfully",
 	})
 }
 
-// DeleteRule deletes a rule (admin only)
+// ListStaleRules reports active rules no event has been attributed to in
+// the last ?days= days (default 90), as candidates for pruning
+func ListStaleRules(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "90"))
+	if err != nil || days < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days must be a positive integer"})
+		return
+	}
+
+	rules, err := services.StaleRules(days)
+	if err != nil {
+		log.Printf("Failed to find stale rules: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stale rules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"days":  days,
+		"rules": rules,
+	})
+}
+
+// LintRuleset reports conflicting, redundant, overly broad and unseen rules
+// in the active ruleset (?broad_threshold= overrides LINT_BROAD_THRESHOLD)
+func LintRuleset(c *gin.Context) {
+	threshold := 0
+	if value := c.Query("broad_threshold"); value != "" {
+		var err error
+		threshold, err = strconv.Atoi(value)
+		if err != nil || threshold < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "broad_threshold must be a positive integer"})
+			return
+		}
+	}
+
+	report, err := services.LintRuleset(threshold)
+	if err != nil {
+		log.Printf("Failed to lint ruleset: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to lint ruleset"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// ListRuleDuplicates reports the duplicate rules superseded when the
+// one-active-rule constraint was introduced (admin only)
+func ListRuleDuplicates(c *gin.Context) {
+	rows, err := database.DB.Query(
+		`SELECT id, identifier, rule_type, kept_rule_id, kept_policy,
+		        superseded_rule_id, superseded_policy, detected_at
+		 FROM rule_duplicates ORDER BY identifier, rule_type, superseded_rule_id`,
+	)
+	if err != nil {
+		log.Printf("Failed to query rule duplicates: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch rule duplicates"})
+		return
+	}
+	defer rows.Close()
+
+	duplicates := []models.RuleDuplicate{}
+	for rows.Next() {
+		var d models.RuleDuplicate
+		err := rows.Scan(&d.ID, &d.Identifier, &d.RuleType, &d.KeptRuleID, &d.KeptPolicy,
+			&d.SupersededRuleID, &d.SupersededPolicy, &d.DetectedAt)
+		if err != nil {
+			log.Printf("Failed to scan rule duplicate: %v", err)
+			continue
+		}
+		duplicates = append(duplicates, d)
+	}
+
+	c.JSON(http.StatusOK, duplicates)
+}
+
+// ListRuleContradictions reports targets still holding several active rules
+// that the duplicate cleanup left for an admin to resolve (admin only)
+func ListRuleContradictions(c *gin.Context) {
+	contradictions, err := services.ActiveRuleContradictions()
+	if err != nil {
+		log.Printf("Failed to query contradicting rules: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch contradicting rules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, contradictions)
+}
+
+// UpdateRule changes a rule's policy, custom message, comment, group or
+// expiry and records the change in its version history (admin only)
+func UpdateRule(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+		return
+	}
+
+	var input struct {
+		Policy        *string `json:"policy"`
+		CELExpr       *string `json:"cel_expr"` // Required when switching to CEL; empty removes it
+		CustomMessage *string `json:"custom_message"` // Template; empty removes it
+		CustomURL     *string `json:"custom_url"`     // Block URL template; empty removes it
+		OwnerTeam     *string `json:"owner_team"`
+		Comment       *string `json:"comment"`
+		GroupID       *int64  `json:"group_id"`   // 0 makes the rule fleet-wide again
+		ExpiresAt     *string `json:"expires_at"` // RFC 3339; empty removes the expiry
+		Reason        *string `json:"reason"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var errs services.ValidationErrors
+	if input.Policy != nil {
+		if err := services.ValidatePolicy("policy", *input.Policy); err != nil {
+			errs = append(errs, *err)
+		}
+	}
+	if input.CELExpr != nil && *input.CELExpr != "" {
+		if err := services.CheckCELSyntax(*input.CELExpr); err != nil {
+			errs = append(errs, *err)
+		}
+	}
+	errs = append(errs, services.ValidateMessageTemplates(input.CustomMessage, input.CustomURL)...)
+	var expiresAt *time.Time
+	if input.ExpiresAt != nil {
+		parsed, verr := services.ParseExpiry(*input.ExpiresAt)
+		if verr != nil {
+			errs = append(errs, *verr)
+		}
+		// A zero expiry clears it
+		expiresAt = &time.Time{}
+		if parsed != nil {
+			expiresAt = parsed
+		}
+	}
+	if input.GroupID != nil && *input.GroupID != 0 {
+		verr, err := services.ValidateGroup(*input.GroupID)
+		if err != nil {
+			log.Printf("Failed to validate rule group: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update rule"})
+			return
+		}
+		if verr != nil {
+			errs = append(errs, *verr)
+		}
+	}
+	if len(errs) > 0 {
+		respondValidationErrors(c, errs)
+		return
+	}
+
+	rule, changed, err := services.UpdateRule(id, services.RuleUpdate{
+		Policy:        input.Policy,
+		CELExpr:       input.CELExpr,
+		CustomMessage: input.CustomMessage,
+		CustomURL:     input.CustomURL,
+		OwnerTeam:     input.OwnerTeam,
+		Comment:       input.Comment,
+		GroupID:       input.GroupID,
+		ExpiresAt:     expiresAt,
+	}, userID, input.Reason)
+	if errors.Is(err, services.ErrRuleNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+		return
+	}
+	if errors.Is(err, services.ErrRuleSuperseded) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Rule has been superseded; update the active rule instead"})
+		return
+	}
+	var conflict *services.RuleConflictError
+	if errors.As(err, &conflict) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":            "Another rule is already active for this identifier and rule type in that scope",
+			"existing_rule_id": conflict.RuleID,
+			"existing_policy":  conflict.Policy,
+		})
+		return
+	}
+	if errors.Is(err, services.ErrRuleManaged) {
+		c.JSON(http.StatusConflict, gin.H{"error": ruleManagedMessage})
+		return
+	}
+	if errors.Is(err, services.ErrCELExprMismatch) {
+		respondValidationErrors(c, services.ValidationErrors{{
+			Field:   "cel_expr",
+			Message: "required for CEL rules and not allowed for other policies",
+		}})
+		return
+	}
+	if errors.Is(err, services.ErrPolicyRuleType) {
+		respondValidationErrors(c, services.ValidationErrors{{
+			Field:   "policy",
+			Message: "ALLOWLIST_COMPILER only applies to BINARY and SIGNINGID rules",
+		}})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update rule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update rule"})
+		return
+	}
+
+	message := "Rule updated successfully"
+	if !changed {
+		message = "No changes"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"rule":    rule,
+		"message": message,
+	})
+}
+
+// DeleteRule deletes a rule (admin only). Its version history is kept so
+// the rule can be restored.
 func DeleteRule(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
 		return
 	}
 
-	result, err := database.DB.Exec(`DELETE FROM rules WHERE id = ?`, id)
+	reason, ok := optionalReason(c)
+	if !ok {
+		return
+	}
+
+	err = services.DeleteRule(id, userID, reason)
+	if errors.Is(err, services.ErrRuleNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+		return
+	}
+	if errors.Is(err, services.ErrRuleManaged) {
+		c.JSON(http.StatusConflict, gin.H{"error": ruleManagedMessage})
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to delete rule: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete rule"})
 		return
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
+	c.JSON(http.StatusOK, gin.H{"message": "Rule deleted successfully"})
+}
+
+// ListRuleVersions returns a rule's version history, newest first
+func ListRuleVersions(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+		return
+	}
+
+	versions, err := services.ListRuleVersions(id)
+	if err != nil {
+		log.Printf("Failed to fetch rule versions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch rule versions"})
+		return
+	}
+	if len(versions) == 0 {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Rule deleted successfully"})
+	c.JSON(http.StatusOK, versions)
+}
+
+// RestoreRuleVersion returns a rule to an earlier version, recreating it if
+// it was deleted (admin only)
+func RestoreRuleVersion(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+		return
+	}
+
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+		return
+	}
+
+	reason, ok := optionalReason(c)
+	if !ok {
+		return
+	}
+
+	rule, err := services.RestoreRuleVersion(id, version, userID, reason)
+	if errors.Is(err, services.ErrRuleVersionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rule version not found"})
+		return
+	}
+	if errors.Is(err, services.ErrRuleSuperseded) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Rule has been superseded; restore the active rule instead"})
+		return
+	}
+	var conflict *services.RuleConflictError
+	if errors.As(err, &conflict) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":            "Another rule is now active for this identifier and rule type",
+			"existing_rule_id": conflict.RuleID,
+		})
+		return
+	}
+	if errors.Is(err, services.ErrRuleManaged) {
+		c.JSON(http.StatusConflict, gin.H{"error": ruleManagedMessage})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to restore rule version: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore rule version"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rule":    rule,
+		"message": "Rule restored successfully",
+	})
+}
+
+// ruleManagedMessage rejects API changes to rules owned by the rules directory
+const ruleManagedMessage = "Rule is managed by the rules directory or a threat feed and is read-only; change it there instead"
+
+// optionalReason reads an optional {"reason": "..."} body, writing a 400
+// response and returning false if the body is malformed
+func optionalReason(c *gin.Context) (*string, bool) {
+	var input struct {
+		Reason *string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return input.Reason, true
+}
+
+// respondValidationErrors writes a 400 response listing each invalid field
+func respondValidationErrors(c *gin.Context, errs services.ValidationErrors) {
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error":   "Validation failed",
+		"details": errs,
+	})
 }
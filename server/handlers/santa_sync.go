@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"krampus/server/config"
 	"krampus/server/database"
 	"krampus/server/models"
+	"krampus/server/services"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -97,14 +100,26 @@ func Preflight(c *gin.Context) {
 		}
 	}
 
+	// Rules no longer reaching the machine since its last download only
+	// leave its local ruleset through a clean sync
+	cleanSync, err := services.NeedsCleanSync(machineID)
+	if err != nil {
+		log.Printf("Failed to check clean sync for %s: %v", machineID, err)
+	}
+
+	settings, err := services.GetSettings()
+	if err != nil {
+		log.Printf("Failed to fetch settings for %s: %v", machineID, err)
+	}
+
 	// Return sync configuration
 	c.JSON(http.StatusOK, gin.H{
 		"client_mode":               "LOCKDOWN",
 		"batch_size":                100,
 		"upload_logs_url":           "",
-		"clean_sync":                false,
+		"clean_sync":                cleanSync,
 		"enable_bundles":            true,
-		"enable_transitive_rules":   false,
+		"enable_transitive_rules":   settings.EnableTransitiveRules,
 		"blocked_path_regex":        "",
 		"allowed_path_regex":        "",
 		"enable_all_event_upload":   false,
@@ -148,21 +163,43 @@ func EventUpload(c *gin.Context) {
 	for _, event := range input.Events {
 		execTime := time.Unix(int64(event.ExecutionTime), 0)
 
-		_, err := database.DB.Exec(
+		// Attribute the event to the rule behind its decision
+		ruleID, err := services.EventRule(machineID, event)
+		if err != nil {
+			log.Printf("Failed to attribute event for %s: %v", event.FileSHA256, err)
+		}
+
+		_, err = database.DB.Exec(
 			`INSERT INTO events (machine_id, file_path, file_hash, execution_time, decision,
 			                     executing_user, cert_sha256, cert_cn, bundle_id, bundle_name,
-			                     bundle_path, signing_id, team_id, quarantine_data_url)
-			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			                     bundle_path, signing_id, team_id, cdhash, quarantine_data_url, rule_id)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 			machineID, event.FilePath, event.FileSHA256, execTime, event.Decision,
 			event.ExecutingUser, event.CertificateSHA256, event.CertificateCN,
 			event.BundleID, event.BundleName, event.BundlePath,
-			event.SigningID, event.TeamID, event.QuarantineDataURL,
+			event.SigningID, event.TeamID, event.CDHash, event.QuarantineDataURL, ruleID,
 		)
 		if err != nil {
 			log.Printf("Failed to insert event: %v", err)
 			continue
 		}
 		eventCount++
+
+		// Look up the binary's reputation in the background
+		services.QueueReputationLookup(event.FileSHA256)
+
+		if ruleID != nil {
+			if err := services.RecordRuleHit(*ruleID, execTime); err != nil {
+				log.Printf("Failed to record hit for rule %d: %v", *ruleID, err)
+			}
+		}
+
+		// Turn blocks into access requests so voters see the demand
+		if config.AppConfig.AutoProposeBlocked && services.IsBlockDecision(event.Decision) {
+			if err := services.RecordBlockedExecution(machineID, event); err != nil {
+				log.Printf("Failed to record access request for %s: %v", event.FileSHA256, err)
+			}
+		}
 	}
 
 	log.Printf("EventUpload: Successfully stored %d events from %s", eventCount, machineID)
@@ -188,19 +225,31 @@ func RuleDownload(c *gin.Context) {
 	// Parse cursor (if provided, it's the last rule ID)
 	var startID int64 = 0
 	if input.Cursor != "" {
-		// In a production system, you might want to properly parse/validate the cursor
-		// For now, we'll use a simple approach
+		id, err := strconv.ParseInt(input.Cursor, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		startID = id
 	}
 
-	// Fetch rules with pagination
+	// Read the revision before the rules so a change made mid-download is
+	// picked up on the next sync
+	revision, revisionErr := services.CurrentRulesetRevision()
+	if revisionErr != nil {
+		log.Printf("Failed to fetch ruleset revision: %v", revisionErr)
+	}
+
+	// Fetch the rules this machine receives, with pagination
 	batchSize := 100
+	inEffect, args := services.RuleInEffect(machineID)
 	rows, err := database.DB.Query(
-		`SELECT id, identifier, policy, rule_type, custom_message
+		`SELECT id, identifier, policy, rule_type, cel_expr, `+services.RuleMessageColumns+`
 		 FROM rules
-		 WHERE id > ?
+		 WHERE id > ? AND `+inEffect+`
 		 ORDER BY id
 		 LIMIT ?`,
-		startID, batchSize,
+		append(append([]interface{}{startID}, args...), batchSize)...,
 	)
 	if err != nil {
 		log.Printf("Failed to query rules: %v", err)
@@ -215,11 +264,14 @@ func RuleDownload(c *gin.Context) {
 	for rows.Next() {
 		var id int64
 		var r models.SantaRule
-		err := rows.Scan(&id, &r.Identifier, &r.Policy, &r.RuleType, &r.CustomMsg)
+		var m services.RuleMessage
+		err := rows.Scan(&id, &r.Identifier, &r.Policy, &r.RuleType, &r.CELExpr,
+			&m.MessageTemplate, &m.URLTemplate, &m.Reason, &m.ProposalIdentifier, &m.OwnerTeam)
 		if err != nil {
 			log.Printf("Failed to scan rule: %v", err)
 			continue
 		}
+		r.CustomMsg, r.CustomURL = services.RenderRuleMessage(m)
 		santaRules = append(santaRules, r)
 		lastID = id
 	}
@@ -229,9 +281,14 @@ func RuleDownload(c *gin.Context) {
 		"rules": santaRules,
 	}
 
-	// If we got a full batch, there might be more rules
+	// If we got a full batch, there might be more rules; otherwise the
+	// machine now has the whole ruleset at this revision
 	if len(santaRules) == batchSize {
-		response["cursor"] = lastID
+		response["cursor"] = strconv.FormatInt(lastID, 10)
+	} else if revisionErr == nil {
+		if err := services.RecordRuleDownload(machineID, revision); err != nil {
+			log.Printf("Failed to record rule download for %s: %v", machineID, err)
+		}
 	}
 
 	// Update last sync time
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"krampus/server/middleware"
+	"krampus/server/services"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetSettings returns the server-wide settings sent to Santa clients
+func GetSettings(c *gin.Context) {
+	settings, err := services.GetSettings()
+	if err != nil {
+		log.Printf("Failed to fetch settings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// UpdateSettings changes server-wide settings (admin only). Clients pick up
+// the change at their next preflight.
+func UpdateSettings(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var input struct {
+		EnableTransitiveRules *bool `json:"enable_transitive_rules"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	settings, err := services.UpdateSettings(services.SettingsUpdate{
+		EnableTransitiveRules: input.EnableTransitiveRules,
+	}, userID)
+	if err != nil {
+		log.Printf("Failed to update settings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"krampus/server/models"
+	"krampus/server/services"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetStorage reports the database's storage use by table and the state of
+// retention (admin only)
+func GetStorage(c *gin.Context) {
+	report, err := services.StorageUsage()
+	if err != nil {
+		log.Printf("Failed to report storage: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch storage usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// PruneStorage applies retention now instead of waiting for the schedule
+// (admin only). ?vacuum=true also runs a full VACUUM, which holds the
+// database until it finishes.
+func PruneStorage(c *gin.Context) {
+	run, err := services.RunRetention(models.RetentionTriggerManual, c.Query("vacuum") == "true")
+	if err != nil {
+		log.Printf("Failed to run retention: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to run retention"})
+		return
+	}
+
+	c.JSON(http.StatusOK, run)
+}
+
+// ListEventDailyStats returns the daily counts kept for events pruned by
+// retention, filtered by machine_id, since and until (YYYY-MM-DD)
+func ListEventDailyStats(c *gin.Context) {
+	since, until := c.Query("since"), c.Query("until")
+	for _, day := range []string{since, until} {
+		if day == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", day); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since and until must be dates (YYYY-MM-DD)"})
+			return
+		}
+	}
+
+	stats, err := services.ListEventDailyStats(c.Query("machine_id"), since, until)
+	if err != nil {
+		log.Printf("Failed to list event daily stats: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch event daily stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
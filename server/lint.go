@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"krampus/server/models"
+	"krampus/server/services"
+	"os"
+	"text/tabwriter"
+)
+
+// runLint implements `krampus-server lint`: it lints the ruleset in the
+// configured database and prints the findings. The exit status is 1 when
+// there are warnings, so the command can gate rule changes in CI.
+func runLint(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	threshold := flags.Int("broad-threshold", 0, "distinct binaries that make a Team ID or certificate allowlist broad (default LINT_BROAD_THRESHOLD)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	report, err := services.LintRuleset(*threshold)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to lint ruleset: %v\n", err)
+		return 2
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write report: %v\n", err)
+			return 2
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SEVERITY\tKIND\tRULE\tPOLICY\tTYPE\tIDENTIFIER\tMESSAGE")
+		for _, f := range report.Findings {
+			rule := fmt.Sprint(f.RuleID)
+			if f.GroupID != nil {
+				rule += fmt.Sprintf(" (group %d)", *f.GroupID)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				f.Severity, f.Kind, rule, f.Policy, f.RuleType, f.Identifier, f.Message)
+		}
+		w.Flush()
+		fmt.Printf("\n%d rules, %d binaries replayed: %d conflicting, %d broad, %d redundant, %d unseen\n",
+			report.RuleCount, report.BinaryCount,
+			report.Counts[string(models.LintKindConflict)], report.Counts[string(models.LintKindBroadAllowlist)],
+			report.Counts[string(models.LintKindRedundant)], report.Counts[string(models.LintKindUnseen)])
+	}
+
+	for _, f := range report.Findings {
+		if f.Severity == string(models.LintSeverityWarning) {
+			return 1
+		}
+	}
+	return 0
+}
@@ -11,6 +11,7 @@ import (
 	"krampus/server/services"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -23,12 +24,37 @@ func main() {
 	// Load configuration
 	config.Load()
 
+	// Subcommands read the database as it is, without migrating it, and exit
+	// instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "lint" {
+		if err := database.OpenReadOnly(config.AppConfig.DatabasePath); err != nil {
+			log.Fatalf("Failed to open database: %v", err)
+		}
+		code := runLint(os.Args[2:])
+		database.Close()
+		os.Exit(code)
+	}
+
 	// Initialize database
 	if err := database.Initialize(config.AppConfig.DatabasePath); err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer database.Close()
 
+	// Initialize the store events are archived to before they are pruned;
+	// failing is fatal, as retention would otherwise prune events without
+	// archiving them
+	if err := services.InitializeArchive(); err != nil {
+		log.Fatalf("Failed to initialize archive store: %v", err)
+	}
+
+	// Let retention return freed pages to the filesystem
+	if config.AppConfig.IncrementalVacuum {
+		if err := services.EnableIncrementalVacuum(); err != nil {
+			log.Printf("WARNING: Failed to enable incremental vacuum: %v", err)
+		}
+	}
+
 	// Initialize OIDC provider
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -39,6 +65,12 @@ func main() {
 		log.Println("OIDC provider initialized successfully")
 	}
 
+	// Initialize the binary reputation provider
+	if err := services.InitializeReputation(); err != nil {
+		log.Printf("WARNING: Reputation provider initialization failed: %v", err)
+		log.Println("Binary reputation lookups will not be available")
+	}
+
 	// Setup Gin router
 	router := gin.Default()
 
@@ -85,6 +117,9 @@ func main() {
 		c.Data(http.StatusOK, "text/html; charset=utf-8", data)
 	})
 
+	// Block landing page opened from Santa notifications (EventDetailURL)
+	router.GET("/blocked", middleware.OptionalAuthMiddleware(), handlers.BlockedPage)
+
 	// Health check endpoint (public)
 	router.GET("/ping", handlers.Health)
 
@@ -109,23 +144,141 @@ func main() {
 		{
 			proposalsGroup.GET("", handlers.ListProposals)
 			proposalsGroup.GET("/:id", handlers.GetProposal)
+			proposalsGroup.GET("/:id/requesters", handlers.ListProposalRequesters)
 			proposalsGroup.POST("", handlers.CreateProposal)
 			proposalsGroup.POST("/:id/vote", handlers.VoteOnProposal)
 			proposalsGroup.DELETE("/:id", handlers.DeleteProposal)
 
+			// Discussion and evidence
+			proposalsGroup.GET("/:id/comments", handlers.ListProposalComments)
+			proposalsGroup.POST("/:id/comments", handlers.CreateProposalComment)
+			proposalsGroup.PUT("/:id/comments/:comment_id", handlers.UpdateProposalComment)
+			proposalsGroup.GET("/:id/comments/:comment_id/history", handlers.GetProposalCommentHistory)
+			proposalsGroup.DELETE("/:id/comments/:comment_id", handlers.DeleteProposalComment)
+			proposalsGroup.GET("/:id/evidence", handlers.ListProposalEvidence)
+			proposalsGroup.POST("/:id/evidence", handlers.AddProposalEvidence)
+			proposalsGroup.DELETE("/:id/evidence/:evidence_id", handlers.DeleteProposalEvidence)
+
 			// Admin-only proposal routes
 			proposalsGroup.POST("/:id/approve", middleware.AdminMiddleware(), handlers.ApproveProposal)
+
+			// Baseline proposal batches generated from monitor-mode activity
+			proposalsGroup.GET("/batches", handlers.ListProposalBatches)
+			proposalsGroup.GET("/batches/:id", handlers.GetProposalBatch)
+			proposalsGroup.POST("/batches", middleware.AdminMiddleware(), handlers.GenerateProposalBatch)
+			proposalsGroup.PUT("/batches/:id/items/:item_id", middleware.AdminMiddleware(), handlers.UpdateProposalBatchItem)
+			proposalsGroup.POST("/batches/:id/approve", middleware.AdminMiddleware(), handlers.ApproveProposalBatch)
+			proposalsGroup.DELETE("/batches/:id", middleware.AdminMiddleware(), handlers.DeleteProposalBatch)
+		}
+
+		// Blocked binaries (landing page data and one-click access requests)
+		blockedGroup := api.Group("/blocked")
+		{
+			blockedGroup.GET("/:hash", handlers.LookupBlockedBinary)
+			blockedGroup.POST("/:hash/request", handlers.RequestBlockedAccess)
 		}
 
 		// Rules
 		rulesGroup := api.Group("/rules")
 		{
 			rulesGroup.GET("", handlers.ListRules)
+			rulesGroup.GET("/export", handlers.ExportRules)
+			rulesGroup.GET("/stale", handlers.ListStaleRules)
+			rulesGroup.GET("/lint", handlers.LintRuleset)
+			rulesGroup.GET("/reconciles", handlers.ListRuleReconciles)
+			rulesGroup.GET("/reconciles/:id", handlers.GetRuleReconcile)
+			rulesGroup.GET("/revisions", handlers.ListRulesetRevisions)
+			rulesGroup.GET("/revisions/diff", handlers.DiffRulesetRevisions)
+			rulesGroup.GET("/revisions/:revision", handlers.GetRulesetRevision)
 			rulesGroup.GET("/:id", handlers.GetRule)
+			rulesGroup.GET("/:id/versions", handlers.ListRuleVersions)
+			rulesGroup.GET("/:id/rollout", handlers.GetRuleRollout)
 
 			// Admin-only rule routes
+			rulesGroup.GET("/duplicates", middleware.AdminMiddleware(), handlers.ListRuleDuplicates)
+			rulesGroup.GET("/contradictions", middleware.AdminMiddleware(), handlers.ListRuleContradictions)
 			rulesGroup.POST("", middleware.AdminMiddleware(), handlers.CreateRule)
+			rulesGroup.POST("/import", middleware.AdminMiddleware(), handlers.ImportRules)
+			rulesGroup.POST("/reconcile", middleware.AdminMiddleware(), handlers.ReconcileRules)
+			rulesGroup.POST("/revisions/:revision/rollback", middleware.AdminMiddleware(), handlers.RollbackRuleset)
+			rulesGroup.PUT("/:id", middleware.AdminMiddleware(), handlers.UpdateRule)
 			rulesGroup.DELETE("/:id", middleware.AdminMiddleware(), handlers.DeleteRule)
+			rulesGroup.POST("/:id/versions/:version/restore", middleware.AdminMiddleware(), handlers.RestoreRuleVersion)
+			rulesGroup.POST("/:id/rollout/promote", middleware.AdminMiddleware(), handlers.PromoteRuleRollout)
+			rulesGroup.POST("/:id/rollout/halt", middleware.AdminMiddleware(), handlers.HaltRuleRollout)
+			rulesGroup.POST("/:id/rollout/resume", middleware.AdminMiddleware(), handlers.ResumeRuleRollout)
+			rulesGroup.POST("/:id/rollout/abort", middleware.AdminMiddleware(), handlers.AbortRuleRollout)
+		}
+
+		// Staged rule rollouts
+		api.GET("/rollouts", handlers.ListRollouts)
+
+		// Proposal policies that auto-decide new proposals
+		proposalPoliciesGroup := api.Group("/proposal-policies")
+		{
+			proposalPoliciesGroup.GET("", handlers.ListProposalPolicies)
+
+			// Admin-only proposal policy routes
+			proposalPoliciesGroup.POST("", middleware.AdminMiddleware(), handlers.CreateProposalPolicy)
+			proposalPoliciesGroup.POST("/evaluate", middleware.AdminMiddleware(), handlers.EvaluateProposalPolicies)
+			proposalPoliciesGroup.PUT("/:id", middleware.AdminMiddleware(), handlers.UpdateProposalPolicy)
+			proposalPoliciesGroup.DELETE("/:id", middleware.AdminMiddleware(), handlers.DeleteProposalPolicy)
+		}
+
+		// Threat intelligence feeds
+		feedsGroup := api.Group("/feeds")
+		{
+			feedsGroup.GET("", handlers.ListFeeds)
+			feedsGroup.GET("/:id", handlers.GetFeed)
+
+			// Admin-only feed routes
+			feedsGroup.POST("", middleware.AdminMiddleware(), handlers.CreateFeed)
+			feedsGroup.PUT("/:id", middleware.AdminMiddleware(), handlers.UpdateFeed)
+			feedsGroup.DELETE("/:id", middleware.AdminMiddleware(), handlers.DeleteFeed)
+			feedsGroup.POST("/:id/run", middleware.AdminMiddleware(), handlers.RunFeed)
+		}
+
+		// Binary reputation from the configured provider
+		api.GET("/reputation/:hash", handlers.GetReputation)
+
+		// Storage use and retention (admin-only)
+		storageGroup := api.Group("/storage")
+		storageGroup.Use(middleware.AdminMiddleware())
+		{
+			storageGroup.GET("", handlers.GetStorage)
+			storageGroup.POST("/prune", handlers.PruneStorage)
+		}
+
+		// Cold archive of pruned events (admin-only)
+		archiveGroup := api.Group("/archive")
+		archiveGroup.Use(middleware.AdminMiddleware())
+		{
+			archiveGroup.GET("", handlers.SearchEventArchives)
+			archiveGroup.POST("/restore", handlers.RestoreEventArchives)
+		}
+
+		// Evaluate a binary against the ruleset
+		api.POST("/evaluate", handlers.EvaluateBinary)
+
+		// Replay monitor-mode events to assess lockdown readiness
+		api.POST("/lockdown/readiness", handlers.AnalyzeLockdownReadiness)
+
+		// Server-wide settings sent to clients at preflight
+		api.GET("/settings", handlers.GetSettings)
+		api.PUT("/settings", middleware.AdminMiddleware(), handlers.UpdateSettings)
+
+		// Machine groups
+		groupsGroup := api.Group("/groups")
+		{
+			groupsGroup.GET("", handlers.ListGroups)
+			groupsGroup.GET("/:id", handlers.GetGroup)
+
+			// Admin-only group routes
+			groupsGroup.POST("", middleware.AdminMiddleware(), handlers.CreateGroup)
+			groupsGroup.PUT("/:id", middleware.AdminMiddleware(), handlers.UpdateGroup)
+			groupsGroup.DELETE("/:id", middleware.AdminMiddleware(), handlers.DeleteGroup)
+			groupsGroup.POST("/:id/members", middleware.AdminMiddleware(), handlers.AddGroupMember)
+			groupsGroup.DELETE("/:id/members/:machine_id", middleware.AdminMiddleware(), handlers.RemoveGroupMember)
 		}
 
 		// Machines
@@ -144,6 +297,7 @@ func main() {
 		eventsGroup := api.Group("/events")
 		{
 			eventsGroup.GET("", handlers.ListEvents)
+			eventsGroup.GET("/daily-stats", handlers.ListEventDailyStats)
 		}
 
 		// Programs
@@ -182,16 +336,23 @@ func main() {
 		c.Data(http.StatusOK, "text/html; charset=utf-8", data)
 	})
 
-	// Periodic cleanup of expired sessions
-	go func() {
-		ticker := time.NewTicker(1 * time.Hour)
-		defer ticker.Stop()
-		for range ticker.C {
-			if err := services.CleanupExpiredSessions(); err != nil {
-				log.Printf("Failed to cleanup expired sessions: %v", err)
-			}
-		}
-	}()
+	// Periodically clean up expired sessions, prune data past its retention
+	// and vacuum the database
+	services.WatchRetention(config.AppConfig.RetentionInterval)
+
+	// Reconcile the GitOps rules directory now and whenever it changes
+	if config.AppConfig.RulesDir != "" {
+		services.WatchRulesDir(config.AppConfig.RulesDir, config.AppConfig.RulesDirPollInterval)
+	}
+
+	// Promote and halt staged rule rollouts
+	services.WatchRollouts(config.AppConfig.RolloutCheckInterval)
+
+	// Ingest threat feeds as they come due
+	services.WatchFeeds(config.AppConfig.FeedCheckInterval)
+
+	// Look up the reputation of newly seen binaries
+	services.WatchReputation(config.AppConfig.ReputationLookupInterval)
 
 	// Start server
 	serverAddr := ":" + config.AppConfig.ServerPort
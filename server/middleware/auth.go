@@ -11,27 +11,9 @@ import (
 // AuthMiddleware validates JWT token and injects user info into context
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var tokenString string
+		tokenString := extractToken(c)
 
-		// Try to extract token from Authorization header first
-		authHeader := c.GetHeader("Authorization")
-		if authHeader != "" {
-			// Check Bearer token format
-			parts := strings.SplitN(authHeader, " ", 2)
-			if len(parts) == 2 && parts[0] == "Bearer" {
-				tokenString = parts[1]
-			}
-		}
-
-		// If not in header, try to get from cookie
-		if tokenString == "" {
-			cookie, err := c.Cookie("token")
-			if err == nil && cookie != "" {
-				tokenString = cookie
-			}
-		}
-
-		// If still no token found, return unauthorized
+		// If no token found, return unauthorized
 		if tokenString == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
 			c.Abort()
@@ -46,16 +28,53 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// Inject user info into context
-		c.Set("user_id", claims.UserID)
-		c.Set("username", claims.Username)
-		c.Set("role", claims.Role)
-		c.Set("email", claims.Email)
+		setClaims(c, claims)
+		c.Next()
+	}
+}
 
+// OptionalAuthMiddleware injects user info when a valid token is present but
+// lets anonymous requests through, for pages that render differently once
+// signed in
+func OptionalAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if tokenString := extractToken(c); tokenString != "" {
+			if claims, err := services.ValidateToken(tokenString); err == nil {
+				setClaims(c, claims)
+			}
+		}
 		c.Next()
 	}
 }
 
+// extractToken reads the JWT from the Authorization header or the token cookie
+func extractToken(c *gin.Context) string {
+	// Try to extract token from Authorization header first
+	authHeader := c.GetHeader("Authorization")
+	if authHeader != "" {
+		// Check Bearer token format
+		parts := strings.SplitN(authHeader, " ", 2)
+		if len(parts) == 2 && parts[0] == "Bearer" {
+			return parts[1]
+		}
+	}
+
+	// If not in header, try to get from cookie
+	cookie, err := c.Cookie("token")
+	if err == nil && cookie != "" {
+		return cookie
+	}
+	return ""
+}
+
+// setClaims injects user info into context
+func setClaims(c *gin.Context, claims *services.Claims) {
+	c.Set("user_id", claims.UserID)
+	c.Set("username", claims.Username)
+	c.Set("role", claims.Role)
+	c.Set("email", claims.Email)
+}
+
 // GetUserID retrieves user ID from context
 func GetUserID(c *gin.Context) (int64, bool) {
 	userID, exists := c.Get("user_id")
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+)
+
+// EventArchive indexes one archive object: events of a single day, pruned
+// together, as gzip-compressed NDJSON with one Event per line
+type EventArchive struct {
+	ID           int64     `json:"id"`
+	Day          string    `json:"day"`   // YYYY-MM-DD, UTC
+	Store        string    `json:"store"` // Archive store the object was written to
+	ObjectKey    string    `json:"object_key"`
+	Events       int       `json:"events"`
+	FirstEventID int64     `json:"first_event_id"`
+	LastEventID  int64     `json:"last_event_id"`
+	Bytes        int64     `json:"bytes"`    // Compressed size
+	Machines     []string  `json:"machines"` // Machines with events in the object
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// ArchiveRestore reports archived events copied back into the events table
+type ArchiveRestore struct {
+	Archives           int        `json:"archives"`             // Archive objects read
+	Restored           int        `json:"restored"`             // Events inserted
+	AlreadyLoaded      int        `json:"already_loaded"`       // Matching events already in the events table
+	Truncated          bool       `json:"truncated"`            // Stopped at the restore limit; narrow the range
+	OtherStoreArchives int        `json:"other_store_archives"` // Matching archives in a store other than the current one, not read
+	KeptUntil          *time.Time `json:"kept_until,omitempty"`
+}
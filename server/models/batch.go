@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+)
+
+// ProposalBatch is a draft ruleset generated from monitor-mode activity that
+// admins review and approve as a whole
+type ProposalBatch struct {
+	ID           int64               `json:"id"`
+	Name         string              `json:"name"`
+	Status       string              `json:"status"` // "DRAFT" or "APPROVED"
+	Since        time.Time           `json:"since"`
+	Until        time.Time           `json:"until"`
+	MachineCount int                 `json:"machine_count"` // Monitor-mode machines the events came from
+	ItemCount    int                 `json:"item_count"`
+	CreatedBy    *int64              `json:"created_by,omitempty"`
+	ApprovedBy   *int64              `json:"approved_by,omitempty"`
+	CreatedAt    time.Time           `json:"created_at"`
+	ApprovedAt   *time.Time          `json:"approved_at,omitempty"`
+	Items        []ProposalBatchItem `json:"items,omitempty"`
+}
+
+type ProposalBatchStatus string
+
+const (
+	ProposalBatchStatusDraft    ProposalBatchStatus = "DRAFT"
+	ProposalBatchStatusApproved ProposalBatchStatus = "APPROVED"
+)
+
+// ProposalBatchItem is the rule proposed for one cluster of binaries
+type ProposalBatchItem struct {
+	ID          int64   `json:"id"`
+	BatchID     int64   `json:"batch_id"`
+	Identifier  string  `json:"identifier"`
+	RuleType    string  `json:"rule_type"`
+	Policy      string  `json:"policy"`
+	TeamID      *string `json:"team_id,omitempty"`
+	SigningID   *string `json:"signing_id,omitempty"`
+	ExamplePath *string `json:"example_path,omitempty"` // Path of one binary in the cluster
+	Binaries    int     `json:"binaries"`               // Distinct hashes the rule covers
+	Executions  int     `json:"executions"`
+	Machines    int     `json:"machines"`
+	Status      string  `json:"status"`            // "INCLUDED", "EXCLUDED", "CREATED" or "SKIPPED"
+	RuleID      *int64  `json:"rule_id,omitempty"` // Rule created on approval, or the active rule that already covered the target
+}
+
+type ProposalBatchItemStatus string
+
+const (
+	ProposalBatchItemIncluded ProposalBatchItemStatus = "INCLUDED"
+	ProposalBatchItemExcluded ProposalBatchItemStatus = "EXCLUDED"
+	ProposalBatchItemCreated  ProposalBatchItemStatus = "CREATED"
+	ProposalBatchItemSkipped  ProposalBatchItemStatus = "SKIPPED"
+)
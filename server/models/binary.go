@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+)
+
+// BinaryLookup describes everything the server knows about a binary hash:
+// metadata from stored events, rules and proposals that cover it, and the
+// rule Krampus would suggest when someone requests access
+type BinaryLookup struct {
+	Identifier     string      `json:"identifier"`
+	MachineID      string      `json:"machine_id,omitempty"`
+	Seen           bool        `json:"seen"`
+	FilePath       *string     `json:"file_path,omitempty"`
+	BundleID       *string     `json:"bundle_id,omitempty"`
+	BundleName     *string     `json:"bundle_name,omitempty"`
+	CertSHA256     *string     `json:"cert_sha256,omitempty"`
+	CertCN         *string     `json:"cert_cn,omitempty"`
+	SigningID      *string     `json:"signing_id,omitempty"`
+	TeamID         *string     `json:"team_id,omitempty"`
+	CDHash         *string     `json:"cdhash,omitempty"`
+	ExecutionCount int         `json:"execution_count"`
+	BlockCount     int         `json:"block_count"`
+	MachineCount   int         `json:"machine_count"`
+	FirstSeen      *time.Time  `json:"first_seen,omitempty"`
+	LastSeen       *time.Time  `json:"last_seen,omitempty"`
+	Status         string      `json:"status"` // "ALLOWLISTED", "BLOCKLISTED", "PENDING_REVIEW", "UNKNOWN"
+	Evaluation     *Evaluation `json:"evaluation"`
+	Rules          []Rule      `json:"rules"`
+	Proposals      []Proposal  `json:"proposals"`
+	Suggested      RuleTarget  `json:"suggested"`
+}
+
+// RuleTarget identifies what a rule or proposal would match
+type RuleTarget struct {
+	Identifier string `json:"identifier"`
+	RuleType   string `json:"rule_type"`
+}
+
+type BinaryStatus string
+
+const (
+	BinaryStatusAllowlisted   BinaryStatus = "ALLOWLISTED"
+	BinaryStatusBlocklisted   BinaryStatus = "BLOCKLISTED"
+	BinaryStatusPendingReview BinaryStatus = "PENDING_REVIEW"
+	BinaryStatusUnknown       BinaryStatus = "UNKNOWN"
+)
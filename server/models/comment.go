@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+)
+
+// ProposalComment is a message in a proposal's discussion thread
+type ProposalComment struct {
+	ID         int64      `json:"id"`
+	ProposalID int64      `json:"proposal_id"`
+	UserID     *int64     `json:"user_id,omitempty"`
+	Username   string     `json:"username"`
+	Body       string     `json:"body"`
+	EditCount  int        `json:"edit_count"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+}
+
+// ProposalCommentEdit keeps the body a comment had before an edit
+type ProposalCommentEdit struct {
+	ID           int64     `json:"id"`
+	CommentID    int64     `json:"comment_id"`
+	PreviousBody string    `json:"previous_body"`
+	EditedBy     *int64    `json:"edited_by,omitempty"`
+	EditedByName string    `json:"edited_by_username"`
+	EditedAt     time.Time `json:"edited_at"`
+}
+
+// ProposalEvidence links an event, machine or external ticket to a proposal
+type ProposalEvidence struct {
+	ID           int64     `json:"id"`
+	ProposalID   int64     `json:"proposal_id"`
+	EvidenceType string    `json:"evidence_type"` // "EVENT", "MACHINE", "TICKET"
+	Reference    string    `json:"reference"`     // Event ID, machine ID or ticket reference
+	Note         *string   `json:"note,omitempty"`
+	CreatedBy    *int64    `json:"created_by,omitempty"`
+	CreatorName  string    `json:"creator_username"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type EvidenceType string
+
+const (
+	EvidenceTypeEvent   EvidenceType = "EVENT"
+	EvidenceTypeMachine EvidenceType = "MACHINE"
+	EvidenceTypeTicket  EvidenceType = "TICKET"
+)
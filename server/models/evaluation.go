@@ -0,0 +1,39 @@
+package models
+
+// BinaryAttributes identify a binary the way Santa does when it decides
+// whether the binary may run
+type BinaryAttributes struct {
+	SHA256     string `json:"sha256,omitempty"`
+	CDHash     string `json:"cdhash,omitempty"`
+	SigningID  string `json:"signing_id,omitempty"` // "TEAMID:bundle.id", "platform:bundle.id", or bare with TeamID
+	TeamID     string `json:"team_id,omitempty"`
+	CertSHA256 string `json:"cert_sha256,omitempty"`
+}
+
+// Evaluation is the decision Santa would make for a binary under the current
+// ruleset, on a given machine or fleet-wide
+type Evaluation struct {
+	Attributes BinaryAttributes `json:"attributes"`
+	MachineID  string           `json:"machine_id,omitempty"`
+	ClientMode string           `json:"client_mode"` // Decides binaries no rule matches
+	Decision   string           `json:"decision"`    // Santa decision, e.g. "ALLOW_SIGNINGID" or "BLOCK_UNKNOWN"; "CEL_<TYPE>" when a CEL rule decides at execution
+	Allowed    bool             `json:"allowed"`
+	Rule       *Rule            `json:"rule,omitempty"` // Rule that produced the decision
+	Candidates []EvaluatedRule  `json:"candidates"`     // Every active rule matching the binary, in precedence order
+}
+
+// EvaluatedRule explains what an evaluation did with one matching rule
+type EvaluatedRule struct {
+	Rule   Rule   `json:"rule"`
+	Status string `json:"status"` // "APPLIED", "SHADOWED", "EXPIRED", "OUT_OF_SCOPE" or "REMOVAL"
+}
+
+type EvaluatedRuleStatus string
+
+const (
+	EvaluatedRuleApplied    EvaluatedRuleStatus = "APPLIED"      // Decided the execution
+	EvaluatedRuleShadowed   EvaluatedRuleStatus = "SHADOWED"     // A higher precedence rule decided first, or a group rule took its target
+	EvaluatedRuleExpired    EvaluatedRuleStatus = "EXPIRED"      // Past its expires_at
+	EvaluatedRuleOutOfScope EvaluatedRuleStatus = "OUT_OF_SCOPE" // Scoped to a group the machine isn't in
+	EvaluatedRuleRemoval    EvaluatedRuleStatus = "REMOVAL"      // A REMOVE rule, which leaves clients without a rule for the target
+)
@@ -19,8 +19,11 @@ type Event struct {
 	BundlePath         *string    `json:"bundle_path,omitempty"`
 	SigningID          *string    `json:"signing_id,omitempty"`
 	TeamID             *string    `json:"team_id,omitempty"`
+	CDHash             *string    `json:"cdhash,omitempty"`
 	QuarantineDataURL  *string    `json:"quarantine_data_url,omitempty"`
 	QuarantineTimestamp *time.Time `json:"quarantine_timestamp,omitempty"`
+	RuleID             *int64     `json:"rule_id,omitempty"` // Rule whose decision the event records
+	Reputation         *Reputation `json:"reputation,omitempty"` // Cached reputation of the binary
 }
 
 // SantaEvent represents an event in the Santa sync protocol format
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"time"
+)
+
+// ThreatFeed is a list of known-bad hashes, fetched from a local file or URL
+// on a schedule and kept in the ruleset as BLOCKLIST rules
+type ThreatFeed struct {
+	ID              int64          `json:"id"`
+	Name            string         `json:"name"`
+	URL             string         `json:"url"`       // Local file path, file:// URL or http(s) URL
+	Format          string         `json:"format"`    // "TEXT", "CSV" or "STIX"
+	RuleType        string         `json:"rule_type"` // "BINARY", "CERTIFICATE" or "CDHASH"
+	IntervalMinutes int            `json:"interval_minutes"`
+	Enabled         bool           `json:"enabled"`
+	RuleCount       int            `json:"rule_count"`  // Active rules the feed manages
+	BlockCount      int            `json:"block_count"` // Events attributed to those rules
+	LastRunAt       *time.Time     `json:"last_run_at,omitempty"`
+	LastRun         *ThreatFeedRun `json:"last_run,omitempty"`
+	CreatedBy       *int64         `json:"created_by,omitempty"`
+	CreatedAt       time.Time      `json:"created_at"`
+}
+
+// ThreatFeedRun reports one ingestion of a feed. A failed run changes no
+// rules.
+type ThreatFeedRun struct {
+	ID                 int64               `json:"id"`
+	FeedID             int64               `json:"feed_id"`
+	Trigger            string              `json:"trigger"` // "SCHEDULE" or "MANUAL"
+	Status             string              `json:"status"`  // "SUCCESS" or "FAILED"
+	Entries            int                 `json:"entries"` // Distinct valid hashes in the feed
+	Added              int                 `json:"added"`
+	Refreshed          int                 `json:"refreshed"` // Rules still listed, kept in place
+	Retired            int                 `json:"retired"`   // Rules deleted because the feed no longer lists them
+	SkippedAllowlisted int                 `json:"skipped_allowlisted"`
+	SkippedExisting    int                 `json:"skipped_existing"` // Hashes already ruled on outside the feed
+	Invalid            int                 `json:"invalid"`          // Entries that aren't valid identifiers
+	Error              *string             `json:"error,omitempty"`
+	Refusals           []ThreatFeedRefusal `json:"refusals"` // Hashes refused because an allowlist rule covers them
+	StartedAt          time.Time           `json:"started_at"`
+	FinishedAt         time.Time           `json:"finished_at"`
+}
+
+// ThreatFeedRefusal is a feed hash left unblocked because an allowlist rule
+// covers it
+type ThreatFeedRefusal struct {
+	Identifier string `json:"identifier"`
+	RuleID     int64  `json:"rule_id"` // The allowlist rule
+	RuleType   string `json:"rule_type"`
+	Policy     string `json:"policy"`
+}
+
+type FeedFormat string
+
+const (
+	FeedFormatText FeedFormat = "TEXT" // One hash per line; # starts a comment
+	FeedFormatCSV  FeedFormat = "CSV"  // Header row naming the hash column
+	FeedFormatSTIX FeedFormat = "STIX" // STIX 2 bundle of indicators with hash patterns
+)
+
+type FeedRunTrigger string
+
+const (
+	FeedRunTriggerSchedule FeedRunTrigger = "SCHEDULE"
+	FeedRunTriggerManual   FeedRunTrigger = "MANUAL"
+)
+
+type FeedRunStatus string
+
+const (
+	FeedRunStatusSuccess FeedRunStatus = "SUCCESS"
+	FeedRunStatusFailed  FeedRunStatus = "FAILED"
+)
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+)
+
+// MachineGroup is a named set of machines that rules can be scoped to. Its
+// block message and URL templates and owner team apply to rules scoped to
+// the group that leave their own unset.
+type MachineGroup struct {
+	ID            int64     `json:"id"`
+	Name          string    `json:"name"`
+	Description   *string   `json:"description,omitempty"`
+	CustomMessage *string   `json:"custom_message,omitempty"`
+	CustomURL     *string   `json:"custom_url,omitempty"`
+	OwnerTeam     *string   `json:"owner_team,omitempty"`
+	MemberCount   int       `json:"member_count"`
+	RuleCount     int       `json:"rule_count"` // Active rules scoped to the group
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// MachineGroupMember is a machine's membership in a group
+type MachineGroupMember struct {
+	MachineID string    `json:"machine_id"`
+	Hostname  *string   `json:"hostname,omitempty"`
+	AddedAt   time.Time `json:"added_at"`
+}
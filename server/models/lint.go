@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+)
+
+// RulesetLint reports problems found in the active ruleset. Conflicts and
+// redundant rules are found by replaying the binaries seen in events against
+// every rule that matches them, in Santa's precedence order.
+type RulesetLint struct {
+	GeneratedAt    time.Time      `json:"generated_at"`
+	RuleCount      int            `json:"rule_count"`
+	BinaryCount    int            `json:"binary_count"`    // Distinct binaries from events replayed against the ruleset
+	BroadThreshold int            `json:"broad_threshold"` // Distinct binaries that make a publisher allowlist broad
+	Counts         map[string]int `json:"counts"`          // Findings per kind
+	Findings       []LintFinding  `json:"findings"`        // Warnings first, then by kind and rule
+}
+
+// LintFinding is one problem with a rule
+type LintFinding struct {
+	Kind           string  `json:"kind"`     // "CONFLICT", "REDUNDANT", "BROAD_ALLOWLIST" or "UNSEEN"
+	Severity       string  `json:"severity"` // "WARNING" or "INFO"
+	RuleID         int64   `json:"rule_id"`
+	Identifier     string  `json:"identifier"`
+	RuleType       string  `json:"rule_type"`
+	Policy         string  `json:"policy"`
+	GroupID        *int64  `json:"group_id,omitempty"`
+	RelatedRuleIDs []int64 `json:"related_rule_ids,omitempty"` // Rules that override or cover this one
+	Binaries       int     `json:"binaries,omitempty"`         // Observed binaries the finding applies to
+	Message        string  `json:"message"`
+}
+
+type LintKind string
+
+const (
+	LintKindConflict       LintKind = "CONFLICT"        // Overridden by a higher precedence rule with the opposite effect
+	LintKindRedundant      LintKind = "REDUNDANT"       // Every binary it matches gets the same policy without it
+	LintKindBroadAllowlist LintKind = "BROAD_ALLOWLIST" // A Team ID or certificate allowlist covering many binaries
+	LintKindUnseen         LintKind = "UNSEEN"          // No event has reported its identifier
+)
+
+type LintSeverity string
+
+const (
+	LintSeverityWarning LintSeverity = "WARNING"
+	LintSeverityInfo    LintSeverity = "INFO"
+)
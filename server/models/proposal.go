@@ -5,17 +5,26 @@ import (
 )
 
 type Proposal struct {
-	ID             int64      `json:"id"`
-	Identifier     string     `json:"identifier"`
-	RuleType       string     `json:"rule_type"` // "BINARY", "CERTIFICATE", "SIGNINGID", "TEAMID", "CDHASH"
-	ProposedPolicy string     `json:"proposed_policy"` // "ALLOWLIST" or "BLOCKLIST"
-	CustomMessage  *string    `json:"custom_message,omitempty"`
-	CreatedBy      int64      `json:"created_by"`
-	Status         string     `json:"status"` // "PENDING", "APPROVED", "REJECTED"
-	AllowlistVotes int        `json:"allowlist_votes"`
-	BlocklistVotes int        `json:"blocklist_votes"`
-	CreatedAt      time.Time  `json:"created_at"`
-	FinalizedAt    *time.Time `json:"finalized_at,omitempty"`
+	ID               int64      `json:"id"`
+	Identifier       string     `json:"identifier"`
+	RuleType         string     `json:"rule_type"`       // "BINARY", "CERTIFICATE", "SIGNINGID", "TEAMID", "CDHASH"
+	ProposedPolicy   string     `json:"proposed_policy"` // Any rule policy; see Rule.Policy
+	CELExpr          *string    `json:"cel_expr,omitempty"`
+	CustomMessage    *string    `json:"custom_message,omitempty"`
+	CreatedBy        *int64     `json:"created_by,omitempty"`         // NULL for proposals opened from blocked events
+	Source           string     `json:"source"`                       // "USER" or "BLOCKED_EVENT"
+	Status           string     `json:"status"`                       // "PENDING", "APPROVED", "REJECTED"
+	AutoDecision     *string    `json:"auto_decision,omitempty"`      // "APPROVE", "REJECT" or "ESCALATE" when a proposal policy matched
+	AutoPolicyID     *int64     `json:"auto_policy_id,omitempty"`     // Matching proposal policy; NULL once it is deleted
+	AutoPolicyName   *string    `json:"auto_policy_name,omitempty"`   // Name of the matching policy when it decided
+	AutoDecisionNote *string    `json:"auto_decision_note,omitempty"` // Why an approval was escalated instead
+	ConflictRuleID   *int64     `json:"conflict_rule_id,omitempty"`   // Active rule with another policy that kept the proposal pending at the vote threshold; an admin must approve with supersede
+	AllowlistVotes   int        `json:"allowlist_votes"`
+	BlocklistVotes   int        `json:"blocklist_votes"`
+	RequestCount     int        `json:"request_count"` // Blocked executions recorded against this proposal
+	LastRequestedAt  *time.Time `json:"last_requested_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	FinalizedAt      *time.Time `json:"finalized_at,omitempty"`
 }
 
 type ProposalStatus string
@@ -26,9 +35,52 @@ const (
 	ProposalStatusRejected ProposalStatus = "REJECTED"
 )
 
-// ProposalWithCreator includes creator information
+type ProposalSource string
+
+const (
+	ProposalSourceUser         ProposalSource = "USER"
+	ProposalSourceBlockedEvent ProposalSource = "BLOCKED_EVENT"
+)
+
+// ProposalWithCreator includes creator information and what the fleet has
+// reported about the proposed target
 type ProposalWithCreator struct {
 	Proposal
-	CreatorUsername string  `json:"creator_username"`
-	CreatorEmail    *string `json:"creator_email,omitempty"`
+	CreatorUsername string           `json:"creator_username"`
+	CreatorEmail    *string          `json:"creator_email,omitempty"`
+	Prevalence      Prevalence       `json:"prevalence"`
+	Signing         *SigningIdentity `json:"signing,omitempty"`
+	RelatedHashes   []string         `json:"related_hashes"`       // Other binaries sharing the signing ID
+	Evaluation      *Evaluation      `json:"evaluation"`           // Fleet-wide decision for the target under the current ruleset
+	Reputation      *Reputation      `json:"reputation,omitempty"` // Cached reputation of a BINARY target
+}
+
+// Prevalence summarizes executions of a rule target recorded in events
+type Prevalence struct {
+	MachineCount   int            `json:"machine_count"`
+	ExecutionCount int            `json:"execution_count"`
+	FirstSeen      *time.Time     `json:"first_seen,omitempty"`
+	LastSeen       *time.Time     `json:"last_seen,omitempty"`
+	Decisions      map[string]int `json:"decisions"` // Execution count per Santa decision
+}
+
+// SigningIdentity is the code signature most recently reported for a target
+type SigningIdentity struct {
+	TeamID     *string `json:"team_id,omitempty"`
+	SigningID  *string `json:"signing_id,omitempty"`
+	CertCN     *string `json:"cert_cn,omitempty"`
+	CertSHA256 *string `json:"cert_sha256,omitempty"`
+}
+
+// ProposalRequester records a machine and user that hit the block behind an
+// access request proposal
+type ProposalRequester struct {
+	ID            int64     `json:"id"`
+	ProposalID    int64     `json:"proposal_id"`
+	MachineID     string    `json:"machine_id"`
+	Hostname      *string   `json:"hostname,omitempty"`
+	ExecutingUser string    `json:"executing_user"`
+	BlockCount    int       `json:"block_count"`
+	FirstSeen     time.Time `json:"first_seen"`
+	LastSeen      time.Time `json:"last_seen"`
 }
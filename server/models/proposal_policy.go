@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+)
+
+// ProposalPolicy is an auto-decision rule consulted when a proposal is
+// opened. Policies are evaluated in priority order and the first whose CEL
+// expression is true decides the proposal.
+type ProposalPolicy struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Description *string   `json:"description,omitempty"`
+	Expression  string    `json:"expression"` // CEL over the variables of ProposalPolicyContext
+	Action      string    `json:"action"`     // "APPROVE", "REJECT" or "ESCALATE"
+	Priority    int       `json:"priority"`   // Lower values are evaluated first
+	Enabled     bool      `json:"enabled"`
+	MatchCount  int       `json:"match_count"` // Proposals this policy has decided
+	CreatedBy   *int64    `json:"created_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type AutoDecision string
+
+const (
+	AutoDecisionApprove  AutoDecision = "APPROVE"  // Finalize with the proposed policy
+	AutoDecisionReject   AutoDecision = "REJECT"   // Reject without a vote
+	AutoDecisionEscalate AutoDecision = "ESCALATE" // Keep pending for an admin; votes don't finalize it
+)
+
+// ProposalPolicyContext holds the values policy expressions are evaluated
+// against. Unknown strings are empty and an unknown detection count is -1.
+type ProposalPolicyContext struct {
+	Identifier     string `json:"identifier"`
+	RuleType       string `json:"rule_type"`
+	Policy         string `json:"policy"`      // Proposed policy
+	Source         string `json:"source"`      // "USER" or "BLOCKED_EVENT"
+	TeamID         string `json:"team_id"`     // Set for TEAMID and team-signed SIGNINGID targets
+	SigningID      string `json:"signing_id"`  // Set for SIGNINGID targets
+	CertSHA256     string `json:"cert_sha256"` // Set for CERTIFICATE targets
+	MachineCount   int    `json:"machine_count"`
+	ExecutionCount int    `json:"execution_count"`
+	BlockCount     int    `json:"block_count"`
+	Requester      string `json:"requester"`       // Username, or the executing user of a blocked event
+	RequesterEmail string `json:"requester_email"` // Empty for blocked events
+	RequesterRole  string `json:"requester_role"`  // "ADMIN" or "USER"; empty for blocked events
+	Detections     int    `json:"detections"`      // From the cached binary reputation
+	KnownGood      bool   `json:"known_good"`
+}
+
+// ProposalPolicyResult is the outcome of evaluating the policies for a
+// proposal
+type ProposalPolicyResult struct {
+	Context    ProposalPolicyContext `json:"context"`
+	Decision   *string               `json:"decision,omitempty"` // Action of the matching policy; nil when none matched
+	PolicyID   *int64                `json:"policy_id,omitempty"`
+	PolicyName *string               `json:"policy_name,omitempty"`
+	Note       *string               `json:"note,omitempty"` // Why an approval was escalated instead
+	Errors     []string              `json:"errors,omitempty"`
+}
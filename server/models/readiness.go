@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+)
+
+// LockdownReadiness reports what would break if the analyzed machines moved
+// from MONITOR to LOCKDOWN, by replaying their recorded executions against
+// the current ruleset
+type LockdownReadiness struct {
+	Since              time.Time             `json:"since"`
+	Until              time.Time             `json:"until"`
+	Ready              bool                  `json:"ready"` // No execution would be newly blocked
+	MachineCount       int                   `json:"machine_count"`
+	Executions         int                   `json:"executions"`      // Executions replayed
+	AllowedByRule      int                   `json:"allowed_by_rule"` // Covered by an allowlist rule
+	BlockedByRule      int                   `json:"blocked_by_rule"` // Blocked by a rule in either mode
+	WouldBlock         int                   `json:"would_block"`     // Unknown binaries LOCKDOWN would block
+	WouldBlockBinaries int                   `json:"would_block_binaries"`
+	Machines           []ReadinessMachine    `json:"machines"`
+	Binaries           []ReadinessBinary     `json:"binaries"`    // Binaries that would be blocked, most impactful first
+	Suggestions        []ReadinessSuggestion `json:"suggestions"` // Rules that would cover them, most impactful first
+}
+
+// ReadinessMachine summarizes the replay for one machine
+type ReadinessMachine struct {
+	MachineID  string  `json:"machine_id"`
+	Hostname   *string `json:"hostname,omitempty"`
+	ClientMode *string `json:"client_mode,omitempty"`
+	Executions int     `json:"executions"`
+	WouldBlock int     `json:"would_block"`
+	Binaries   int     `json:"binaries"` // Distinct binaries that would be blocked
+	Ready      bool    `json:"ready"`
+}
+
+// ReadinessBinary is a binary LOCKDOWN would block, with its impact
+type ReadinessBinary struct {
+	SHA256     string     `json:"sha256"`
+	FilePath   *string    `json:"file_path,omitempty"`
+	BundleName *string    `json:"bundle_name,omitempty"`
+	SigningID  *string    `json:"signing_id,omitempty"`
+	TeamID     *string    `json:"team_id,omitempty"`
+	Executions int        `json:"executions"`
+	Machines   int        `json:"machines"`
+	Users      int        `json:"users"`
+	Suggested  RuleTarget `json:"suggested"`
+}
+
+// ReadinessSuggestion is a rule that would let blocked binaries run
+type ReadinessSuggestion struct {
+	RuleTarget
+	Binaries   int    `json:"binaries"` // Blocked binaries the rule covers
+	Executions int    `json:"executions"`
+	Machines   int    `json:"machines"`
+	Users      int    `json:"users"`
+	ProposalID *int64 `json:"proposal_id,omitempty"` // Pending proposal for the rule
+}
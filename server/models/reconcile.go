@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+)
+
+// RuleReconcile reports one reconcile of the GitOps rules directory
+type RuleReconcile struct {
+	ID          int64             `json:"id,omitempty"`
+	Trigger     string            `json:"trigger"` // "STARTUP", "CHANGE" or "MANUAL"
+	Status      string            `json:"status"`  // "APPLIED" or "FAILED"
+	DryRun      bool              `json:"dry_run,omitempty"`
+	Fingerprint string            `json:"fingerprint"`
+	Added       []ReconcileChange `json:"added"`
+	Updated     []ReconcileChange `json:"updated"`
+	Removed     []ReconcileChange `json:"removed"`
+	Unchanged   int               `json:"unchanged"`
+	Errors      []ReconcileError  `json:"errors"`
+	StartedAt   time.Time         `json:"started_at"`
+	FinishedAt  time.Time         `json:"finished_at"`
+}
+
+type ReconcileTrigger string
+
+const (
+	ReconcileTriggerStartup ReconcileTrigger = "STARTUP"
+	ReconcileTriggerChange  ReconcileTrigger = "CHANGE"
+	ReconcileTriggerManual  ReconcileTrigger = "MANUAL"
+)
+
+type ReconcileStatus string
+
+const (
+	ReconcileStatusApplied ReconcileStatus = "APPLIED"
+	ReconcileStatusFailed  ReconcileStatus = "FAILED"
+)
+
+// ReconcileChange is a rule added, updated or removed by a reconcile
+type ReconcileChange struct {
+	File             string                 `json:"file,omitempty"`
+	Identifier       string                 `json:"identifier"`
+	RuleType         string                 `json:"rule_type"`
+	Policy           string                 `json:"policy"`
+	RuleID           int64                  `json:"rule_id,omitempty"`
+	SupersededRuleID int64                  `json:"superseded_rule_id,omitempty"` // Database-managed rule taken over by the file
+	Diff             map[string]FieldChange `json:"diff,omitempty"`
+}
+
+// ReconcileError points at an invalid file or rule in the rules directory
+type ReconcileError struct {
+	File    string `json:"file"`
+	Index   int    `json:"index,omitempty"` // 1-based position of the rule in the file
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
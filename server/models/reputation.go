@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// Reputation is what an outside source reports about a binary's SHA-256
+type Reputation struct {
+	Identifier string     `json:"identifier"`
+	Provider   string     `json:"provider"`             // Name of the provider that answered
+	Found      bool       `json:"found"`                // Whether the provider knows the hash
+	Detections int        `json:"detections"`           // Engines or sources flagging the hash as malicious
+	Engines    int        `json:"engines,omitempty"`    // Engines or sources consulted, when reported
+	FirstSeen  *time.Time `json:"first_seen,omitempty"` // When the provider first saw the hash
+	KnownGood  bool       `json:"known_good"`           // Listed as a known-good file, such as a vendor release
+	Link       *string    `json:"link,omitempty"`       // Provider's page for the hash
+	CheckedAt  time.Time  `json:"checked_at"`
+}
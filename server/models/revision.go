@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+)
+
+// RulesetRevision is one numbered change to the ruleset: every rule version
+// written by the same transaction, such as a single edit, an import or a
+// reconcile, belongs to one revision
+type RulesetRevision struct {
+	Revision          int64          `json:"revision"`
+	ChangedBy         *int64         `json:"changed_by,omitempty"`
+	ChangedByUsername *string        `json:"changed_by_username,omitempty"`
+	Reason            *string        `json:"reason,omitempty"`
+	RolledBackTo      *int64         `json:"rolled_back_to,omitempty"` // Set when the revision restored an earlier one
+	Changes           int            `json:"changes"`                  // Rule versions recorded in the revision
+	RuleCount         int            `json:"rule_count"`               // Active rules once the revision was applied
+	CreatedAt         time.Time      `json:"created_at"`
+	Rules             []RulesetEntry `json:"rules,omitempty"` // Ruleset as of the revision
+}
+
+// RulesetEntry is a rule as it stood in a ruleset revision
+type RulesetEntry struct {
+	RuleID        int64      `json:"rule_id"`
+	Identifier    string     `json:"identifier"`
+	Policy        string     `json:"policy"`
+	RuleType      string     `json:"rule_type"`
+	CELExpr       *string    `json:"cel_expr,omitempty"`
+	CustomMessage *string    `json:"custom_message,omitempty"`
+	CustomURL     *string    `json:"custom_url,omitempty"`
+	OwnerTeam     *string    `json:"owner_team,omitempty"`
+	Comment       *string    `json:"comment,omitempty"`
+	ProposalID    *int64     `json:"proposal_id,omitempty"`
+	GroupID       *int64     `json:"group_id,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+}
+
+// RulesetDiff lists how the ruleset changed between two revisions, matching
+// rules by identifier and rule type
+type RulesetDiff struct {
+	From    int64           `json:"from"`
+	To      int64           `json:"to"`
+	Added   []RulesetEntry  `json:"added"`
+	Removed []RulesetEntry  `json:"removed"`
+	Changed []RulesetChange `json:"changed"`
+}
+
+// RulesetChange is a rule present in both revisions with different settings
+type RulesetChange struct {
+	Identifier string                 `json:"identifier"`
+	RuleType   string                 `json:"rule_type"`
+	GroupID    *int64                 `json:"group_id,omitempty"` // Unset for fleet-wide rules
+	Diff       map[string]FieldChange `json:"diff"`
+}
+
+// RulesetRollback reports a rollback to an earlier revision
+type RulesetRollback struct {
+	RulesetDiff
+	Revision int64          `json:"revision"` // Revision the rollback recorded
+	Skipped  []RulesetEntry `json:"skipped"`  // Rules managed by the rules directory or a threat feed, left as they are
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"time"
+)
+
+// RuleRollout serves a new rule to machine groups in stages. While the
+// rollout is ACTIVE or HALTED the rule only reaches members of the groups in
+// the stages up to CurrentStage; once COMPLETED it applies as if it had no
+// rollout.
+type RuleRollout struct {
+	ID              int64          `json:"id"`
+	RuleID          int64          `json:"rule_id"`
+	Identifier      string         `json:"identifier"`
+	RuleType        string         `json:"rule_type"`
+	Policy          string         `json:"policy"`
+	Status          string         `json:"status"`        // "ACTIVE", "HALTED", "COMPLETED" or "ABORTED"
+	CurrentStage    int            `json:"current_stage"` // Position of the last stage reached
+	HaltThreshold   int            `json:"halt_threshold"`
+	HaltReason      *string        `json:"halt_reason,omitempty"`
+	StageStartedAt  time.Time      `json:"stage_started_at"`
+	NextPromotionAt *time.Time     `json:"next_promotion_at,omitempty"` // Set while the current stage has a hold time
+	CreatedBy       *int64         `json:"created_by,omitempty"`
+	CreatedAt       time.Time      `json:"created_at"`
+	CompletedAt     *time.Time     `json:"completed_at,omitempty"`
+	Stages          []RolloutStage `json:"stages"`
+}
+
+// RolloutStage is one group a rollout reaches, with its progress
+type RolloutStage struct {
+	Position       int        `json:"position"`
+	GroupID        int64      `json:"group_id"`
+	GroupName      string     `json:"group_name"`
+	HoldMinutes    *int       `json:"hold_minutes,omitempty"` // nil waits for manual promotion
+	Reached        bool       `json:"reached"`
+	ReachedAt      *time.Time `json:"reached_at,omitempty"`
+	MachineCount   int        `json:"machine_count"`
+	SyncedMachines int        `json:"synced_machines"` // Members that downloaded rules since the stage was reached
+	BlockEvents    int        `json:"block_events"`    // Blocks attributed to the rule on members since the stage was reached
+}
+
+// RuleRolloutSummary is the rollout state attached to a rule
+type RuleRolloutSummary struct {
+	ID           int64   `json:"id"`
+	Status       string  `json:"status"`
+	CurrentStage int     `json:"current_stage"`
+	StageCount   int     `json:"stage_count"`
+	GroupIDs     []int64 `json:"group_ids"` // Groups the rule has reached so far
+}
+
+type RolloutStatus string
+
+const (
+	RolloutStatusActive    RolloutStatus = "ACTIVE"
+	RolloutStatusHalted    RolloutStatus = "HALTED"
+	RolloutStatusCompleted RolloutStatus = "COMPLETED"
+	RolloutStatusAborted   RolloutStatus = "ABORTED"
+)
@@ -7,13 +7,90 @@ import (
 type Rule struct {
 	ID            int64      `json:"id"`
 	Identifier    string     `json:"identifier"`
-	Policy        string     `json:"policy"` // "ALLOWLIST" or "BLOCKLIST"
-	RuleType      string     `json:"rule_type"` // "BINARY", "CERTIFICATE", "SIGNINGID", "TEAMID", "CDHASH"
-	CustomMessage *string    `json:"custom_message,omitempty"`
-	Comment       *string    `json:"comment,omitempty"` // Internal comment for identifying the application
+	Policy        string     `json:"policy"`                   // "ALLOWLIST", "ALLOWLIST_COMPILER", "BLOCKLIST", "SILENT_BLOCKLIST", "REMOVE" or "CEL"
+	RuleType      string     `json:"rule_type"`                // "BINARY", "CERTIFICATE", "SIGNINGID", "TEAMID", "CDHASH"
+	CELExpr       *string    `json:"cel_expr,omitempty"`       // Expression deciding executions for CEL rules
+	CustomMessage *string    `json:"custom_message,omitempty"` // Block message template; see RuleDownload
+	CustomURL     *string    `json:"custom_url,omitempty"`     // Block URL template
+	OwnerTeam     *string    `json:"owner_team,omitempty"`     // Team responsible for the rule, for templates
+	Comment       *string    `json:"comment,omitempty"`        // Internal comment for identifying the application
 	CreatedBy     *int64     `json:"created_by,omitempty"`
 	ProposalID    *int64     `json:"proposal_id,omitempty"`
 	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     *time.Time `json:"updated_at,omitempty"`
+	SupersededBy  *int64     `json:"superseded_by,omitempty"` // Rule that replaced this one
+	SupersededAt  *time.Time `json:"superseded_at,omitempty"` // Set once the rule is no longer active
+	Source        string     `json:"source"`                  // "DATABASE", "GITOPS" or "FEED"
+	SourceFile    *string    `json:"source_file,omitempty"`   // Rules directory file defining a GITOPS rule
+	FeedID        *int64     `json:"feed_id,omitempty"`       // Threat feed managing a FEED rule
+	GroupID       *int64     `json:"group_id,omitempty"`      // Machine group the rule is limited to; nil applies fleet-wide
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`    // After this the rule is no longer served or evaluated
+	HitCount      int        `json:"hit_count"`               // Uploaded events whose decision this rule produced
+	LastHitAt     *time.Time `json:"last_hit_at,omitempty"`   // Execution time of the most recent such event
+
+	Rollout *RuleRolloutSummary `json:"rollout,omitempty"` // Staged rollout, if the rule was created with one
+}
+
+// RuleVersion is a snapshot of a rule taken after each change
+type RuleVersion struct {
+	ID                int64                  `json:"id"`
+	RuleID            int64                  `json:"rule_id"`
+	Version           int                    `json:"version"`
+	Action            string                 `json:"action"` // "CREATE", "UPDATE", "RESTORE", "DELETE", "SUPERSEDE"
+	Identifier        string                 `json:"identifier"`
+	Policy            string                 `json:"policy"`
+	RuleType          string                 `json:"rule_type"`
+	CELExpr           *string                `json:"cel_expr,omitempty"`
+	CustomMessage     *string                `json:"custom_message,omitempty"`
+	CustomURL         *string                `json:"custom_url,omitempty"`
+	OwnerTeam         *string                `json:"owner_team,omitempty"`
+	Comment           *string                `json:"comment,omitempty"`
+	ProposalID        *int64                 `json:"proposal_id,omitempty"`
+	GroupID           *int64                 `json:"group_id,omitempty"`
+	ExpiresAt         *time.Time             `json:"expires_at,omitempty"`
+	Revision          *int64                 `json:"revision,omitempty"` // Ruleset revision the change belongs to
+	ChangedBy         *int64                 `json:"changed_by,omitempty"`
+	ChangedByUsername *string                `json:"changed_by_username,omitempty"`
+	Reason            *string                `json:"reason,omitempty"`
+	Diff              map[string]FieldChange `json:"diff"`
+	CreatedAt         time.Time              `json:"created_at"`
+}
+
+// FieldChange records the old and new value of one field in a rule version
+type FieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+type RuleVersionAction string
+
+const (
+	RuleVersionCreate    RuleVersionAction = "CREATE"
+	RuleVersionUpdate    RuleVersionAction = "UPDATE"
+	RuleVersionRestore   RuleVersionAction = "RESTORE"
+	RuleVersionDelete    RuleVersionAction = "DELETE"
+	RuleVersionSupersede RuleVersionAction = "SUPERSEDE"
+)
+
+// RuleDuplicate records a rule superseded by the one-time duplicate cleanup
+type RuleDuplicate struct {
+	ID               int64     `json:"id"`
+	Identifier       string    `json:"identifier"`
+	RuleType         string    `json:"rule_type"`
+	KeptRuleID       int64     `json:"kept_rule_id"`
+	KeptPolicy       string    `json:"kept_policy"`
+	SupersededRuleID int64     `json:"superseded_rule_id"`
+	SupersededPolicy string    `json:"superseded_policy"`
+	DetectedAt       time.Time `json:"detected_at"`
+}
+
+// RuleContradiction is a target with several active rules in one scope, left
+// by the duplicate cleanup for an admin to choose between
+type RuleContradiction struct {
+	Identifier string `json:"identifier"`
+	RuleType   string `json:"rule_type"`
+	GroupID    *int64 `json:"group_id,omitempty"` // Unset for fleet-wide rules
+	Rules      []Rule `json:"rules"`              // Newest first
 }
 
 type Policy string
@@ -21,6 +98,31 @@ type Policy string
 const (
 	PolicyAllowlist Policy = "ALLOWLIST"
 	PolicyBlocklist Policy = "BLOCKLIST"
+
+	// PolicySilentBlocklist blocks without showing the user a notification
+	PolicySilentBlocklist Policy = "SILENT_BLOCKLIST"
+
+	// PolicyAllowlistCompiler allows a compiler and, while transitive rules
+	// are enabled, the binaries it produces
+	PolicyAllowlistCompiler Policy = "ALLOWLIST_COMPILER"
+
+	// PolicyRemove deletes whatever rule clients hold for the target
+	PolicyRemove Policy = "REMOVE"
+
+	// PolicyCEL rules leave the decision to a CEL expression Santa
+	// evaluates for each matching execution
+	PolicyCEL Policy = "CEL"
+)
+
+// RuleSource records where a rule is managed. GITOPS rules are reconciled
+// from the rules directory and FEED rules from a threat feed; both are
+// read-only through the API.
+type RuleSource string
+
+const (
+	RuleSourceDatabase RuleSource = "DATABASE"
+	RuleSourceGitOps   RuleSource = "GITOPS"
+	RuleSourceFeed     RuleSource = "FEED"
 )
 
 type RuleType string
@@ -35,9 +137,10 @@ const (
 
 // Santa sync protocol rule format
 type SantaRule struct {
-	Identifier  string  `json:"identifier"`
-	Policy      string  `json:"policy"`
-	RuleType    string  `json:"rule_type"`
-	CustomMsg   *string `json:"custom_msg,omitempty"`
-	CustomURL   *string `json:"custom_url,omitempty"`
+	Identifier string  `json:"identifier"`
+	Policy     string  `json:"policy"`
+	RuleType   string  `json:"rule_type"`
+	CustomMsg  *string `json:"custom_msg,omitempty"`
+	CustomURL  *string `json:"custom_url,omitempty"`
+	CELExpr    *string `json:"cel_expr,omitempty"` // Only for the CEL policy
 }
@@ -0,0 +1,14 @@
+package models
+
+import (
+	"time"
+)
+
+// Settings are the server-wide options admins change at runtime. Each is
+// stored as one row of the settings table and falls back to its default
+// until first set.
+type Settings struct {
+	EnableTransitiveRules bool       `json:"enable_transitive_rules"` // Lets ALLOWLIST_COMPILER rules allow the binaries compilers produce
+	UpdatedBy             *int64     `json:"updated_by,omitempty"`
+	UpdatedAt             *time.Time `json:"updated_at,omitempty"`
+}
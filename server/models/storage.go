@@ -0,0 +1,75 @@
+package models
+
+import (
+	"time"
+)
+
+// StorageReport describes how much of the database each table uses and what
+// retention has pruned from it
+type StorageReport struct {
+	Path         string            `json:"path"`
+	PageSize     int64             `json:"page_size"`
+	PageCount    int64             `json:"page_count"`
+	FreePages    int64             `json:"free_pages"` // Pages freed by deletes and not yet returned by a vacuum
+	SizeBytes    int64             `json:"size_bytes"`
+	FreeBytes    int64             `json:"free_bytes"`
+	AutoVacuum   string            `json:"auto_vacuum"`             // "NONE", "FULL" or "INCREMENTAL"
+	ArchiveStore string            `json:"archive_store,omitempty"` // Store pruned events are archived to
+	Tables       []TableStorage    `json:"tables"`
+	Policies     []RetentionPolicy `json:"policies"`
+	RecentRuns   []RetentionRun    `json:"recent_runs"`
+	LastVacuum   *time.Time        `json:"last_vacuum_at,omitempty"` // Last full VACUUM run by retention
+	NextVacuum   *time.Time        `json:"next_vacuum_at,omitempty"` // When VACUUM_INTERVAL next allows one
+}
+
+// TableStorage is one table's share of the database. Without SQLite's dbstat
+// table, bytes are estimated from the payload of a sample of rows and exclude
+// indexes.
+type TableStorage struct {
+	Name         string     `json:"name"`
+	Rows         int64      `json:"rows"`
+	Bytes        int64      `json:"bytes"`
+	IndexBytes   int64      `json:"index_bytes"`
+	Estimated    bool       `json:"estimated"`
+	PrunedRows   int64      `json:"pruned_rows"` // Rows retention has deleted, all time
+	LastPrunedAt *time.Time `json:"last_pruned_at,omitempty"`
+	Horizon      *int64     `json:"horizon,omitempty"` // Oldest ruleset revision kept in full, for rule history
+}
+
+// RetentionPolicy is the configured retention for a category of tables; zero
+// means unlimited
+type RetentionPolicy struct {
+	Category string   `json:"category"` // "EVENTS", "SYNC_HISTORY" or "AUDIT"
+	Tables   []string `json:"tables"`
+	Days     int      `json:"days"`
+	MaxRows  int      `json:"max_rows"` // Per table
+}
+
+// RetentionRun reports one pass of pruning and vacuuming
+type RetentionRun struct {
+	ID         int64            `json:"id,omitempty"`
+	Trigger    string           `json:"trigger"` // "SCHEDULE" or "MANUAL"
+	Status     string           `json:"status"`  // "SUCCESS" or "FAILED"
+	Pruned     map[string]int64 `json:"pruned"`  // Rows deleted per table
+	Vacuum     string           `json:"vacuum"`  // "NONE", "INCREMENTAL" or "FULL"
+	FreedBytes int64            `json:"freed_bytes"`
+	Error      *string          `json:"error,omitempty"`
+	StartedAt  time.Time        `json:"started_at"`
+	FinishedAt time.Time        `json:"finished_at"`
+}
+
+type RetentionTrigger string
+
+const (
+	RetentionTriggerSchedule RetentionTrigger = "SCHEDULE"
+	RetentionTriggerManual   RetentionTrigger = "MANUAL"
+)
+
+// EventDailyStat counts the events pruned for a machine, day and decision,
+// so activity trends outlive the events themselves
+type EventDailyStat struct {
+	Day       string `json:"day"` // YYYY-MM-DD, UTC
+	MachineID string `json:"machine_id"`
+	Decision  string `json:"decision"`
+	Events    int64  `json:"events"`
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"krampus/server/database"
+	"krampus/server/models"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrBinaryNotSeen is returned when an access request names a machine that
+// never reported executing the binary
+var ErrBinaryNotSeen = errors.New("machine has not reported executing this binary")
+
+// IsBlockDecision reports whether a Santa decision string denied execution.
+// Santa sends detailed values such as "BLOCK_BINARY" or "BLOCK_UNKNOWN".
+func IsBlockDecision(decision string) bool {
+	return strings.HasPrefix(strings.ToUpper(decision), "BLOCK")
+}
+
+// RecordBlockedExecution opens an access request proposal for a binary that
+// was blocked for lack of a rule, or joins the pending proposal that already
+// covers it, and records which machine and user hit the block
+func RecordBlockedExecution(machineID string, event models.SantaEvent) error {
+	if event.FileSHA256 == "" {
+		return nil
+	}
+
+	// Blocks by a rule of any type were deliberate decisions; only binaries
+	// Santa knew nothing about are worth asking for
+	if !strings.EqualFold(event.Decision, "BLOCK_UNKNOWN") {
+		return nil
+	}
+
+	// A rule may have been created for the binary, its certificate or its
+	// team since the client decided
+	attrs := models.BinaryAttributes{
+		SHA256:     event.FileSHA256,
+		CDHash:     event.CDHash,
+		SigningID:  event.SigningID,
+		TeamID:     event.TeamID,
+		CertSHA256: event.CertificateSHA256,
+	}
+	normalizeAttributes(&attrs)
+	eval, err := EvaluateBinary(attrs, machineID)
+	if errors.Is(err, ErrMachineNotFound) {
+		eval, err = EvaluateBinary(attrs, "")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to evaluate blocked binary: %w", err)
+	}
+	if eval.Rule != nil {
+		return nil
+	}
+
+	seenAt := time.Unix(int64(event.ExecutionTime), 0)
+	if event.ExecutionTime == 0 {
+		seenAt = time.Now()
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	message := blockedEventName(event)
+	proposalID, created, err := openProposal(tx, models.Proposal{
+		Identifier:     event.FileSHA256,
+		RuleType:       string(models.RuleTypeBinary),
+		ProposedPolicy: string(models.PolicyAllowlist),
+		CustomMessage:  &message,
+		Source:         string(models.ProposalSourceBlockedEvent),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := recordRequester(tx, proposalID, machineID, event.ExecutingUser, seenAt); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if created {
+		if _, err := ApplyProposalPolicies(proposalID); err != nil {
+			log.Printf("Failed to apply proposal policies to proposal %d: %v", proposalID, err)
+		}
+	}
+	return nil
+}
+
+// AccessRequest reports the proposal a user's access request created or
+// joined
+type AccessRequest struct {
+	ProposalID       int64
+	Target           models.RuleTarget // Target as normalized for the proposal
+	Joined           bool
+	ConflictingRules []models.Rule // Active rules for the target with another policy, which approval must supersede
+}
+
+// RequestAccess creates an access request proposal on behalf of a user, or
+// joins the pending proposal that already targets the same rule. The target
+// is validated and checked against active rules like any other proposal. A
+// machine is only listed as a requester if it is enrolled and has reported
+// executing the binary with the given hash.
+func RequestAccess(userID int64, hash string, target models.RuleTarget, machineID, username string, message *string) (*AccessRequest, error) {
+	if machineID != "" {
+		if err := checkRequestingMachine(machineID, hash); err != nil {
+			return nil, err
+		}
+	}
+
+	target.Identifier = NormalizeIdentifier(target.RuleType, target.Identifier)
+	if errs := ValidateRule(target.Identifier, target.RuleType, string(models.PolicyAllowlist), "", "proposed_policy"); len(errs) > 0 {
+		return nil, errs
+	}
+
+	sameRules, oppositeRules, err := FindRuleConflicts(target.Identifier, target.RuleType, nil, string(models.PolicyAllowlist), "")
+	if err != nil {
+		return nil, err
+	}
+	if len(sameRules) > 0 {
+		return nil, &RuleAppliesError{RuleID: sameRules[0].ID}
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	proposalID, created, err := openProposal(tx, models.Proposal{
+		Identifier:     target.Identifier,
+		RuleType:       target.RuleType,
+		ProposedPolicy: string(models.PolicyAllowlist),
+		CustomMessage:  message,
+		CreatedBy:      &userID,
+		Source:         string(models.ProposalSourceUser),
+	})
+	if err != nil {
+		return nil, err
+	}
+	joined := !created
+
+	// List the requester alongside machines that reported blocks, without
+	// counting the request itself as another blocked execution
+	if machineID != "" {
+		_, err = tx.Exec(
+			`INSERT INTO proposal_requesters (proposal_id, machine_id, executing_user, block_count)
+			 VALUES (?, ?, ?, 0)
+			 ON CONFLICT(proposal_id, machine_id, executing_user) DO NOTHING`,
+			proposalID, machineID, username,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to record requester: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if !joined {
+		if _, err := ApplyProposalPolicies(proposalID); err != nil {
+			log.Printf("Failed to apply proposal policies to proposal %d: %v", proposalID, err)
+		}
+	}
+	return &AccessRequest{ProposalID: proposalID, Target: target, Joined: joined, ConflictingRules: oppositeRules}, nil
+}
+
+// checkRequestingMachine confirms a machine named by an access request is
+// enrolled and has reported executing the binary
+func checkRequestingMachine(machineID, hash string) error {
+	var enrolled, seen bool
+	err := database.DB.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM machines WHERE machine_id = ?),
+		        EXISTS(SELECT 1 FROM events WHERE machine_id = ? AND file_hash = ?)`,
+		machineID, machineID, hash,
+	).Scan(&enrolled, &seen)
+	if err != nil {
+		return fmt.Errorf("failed to check requesting machine: %w", err)
+	}
+	if !enrolled {
+		return ErrMachineNotFound
+	}
+	if !seen {
+		return ErrBinaryNotSeen
+	}
+	return nil
+}
+
+// recordRequester bumps the demand counters on a proposal for one blocked execution
+func recordRequester(tx *sql.Tx, proposalID int64, machineID, executingUser string, seenAt time.Time) error {
+	_, err := tx.Exec(
+		`UPDATE proposals SET request_count = request_count + 1, last_requested_at = ? WHERE id = ?`,
+		seenAt, proposalID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update request count: %w", err)
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO proposal_requesters (proposal_id, machine_id, executing_user, block_count, first_seen, last_seen)
+		 VALUES (?, ?, ?, 1, ?, ?)
+		 ON CONFLICT(proposal_id, machine_id, executing_user) DO UPDATE SET
+		   block_count = block_count + 1,
+		   last_seen = MAX(last_seen, excluded.last_seen)`,
+		proposalID, machineID, executingUser, seenAt, seenAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record requester: %w", err)
+	}
+	return nil
+}
+
+// blockedEventName picks a human readable name for the blocked binary, used
+// as the proposal message and later as the rule comment
+func blockedEventName(event models.SantaEvent) string {
+	switch {
+	case event.BundleName != "":
+		return event.BundleName
+	case event.FileName != "":
+		return event.FileName
+	case event.FilePath != "":
+		return filepath.Base(event.FilePath)
+	}
+	return event.FileSHA256
+}
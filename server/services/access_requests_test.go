@@ -0,0 +1,266 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"krampus/server/database"
+	"krampus/server/models"
+	"reflect"
+	"testing"
+)
+
+func TestRecordBlockedExecution(t *testing.T) {
+	blocked := func(machineID, user, decision string) blockedExecution {
+		return blockedExecution{machineID, models.SantaEvent{
+			FileSHA256:    testHash,
+			FileName:      "tool",
+			ExecutingUser: user,
+			ExecutionTime: 1767225600,
+			Decision:      decision,
+			TeamID:        testTeamID,
+		}}
+	}
+
+	tests := []struct {
+		name       string
+		setup      func(t *testing.T)
+		executions []blockedExecution
+		// Requests recorded against the pending ALLOWLIST proposal, per
+		// machine and user; nil when none should be open
+		requesters map[string]int
+		requests   int
+		proposals  int
+	}{
+		{
+			name:       "unknown binary opens a proposal",
+			executions: []blockedExecution{blocked("m1", "alice", "BLOCK_UNKNOWN")},
+			requesters: map[string]int{"m1/alice": 1},
+			requests:   1,
+			proposals:  1,
+		},
+		{
+			name: "repeat blocks join the proposal",
+			executions: []blockedExecution{
+				blocked("m1", "alice", "BLOCK_UNKNOWN"),
+				blocked("m1", "alice", "BLOCK_UNKNOWN"),
+				blocked("m2", "bob", "BLOCK_UNKNOWN"),
+			},
+			requesters: map[string]int{"m1/alice": 2, "m2/bob": 1},
+			requests:   3,
+			proposals:  1,
+		},
+		{
+			name:       "allowed execution",
+			executions: []blockedExecution{blocked("m1", "alice", "ALLOW_UNKNOWN")},
+		},
+		{
+			name:       "blocked by a rule",
+			executions: []blockedExecution{blocked("m1", "alice", "BLOCK_BINARY")},
+		},
+		{
+			name: "rule created since the block",
+			setup: func(t *testing.T) {
+				createTestRule(t, models.Rule{
+					Identifier: testTeamID, RuleType: string(models.RuleTypeTeamID), Policy: string(models.PolicyAllowlist),
+				})
+			},
+			executions: []blockedExecution{blocked("m1", "alice", "BLOCK_UNKNOWN")},
+		},
+		{
+			name: "pending blocklist proposal for the binary",
+			setup: func(t *testing.T) {
+				_, _, err := OpenProposal(models.Proposal{
+					Identifier:     testHash,
+					RuleType:       string(models.RuleTypeBinary),
+					ProposedPolicy: string(models.PolicyBlocklist),
+					Source:         string(models.ProposalSourceUser),
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			executions: []blockedExecution{blocked("m1", "alice", "BLOCK_UNKNOWN")},
+			requesters: map[string]int{"m1/alice": 1},
+			requests:   1,
+			proposals:  2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			mustExec(t, `INSERT INTO machines (machine_id) VALUES ('m1'), ('m2')`)
+			if tt.setup != nil {
+				tt.setup(t)
+			}
+			for _, e := range tt.executions {
+				if err := RecordBlockedExecution(e.machineID, e.event); err != nil {
+					t.Fatalf("RecordBlockedExecution: %v", err)
+				}
+			}
+
+			var proposals int
+			if err := database.DB.QueryRow(`SELECT COUNT(*) FROM proposals`).Scan(&proposals); err != nil {
+				t.Fatal(err)
+			}
+			if proposals != tt.proposals {
+				t.Errorf("%d proposals, want %d", proposals, tt.proposals)
+			}
+
+			proposalID, err := FindPendingProposal(testHash, string(models.RuleTypeBinary), string(models.PolicyAllowlist))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (proposalID != 0) != (tt.requesters != nil) {
+				t.Fatalf("pending allowlist proposal %d, want one: %v", proposalID, tt.requesters != nil)
+			}
+			if proposalID == 0 {
+				return
+			}
+
+			var requests int
+			if err := database.DB.QueryRow(`SELECT request_count FROM proposals WHERE id = ?`, proposalID).Scan(&requests); err != nil {
+				t.Fatal(err)
+			}
+			if requests != tt.requests {
+				t.Errorf("request count = %d, want %d", requests, tt.requests)
+			}
+
+			rows, err := database.DB.Query(
+				`SELECT machine_id, executing_user, block_count FROM proposal_requesters WHERE proposal_id = ?`, proposalID,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			requesters := map[string]int{}
+			for rows.Next() {
+				var machineID, user string
+				var count int
+				if err := rows.Scan(&machineID, &user, &count); err != nil {
+					t.Fatal(err)
+				}
+				requesters[machineID+"/"+user] = count
+			}
+			if !reflect.DeepEqual(requesters, tt.requesters) {
+				t.Errorf("requesters = %v, want %v", requesters, tt.requesters)
+			}
+		})
+	}
+}
+
+type blockedExecution struct {
+	machineID string
+	event     models.SantaEvent
+}
+
+func TestRequestAccess(t *testing.T) {
+	binary := models.RuleTarget{Identifier: testHash, RuleType: string(models.RuleTypeBinary)}
+
+	tests := []struct {
+		name      string
+		setup     func(t *testing.T)
+		target    models.RuleTarget
+		machineID string
+		joined    bool
+		err       error
+		// requester is the machine listed on the proposal, if any
+		requester string
+	}{
+		{
+			name:   "without a machine",
+			target: binary,
+		},
+		{
+			name:      "from a machine that ran the binary",
+			target:    binary,
+			machineID: "m1",
+			requester: "m1",
+		},
+		{
+			name:   "team of the binary, upper-cased",
+			target: models.RuleTarget{Identifier: "abcde12345", RuleType: string(models.RuleTypeTeamID)},
+		},
+		{
+			name: "joins a pending request",
+			setup: func(t *testing.T) {
+				if _, err := RequestAccess(1, testHash, binary, "", "alice", nil); err != nil {
+					t.Fatal(err)
+				}
+			},
+			target:    binary,
+			machineID: "m1",
+			joined:    true,
+			requester: "m1",
+		},
+		{
+			name:      "unknown machine",
+			target:    binary,
+			machineID: "forged",
+			err:       ErrMachineNotFound,
+		},
+		{
+			name:      "machine that never ran the binary",
+			target:    binary,
+			machineID: "m2",
+			err:       ErrBinaryNotSeen,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			mustExec(t, `INSERT INTO users (id, username, role) VALUES (1, 'alice', 'USER')`)
+			mustExec(t, `INSERT INTO machines (machine_id) VALUES ('m1'), ('m2')`)
+			mustExec(t, `INSERT INTO events (machine_id, file_hash, decision) VALUES ('m1', ?, 'BLOCK_UNKNOWN')`, testHash)
+			if tt.setup != nil {
+				tt.setup(t)
+			}
+
+			request, err := RequestAccess(1, testHash, tt.target, tt.machineID, "alice", nil)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("RequestAccess error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if request.Joined != tt.joined {
+				t.Errorf("joined = %v, want %v", request.Joined, tt.joined)
+			}
+			if want := NormalizeIdentifier(tt.target.RuleType, tt.target.Identifier); request.Target.Identifier != want {
+				t.Errorf("identifier = %s, want %s", request.Target.Identifier, want)
+			}
+
+			var requester sql.NullString
+			err = database.DB.QueryRow(
+				`SELECT machine_id FROM proposal_requesters WHERE proposal_id = ?`, request.ProposalID,
+			).Scan(&requester)
+			if err != nil && err != sql.ErrNoRows {
+				t.Fatal(err)
+			}
+			if requester.String != tt.requester {
+				t.Errorf("requester = %q, want %q", requester.String, tt.requester)
+			}
+		})
+	}
+}
+
+func TestRequestAccessRejects(t *testing.T) {
+	openTestDB(t)
+	mustExec(t, `INSERT INTO users (id, username, role) VALUES (1, 'alice', 'USER')`)
+	createTestRule(t, models.Rule{
+		Identifier: testHash, RuleType: string(models.RuleTypeBinary), Policy: string(models.PolicyAllowlist),
+	})
+
+	_, err := RequestAccess(1, testHash, models.RuleTarget{Identifier: testHash, RuleType: string(models.RuleTypeBinary)}, "", "alice", nil)
+	var applies *RuleAppliesError
+	if !errors.As(err, &applies) || applies.RuleID != 1 {
+		t.Errorf("request for an allowlisted binary: error = %v, want rule 1 applies", err)
+	}
+
+	_, err = RequestAccess(1, testHash, models.RuleTarget{Identifier: "not-a-team", RuleType: string(models.RuleTypeTeamID)}, "", "alice", nil)
+	var verrs ValidationErrors
+	if !errors.As(err, &verrs) {
+		t.Errorf("request for a malformed team ID: error = %v, want validation errors", err)
+	}
+}
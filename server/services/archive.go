@@ -0,0 +1,381 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"krampus/server/config"
+	"krampus/server/database"
+	"krampus/server/models"
+	"strings"
+	"time"
+)
+
+var ErrNoArchiveStore = errors.New("no archive store is configured")
+
+// maxRestoreEvents caps the events one restore copies back into the database
+const maxRestoreEvents = 100000
+
+var archiveStore ArchiveStore
+
+// InitializeArchive sets up the archive store named by ARCHIVE_STORE
+// ("filesystem" or "s3"); events are pruned without archiving when it is
+// empty
+func InitializeArchive() error {
+	cfg := config.AppConfig
+	switch strings.ToLower(cfg.ArchiveStore) {
+	case "":
+		return nil
+	case "filesystem":
+		if cfg.ArchiveDir == "" {
+			return fmt.Errorf("ARCHIVE_DIR must be set")
+		}
+		archiveStore = NewFileArchiveStore(cfg.ArchiveDir)
+	case "s3":
+		if cfg.ArchiveS3Endpoint == "" || cfg.ArchiveS3Bucket == "" {
+			return fmt.Errorf("ARCHIVE_S3_ENDPOINT and ARCHIVE_S3_BUCKET must be set")
+		}
+		archiveStore = NewS3ArchiveStore(cfg.ArchiveS3Endpoint, cfg.ArchiveS3Bucket, cfg.ArchiveS3Region,
+			cfg.ArchiveS3AccessKey, cfg.ArchiveS3SecretKey, cfg.ArchiveS3Prefix, cfg.ArchiveS3PathStyle)
+	default:
+		return fmt.Errorf("unknown archive store %q", cfg.ArchiveStore)
+	}
+	return nil
+}
+
+// SetArchiveStore replaces the archive store; nil disables archiving
+func SetArchiveStore(store ArchiveStore) {
+	archiveStore = store
+}
+
+// archiveEventColumns are the events columns written to the archive, in the
+// order scanArchivedEvent reads them
+const archiveEventColumns = `id, machine_id, file_path, file_hash, execution_time, decision, executing_user,
+	cert_sha256, cert_cn, bundle_id, bundle_name, bundle_path, signing_id, team_id, cdhash,
+	quarantine_data_url, quarantine_timestamp, rule_id`
+
+func scanArchivedEvent(rows *sql.Rows) (models.Event, error) {
+	var e models.Event
+	err := rows.Scan(&e.ID, &e.MachineID, &e.FilePath, &e.FileHash, &e.ExecutionTime, &e.Decision, &e.ExecutingUser,
+		&e.CertSHA256, &e.CertCN, &e.BundleID, &e.BundleName, &e.BundlePath, &e.SigningID, &e.TeamID, &e.CDHash,
+		&e.QuarantineDataURL, &e.QuarantineTimestamp, &e.RuleID)
+	return e, err
+}
+
+// archivePrunedEvents writes events about to be pruned to the archive store,
+// one gzip-compressed NDJSON object per day, and returns a function that
+// indexes the objects in the transaction deleting the events. Events that
+// were restored from the archive are already in it and are skipped.
+func archivePrunedEvents(ids []interface{}) (func(tx *sql.Tx) error, error) {
+	store := archiveStore
+	if store == nil {
+		return nil, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	rows, err := database.DB.Query(
+		`SELECT `+archiveEventColumns+` FROM events
+		 WHERE id IN (`+placeholders+`) AND restored_at IS NULL ORDER BY id`,
+		ids...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events to archive: %w", err)
+	}
+	byDay := map[string][]models.Event{}
+	var days []string
+	for rows.Next() {
+		e, err := scanArchivedEvent(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan event to archive: %w", err)
+		}
+		day := e.ExecutionTime.UTC().Format("2006-01-02")
+		if _, ok := byDay[day]; !ok {
+			days = append(days, day)
+		}
+		byDay[day] = append(byDay[day], e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query events to archive: %w", err)
+	}
+
+	archives := make([]models.EventArchive, 0, len(days))
+	for _, day := range days {
+		archive, err := writeEventArchive(store, day, byDay[day])
+		if err != nil {
+			return nil, err
+		}
+		archives = append(archives, *archive)
+	}
+
+	return func(tx *sql.Tx) error {
+		for _, a := range archives {
+			machines, err := json.Marshal(a.Machines)
+			if err != nil {
+				return fmt.Errorf("failed to encode archive machines: %w", err)
+			}
+			_, err = tx.Exec(
+				`INSERT INTO event_archives (day, store, object_key, events, first_event_id, last_event_id, bytes, machines)
+				 VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+				 ON CONFLICT(object_key) DO UPDATE SET
+				   store = excluded.store, events = excluded.events, first_event_id = excluded.first_event_id,
+				   last_event_id = excluded.last_event_id, bytes = excluded.bytes, machines = excluded.machines,
+				   created_at = CURRENT_TIMESTAMP`,
+				a.Day, a.Store, a.ObjectKey, a.Events, a.FirstEventID, a.LastEventID, a.Bytes, string(machines),
+			)
+			if err != nil {
+				return fmt.Errorf("failed to index event archive: %w", err)
+			}
+		}
+		return nil
+	}, nil
+}
+
+// writeEventArchive compresses a day's events and writes them to the store
+// under events/YYYY/MM/DD/, named after the range of event IDs they cover
+func writeEventArchive(store ArchiveStore, day string, events []models.Event) (*models.EventArchive, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(gz)
+	seen := map[string]bool{}
+	archive := &models.EventArchive{
+		Day:          day,
+		Store:        store.Name(),
+		Events:       len(events),
+		FirstEventID: events[0].ID,
+		LastEventID:  events[len(events)-1].ID,
+		Machines:     []string{},
+	}
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return nil, fmt.Errorf("failed to encode archived event: %w", err)
+		}
+		if !seen[e.MachineID] {
+			seen[e.MachineID] = true
+			archive.Machines = append(archive.Machines, e.MachineID)
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress event archive: %w", err)
+	}
+
+	archive.ObjectKey = fmt.Sprintf("events/%s/%s/%s/events-%d-%d.ndjson.gz",
+		day[0:4], day[5:7], day[8:10], archive.FirstEventID, archive.LastEventID)
+	archive.Bytes = int64(buf.Len())
+	if err := store.Put(archive.ObjectKey, buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
+
+// pruneEventArchives deletes archive objects whose day is older than
+// ARCHIVE_RETENTION_DAYS, with their index entries
+func pruneEventArchives() (int64, error) {
+	days := config.AppConfig.ArchiveRetentionDays
+	store := archiveStore
+	if days <= 0 || store == nil {
+		return 0, nil
+	}
+
+	archives, err := queryEventArchives(
+		`WHERE day < date('now', ?) AND store = ?`, fmt.Sprintf("-%d days", days), store.Name(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	var pruned int64
+	for _, a := range archives {
+		if err := store.Delete(a.ObjectKey); err != nil {
+			return pruned, err
+		}
+		if _, err := database.DB.Exec(`DELETE FROM event_archives WHERE id = ?`, a.ID); err != nil {
+			return pruned, fmt.Errorf("failed to delete event archive: %w", err)
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// SearchEventArchives returns the archive objects holding events of the
+// given days (YYYY-MM-DD, inclusive; empty is open-ended), optionally only
+// those with events from a machine
+func SearchEventArchives(since, until, machineID string) ([]models.EventArchive, error) {
+	where := `WHERE 1 = 1`
+	var args []interface{}
+	if since != "" {
+		where += ` AND day >= ?`
+		args = append(args, since)
+	}
+	if until != "" {
+		where += ` AND day <= ?`
+		args = append(args, until)
+	}
+	if machineID != "" {
+		where += ` AND EXISTS (SELECT 1 FROM json_each(event_archives.machines) WHERE value = ?)`
+		args = append(args, machineID)
+	}
+	return queryEventArchives(where, args...)
+}
+
+func queryEventArchives(where string, args ...interface{}) ([]models.EventArchive, error) {
+	rows, err := database.DB.Query(
+		`SELECT id, day, store, object_key, events, first_event_id, last_event_id, bytes, machines, created_at
+		 FROM event_archives `+where+` ORDER BY day, first_event_id`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query event archives: %w", err)
+	}
+	defer rows.Close()
+
+	archives := []models.EventArchive{}
+	for rows.Next() {
+		var a models.EventArchive
+		var machines string
+		if err := rows.Scan(&a.ID, &a.Day, &a.Store, &a.ObjectKey, &a.Events, &a.FirstEventID, &a.LastEventID,
+			&a.Bytes, &machines, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan event archive: %w", err)
+		}
+		if err := json.Unmarshal([]byte(machines), &a.Machines); err != nil {
+			return nil, fmt.Errorf("failed to decode archive machines: %w", err)
+		}
+		archives = append(archives, a)
+	}
+	return archives, rows.Err()
+}
+
+// RestoreEventArchives copies archived events of the given days back into
+// the events table, optionally only a machine's or a binary's. Restored
+// events keep their IDs and stay for ARCHIVE_RESTORE_DAYS before retention
+// prunes them again. Archives written to a store other than the current one
+// are counted but can't be read.
+func RestoreEventArchives(since, until, machineID, fileHash string) (*models.ArchiveRestore, error) {
+	store := archiveStore
+	if store == nil {
+		return nil, ErrNoArchiveStore
+	}
+
+	archives, err := SearchEventArchives(since, until, machineID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result := &models.ArchiveRestore{}
+	if days := config.AppConfig.ArchiveRestoreDays; days > 0 {
+		keptUntil := now.AddDate(0, 0, days)
+		result.KeptUntil = &keptUntil
+	}
+
+	for _, a := range archives {
+		if a.Store != store.Name() {
+			result.OtherStoreArchives++
+			continue
+		}
+		data, err := store.Get(a.ObjectKey)
+		if err != nil {
+			return result, fmt.Errorf("failed to read archive %s: %w", a.ObjectKey, err)
+		}
+		events, err := readEventArchive(data)
+		if err != nil {
+			return result, fmt.Errorf("failed to read archive %s: %w", a.ObjectKey, err)
+		}
+		result.Archives++
+
+		var matching []models.Event
+		for _, e := range events {
+			if (machineID == "" || e.MachineID == machineID) && (fileHash == "" || strings.EqualFold(e.FileHash, fileHash)) {
+				matching = append(matching, e)
+			}
+		}
+		if remaining := maxRestoreEvents - result.Restored - result.AlreadyLoaded; len(matching) > remaining {
+			matching = matching[:remaining]
+			result.Truncated = true
+		}
+		if err := restoreEvents(matching, now, result); err != nil {
+			return result, err
+		}
+		if result.Truncated {
+			break
+		}
+	}
+	return result, nil
+}
+
+// readEventArchive decodes a gzip-compressed NDJSON archive object
+func readEventArchive(data []byte) ([]models.Event, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var events []models.Event
+	reader := bufio.NewReader(gz)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var e models.Event
+			if err := json.Unmarshal(line, &e); err != nil {
+				return nil, err
+			}
+			events = append(events, e)
+		}
+		if errors.Is(err, io.EOF) {
+			return events, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// restoreEvents inserts archived events in one transaction. Events still in
+// the table are left as they are, though a previous restore's keep period
+// is extended.
+func restoreEvents(events []models.Event, now time.Time, result *models.ArchiveRestore) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, e := range events {
+		res, err := tx.Exec(
+			`INSERT OR IGNORE INTO events (`+archiveEventColumns+`, restored_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			e.ID, e.MachineID, e.FilePath, e.FileHash, e.ExecutionTime, e.Decision, e.ExecutingUser,
+			e.CertSHA256, e.CertCN, e.BundleID, e.BundleName, e.BundlePath, e.SigningID, e.TeamID, e.CDHash,
+			e.QuarantineDataURL, e.QuarantineTimestamp, e.RuleID, now,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to restore event: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			result.Restored++
+			continue
+		}
+		result.AlreadyLoaded++
+		if _, err := tx.Exec(
+			`UPDATE events SET restored_at = ? WHERE id = ? AND restored_at IS NOT NULL`, now, e.ID,
+		); err != nil {
+			return fmt.Errorf("failed to restore event: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
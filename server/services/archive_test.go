@@ -0,0 +1,76 @@
+package services
+
+import (
+	"krampus/server/config"
+	"krampus/server/database"
+	"testing"
+)
+
+func TestRestoreEventArchives(t *testing.T) {
+	const (
+		hashA = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+		hashB = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+	)
+
+	tests := []struct {
+		name         string
+		since, until string
+		machineID    string
+		fileHash     string
+		archives     int
+		restored     int
+	}{
+		{name: "everything", archives: 2, restored: 3},
+		{name: "one machine", machineID: "m2", archives: 1, restored: 1},
+		{name: "one binary", fileHash: hashA, archives: 2, restored: 2},
+		{name: "one day", since: "2026-01-02", until: "2026-01-02", archives: 1, restored: 1},
+		{name: "no archive in range", since: "2026-02-01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			SetArchiveStore(NewFileArchiveStore(t.TempDir()))
+			t.Cleanup(func() { SetArchiveStore(nil) })
+
+			mustExec(t, `INSERT INTO machines (machine_id) VALUES ('m1'), ('m2')`)
+			mustExec(t, `INSERT INTO events (machine_id, file_hash, execution_time, decision) VALUES
+				('m1', ?, '2026-01-01 10:00:00', 'BLOCK_UNKNOWN'),
+				('m2', ?, '2026-01-01 11:00:00', 'ALLOW_UNKNOWN'),
+				('m1', ?, '2026-01-02 10:00:00', 'BLOCK_UNKNOWN'),
+				('m1', ?, '2026-01-03 10:00:00', 'BLOCK_UNKNOWN')`,
+				hashA, hashB, hashA, hashB)
+
+			// Keep only the newest event; the rest are archived as they go
+			config.AppConfig.EventRetentionMaxRows = 1
+			if _, err := pruneTable(retentionTargets()[0]); err != nil {
+				t.Fatalf("pruneTable: %v", err)
+			}
+
+			archives, err := SearchEventArchives("", "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(archives) != 2 || archives[0].Events != 2 || archives[1].Events != 1 {
+				t.Fatalf("archives = %+v, want 2 and 1 events on two days", archives)
+			}
+
+			result, err := RestoreEventArchives(tt.since, tt.until, tt.machineID, tt.fileHash)
+			if err != nil {
+				t.Fatalf("RestoreEventArchives: %v", err)
+			}
+			if result.Archives != tt.archives || result.Restored != tt.restored {
+				t.Errorf("read %d archives and restored %d events, want %d and %d",
+					result.Archives, result.Restored, tt.archives, tt.restored)
+			}
+
+			var count int
+			if err := database.DB.QueryRow(`SELECT COUNT(*) FROM events`).Scan(&count); err != nil {
+				t.Fatal(err)
+			}
+			if count != 1+tt.restored {
+				t.Errorf("%d events after restore, want %d", count, 1+tt.restored)
+			}
+		})
+	}
+}
@@ -0,0 +1,447 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"krampus/server/database"
+	"krampus/server/models"
+	"sort"
+	"time"
+)
+
+var (
+	ErrBatchNotFound     = errors.New("proposal batch not found")
+	ErrBatchItemNotFound = errors.New("proposal batch item not found")
+	ErrBatchNotDraft     = errors.New("proposal batch is no longer a draft")
+)
+
+// BaselineRequest configures how a baseline ruleset is generated from the
+// executions recorded on monitor-mode machines
+type BaselineRequest struct {
+	Name              string
+	Since             time.Time
+	Until             time.Time
+	MinVersions       int // Distinct hashes before a signing ID rule replaces hash rules
+	MinTeamSigningIDs int // Distinct signing IDs before a team ID rule covers them all; 0 never proposes team ID rules
+	MinMachines       int // Machines a cluster must have run on to be proposed
+	CreatedBy         int64
+}
+
+// baselineBinary accumulates where one binary ran
+type baselineBinary struct {
+	hash       string
+	path       string
+	executions int
+	machines   map[string]bool
+}
+
+// baselineCluster is a set of binaries one rule would cover
+type baselineCluster struct {
+	item     models.ProposalBatchItem
+	machines map[string]bool
+	top      int // Executions of the binary the example path comes from
+}
+
+func (c *baselineCluster) add(b *baselineBinary) {
+	c.item.Binaries++
+	c.item.Executions += b.executions
+	for m := range b.machines {
+		c.machines[m] = true
+	}
+	if b.path != "" && b.executions > c.top {
+		c.item.ExamplePath = &b.path
+		c.top = b.executions
+	}
+}
+
+// GenerateBaseline clusters the binaries that ran on monitor-mode machines
+// and no rule covers yet by team ID and signing ID, proposes the broadest
+// rule for each cluster, and saves them as a draft batch
+func GenerateBaseline(req BaselineRequest) (*models.ProposalBatch, error) {
+	var machineCount int
+	if err := database.DB.QueryRow(
+		`SELECT COUNT(*) FROM machines WHERE client_mode = ?`, models.ClientModeMonitor,
+	).Scan(&machineCount); err != nil {
+		return nil, fmt.Errorf("failed to count monitor-mode machines: %w", err)
+	}
+
+	rules, err := activeRulesByTarget()
+	if err != nil {
+		return nil, err
+	}
+
+	// Event times are stored in local time, so the bounds must be too
+	rows, err := database.DB.Query(
+		`SELECT file_hash, machine_id, MAX(COALESCE(cdhash, '')), MAX(COALESCE(signing_id, '')),
+		        MAX(COALESCE(team_id, '')), MAX(COALESCE(cert_sha256, '')), MAX(COALESCE(file_path, '')), COUNT(*)
+		 FROM events
+		 WHERE execution_time >= ? AND execution_time < ? AND file_hash != ''
+		   AND machine_id IN (SELECT machine_id FROM machines WHERE client_mode = ?)
+		 GROUP BY file_hash, machine_id`,
+		req.Since.Local(), req.Until.Local(), models.ClientModeMonitor,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events: %w", err)
+	}
+	defer rows.Close()
+
+	binaries := map[string]*baselineBinary{}
+	attributes := map[string]models.BinaryAttributes{}
+	for rows.Next() {
+		var attrs models.BinaryAttributes
+		var machineID, path string
+		var count int
+		if err := rows.Scan(&attrs.SHA256, &machineID, &attrs.CDHash, &attrs.SigningID,
+			&attrs.TeamID, &attrs.CertSHA256, &path, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		b := binaries[attrs.SHA256]
+		if b == nil {
+			b = &baselineBinary{hash: attrs.SHA256, path: path, machines: map[string]bool{}}
+			binaries[attrs.SHA256] = b
+			normalizeAttributes(&attrs)
+			attributes[attrs.SHA256] = attrs
+		}
+		b.executions += count
+		b.machines[machineID] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read events: %w", err)
+	}
+
+	// Binaries any active rule already decides fleet-wide need no proposal.
+	// The rest are grouped by team, then signing ID; platform binaries share
+	// the empty team and unsigned binaries can only be pinned by hash.
+	now := time.Now()
+	teams := map[string]map[string][]*baselineBinary{}
+	var unsigned []*baselineBinary
+	for hash, b := range binaries {
+		attrs := attributes[hash]
+		var matching []models.Rule
+		for _, target := range binaryTargets(attrs) {
+			matching = append(matching, rules[target]...)
+		}
+		eval := &models.Evaluation{ClientMode: string(models.ClientModeMonitor)}
+		decide(eval, matching, nil, now)
+		if eval.Rule != nil {
+			continue
+		}
+
+		if attrs.SigningID == "" {
+			unsigned = append(unsigned, b)
+			continue
+		}
+		if teams[attrs.TeamID] == nil {
+			teams[attrs.TeamID] = map[string][]*baselineBinary{}
+		}
+		teams[attrs.TeamID][attrs.SigningID] = append(teams[attrs.TeamID][attrs.SigningID], b)
+	}
+
+	var clusters []*baselineCluster
+	newCluster := func(identifier string, ruleType models.RuleType, teamID, signingID string) *baselineCluster {
+		c := &baselineCluster{
+			item: models.ProposalBatchItem{
+				Identifier: identifier,
+				RuleType:   string(ruleType),
+				Policy:     string(models.PolicyAllowlist),
+				TeamID:     nullIfEmpty(teamID),
+				SigningID:  nullIfEmpty(signingID),
+				Status:     string(models.ProposalBatchItemIncluded),
+			},
+			machines: map[string]bool{},
+		}
+		clusters = append(clusters, c)
+		return c
+	}
+	for teamID, signingIDs := range teams {
+		if teamID != "" && req.MinTeamSigningIDs > 0 && len(signingIDs) >= req.MinTeamSigningIDs {
+			c := newCluster(teamID, models.RuleTypeTeamID, teamID, "")
+			for _, bs := range signingIDs {
+				for _, b := range bs {
+					c.add(b)
+				}
+			}
+			continue
+		}
+		for signingID, bs := range signingIDs {
+			if len(bs) >= req.MinVersions {
+				c := newCluster(signingID, models.RuleTypeSigningID, teamID, signingID)
+				for _, b := range bs {
+					c.add(b)
+				}
+				continue
+			}
+			for _, b := range bs {
+				newCluster(b.hash, models.RuleTypeBinary, teamID, signingID).add(b)
+			}
+		}
+	}
+	for _, b := range unsigned {
+		newCluster(b.hash, models.RuleTypeBinary, "", "").add(b)
+	}
+
+	var items []models.ProposalBatchItem
+	for _, c := range clusters {
+		c.item.Machines = len(c.machines)
+		if c.item.Machines < req.MinMachines {
+			continue
+		}
+		items = append(items, c.item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		return rankImpact(a.Machines, 0, a.Executions, a.Identifier, b.Machines, 0, b.Executions, b.Identifier)
+	})
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		`INSERT INTO proposal_batches (name, since, until, machine_count, created_by) VALUES (?, ?, ?, ?, ?)`,
+		req.Name, req.Since.UTC(), req.Until.UTC(), machineCount, req.CreatedBy,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create proposal batch: %w", err)
+	}
+	batchID, _ := result.LastInsertId()
+
+	for _, item := range items {
+		if _, err := tx.Exec(
+			`INSERT INTO proposal_batch_items (batch_id, identifier, rule_type, policy, team_id, signing_id,
+			                                   example_path, binaries, executions, machines, status)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			batchID, item.Identifier, item.RuleType, item.Policy, item.TeamID, item.SigningID,
+			item.ExamplePath, item.Binaries, item.Executions, item.Machines, item.Status,
+		); err != nil {
+			return nil, fmt.Errorf("failed to create proposal batch item: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return GetProposalBatch(batchID)
+}
+
+const proposalBatchSelect = `
+	SELECT b.id, b.name, b.status, b.since, b.until, b.machine_count, b.created_by, b.approved_by,
+	       b.created_at, b.approved_at,
+	       (SELECT COUNT(*) FROM proposal_batch_items i WHERE i.batch_id = b.id)
+	FROM proposal_batches b
+`
+
+func scanProposalBatch(row interface{ Scan(...interface{}) error }, b *models.ProposalBatch) error {
+	return row.Scan(&b.ID, &b.Name, &b.Status, &b.Since, &b.Until, &b.MachineCount, &b.CreatedBy,
+		&b.ApprovedBy, &b.CreatedAt, &b.ApprovedAt, &b.ItemCount)
+}
+
+// ListProposalBatches returns every proposal batch without its items,
+// newest first
+func ListProposalBatches() ([]models.ProposalBatch, error) {
+	rows, err := database.DB.Query(proposalBatchSelect + ` ORDER BY b.id DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query proposal batches: %w", err)
+	}
+	defer rows.Close()
+
+	batches := []models.ProposalBatch{}
+	for rows.Next() {
+		var b models.ProposalBatch
+		if err := scanProposalBatch(rows, &b); err != nil {
+			return nil, fmt.Errorf("failed to scan proposal batch: %w", err)
+		}
+		batches = append(batches, b)
+	}
+	return batches, rows.Err()
+}
+
+// GetProposalBatch returns a proposal batch with its items
+func GetProposalBatch(batchID int64) (*models.ProposalBatch, error) {
+	var b models.ProposalBatch
+	err := scanProposalBatch(database.DB.QueryRow(proposalBatchSelect+` WHERE b.id = ?`, batchID), &b)
+	if err == sql.ErrNoRows {
+		return nil, ErrBatchNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch proposal batch: %w", err)
+	}
+
+	rows, err := database.DB.Query(
+		`SELECT id, batch_id, identifier, rule_type, policy, team_id, signing_id, example_path,
+		        binaries, executions, machines, status, rule_id
+		 FROM proposal_batch_items WHERE batch_id = ? ORDER BY id`, batchID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query proposal batch items: %w", err)
+	}
+	defer rows.Close()
+
+	b.Items = []models.ProposalBatchItem{}
+	for rows.Next() {
+		var i models.ProposalBatchItem
+		if err := rows.Scan(&i.ID, &i.BatchID, &i.Identifier, &i.RuleType, &i.Policy, &i.TeamID,
+			&i.SigningID, &i.ExamplePath, &i.Binaries, &i.Executions, &i.Machines, &i.Status, &i.RuleID); err != nil {
+			return nil, fmt.Errorf("failed to scan proposal batch item: %w", err)
+		}
+		b.Items = append(b.Items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read proposal batch items: %w", err)
+	}
+	return &b, nil
+}
+
+// draftBatch checks within a transaction that a batch exists and is a draft
+func draftBatch(tx *sql.Tx, batchID int64) error {
+	var status string
+	err := tx.QueryRow(`SELECT status FROM proposal_batches WHERE id = ?`, batchID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return ErrBatchNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch proposal batch: %w", err)
+	}
+	if status != string(models.ProposalBatchStatusDraft) {
+		return ErrBatchNotDraft
+	}
+	return nil
+}
+
+// SetBatchItemExcluded includes or excludes one item of a draft batch
+func SetBatchItemExcluded(batchID, itemID int64, excluded bool) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := draftBatch(tx, batchID); err != nil {
+		return err
+	}
+
+	status := models.ProposalBatchItemIncluded
+	if excluded {
+		status = models.ProposalBatchItemExcluded
+	}
+	result, err := tx.Exec(
+		`UPDATE proposal_batch_items SET status = ? WHERE id = ? AND batch_id = ?`, status, itemID, batchID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update proposal batch item: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrBatchItemNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// ApproveProposalBatch creates a rule for every included item of a draft
+// batch in one transaction. Targets an active rule already covers are
+// skipped rather than superseded.
+func ApproveProposalBatch(batchID, approvedBy int64) (*models.ProposalBatch, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := draftBatch(tx, batchID); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(
+		`SELECT id, identifier, rule_type, policy FROM proposal_batch_items WHERE batch_id = ? AND status = ?`,
+		batchID, models.ProposalBatchItemIncluded,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query proposal batch items: %w", err)
+	}
+	var items []models.ProposalBatchItem
+	for rows.Next() {
+		var i models.ProposalBatchItem
+		if err := rows.Scan(&i.ID, &i.Identifier, &i.RuleType, &i.Policy); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan proposal batch item: %w", err)
+		}
+		items = append(items, i)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read proposal batch items: %w", err)
+	}
+
+	reason := fmt.Sprintf("Approved proposal batch #%d", batchID)
+	revision := newRulesetRevision(&approvedBy, &reason)
+	for _, item := range items {
+		status := models.ProposalBatchItemCreated
+		existing, err := activeRule(tx, item.Identifier, item.RuleType, nil)
+		if err != nil {
+			return nil, err
+		}
+		var ruleID int64
+		if existing != nil {
+			status = models.ProposalBatchItemSkipped
+			ruleID = existing.ID
+		} else {
+			rule := models.Rule{
+				Identifier: item.Identifier,
+				Policy:     item.Policy,
+				RuleType:   item.RuleType,
+				CreatedBy:  &approvedBy,
+			}
+			if ruleID, err = insertRule(tx, revision, rule, &approvedBy, &reason, false); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := tx.Exec(
+			`UPDATE proposal_batch_items SET status = ?, rule_id = ? WHERE id = ?`, status, ruleID, item.ID,
+		); err != nil {
+			return nil, fmt.Errorf("failed to update proposal batch item: %w", err)
+		}
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE proposal_batches SET status = ?, approved_by = ?, approved_at = ? WHERE id = ?`,
+		models.ProposalBatchStatusApproved, approvedBy, time.Now(), batchID,
+	); err != nil {
+		return nil, fmt.Errorf("failed to approve proposal batch: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return GetProposalBatch(batchID)
+}
+
+// DeleteProposalBatch discards a draft batch. Approved batches are kept as
+// the record of the rules they created.
+func DeleteProposalBatch(batchID int64) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := draftBatch(tx, batchID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM proposal_batch_items WHERE batch_id = ?`, batchID); err != nil {
+		return fmt.Errorf("failed to delete proposal batch items: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM proposal_batches WHERE id = ?`, batchID); err != nil {
+		return fmt.Errorf("failed to delete proposal batch: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"errors"
+	"krampus/server/models"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	baselineOtherHash   = strings.Repeat("b", 64)
+	baselineHelperHash  = strings.Repeat("c", 64)
+	baselineCoveredHash = strings.Repeat("e", 64)
+	baselineAdHocHash   = strings.Repeat("f", 64)
+)
+
+// seedBaselineEvents records executions on two monitor-mode machines and one
+// in lockdown, and allowlists one team fleet-wide
+func seedBaselineEvents(t *testing.T, now time.Time) {
+	t.Helper()
+	mustExec(t, `INSERT INTO users (id, username, role) VALUES (1, 'admin', 'ADMIN')`)
+	mustExec(t, `INSERT INTO machines (machine_id, client_mode) VALUES ('m1', 'MONITOR'), ('m2', 'MONITOR'), ('m3', 'LOCKDOWN')`)
+	createTestRule(t, testRule(0, string(models.RuleTypeTeamID), "ZZZZZ99999", models.PolicyAllowlist))
+
+	within := now.Add(-time.Hour)
+	for _, e := range []struct {
+		machineID, hash, signingID, teamID string
+		at                                 time.Time
+	}{
+		{"m1", testHash, "com.example.tool", testTeamID, within},
+		{"m2", baselineOtherHash, "com.example.tool", testTeamID, within},
+		{"m1", baselineHelperHash, "com.example.helper", testTeamID, within},
+		{"m1", baselineCoveredHash, "com.other.app", "ZZZZZ99999", within},
+		{"m1", baselineAdHocHash, "", "", within},
+		{"m2", baselineAdHocHash, "", "", within},
+		{"m3", strings.Repeat("9", 64), "", "", within},                   // Lockdown machine
+		{"m1", strings.Repeat("8", 64), "", "", now.Add(-48 * time.Hour)}, // Outside the window
+	} {
+		mustExec(t, `INSERT INTO events (machine_id, file_hash, decision, signing_id, team_id, file_path, execution_time)
+			VALUES (?, ?, 'ALLOW_UNKNOWN', ?, ?, '/Applications/Tool.app', ?)`,
+			e.machineID, e.hash, nullIfEmpty(e.signingID), nullIfEmpty(e.teamID), e.at)
+	}
+}
+
+func TestGenerateBaseline(t *testing.T) {
+	tests := []struct {
+		name  string
+		req   BaselineRequest
+		items []string // "RULE_TYPE identifier", most impactful first
+	}{
+		{
+			name:  "signing ID once there are enough versions",
+			req:   BaselineRequest{MinVersions: 2},
+			items: []string{"SIGNINGID " + testSigning, "BINARY " + baselineAdHocHash, "BINARY " + baselineHelperHash},
+		},
+		{
+			name:  "team ID once there are enough signing IDs",
+			req:   BaselineRequest{MinVersions: 2, MinTeamSigningIDs: 2},
+			items: []string{"TEAMID " + testTeamID, "BINARY " + baselineAdHocHash},
+		},
+		{
+			name:  "hashes below the version threshold",
+			req:   BaselineRequest{MinVersions: 3},
+			items: []string{"BINARY " + baselineAdHocHash, "BINARY " + testHash, "BINARY " + baselineOtherHash, "BINARY " + baselineHelperHash},
+		},
+		{
+			name:  "machine threshold",
+			req:   BaselineRequest{MinVersions: 2, MinMachines: 2},
+			items: []string{"SIGNINGID " + testSigning, "BINARY " + baselineAdHocHash},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			now := time.Now()
+			seedBaselineEvents(t, now)
+
+			tt.req.Name, tt.req.CreatedBy = "baseline", 1
+			tt.req.Since, tt.req.Until = now.Add(-24*time.Hour), now
+			batch, err := GenerateBaseline(tt.req)
+			if err != nil {
+				t.Fatalf("GenerateBaseline failed: %v", err)
+			}
+			if batch.Status != string(models.ProposalBatchStatusDraft) || batch.MachineCount != 2 {
+				t.Errorf("batch is %s from %d machines, want DRAFT from 2", batch.Status, batch.MachineCount)
+			}
+
+			var items []string
+			for _, item := range batch.Items {
+				items = append(items, item.RuleType+" "+item.Identifier)
+			}
+			if !reflect.DeepEqual(items, tt.items) {
+				t.Errorf("items = %v, want %v", items, tt.items)
+			}
+		})
+	}
+}
+
+func TestApproveProposalBatch(t *testing.T) {
+	openTestDB(t)
+	now := time.Now()
+	seedBaselineEvents(t, now)
+
+	batch, err := GenerateBaseline(BaselineRequest{
+		Name: "baseline", Since: now.Add(-24 * time.Hour), Until: now, MinVersions: 2, CreatedBy: 1,
+	})
+	if err != nil {
+		t.Fatalf("GenerateBaseline failed: %v", err)
+	}
+	items := map[string]models.ProposalBatchItem{}
+	for _, item := range batch.Items {
+		items[item.Identifier] = item
+	}
+
+	// One item is excluded, and another target gets a rule before approval
+	if err := SetBatchItemExcluded(batch.ID, items[baselineHelperHash].ID, true); err != nil {
+		t.Fatalf("SetBatchItemExcluded failed: %v", err)
+	}
+	existingID := createTestRule(t, testRule(0, string(models.RuleTypeBinary), baselineAdHocHash, models.PolicyBlocklist))
+
+	approved, err := ApproveProposalBatch(batch.ID, 1)
+	if err != nil {
+		t.Fatalf("ApproveProposalBatch failed: %v", err)
+	}
+	if approved.Status != string(models.ProposalBatchStatusApproved) {
+		t.Errorf("batch status = %s, want APPROVED", approved.Status)
+	}
+	for _, item := range approved.Items {
+		want := map[string]models.ProposalBatchItemStatus{
+			testSigning:        models.ProposalBatchItemCreated,
+			baselineAdHocHash:  models.ProposalBatchItemSkipped,
+			baselineHelperHash: models.ProposalBatchItemExcluded,
+		}[item.Identifier]
+		if item.Status != string(want) {
+			t.Errorf("%s status = %s, want %s", item.Identifier, item.Status, want)
+		}
+		switch want {
+		case models.ProposalBatchItemCreated:
+			if item.RuleID == nil || *item.RuleID == existingID {
+				t.Errorf("%s rule = %v, want a new rule", item.Identifier, item.RuleID)
+			}
+		case models.ProposalBatchItemSkipped:
+			if item.RuleID == nil || *item.RuleID != existingID {
+				t.Errorf("%s rule = %v, want the existing rule %d", item.Identifier, item.RuleID, existingID)
+			}
+		}
+	}
+
+	// An approved batch is the record of the rules it created
+	if _, err := ApproveProposalBatch(batch.ID, 1); !errors.Is(err, ErrBatchNotDraft) {
+		t.Errorf("second approval error = %v, want %v", err, ErrBatchNotDraft)
+	}
+	if err := SetBatchItemExcluded(batch.ID, items[testSigning].ID, true); !errors.Is(err, ErrBatchNotDraft) {
+		t.Errorf("excluding from an approved batch error = %v, want %v", err, ErrBatchNotDraft)
+	}
+	if err := DeleteProposalBatch(batch.ID); !errors.Is(err, ErrBatchNotDraft) {
+		t.Errorf("deleting an approved batch error = %v, want %v", err, ErrBatchNotDraft)
+	}
+	if err := DeleteProposalBatch(batch.ID + 1); !errors.Is(err, ErrBatchNotFound) {
+		t.Errorf("deleting an unknown batch error = %v, want %v", err, ErrBatchNotFound)
+	}
+}
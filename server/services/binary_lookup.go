@@ -0,0 +1,227 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"krampus/server/database"
+	"krampus/server/models"
+	"strings"
+)
+
+// LookupBinary resolves a binary hash against stored events, rules and
+// proposals. When machineID is set, metadata reported by that machine is
+// preferred and the binary is evaluated against the rules that machine
+// receives.
+func LookupBinary(hash, machineID string) (*models.BinaryLookup, error) {
+	lookup := &models.BinaryLookup{
+		Identifier: hash,
+		MachineID:  machineID,
+		Rules:      []models.Rule{},
+		Proposals:  []models.Proposal{},
+	}
+
+	// Latest metadata for the hash, preferring the machine that was blocked
+	err := database.DB.QueryRow(
+		`SELECT file_path, bundle_id, bundle_name, cert_sha256, cert_cn, signing_id, team_id, cdhash
+		 FROM events WHERE file_hash = ?
+		 ORDER BY (machine_id = ?) DESC, execution_time DESC
+		 LIMIT 1`,
+		hash, machineID,
+	).Scan(
+		&lookup.FilePath, &lookup.BundleID, &lookup.BundleName,
+		&lookup.CertSHA256, &lookup.CertCN, &lookup.SigningID, &lookup.TeamID, &lookup.CDHash,
+	)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to fetch binary metadata: %w", err)
+	}
+	lookup.Seen = err == nil
+
+	// Events store missing fields as empty strings
+	for _, field := range []**string{
+		&lookup.FilePath, &lookup.BundleID, &lookup.BundleName,
+		&lookup.CertSHA256, &lookup.CertCN, &lookup.SigningID, &lookup.TeamID, &lookup.CDHash,
+	} {
+		if *field != nil && **field == "" {
+			*field = nil
+		}
+	}
+
+	if lookup.Seen {
+		prevalence, err := TargetPrevalence(models.RuleTarget{Identifier: hash, RuleType: string(models.RuleTypeBinary)})
+		if err != nil {
+			return nil, err
+		}
+		lookup.ExecutionCount = prevalence.ExecutionCount
+		lookup.MachineCount = prevalence.MachineCount
+		lookup.FirstSeen = prevalence.FirstSeen
+		lookup.LastSeen = prevalence.LastSeen
+		for decision, count := range prevalence.Decisions {
+			if IsBlockDecision(decision) {
+				lookup.BlockCount += count
+			}
+		}
+	}
+
+	attrs := models.BinaryAttributes{
+		SHA256:     hash,
+		CDHash:     deref(lookup.CDHash),
+		SigningID:  SigningIdentifier(deref(lookup.SigningID), deref(lookup.TeamID)),
+		TeamID:     deref(lookup.TeamID),
+		CertSHA256: deref(lookup.CertSHA256),
+	}
+	targets := binaryTargets(attrs)
+
+	lookup.Rules, err = rulesForTargets(targets)
+	if err != nil {
+		return nil, err
+	}
+	lookup.Proposals, err = proposalsForTargets(targets)
+	if err != nil {
+		return nil, err
+	}
+
+	// Block pages are opened for machines that may never have synced here
+	lookup.Evaluation, err = EvaluateBinary(attrs, machineID)
+	if errors.Is(err, ErrMachineNotFound) {
+		lookup.Evaluation, err = EvaluateBinary(attrs, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lookup.Status = string(models.BinaryStatusUnknown)
+	if rule := lookup.Evaluation.Rule; rule != nil {
+		if isBlockPolicy(rule.Policy) {
+			lookup.Status = string(models.BinaryStatusBlocklisted)
+		} else {
+			lookup.Status = string(models.BinaryStatusAllowlisted)
+		}
+	} else {
+		for _, p := range lookup.Proposals {
+			if p.Status == string(models.ProposalStatusPending) {
+				lookup.Status = string(models.BinaryStatusPendingReview)
+				break
+			}
+		}
+	}
+
+	lookup.Suggested = SuggestRule(hash, deref(lookup.SigningID), deref(lookup.TeamID))
+
+	return lookup, nil
+}
+
+// SuggestRule picks the rule an access request should propose. Signed
+// binaries get a signing ID rule so that updates keep working; anything
+// else is pinned to its hash.
+func SuggestRule(hash, signingID, teamID string) models.RuleTarget {
+	if id := SigningIdentifier(signingID, teamID); id != "" {
+		return models.RuleTarget{Identifier: id, RuleType: string(models.RuleTypeSigningID)}
+	}
+	return models.RuleTarget{Identifier: hash, RuleType: string(models.RuleTypeBinary)}
+}
+
+// SigningIdentifier returns the signing ID in the "TEAMID:bundle.id" form that
+// Santa rules use. Binaries without a team ID are Apple platform binaries.
+func SigningIdentifier(signingID, teamID string) string {
+	switch {
+	case signingID == "":
+		return ""
+	case strings.Contains(signingID, ":"):
+		return signingID
+	case teamID != "":
+		return teamID + ":" + signingID
+	}
+	return "platform:" + signingID
+}
+
+// binaryTargets lists every rule target that could match a binary. The
+// signing ID must already be in "TEAMID:bundle.id" form.
+func binaryTargets(a models.BinaryAttributes) []models.RuleTarget {
+	var targets []models.RuleTarget
+	for _, t := range []models.RuleTarget{
+		{Identifier: a.CDHash, RuleType: string(models.RuleTypeCDHash)},
+		{Identifier: a.SHA256, RuleType: string(models.RuleTypeBinary)},
+		{Identifier: a.SigningID, RuleType: string(models.RuleTypeSigningID)},
+		{Identifier: a.CertSHA256, RuleType: string(models.RuleTypeCertificate)},
+		{Identifier: a.TeamID, RuleType: string(models.RuleTypeTeamID)},
+	} {
+		if t.Identifier != "" {
+			targets = append(targets, t)
+		}
+	}
+	return targets
+}
+
+// targetClause builds a WHERE fragment matching any of the given targets
+func targetClause(targets []models.RuleTarget) (string, []interface{}) {
+	clauses := make([]string, 0, len(targets))
+	args := make([]interface{}, 0, len(targets)*2)
+	for _, t := range targets {
+		clauses = append(clauses, "(identifier = ? AND rule_type = ?)")
+		args = append(args, t.Identifier, t.RuleType)
+	}
+	return "(" + strings.Join(clauses, " OR ") + ")", args
+}
+
+// rulesForTargets returns the active rules matching any of the targets
+func rulesForTargets(targets []models.RuleTarget) ([]models.Rule, error) {
+	clause, args := targetClause(targets)
+	rows, err := database.DB.Query(
+		`SELECT `+RuleColumns+` FROM rules WHERE `+clause+` AND `+ActiveRule+` ORDER BY created_at DESC`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rules: %w", err)
+	}
+	defer rows.Close()
+
+	rules := []models.Rule{}
+	for rows.Next() {
+		var r models.Rule
+		if err := ScanRule(rows, &r); err != nil {
+			return nil, fmt.Errorf("failed to scan rule: %w", err)
+		}
+		rules = append(rules, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rules: %w", err)
+	}
+	return rules, AttachRollouts(rules)
+}
+
+// proposalsForTargets returns the proposals matching any of the targets
+func proposalsForTargets(targets []models.RuleTarget) ([]models.Proposal, error) {
+	clause, args := targetClause(targets)
+	rows, err := database.DB.Query(
+		`SELECT id, identifier, rule_type, proposed_policy, cel_expr, custom_message, created_by, source,
+		        status, allowlist_votes, blocklist_votes, request_count, last_requested_at,
+		        created_at, finalized_at
+		 FROM proposals WHERE `+clause+` ORDER BY created_at DESC`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query proposals: %w", err)
+	}
+	defer rows.Close()
+
+	proposals := []models.Proposal{}
+	for rows.Next() {
+		var p models.Proposal
+		err := rows.Scan(&p.ID, &p.Identifier, &p.RuleType, &p.ProposedPolicy, &p.CELExpr, &p.CustomMessage,
+			&p.CreatedBy, &p.Source, &p.Status, &p.AllowlistVotes, &p.BlocklistVotes,
+			&p.RequestCount, &p.LastRequestedAt, &p.CreatedAt, &p.FinalizedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan proposal: %w", err)
+		}
+		proposals = append(proposals, p)
+	}
+	return proposals, rows.Err()
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"errors"
+	"krampus/server/models"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+)
+
+// ErrCELExprMismatch is returned when a change would leave a CEL rule
+// without an expression, or give an expression to a rule of another policy
+var ErrCELExprMismatch = errors.New("only CEL rules have an expression, and every CEL rule needs one")
+
+// celParser parses expressions without declaring Santa's variables, so
+// expressions are checked for syntax only and stay valid as Santa adds
+// fields to its execution context
+var celParser, _ = cel.NewEnv()
+
+// ValidateCELExpr checks a rule's CEL expression against its policy: CEL
+// rules need a syntactically valid expression and other policies take none.
+func ValidateCELExpr(policy, expr string) *ValidationError {
+	if policy != string(models.PolicyCEL) {
+		if expr != "" {
+			return &ValidationError{Field: "cel_expr", Message: "only allowed for CEL rules"}
+		}
+		return nil
+	}
+	if strings.TrimSpace(expr) == "" {
+		return &ValidationError{Field: "cel_expr", Message: "required for CEL rules"}
+	}
+	return CheckCELSyntax(expr)
+}
+
+// CheckCELSyntax parses a CEL expression, reporting the first syntax error
+func CheckCELSyntax(expr string) *ValidationError {
+	if _, issues := celParser.Parse(expr); issues != nil && issues.Err() != nil {
+		message := issues.Err().Error()
+		if len(issues.Errors()) > 0 {
+			message = issues.Errors()[0].Message
+		}
+		return &ValidationError{Field: "cel_expr", Message: "invalid CEL expression: " + message}
+	}
+	return nil
+}
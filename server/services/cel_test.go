@@ -0,0 +1,123 @@
+package services
+
+import (
+	"errors"
+	"krampus/server/database"
+	"krampus/server/models"
+	"reflect"
+	"testing"
+)
+
+func TestValidateCELExpr(t *testing.T) {
+	cel, allow := string(models.PolicyCEL), string(models.PolicyAllowlist)
+
+	tests := []struct {
+		name    string
+		policy  string
+		expr    string
+		invalid bool
+	}{
+		{name: "CEL rule", policy: cel, expr: `target.signing_time >= timestamp('2025-05-31T00:00:00Z')`},
+		{name: "unknown fields pass", policy: cel, expr: `args.exists(a, a == '--unsafe')`},
+		{name: "missing expression", policy: cel, expr: "  ", invalid: true},
+		{name: "syntax error", policy: cel, expr: `target.signing_time >=`, invalid: true},
+		{name: "expression on another policy", policy: allow, expr: `true`, invalid: true},
+		{name: "no expression on another policy", policy: allow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateCELExpr(tt.policy, tt.expr)
+			if (err != nil) != tt.invalid {
+				t.Errorf("ValidateCELExpr = %v, want invalid: %v", err, tt.invalid)
+			}
+			if err != nil && err.Field != "cel_expr" {
+				t.Errorf("error on %s, want cel_expr", err.Field)
+			}
+		})
+	}
+}
+
+func TestUpdateRuleCELExpr(t *testing.T) {
+	cel, allow := string(models.PolicyCEL), string(models.PolicyAllowlist)
+	expr, other, empty := "true", "false", ""
+
+	tests := []struct {
+		name   string
+		cel    bool // Whether the rule starts out as a CEL rule with expr
+		update RuleUpdate
+		err    error
+		policy string
+		expr   *string
+	}{
+		{name: "to CEL with an expression", update: RuleUpdate{Policy: &cel, CELExpr: &expr}, policy: cel, expr: &expr},
+		{name: "to CEL without an expression", update: RuleUpdate{Policy: &cel}, err: ErrCELExprMismatch},
+		{name: "expression without CEL", update: RuleUpdate{CELExpr: &expr}, err: ErrCELExprMismatch},
+		{name: "away from CEL drops the expression", cel: true, update: RuleUpdate{Policy: &allow}, policy: allow},
+		{name: "away from CEL with an expression", cel: true, update: RuleUpdate{Policy: &allow, CELExpr: &other}, err: ErrCELExprMismatch},
+		{name: "new expression", cel: true, update: RuleUpdate{CELExpr: &other}, policy: cel, expr: &other},
+		{name: "cleared expression", cel: true, update: RuleUpdate{CELExpr: &empty}, err: ErrCELExprMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			mustExec(t, `INSERT INTO users (id, username, role) VALUES (1, 'admin', 'ADMIN')`)
+			rule := testRule(0, string(models.RuleTypeBinary), testHash, models.PolicyAllowlist)
+			if tt.cel {
+				rule.Policy, rule.CELExpr = cel, &expr
+			}
+			ruleID := createTestRule(t, rule)
+
+			updated, _, err := UpdateRule(ruleID, tt.update, 1, nil)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("UpdateRule error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if updated.Policy != tt.policy || !reflect.DeepEqual(updated.CELExpr, tt.expr) {
+				t.Errorf("rule is %s with expression %v, want %s with %v", updated.Policy, deref(updated.CELExpr), tt.policy, deref(tt.expr))
+			}
+		})
+	}
+}
+
+func TestFinalizeCELProposal(t *testing.T) {
+	openTestDB(t)
+	mustExec(t, `INSERT INTO users (id, username, role) VALUES (1, 'a', 'USER'), (2, 'b', 'USER'), (3, 'c', 'USER')`)
+	expr := `target.signing_time >= timestamp('2025-05-31T00:00:00Z')`
+	userID := int64(1)
+
+	plain := openTestProposal(t, models.PolicyAllowlist)
+	if err := FinalizeProposal(plain, string(models.PolicyCEL), false, &userID); err == nil {
+		t.Error("finalizing a proposal without an expression as CEL succeeded")
+	}
+
+	proposalID, _, err := OpenProposal(models.Proposal{
+		Identifier:     testHash,
+		RuleType:       string(models.RuleTypeBinary),
+		ProposedPolicy: string(models.PolicyCEL),
+		CELExpr:        &expr,
+		CreatedBy:      &userID,
+		Source:         string(models.ProposalSourceUser),
+	})
+	if err != nil {
+		t.Fatalf("failed to open proposal: %v", err)
+	}
+
+	// Allowing votes accept the expression
+	for i := int64(1); i <= 3; i++ {
+		if err := SubmitVote(i, proposalID, string(models.VoteTypeAllowlist)); err != nil {
+			t.Fatalf("SubmitVote: %v", err)
+		}
+	}
+	var policy string
+	var celExpr *string
+	if err := database.DB.QueryRow(`SELECT policy, cel_expr FROM rules WHERE `+ActiveRule).Scan(&policy, &celExpr); err != nil {
+		t.Fatal(err)
+	}
+	if policy != string(models.PolicyCEL) || deref(celExpr) != expr {
+		t.Errorf("rule is %s with expression %q, want CEL with %q", policy, deref(celExpr), expr)
+	}
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"krampus/server/database"
+	"time"
+)
+
+var (
+	ErrCommentNotFound  = errors.New("comment not found")
+	ErrNotCommentAuthor = errors.New("only the author can edit this comment")
+)
+
+// EditComment replaces a comment's body, keeping the previous body in the
+// comment's edit history. Only the author may edit.
+func EditComment(proposalID, commentID, userID int64, body string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var authorID sql.NullInt64
+	var previousBody string
+	err = tx.QueryRow(
+		`SELECT user_id, body FROM proposal_comments WHERE id = ? AND proposal_id = ?`,
+		commentID, proposalID,
+	).Scan(&authorID, &previousBody)
+	if err == sql.ErrNoRows {
+		return ErrCommentNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch comment: %w", err)
+	}
+
+	if !authorID.Valid || authorID.Int64 != userID {
+		return ErrNotCommentAuthor
+	}
+	if previousBody == body {
+		return nil
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO proposal_comment_edits (comment_id, previous_body, edited_by) VALUES (?, ?, ?)`,
+		commentID, previousBody, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record comment history: %w", err)
+	}
+
+	_, err = tx.Exec(
+		`UPDATE proposal_comments SET body = ?, updated_at = ? WHERE id = ?`,
+		body, time.Now(), commentID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update comment: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
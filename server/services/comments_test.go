@@ -0,0 +1,121 @@
+package services
+
+import (
+	"errors"
+	"krampus/server/database"
+	"krampus/server/models"
+	"reflect"
+	"testing"
+)
+
+func TestEditComment(t *testing.T) {
+	tests := []struct {
+		name       string
+		proposalID int64
+		commentID  int64
+		userID     int64
+		bodies     []string // Edits in order
+		err        error
+		body       string
+		history    []string // Previous bodies, oldest first
+	}{
+		{
+			name: "author edits", proposalID: 1, commentID: 1, userID: 1,
+			bodies: []string{"second", "third"}, body: "third", history: []string{"first", "second"},
+		},
+		{
+			name: "unchanged body", proposalID: 1, commentID: 1, userID: 1,
+			bodies: []string{"first"}, body: "first",
+		},
+		{
+			name: "someone else", proposalID: 1, commentID: 1, userID: 2,
+			bodies: []string{"second"}, err: ErrNotCommentAuthor, body: "first",
+		},
+		{
+			name: "comment of another proposal", proposalID: 2, commentID: 1, userID: 1,
+			bodies: []string{"second"}, err: ErrCommentNotFound, body: "first",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			mustExec(t, `INSERT INTO users (id, username, role) VALUES (1, 'alice', 'USER'), (2, 'bob', 'USER')`)
+			proposalID := openTestProposal(t, models.PolicyAllowlist)
+			mustExec(t, `INSERT INTO proposal_comments (proposal_id, user_id, body) VALUES (?, 1, 'first')`, proposalID)
+
+			for _, body := range tt.bodies {
+				if err := EditComment(tt.proposalID, tt.commentID, tt.userID, body); !errors.Is(err, tt.err) {
+					t.Fatalf("EditComment error = %v, want %v", err, tt.err)
+				}
+			}
+
+			var body string
+			if err := database.DB.QueryRow(`SELECT body FROM proposal_comments WHERE id = 1`).Scan(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+
+			rows, err := database.DB.Query(`SELECT previous_body FROM proposal_comment_edits WHERE comment_id = 1 ORDER BY id`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			var history []string
+			for rows.Next() {
+				var previous string
+				if err := rows.Scan(&previous); err != nil {
+					t.Fatal(err)
+				}
+				history = append(history, previous)
+			}
+			if !reflect.DeepEqual(history, tt.history) {
+				t.Errorf("history = %q, want %q", history, tt.history)
+			}
+		})
+	}
+}
+
+func TestDeleteProposal(t *testing.T) {
+	openTestDB(t)
+	mustExec(t, `INSERT INTO users (id, username, role) VALUES (1, 'alice', 'USER')`)
+	mustExec(t, `INSERT INTO machines (machine_id) VALUES ('m1')`)
+	deleted := openTestProposal(t, models.PolicyAllowlist)
+	kept := openTestProposal(t, models.PolicyBlocklist)
+
+	for _, proposalID := range []int64{deleted, kept} {
+		mustExec(t, `INSERT INTO proposal_comments (proposal_id, user_id, body) VALUES (?, 1, 'why')`, proposalID)
+		mustExec(t, `INSERT INTO proposal_comment_edits (comment_id, previous_body, edited_by)
+			SELECT id, 'before', 1 FROM proposal_comments WHERE proposal_id = ?`, proposalID)
+		mustExec(t, `INSERT INTO proposal_evidence (proposal_id, evidence_type, reference) VALUES (?, 'TICKET', 'SEC-1')`, proposalID)
+		mustExec(t, `INSERT INTO proposal_requesters (proposal_id, machine_id) VALUES (?, 'm1')`, proposalID)
+		if err := SubmitVote(1, proposalID, string(models.VoteTypeAllowlist)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := DeleteProposal(deleted); err != nil {
+		t.Fatalf("DeleteProposal: %v", err)
+	}
+
+	for _, table := range []string{"proposal_comments", "proposal_evidence", "proposal_requesters", "votes"} {
+		for proposalID, want := range map[int64]int{deleted: 0, kept: 1} {
+			var n int
+			if err := database.DB.QueryRow(`SELECT COUNT(*) FROM `+table+` WHERE proposal_id = ?`, proposalID).Scan(&n); err != nil {
+				t.Fatal(err)
+			}
+			if n != want {
+				t.Errorf("%d %s rows for proposal %d, want %d", n, table, proposalID, want)
+			}
+		}
+	}
+	var edits int
+	if err := database.DB.QueryRow(`SELECT COUNT(*) FROM proposal_comment_edits`).Scan(&edits); err != nil {
+		t.Fatal(err)
+	}
+	if edits != 1 {
+		t.Errorf("%d comment edits left, want the kept proposal's 1", edits)
+	}
+}
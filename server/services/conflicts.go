@@ -0,0 +1,175 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"krampus/server/database"
+	"krampus/server/models"
+)
+
+// RuleConflictError reports the active rule standing in the way of a new
+// rule for the same identifier and rule type
+type RuleConflictError struct {
+	RuleID     int64
+	Identifier string
+	RuleType   string
+	Policy     string
+}
+
+func (e *RuleConflictError) Error() string {
+	return fmt.Sprintf("active %s rule %d already exists for %s %s; pass supersede to replace it",
+		e.Policy, e.RuleID, e.RuleType, e.Identifier)
+}
+
+// RuleAppliesError reports the active rule that already applies a proposed
+// policy, making the proposal moot
+type RuleAppliesError struct {
+	RuleID int64
+}
+
+func (e *RuleAppliesError) Error() string {
+	return fmt.Sprintf("active rule %d already applies this policy", e.RuleID)
+}
+
+// FindRuleConflicts splits the active rules for an identifier and rule type
+// in a scope (a group, or fleet-wide when groupID is nil) into those that
+// already apply the given policy (and, for CEL, the same expression) and
+// those that contradict it. Rules of other scopes neither apply nor
+// contradict it.
+func FindRuleConflicts(identifier, ruleType string, groupID *int64, policy, celExpr string) (same, opposite []models.Rule, err error) {
+	rules, err := rulesForTargets([]models.RuleTarget{{Identifier: identifier, RuleType: ruleType}})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, r := range rules {
+		if !sameGroup(r.GroupID, groupID) {
+			continue
+		}
+		if r.Policy == policy && deref(r.CELExpr) == celExpr {
+			same = append(same, r)
+		} else {
+			opposite = append(opposite, r)
+		}
+	}
+	return same, opposite, nil
+}
+
+// sameGroup reports whether two rule scopes are the same group, or both
+// fleet-wide
+func sameGroup(a, b *int64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// ActiveRuleContradictions lists targets holding more than one active rule
+// in a scope.
+// Databases from before one-active-rule enforcement may keep rules applying
+// different policies to a target until an admin resolves them.
+func ActiveRuleContradictions() ([]models.RuleContradiction, error) {
+	rows, err := database.DB.Query(
+		`SELECT ` + RuleColumns + ` FROM rules
+		 WHERE ` + ActiveRule + ` AND (identifier, rule_type, COALESCE(group_id, 0)) IN (
+		   SELECT identifier, rule_type, COALESCE(group_id, 0) FROM rules WHERE ` + ActiveRule + `
+		   GROUP BY identifier, rule_type, COALESCE(group_id, 0) HAVING COUNT(*) > 1)
+		 ORDER BY identifier, rule_type, COALESCE(group_id, 0), created_at DESC, id DESC`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query contradicting rules: %w", err)
+	}
+	defer rows.Close()
+
+	contradictions := []models.RuleContradiction{}
+	for rows.Next() {
+		var r models.Rule
+		if err := ScanRule(rows, &r); err != nil {
+			return nil, fmt.Errorf("failed to scan rule: %w", err)
+		}
+		n := len(contradictions)
+		if n == 0 || contradictions[n-1].Identifier != r.Identifier || contradictions[n-1].RuleType != r.RuleType ||
+			!sameGroup(contradictions[n-1].GroupID, r.GroupID) {
+			contradictions = append(contradictions, models.RuleContradiction{
+				Identifier: r.Identifier, RuleType: r.RuleType, GroupID: r.GroupID,
+			})
+			n++
+		}
+		contradictions[n-1].Rules = append(contradictions[n-1].Rules, r)
+	}
+	return contradictions, rows.Err()
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// FindPendingProposal returns the ID of the pending proposal for an
+// identifier and rule type proposing a policy, or 0 if there is none
+func FindPendingProposal(identifier, ruleType, policy string) (int64, error) {
+	return findPendingProposal(database.DB, identifier, ruleType, policy)
+}
+
+func findPendingProposal(q queryRower, identifier, ruleType, policy string) (int64, error) {
+	var proposalID int64
+	err := q.QueryRow(
+		`SELECT id FROM proposals
+		 WHERE identifier = ? AND rule_type = ? AND proposed_policy = ? AND status = ?
+		 ORDER BY created_at ASC LIMIT 1`,
+		identifier, ruleType, policy, models.ProposalStatusPending,
+	).Scan(&proposalID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up pending proposal: %w", err)
+	}
+	return proposalID, nil
+}
+
+// OpenProposal opens a pending proposal unless one is already pending for the
+// same identifier and rule type proposing the same policy. It returns the ID
+// of the new proposal, or of the pending one, and whether it was created.
+func OpenProposal(p models.Proposal) (int64, bool, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	proposalID, created, err := openProposal(tx, p)
+	if err != nil {
+		return 0, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return proposalID, created, nil
+}
+
+// openProposal checks for a pending proposal and inserts one in a single
+// statement, which SQLite runs under the write lock, so concurrent requests
+// for the same target cannot both open a proposal
+func openProposal(tx *sql.Tx, p models.Proposal) (int64, bool, error) {
+	result, err := tx.Exec(
+		`INSERT INTO proposals (identifier, rule_type, proposed_policy, cel_expr, custom_message, created_by, source)
+		 SELECT ?, ?, ?, ?, ?, ?, ?
+		 WHERE NOT EXISTS (SELECT 1 FROM proposals
+		                   WHERE identifier = ? AND rule_type = ? AND proposed_policy = ? AND status = ?)`,
+		p.Identifier, p.RuleType, p.ProposedPolicy, p.CELExpr, p.CustomMessage, p.CreatedBy, p.Source,
+		p.Identifier, p.RuleType, p.ProposedPolicy, models.ProposalStatusPending,
+	)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to create proposal: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		proposalID, _ := result.LastInsertId()
+		return proposalID, true, nil
+	}
+
+	proposalID, err := findPendingProposal(tx, p.Identifier, p.RuleType, p.ProposedPolicy)
+	if err != nil {
+		return 0, false, err
+	}
+	return proposalID, false, nil
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"krampus/server/models"
+	"testing"
+)
+
+func TestOpenProposal(t *testing.T) {
+	binary, teamID := string(models.RuleTypeBinary), string(models.RuleTypeTeamID)
+	proposal := func(ruleType, identifier string, policy models.Policy) models.Proposal {
+		return models.Proposal{
+			Identifier: identifier, RuleType: ruleType, ProposedPolicy: string(policy),
+			Source: string(models.ProposalSourceUser),
+		}
+	}
+	first := proposal(binary, testHash, models.PolicyAllowlist)
+
+	tests := []struct {
+		name     string
+		finalize bool // Approve the first proposal before opening the second
+		second   models.Proposal
+		created  bool
+	}{
+		{name: "same target and policy", second: first},
+		{name: "other policy", second: proposal(binary, testHash, models.PolicyBlocklist), created: true},
+		{name: "other rule type", second: proposal(teamID, testTeamID, models.PolicyAllowlist), created: true},
+		{name: "first already finalized", finalize: true, second: first, created: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			firstID, created, err := OpenProposal(first)
+			if err != nil || !created {
+				t.Fatalf("OpenProposal = %d, %v, %v", firstID, created, err)
+			}
+			if tt.finalize {
+				mustExec(t, `UPDATE proposals SET status = 'APPROVED' WHERE id = ?`, firstID)
+			}
+
+			secondID, created, err := OpenProposal(tt.second)
+			if err != nil {
+				t.Fatalf("OpenProposal: %v", err)
+			}
+			if created != tt.created {
+				t.Errorf("created = %v, want %v", created, tt.created)
+			}
+			if !created && secondID != firstID {
+				t.Errorf("joined proposal %d, want %d", secondID, firstID)
+			}
+		})
+	}
+}
+
+func TestFindRuleConflicts(t *testing.T) {
+	openTestDB(t)
+	binary := string(models.RuleTypeBinary)
+	allowID := createTestRule(t, testRule(0, binary, testHash, models.PolicyAllowlist))
+
+	tests := []struct {
+		name           string
+		policy         models.Policy
+		same, opposite []int64
+	}{
+		{name: "same policy", policy: models.PolicyAllowlist, same: []int64{allowID}},
+		{name: "opposite policy", policy: models.PolicyBlocklist, opposite: []int64{allowID}},
+		{name: "compiler allowlist", policy: models.PolicyAllowlistCompiler, opposite: []int64{allowID}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			same, opposite, err := FindRuleConflicts(testHash, binary, nil, string(tt.policy), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalRuleIDs(same, tt.same) || !equalRuleIDs(opposite, tt.opposite) {
+				t.Errorf("same %v and opposite %v, want %v and %v", ruleIDs(same), ruleIDs(opposite), tt.same, tt.opposite)
+			}
+		})
+	}
+}
+
+func ruleIDs(rules []models.Rule) []int64 {
+	var ids []int64
+	for _, r := range rules {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+func equalRuleIDs(rules []models.Rule, want []int64) bool {
+	ids := ruleIDs(rules)
+	if len(ids) != len(want) {
+		return false
+	}
+	for i := range ids {
+		if ids[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"krampus/server/config"
+	"krampus/server/database"
+	"krampus/server/models"
+	"path/filepath"
+	"testing"
+)
+
+// openTestDB points the services at a fresh, migrated database in a
+// temporary directory for the length of a test
+func openTestDB(t *testing.T) {
+	t.Helper()
+	config.AppConfig = &config.Config{VoteThreshold: 3, RetentionBatchSize: 2}
+	if err := database.Initialize(filepath.Join(t.TempDir(), "krampus.db")); err != nil {
+		t.Fatalf("failed to initialize database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+}
+
+// mustExec runs a statement that test setup depends on
+func mustExec(t *testing.T, query string, args ...interface{}) {
+	t.Helper()
+	if _, err := database.DB.Exec(query, args...); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+}
+
+const (
+	testHash    = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	testTeamID  = "ABCDE12345"
+	testSigning = "ABCDE12345:com.example.tool"
+)
+
+// createTestRule creates a rule in its own revision
+func createTestRule(t *testing.T, rule models.Rule) int64 {
+	t.Helper()
+	id, err := CreateRule(rule, nil, false, nil)
+	if err != nil {
+		t.Fatalf("failed to create rule: %v", err)
+	}
+	return id
+}
+
+func testRule(id int64, ruleType, identifier string, policy models.Policy) models.Rule {
+	return models.Rule{ID: id, RuleType: ruleType, Identifier: identifier, Policy: string(policy)}
+}
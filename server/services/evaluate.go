@@ -0,0 +1,262 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"krampus/server/database"
+	"krampus/server/models"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrMachineNotFound = errors.New("machine not found")
+
+// ruleTypePrecedence is the order in which Santa consults rule types when
+// deciding on an execution; earlier types win
+var ruleTypePrecedence = []string{
+	string(models.RuleTypeCDHash),
+	string(models.RuleTypeBinary),
+	string(models.RuleTypeSigningID),
+	string(models.RuleTypeCertificate),
+	string(models.RuleTypeTeamID),
+}
+
+// NormalizeBinaryAttributes puts binary attributes in the form rules use,
+// qualifying a bare signing ID with the team ID (and the reverse), and
+// checks their formats.
+// Field names are those of the evaluate request body.
+func NormalizeBinaryAttributes(a *models.BinaryAttributes) ValidationErrors {
+	normalizeAttributes(a)
+
+	if a.SHA256 == "" && a.CDHash == "" && a.CertSHA256 == "" && a.TeamID == "" && a.SigningID == "" {
+		return ValidationErrors{{Field: "sha256", Message: "at least one binary attribute is required"}}
+	}
+
+	var errs ValidationErrors
+	for _, attr := range []struct {
+		field, ruleType, value string
+	}{
+		{"sha256", string(models.RuleTypeBinary), a.SHA256},
+		{"cdhash", string(models.RuleTypeCDHash), a.CDHash},
+		{"signing_id", string(models.RuleTypeSigningID), a.SigningID},
+		{"team_id", string(models.RuleTypeTeamID), a.TeamID},
+		{"cert_sha256", string(models.RuleTypeCertificate), a.CertSHA256},
+	} {
+		if attr.value == "" {
+			continue
+		}
+		if err := ValidateIdentifier(attr.ruleType, attr.value); err != nil {
+			errs = append(errs, ValidationError{Field: attr.field, Message: err.Message})
+		}
+	}
+	return errs
+}
+
+func normalizeAttributes(a *models.BinaryAttributes) {
+	a.SHA256 = NormalizeIdentifier(string(models.RuleTypeBinary), a.SHA256)
+	a.CDHash = NormalizeIdentifier(string(models.RuleTypeCDHash), a.CDHash)
+	a.CertSHA256 = NormalizeIdentifier(string(models.RuleTypeCertificate), a.CertSHA256)
+	a.TeamID = NormalizeIdentifier(string(models.RuleTypeTeamID), a.TeamID)
+	a.SigningID = SigningIdentifier(strings.TrimSpace(a.SigningID), a.TeamID)
+	if prefix, _, found := strings.Cut(a.SigningID, ":"); found && a.TeamID == "" && prefix != "platform" {
+		a.TeamID = prefix
+	}
+}
+
+// EvaluateBinary decides what Santa would do with a binary under the current
+// ruleset. Rules are consulted in Santa's precedence order, skipping expired
+// rules and rules scoped to groups the machine isn't in or whose rollout has
+// not reached it; a rule of one of the machine's groups takes its target
+// from the fleet-wide rule. Without a machine
+// only fleet-wide rules apply and unmatched binaries are decided by the
+// LOCKDOWN mode preflight assigns.
+func EvaluateBinary(attrs models.BinaryAttributes, machineID string) (*models.Evaluation, error) {
+	eval := &models.Evaluation{
+		Attributes: attrs,
+		MachineID:  machineID,
+		ClientMode: string(models.ClientModeLockdown),
+		Candidates: []models.EvaluatedRule{},
+	}
+
+	groups := map[int64]bool{}
+	if machineID != "" {
+		var clientMode sql.NullString
+		err := database.DB.QueryRow(
+			`SELECT client_mode FROM machines WHERE machine_id = ?`, machineID,
+		).Scan(&clientMode)
+		if err == sql.ErrNoRows {
+			return nil, ErrMachineNotFound
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch machine: %w", err)
+		}
+		if clientMode.String != "" {
+			eval.ClientMode = clientMode.String
+		}
+
+		groups, err = machineGroupIDs(machineID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var rules []models.Rule
+	if targets := binaryTargets(attrs); len(targets) > 0 {
+		var err error
+		rules, err = rulesForTargets(targets)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	decide(eval, rules, groups, time.Now())
+	return eval, nil
+}
+
+// fleetEvaluation decides a binary as EvaluateBinary does without a machine,
+// picking the binary's rules out of rules fetched for several binaries
+func fleetEvaluation(attrs models.BinaryAttributes, rules []models.Rule, now time.Time) *models.Evaluation {
+	targets := map[models.RuleTarget]bool{}
+	for _, t := range binaryTargets(attrs) {
+		targets[t] = true
+	}
+	var matching []models.Rule
+	for _, r := range rules {
+		if targets[models.RuleTarget{Identifier: r.Identifier, RuleType: r.RuleType}] {
+			matching = append(matching, r)
+		}
+	}
+
+	eval := &models.Evaluation{
+		Attributes: attrs,
+		ClientMode: string(models.ClientModeLockdown),
+		Candidates: []models.EvaluatedRule{},
+	}
+	decide(eval, matching, map[int64]bool{}, now)
+	return eval
+}
+
+// decide applies the highest precedence rule in effect for the machine to
+// an evaluation, falling back to its client mode, and records what happened
+// to every candidate rule
+func decide(eval *models.Evaluation, rules []models.Rule, groups map[int64]bool, now time.Time) {
+	for _, ruleType := range ruleTypePrecedence {
+		var candidates []models.Rule
+		for _, r := range rules {
+			if r.RuleType == ruleType {
+				candidates = append(candidates, r)
+			}
+		}
+		sort.SliceStable(candidates, func(i, j int) bool { return outranks(candidates[i], candidates[j]) })
+
+		// A binary has one target per rule type, which the machine holds
+		// only the first rule reaching it for
+		held := false
+		for _, r := range candidates {
+			var status models.EvaluatedRuleStatus
+			switch {
+			case r.ExpiresAt != nil && !r.ExpiresAt.After(now):
+				status = models.EvaluatedRuleExpired
+			case r.GroupID != nil && !groups[*r.GroupID], !rolloutReaches(r, groups):
+				status = models.EvaluatedRuleOutOfScope
+			case held:
+				status = models.EvaluatedRuleShadowed
+			case r.Policy == string(models.PolicyRemove):
+				status = models.EvaluatedRuleRemoval
+			case eval.Rule != nil:
+				status = models.EvaluatedRuleShadowed
+			default:
+				status = models.EvaluatedRuleApplied
+				applied := r
+				eval.Rule = &applied
+			}
+			if status != models.EvaluatedRuleExpired && status != models.EvaluatedRuleOutOfScope {
+				held = true
+			}
+			eval.Candidates = append(eval.Candidates, models.EvaluatedRule{Rule: r, Status: string(status)})
+		}
+	}
+
+	switch {
+	case eval.Rule != nil && isBlockPolicy(eval.Rule.Policy):
+		eval.Decision = "BLOCK_" + eval.Rule.RuleType
+	case eval.Rule != nil && eval.Rule.Policy == string(models.PolicyCEL):
+		eval.Decision = "CEL_" + eval.Rule.RuleType
+	case eval.Rule != nil:
+		eval.Decision = "ALLOW_" + eval.Rule.RuleType
+	case eval.ClientMode == string(models.ClientModeMonitor):
+		eval.Decision = "ALLOW_UNKNOWN"
+	default:
+		eval.Decision = "BLOCK_UNKNOWN"
+	}
+	// Santa evaluates a CEL rule's expression against each execution, so its
+	// outcome is unknown here and the binary isn't reported as allowed
+	eval.Allowed = !IsBlockDecision(eval.Decision) && !strings.HasPrefix(eval.Decision, "CEL_")
+}
+
+// RuleInEffect returns the WHERE condition selecting the active rules a
+// machine receives: unexpired, either fleet-wide or scoped to one of the
+// machine's groups, past any rollout stage the machine is waiting on, and
+// not outranked for their target. Santa keeps one rule per target, so a rule
+// scoped to one of the machine's groups takes the place of the fleet-wide
+// rule, and the newest wins among the rules of several groups.
+func RuleInEffect(machineID string) (string, []interface{}) {
+	now := time.Now().UTC()
+	return ruleReaches("rules") + ` AND NOT EXISTS (
+			SELECT 1 FROM rules outranking
+			WHERE outranking.identifier = rules.identifier AND outranking.rule_type = rules.rule_type
+			  AND outranking.group_id IS NOT NULL AND (rules.group_id IS NULL OR outranking.id > rules.id)
+			  AND ` + ruleReaches("outranking") + `)`,
+		[]interface{}{now, machineID, machineID, now, machineID, machineID}
+}
+
+// ruleReaches returns the WHERE condition selecting the rules of the named
+// table or alias that reach a machine, whether or not another rule outranks
+// them. It takes the current time and the machine ID twice as arguments.
+func ruleReaches(rules string) string {
+	return rules + `.superseded_at IS NULL AND (` + rules + `.expires_at IS NULL OR ` + rules + `.expires_at > ?)
+		AND (` + rules + `.group_id IS NULL OR ` + rules + `.group_id IN (SELECT group_id FROM machine_group_members WHERE machine_id = ?))
+		AND ` + rolloutPending(rules)
+}
+
+// outranks reports whether rule a takes a target from rule b when both reach
+// a machine: group-scoped rules before fleet-wide ones, newest first
+func outranks(a, b models.Rule) bool {
+	if (a.GroupID != nil) != (b.GroupID != nil) {
+		return a.GroupID != nil
+	}
+	return a.ID > b.ID
+}
+
+// GroupExists reports whether a machine group exists
+func GroupExists(groupID int64) (bool, error) {
+	var count int
+	err := database.DB.QueryRow(`SELECT COUNT(*) FROM machine_groups WHERE id = ?`, groupID).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch group: %w", err)
+	}
+	return count > 0, nil
+}
+
+// machineGroupIDs returns the set of groups a machine belongs to
+func machineGroupIDs(machineID string) (map[int64]bool, error) {
+	rows, err := database.DB.Query(
+		`SELECT group_id FROM machine_group_members WHERE machine_id = ?`, machineID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query machine groups: %w", err)
+	}
+	defer rows.Close()
+
+	groups := map[int64]bool{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan machine group: %w", err)
+		}
+		groups[id] = true
+	}
+	return groups, rows.Err()
+}
@@ -0,0 +1,220 @@
+package services
+
+import (
+	"errors"
+	"krampus/server/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func inGroup(r models.Rule, groupID int64) models.Rule {
+	r.GroupID = &groupID
+	return r
+}
+
+func expiringAt(r models.Rule, at time.Time) models.Rule {
+	r.ExpiresAt = &at
+	return r
+}
+
+func TestDecide(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	binary := string(models.RuleTypeBinary)
+	signingID := string(models.RuleTypeSigningID)
+	teamID := string(models.RuleTypeTeamID)
+
+	tests := []struct {
+		name       string
+		rules      []models.Rule
+		groups     map[int64]bool
+		clientMode models.ClientMode
+		decision   string
+		allowed    bool
+		statuses   map[int64]models.EvaluatedRuleStatus
+	}{
+		{
+			name:       "no rules in lockdown",
+			clientMode: models.ClientModeLockdown,
+			decision:   "BLOCK_UNKNOWN",
+			statuses:   map[int64]models.EvaluatedRuleStatus{},
+		},
+		{
+			name:       "no rules in monitor",
+			clientMode: models.ClientModeMonitor,
+			decision:   "ALLOW_UNKNOWN",
+			allowed:    true,
+			statuses:   map[int64]models.EvaluatedRuleStatus{},
+		},
+		{
+			name: "more specific rule type wins",
+			rules: []models.Rule{
+				testRule(1, teamID, testTeamID, models.PolicyAllowlist),
+				testRule(2, signingID, testSigning, models.PolicyBlocklist),
+			},
+			decision: "BLOCK_SIGNINGID",
+			statuses: map[int64]models.EvaluatedRuleStatus{
+				1: models.EvaluatedRuleShadowed,
+				2: models.EvaluatedRuleApplied,
+			},
+		},
+		{
+			name: "expired rule is skipped",
+			rules: []models.Rule{
+				expiringAt(testRule(1, binary, testHash, models.PolicyBlocklist), now.Add(-time.Minute)),
+				testRule(2, teamID, testTeamID, models.PolicyAllowlist),
+			},
+			decision: "ALLOW_TEAMID",
+			allowed:  true,
+			statuses: map[int64]models.EvaluatedRuleStatus{
+				1: models.EvaluatedRuleExpired,
+				2: models.EvaluatedRuleApplied,
+			},
+		},
+		{
+			name: "rule of another group is out of scope",
+			rules: []models.Rule{
+				inGroup(testRule(1, binary, testHash, models.PolicyAllowlist), 7),
+			},
+			groups:   map[int64]bool{8: true},
+			decision: "BLOCK_UNKNOWN",
+			statuses: map[int64]models.EvaluatedRuleStatus{1: models.EvaluatedRuleOutOfScope},
+		},
+		{
+			name: "group rule takes the target from the fleet-wide rule",
+			rules: []models.Rule{
+				testRule(5, binary, testHash, models.PolicyBlocklist),
+				inGroup(testRule(3, binary, testHash, models.PolicyAllowlist), 7),
+			},
+			groups:   map[int64]bool{7: true},
+			decision: "ALLOW_BINARY",
+			allowed:  true,
+			statuses: map[int64]models.EvaluatedRuleStatus{
+				3: models.EvaluatedRuleApplied,
+				5: models.EvaluatedRuleShadowed,
+			},
+		},
+		{
+			name: "newest group rule wins among several groups",
+			rules: []models.Rule{
+				inGroup(testRule(3, binary, testHash, models.PolicyAllowlist), 7),
+				inGroup(testRule(4, binary, testHash, models.PolicyBlocklist), 8),
+			},
+			groups:   map[int64]bool{7: true, 8: true},
+			decision: "BLOCK_BINARY",
+			statuses: map[int64]models.EvaluatedRuleStatus{
+				3: models.EvaluatedRuleShadowed,
+				4: models.EvaluatedRuleApplied,
+			},
+		},
+		{
+			name: "group removal leaves the target to lower rule types",
+			rules: []models.Rule{
+				testRule(1, binary, testHash, models.PolicyBlocklist),
+				inGroup(testRule(2, binary, testHash, models.PolicyRemove), 7),
+				testRule(3, teamID, testTeamID, models.PolicyAllowlist),
+			},
+			groups:   map[int64]bool{7: true},
+			decision: "ALLOW_TEAMID",
+			allowed:  true,
+			statuses: map[int64]models.EvaluatedRuleStatus{
+				1: models.EvaluatedRuleShadowed,
+				2: models.EvaluatedRuleRemoval,
+				3: models.EvaluatedRuleApplied,
+			},
+		},
+		{
+			name: "rollout not yet reaching the machine",
+			rules: []models.Rule{func() models.Rule {
+				r := testRule(1, binary, testHash, models.PolicyBlocklist)
+				r.Rollout = &models.RuleRolloutSummary{Status: string(models.RolloutStatusActive), GroupIDs: []int64{9}}
+				return r
+			}()},
+			groups:     map[int64]bool{7: true},
+			clientMode: models.ClientModeMonitor,
+			decision:   "ALLOW_UNKNOWN",
+			allowed:    true,
+			statuses:   map[int64]models.EvaluatedRuleStatus{1: models.EvaluatedRuleOutOfScope},
+		},
+		{
+			name:     "CEL rule is not reported as allowed",
+			rules:    []models.Rule{testRule(1, signingID, testSigning, models.PolicyCEL)},
+			decision: "CEL_SIGNINGID",
+			statuses: map[int64]models.EvaluatedRuleStatus{1: models.EvaluatedRuleApplied},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientMode := tt.clientMode
+			if clientMode == "" {
+				clientMode = models.ClientModeLockdown
+			}
+			eval := &models.Evaluation{ClientMode: string(clientMode)}
+			decide(eval, tt.rules, tt.groups, now)
+
+			if eval.Decision != tt.decision {
+				t.Errorf("decision = %s, want %s", eval.Decision, tt.decision)
+			}
+			if eval.Allowed != tt.allowed {
+				t.Errorf("allowed = %v, want %v", eval.Allowed, tt.allowed)
+			}
+			statuses := map[int64]models.EvaluatedRuleStatus{}
+			for _, c := range eval.Candidates {
+				statuses[c.Rule.ID] = models.EvaluatedRuleStatus(c.Status)
+			}
+			if !reflect.DeepEqual(statuses, tt.statuses) {
+				t.Errorf("statuses = %v, want %v", statuses, tt.statuses)
+			}
+		})
+	}
+}
+
+func TestEvaluateBinary(t *testing.T) {
+	openTestDB(t)
+	mustExec(t, `INSERT INTO machines (machine_id, client_mode) VALUES ('grouped', 'MONITOR'), ('plain', 'LOCKDOWN')`)
+	mustExec(t, `INSERT INTO machine_groups (id, name) VALUES (7, 'eng')`)
+	mustExec(t, `INSERT INTO machine_group_members (group_id, machine_id) VALUES (7, 'grouped')`)
+
+	group := int64(7)
+	for _, rule := range []models.Rule{
+		{Identifier: testHash, RuleType: string(models.RuleTypeBinary), Policy: string(models.PolicyBlocklist)},
+		{Identifier: testHash, RuleType: string(models.RuleTypeBinary), Policy: string(models.PolicyAllowlist), GroupID: &group},
+	} {
+		if _, err := CreateRule(rule, nil, false, nil); err != nil {
+			t.Fatalf("failed to create rule: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		attrs     models.BinaryAttributes
+		machineID string
+		decision  string
+		err       error
+	}{
+		{name: "fleet-wide without a machine", attrs: models.BinaryAttributes{SHA256: testHash}, decision: "BLOCK_BINARY"},
+		{name: "machine outside the group", attrs: models.BinaryAttributes{SHA256: testHash}, machineID: "plain", decision: "BLOCK_BINARY"},
+		{name: "machine in the group", attrs: models.BinaryAttributes{SHA256: testHash}, machineID: "grouped", decision: "ALLOW_BINARY"},
+		{name: "unmatched binary in monitor mode", attrs: models.BinaryAttributes{TeamID: testTeamID}, machineID: "grouped", decision: "ALLOW_UNKNOWN"},
+		{name: "unknown machine", attrs: models.BinaryAttributes{SHA256: testHash}, machineID: "missing", err: ErrMachineNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eval, err := EvaluateBinary(tt.attrs, tt.machineID)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EvaluateBinary: %v", err)
+			}
+			if eval.Decision != tt.decision {
+				t.Errorf("decision = %s, want %s", eval.Decision, tt.decision)
+			}
+		})
+	}
+}
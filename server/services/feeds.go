@@ -0,0 +1,780 @@
+package services
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"krampus/server/database"
+	"krampus/server/models"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrFeedNotFound  = errors.New("threat feed not found")
+	ErrFeedNameTaken = errors.New("a threat feed with this name already exists")
+)
+
+// feedMu serializes feed runs so a scheduled and a manual run of the same
+// feed can't race
+var feedMu sync.Mutex
+
+const (
+	maxFeedSize     = 64 << 20 // Bytes read from a feed
+	maxFeedRefusals = 100      // Refusals listed in a run report
+)
+
+var feedClient = &http.Client{Timeout: 60 * time.Second}
+
+// stixHashPattern matches SHA-256 comparisons in STIX indicator patterns,
+// such as [file:hashes.'SHA-256' = '...']
+var stixHashPattern = regexp.MustCompile(`(file|x509-certificate):hashes\.(?:'SHA-256'|"SHA-256"|SHA256|'SHA256')\s*=\s*'([0-9A-Fa-f]+)'`)
+
+const feedColumns = `f.id, f.name, f.url, f.format, f.rule_type, f.interval_minutes, f.enabled, f.last_run_at,
+	f.created_by, f.created_at,
+	(SELECT COUNT(*) FROM rules r WHERE r.feed_id = f.id AND r.source = 'FEED' AND r.` + ActiveRule + `),
+	(SELECT COALESCE(SUM(r.hit_count), 0) FROM rules r WHERE r.feed_id = f.id AND r.source = 'FEED' AND r.` + ActiveRule + `)`
+
+func scanFeed(row interface{ Scan(...interface{}) error }, f *models.ThreatFeed) error {
+	return row.Scan(&f.ID, &f.Name, &f.URL, &f.Format, &f.RuleType, &f.IntervalMinutes, &f.Enabled, &f.LastRunAt,
+		&f.CreatedBy, &f.CreatedAt, &f.RuleCount, &f.BlockCount)
+}
+
+// FeedInput describes a threat feed to create
+type FeedInput struct {
+	Name            string
+	URL             string
+	Format          string
+	RuleType        string // Defaults to BINARY
+	IntervalMinutes int    // Defaults to 60
+	Enabled         *bool  // Defaults to true
+}
+
+// FeedUpdate lists the feed fields an update may change; nil fields are left
+// alone
+type FeedUpdate struct {
+	Name            *string
+	URL             *string
+	Format          *string
+	RuleType        *string
+	IntervalMinutes *int
+	Enabled         *bool
+}
+
+// ValidateFeed checks a feed's settings. Field names are those of the
+// request body.
+func ValidateFeed(name, location, format, ruleType string, intervalMinutes int) ValidationErrors {
+	var errs ValidationErrors
+	if strings.TrimSpace(name) == "" {
+		errs = append(errs, ValidationError{Field: "name", Message: "must not be empty"})
+	}
+	if err := validateFeedURL(location); err != nil {
+		errs = append(errs, *err)
+	}
+	switch models.FeedFormat(format) {
+	case models.FeedFormatText, models.FeedFormatCSV, models.FeedFormatSTIX:
+	default:
+		errs = append(errs, ValidationError{Field: "format", Message: "must be TEXT, CSV or STIX"})
+	}
+	switch models.RuleType(ruleType) {
+	case models.RuleTypeBinary, models.RuleTypeCertificate:
+	case models.RuleTypeCDHash:
+		if format == string(models.FeedFormatSTIX) {
+			errs = append(errs, ValidationError{Field: "rule_type", Message: "STIX feeds carry BINARY or CERTIFICATE hashes"})
+		}
+	default:
+		errs = append(errs, ValidationError{Field: "rule_type", Message: "must be BINARY, CERTIFICATE or CDHASH"})
+	}
+	if intervalMinutes < 1 {
+		errs = append(errs, ValidationError{Field: "interval_minutes", Message: "must be at least 1"})
+	}
+	return errs
+}
+
+func validateFeedURL(location string) *ValidationError {
+	location = strings.TrimSpace(location)
+	if location == "" {
+		return &ValidationError{Field: "url", Message: "must not be empty"}
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return &ValidationError{Field: "url", Message: "must be a file path or an http, https or file URL"}
+	}
+	switch u.Scheme {
+	case "":
+	case "http", "https":
+		if u.Host == "" {
+			return &ValidationError{Field: "url", Message: "must include a host"}
+		}
+	case "file":
+		if u.Path == "" {
+			return &ValidationError{Field: "url", Message: "must include a path"}
+		}
+	default:
+		return &ValidationError{Field: "url", Message: "must be a file path or an http, https or file URL"}
+	}
+	return nil
+}
+
+// ListFeeds returns every threat feed with its rule counts and last run
+func ListFeeds() ([]models.ThreatFeed, error) {
+	rows, err := database.DB.Query(`SELECT ` + feedColumns + ` FROM threat_feeds f ORDER BY f.name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query threat feeds: %w", err)
+	}
+	defer rows.Close()
+
+	feeds := []models.ThreatFeed{}
+	for rows.Next() {
+		var f models.ThreatFeed
+		if err := scanFeed(rows, &f); err != nil {
+			return nil, fmt.Errorf("failed to scan threat feed: %w", err)
+		}
+		feeds = append(feeds, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read threat feeds: %w", err)
+	}
+
+	for i := range feeds {
+		runs, err := ListFeedRuns(feeds[i].ID, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(runs) > 0 {
+			feeds[i].LastRun = &runs[0]
+		}
+	}
+	return feeds, nil
+}
+
+// GetFeed returns a threat feed with its rule counts and last run
+func GetFeed(feedID int64) (*models.ThreatFeed, error) {
+	var f models.ThreatFeed
+	err := scanFeed(database.DB.QueryRow(`SELECT `+feedColumns+` FROM threat_feeds f WHERE f.id = ?`, feedID), &f)
+	if err == sql.ErrNoRows {
+		return nil, ErrFeedNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch threat feed: %w", err)
+	}
+
+	runs, err := ListFeedRuns(feedID, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) > 0 {
+		f.LastRun = &runs[0]
+	}
+	return &f, nil
+}
+
+// ListFeedRuns returns a feed's most recent runs, newest first
+func ListFeedRuns(feedID int64, limit int) ([]models.ThreatFeedRun, error) {
+	rows, err := database.DB.Query(
+		`SELECT id, feed_id, trigger, status, entries, added, refreshed, retired, skipped_allowlisted,
+		        skipped_existing, invalid, error, refusals, started_at, finished_at
+		 FROM threat_feed_runs WHERE feed_id = ? ORDER BY id DESC LIMIT ?`,
+		feedID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query threat feed runs: %w", err)
+	}
+	defer rows.Close()
+
+	runs := []models.ThreatFeedRun{}
+	for rows.Next() {
+		var run models.ThreatFeedRun
+		var refusals string
+		if err := rows.Scan(&run.ID, &run.FeedID, &run.Trigger, &run.Status, &run.Entries, &run.Added,
+			&run.Refreshed, &run.Retired, &run.SkippedAllowlisted, &run.SkippedExisting, &run.Invalid,
+			&run.Error, &refusals, &run.StartedAt, &run.FinishedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan threat feed run: %w", err)
+		}
+		if err := json.Unmarshal([]byte(refusals), &run.Refusals); err != nil {
+			return nil, fmt.Errorf("failed to decode threat feed refusals: %w", err)
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// CreateFeed adds a threat feed. It is first ingested on the next scheduler
+// check.
+func CreateFeed(input FeedInput, createdBy int64) (int64, error) {
+	enabled := input.Enabled == nil || *input.Enabled
+	result, err := database.DB.Exec(
+		`INSERT INTO threat_feeds (name, url, format, rule_type, interval_minutes, enabled, created_by)
+		 SELECT ?, ?, ?, ?, ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM threat_feeds WHERE name = ?)`,
+		input.Name, input.URL, input.Format, input.RuleType, input.IntervalMinutes, enabled, createdBy, input.Name,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create threat feed: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return 0, ErrFeedNameTaken
+	}
+	return result.LastInsertId()
+}
+
+// UpdateFeed changes a feed's settings. Changing its rule type retires the
+// rules it manages at the next run.
+func UpdateFeed(feedID int64, update FeedUpdate) (*models.ThreatFeed, error) {
+	feed, err := GetFeed(feedID)
+	if err != nil {
+		return nil, err
+	}
+	if update.Name != nil {
+		feed.Name = strings.TrimSpace(*update.Name)
+	}
+	if update.URL != nil {
+		feed.URL = strings.TrimSpace(*update.URL)
+	}
+	if update.Format != nil {
+		feed.Format = strings.ToUpper(*update.Format)
+	}
+	if update.RuleType != nil {
+		feed.RuleType = strings.ToUpper(*update.RuleType)
+	}
+	if update.IntervalMinutes != nil {
+		feed.IntervalMinutes = *update.IntervalMinutes
+	}
+	if update.Enabled != nil {
+		feed.Enabled = *update.Enabled
+	}
+	if errs := ValidateFeed(feed.Name, feed.URL, feed.Format, feed.RuleType, feed.IntervalMinutes); len(errs) > 0 {
+		return nil, errs
+	}
+
+	var taken int
+	if err := database.DB.QueryRow(
+		`SELECT COUNT(*) FROM threat_feeds WHERE name = ? AND id != ?`, feed.Name, feedID,
+	).Scan(&taken); err != nil {
+		return nil, fmt.Errorf("failed to check threat feed name: %w", err)
+	}
+	if taken > 0 {
+		return nil, ErrFeedNameTaken
+	}
+
+	_, err = database.DB.Exec(
+		`UPDATE threat_feeds SET name = ?, url = ?, format = ?, rule_type = ?, interval_minutes = ?, enabled = ?
+		 WHERE id = ?`,
+		feed.Name, feed.URL, feed.Format, feed.RuleType, feed.IntervalMinutes, feed.Enabled, feedID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update threat feed: %w", err)
+	}
+	return GetFeed(feedID)
+}
+
+// DeleteFeed deletes a feed and retires the rules it manages in one ruleset
+// revision
+func DeleteFeed(feedID, deletedBy int64) error {
+	feedMu.Lock()
+	defer feedMu.Unlock()
+
+	feed, err := GetFeed(feedID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	reason := fmt.Sprintf("Threat feed %s deleted", feed.Name)
+	ids, err := feedRuleIDs(tx, feedID)
+	if err != nil {
+		return err
+	}
+	revision := newRulesetRevision(&deletedBy, &reason)
+	for _, id := range ids {
+		if err := deleteRule(tx, revision, id, &deletedBy, &reason); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM threat_feed_runs WHERE feed_id = ?`, feedID); err != nil {
+		return fmt.Errorf("failed to delete threat feed runs: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM threat_feeds WHERE id = ?`, feedID); err != nil {
+		return fmt.Errorf("failed to delete threat feed: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func feedRuleIDs(tx *sql.Tx, feedID int64) ([]int64, error) {
+	rows, err := tx.Query(
+		`SELECT id FROM rules WHERE feed_id = ? AND source = ? AND `+ActiveRule, feedID, models.RuleSourceFeed,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query threat feed rules: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan threat feed rule: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// WatchFeeds runs every enabled feed whose interval has passed since its last
+// run, checking at the given interval. A zero or negative interval turns the
+// scheduled runs off, leaving feeds to be run by hand.
+func WatchFeeds(interval time.Duration) {
+	if interval <= 0 {
+		log.Printf("Scheduled threat feed runs are disabled (FEED_CHECK_INTERVAL=%s)", interval)
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := RunDueFeeds(); err != nil {
+				log.Printf("Failed to run threat feeds: %v", err)
+			}
+		}
+	}()
+}
+
+// RunDueFeeds runs the enabled feeds that are due
+func RunDueFeeds() error {
+	feeds, err := ListFeeds()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, f := range feeds {
+		if !f.Enabled {
+			continue
+		}
+		if f.LastRunAt != nil && now.Before(f.LastRunAt.Add(time.Duration(f.IntervalMinutes)*time.Minute)) {
+			continue
+		}
+		run, err := RunFeed(f.ID, models.FeedRunTriggerSchedule)
+		if err != nil {
+			log.Printf("Failed to run threat feed %s: %v", f.Name, err)
+			continue
+		}
+		if run.Status == string(models.FeedRunStatusFailed) {
+			log.Printf("Threat feed %s failed: %s", f.Name, deref(run.Error))
+			continue
+		}
+		log.Printf("Ran threat feed %s: %d added, %d refreshed, %d retired, %d refused as allowlisted",
+			f.Name, run.Added, run.Refreshed, run.Retired, run.SkippedAllowlisted)
+	}
+	return nil
+}
+
+// feedEntry is one hash listed by a feed
+type feedEntry struct {
+	Identifier string
+	Comment    string
+}
+
+// RunFeed fetches a feed and makes its rules match it in one ruleset
+// revision: listed hashes without a rule get a BLOCKLIST rule, hashes the
+// feed already blocks are refreshed, and its rules for hashes no longer
+// listed are retired. Hashes covered by an allowlist rule, for the same
+// target or for the signing identity events report for the binary, are
+// refused; hashes with another rule are left to it. A feed that can't be
+// fetched or parsed, or lists no valid hashes, changes nothing and the run
+// is recorded as FAILED.
+func RunFeed(feedID int64, trigger models.FeedRunTrigger) (*models.ThreatFeedRun, error) {
+	feedMu.Lock()
+	defer feedMu.Unlock()
+
+	feed, err := GetFeed(feedID)
+	if err != nil {
+		return nil, err
+	}
+
+	run := &models.ThreatFeedRun{
+		FeedID:    feedID,
+		Trigger:   string(trigger),
+		Status:    string(models.FeedRunStatusSuccess),
+		Refusals:  []models.ThreatFeedRefusal{},
+		StartedAt: time.Now(),
+	}
+
+	data, err := fetchFeed(feed.URL)
+	var entries []feedEntry
+	if err == nil {
+		entries, run.Invalid, err = parseFeed(feed.Format, feed.RuleType, data)
+	}
+	if err == nil && len(entries) == 0 {
+		err = errors.New("feed lists no valid hashes; keeping its rules")
+	}
+	if err == nil {
+		err = applyFeed(feed, entries, run)
+	}
+	if err != nil {
+		message := err.Error()
+		run.Status = string(models.FeedRunStatusFailed)
+		run.Error = &message
+		run.Added, run.Refreshed, run.Retired = 0, 0, 0
+		run.SkippedAllowlisted, run.SkippedExisting = 0, 0
+		run.Refusals = []models.ThreatFeedRefusal{}
+	}
+	return run, saveFeedRun(run)
+}
+
+func saveFeedRun(run *models.ThreatFeedRun) error {
+	run.FinishedAt = time.Now()
+	refusals, err := json.Marshal(run.Refusals)
+	if err != nil {
+		return fmt.Errorf("failed to encode threat feed refusals: %w", err)
+	}
+
+	result, err := database.DB.Exec(
+		`INSERT INTO threat_feed_runs (feed_id, trigger, status, entries, added, refreshed, retired,
+		                               skipped_allowlisted, skipped_existing, invalid, error, refusals,
+		                               started_at, finished_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		run.FeedID, run.Trigger, run.Status, run.Entries, run.Added, run.Refreshed, run.Retired,
+		run.SkippedAllowlisted, run.SkippedExisting, run.Invalid, run.Error, string(refusals),
+		run.StartedAt, run.FinishedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record threat feed run: %w", err)
+	}
+	run.ID, _ = result.LastInsertId()
+
+	if _, err := database.DB.Exec(`UPDATE threat_feeds SET last_run_at = ? WHERE id = ?`, run.StartedAt, run.FeedID); err != nil {
+		return fmt.Errorf("failed to update threat feed: %w", err)
+	}
+	return nil
+}
+
+// fetchFeed reads a feed from a local path, a file URL or an http(s) URL
+func fetchFeed(location string) ([]byte, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid feed URL: %w", err)
+	}
+
+	var body io.ReadCloser
+	switch u.Scheme {
+	case "http", "https":
+		resp, err := feedClient.Get(location)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch feed: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to fetch feed: %s", resp.Status)
+		}
+		body = resp.Body
+	case "file":
+		if body, err = os.Open(u.Path); err != nil {
+			return nil, fmt.Errorf("failed to open feed: %w", err)
+		}
+	default:
+		if body, err = os.Open(location); err != nil {
+			return nil, fmt.Errorf("failed to open feed: %w", err)
+		}
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(body, maxFeedSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read feed: %w", err)
+	}
+	if len(data) > maxFeedSize {
+		return nil, fmt.Errorf("feed is larger than %d bytes", maxFeedSize)
+	}
+	return data, nil
+}
+
+// parseFeed reads the distinct valid hashes a feed lists, counting entries
+// that aren't valid identifiers for the feed's rule type
+func parseFeed(format, ruleType string, data []byte) ([]feedEntry, int, error) {
+	var raw []feedEntry
+	switch models.FeedFormat(format) {
+	case models.FeedFormatText:
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+				continue
+			}
+			// The first token is the hash; the rest of the line, optionally
+			// after a #, is its comment
+			hash := strings.Fields(line)[0]
+			comment := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line[len(hash):]), "#"))
+			raw = append(raw, feedEntry{Identifier: hash, Comment: comment})
+		}
+
+	case models.FeedFormatCSV:
+		entries, err := parseFeedCSV(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		raw = entries
+
+	case models.FeedFormatSTIX:
+		entries, err := parseFeedSTIX(ruleType, data)
+		if err != nil {
+			return nil, 0, err
+		}
+		raw = entries
+
+	default:
+		return nil, 0, fmt.Errorf("unsupported feed format %q", format)
+	}
+
+	var entries []feedEntry
+	invalid := 0
+	seen := map[string]bool{}
+	for _, e := range raw {
+		e.Identifier = NormalizeIdentifier(ruleType, e.Identifier)
+		if ValidateIdentifier(ruleType, e.Identifier) != nil {
+			invalid++
+			continue
+		}
+		if seen[e.Identifier] {
+			continue
+		}
+		seen[e.Identifier] = true
+		entries = append(entries, e)
+	}
+	return entries, invalid, nil
+}
+
+// parseFeedCSV reads a CSV feed whose header row names the hash column
+// (identifier, sha256, hash or cdhash) and optionally a comment,
+// description or name column
+func parseFeedCSV(data []byte) ([]feedEntry, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV header: %w", err)
+	}
+	column := func(names ...string) int {
+		for _, name := range names {
+			for i, h := range header {
+				if strings.EqualFold(strings.TrimSpace(h), name) {
+					return i
+				}
+			}
+		}
+		return -1
+	}
+	hashColumn := column("identifier", "sha256", "hash", "cdhash")
+	if hashColumn < 0 {
+		return nil, errors.New("CSV header names no identifier, sha256, hash or cdhash column")
+	}
+	commentColumn := column("comment", "description", "name")
+
+	var entries []feedEntry
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		e := feedEntry{}
+		if hashColumn < len(record) {
+			e.Identifier = record[hashColumn]
+		}
+		if commentColumn >= 0 && commentColumn < len(record) {
+			e.Comment = strings.TrimSpace(record[commentColumn])
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// parseFeedSTIX reads the SHA-256 hashes from the patterns of a STIX 2
+// bundle's indicators: file hashes for BINARY feeds and certificate hashes
+// for CERTIFICATE feeds. Revoked and expired indicators are skipped.
+func parseFeedSTIX(ruleType string, data []byte) ([]feedEntry, error) {
+	var bundle struct {
+		Objects []struct {
+			Type       string     `json:"type"`
+			Name       string     `json:"name"`
+			Pattern    string     `json:"pattern"`
+			Revoked    bool       `json:"revoked"`
+			ValidUntil *time.Time `json:"valid_until"`
+		} `json:"objects"`
+	}
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, fmt.Errorf("invalid STIX JSON: %w", err)
+	}
+
+	object := "file"
+	if ruleType == string(models.RuleTypeCertificate) {
+		object = "x509-certificate"
+	}
+	now := time.Now()
+	var entries []feedEntry
+	for _, o := range bundle.Objects {
+		if o.Type != "indicator" || o.Revoked || (o.ValidUntil != nil && o.ValidUntil.Before(now)) {
+			continue
+		}
+		for _, match := range stixHashPattern.FindAllStringSubmatch(o.Pattern, -1) {
+			if match[1] == object {
+				entries = append(entries, feedEntry{Identifier: match[2], Comment: o.Name})
+			}
+		}
+	}
+	return entries, nil
+}
+
+// applyFeed makes a feed's rules match its entries in one transaction,
+// filling in the run's counts
+func applyFeed(feed *models.ThreatFeed, entries []feedEntry, run *models.ThreatFeedRun) error {
+	active, err := activeRulesByTarget()
+	if err != nil {
+		return err
+	}
+	covered := map[string]models.Rule{}
+	if feed.RuleType == string(models.RuleTypeBinary) {
+		if covered, err = allowlistedBinaries(active); err != nil {
+			return err
+		}
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	reason := "Threat feed " + feed.Name
+	revision := newRulesetRevision(nil, &reason)
+	owned := func(r models.Rule) bool {
+		return r.Source == string(models.RuleSourceFeed) && r.FeedID != nil && *r.FeedID == feed.ID &&
+			r.RuleType == feed.RuleType
+	}
+	refuse := func(identifier string, allow models.Rule) {
+		run.SkippedAllowlisted++
+		if len(run.Refusals) < maxFeedRefusals {
+			run.Refusals = append(run.Refusals, models.ThreatFeedRefusal{
+				Identifier: identifier, RuleID: allow.ID, RuleType: allow.RuleType, Policy: allow.Policy,
+			})
+		}
+	}
+
+	listed := map[models.RuleTarget]bool{}
+	for _, e := range entries {
+		target := models.RuleTarget{Identifier: e.Identifier, RuleType: feed.RuleType}
+		listed[target] = true
+		run.Entries++
+
+		existing, exists := fleetRule(active[target])
+		switch {
+		case exists && owned(existing):
+			run.Refreshed++
+			comment := e.Comment
+			if _, _, err := updateRule(tx, revision, existing, RuleUpdate{Comment: &comment}, nil, &reason); err != nil {
+				return err
+			}
+			continue
+		case exists && ruleEffect(existing.Policy) == "ALLOW":
+			refuse(e.Identifier, existing)
+			continue
+		case exists:
+			run.SkippedExisting++
+			continue
+		}
+		if allow, ok := covered[e.Identifier]; ok {
+			refuse(e.Identifier, allow)
+			continue
+		}
+
+		feedID := feed.ID
+		_, err := insertRule(tx, revision, models.Rule{
+			Identifier: e.Identifier,
+			Policy:     string(models.PolicyBlocklist),
+			RuleType:   feed.RuleType,
+			Comment:    nullIfEmpty(e.Comment),
+			Source:     string(models.RuleSourceFeed),
+			FeedID:     &feedID,
+		}, nil, &reason, false)
+		if err != nil {
+			return err
+		}
+		run.Added++
+	}
+
+	// Rules for hashes no longer listed, or of a rule type the feed no longer
+	// uses, are retired
+	for target, scoped := range active {
+		r, ok := fleetRule(scoped)
+		if !ok || r.Source != string(models.RuleSourceFeed) || r.FeedID == nil || *r.FeedID != feed.ID {
+			continue
+		}
+		if listed[target] && owned(r) {
+			continue
+		}
+		if err := deleteRule(tx, revision, r.ID, nil, &reason); err != nil {
+			return err
+		}
+		run.Retired++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// allowlistedBinaries maps the hashes of binaries seen in events to a
+// fleet-wide allowlist rule matching their certificate, signing ID, Team ID
+// or CDHash
+func allowlistedBinaries(active map[models.RuleTarget][]models.Rule) (map[string]models.Rule, error) {
+	covered := map[string]models.Rule{}
+	rows, err := database.DB.Query(
+		`SELECT file_hash, MAX(COALESCE(cdhash, '')), MAX(COALESCE(signing_id, '')),
+		        MAX(COALESCE(team_id, '')), MAX(COALESCE(cert_sha256, ''))
+		 FROM events WHERE file_hash != '' GROUP BY file_hash`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var attrs models.BinaryAttributes
+		if err := rows.Scan(&attrs.SHA256, &attrs.CDHash, &attrs.SigningID, &attrs.TeamID, &attrs.CertSHA256); err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		normalizeAttributes(&attrs)
+		for _, target := range binaryTargets(attrs) {
+			if r, ok := fleetRule(active[target]); ok && target.RuleType != string(models.RuleTypeBinary) && ruleEffect(r.Policy) == "ALLOW" {
+				covered[attrs.SHA256] = r
+				break
+			}
+		}
+	}
+	return covered, rows.Err()
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"errors"
+	"krampus/server/database"
+	"krampus/server/models"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFeed(t *testing.T) {
+	binary, cert := string(models.RuleTypeBinary), string(models.RuleTypeCertificate)
+	otherHash := strings.Repeat("b", 64)
+	future := time.Now().Add(time.Hour).Format(time.RFC3339)
+	past := time.Now().Add(-time.Hour).Format(time.RFC3339)
+
+	tests := []struct {
+		name     string
+		format   models.FeedFormat
+		ruleType string
+		data     string
+		entries  []feedEntry
+		invalid  int
+		err      bool
+	}{
+		{
+			name: "text", format: models.FeedFormatText, ruleType: binary,
+			data:    "# header\n" + strings.ToUpper(testHash) + " # Dropper\n\n// note\nnot-a-hash\n" + otherHash + "\n" + testHash + "\n",
+			entries: []feedEntry{{Identifier: testHash, Comment: "Dropper"}, {Identifier: otherHash}},
+			invalid: 1,
+		},
+		{
+			name: "CSV", format: models.FeedFormatCSV, ruleType: binary,
+			data:    "Description,SHA256\nDropper," + testHash + "\n# skipped\nLoader,zz\n",
+			entries: []feedEntry{{Identifier: testHash, Comment: "Dropper"}},
+			invalid: 1,
+		},
+		{name: "CSV without a hash column", format: models.FeedFormatCSV, ruleType: binary, data: "name,url\n", err: true},
+		{
+			name: "STIX files", format: models.FeedFormatSTIX, ruleType: binary,
+			data: `{"objects": [
+				{"type": "indicator", "name": "Dropper", "pattern": "[file:hashes.'SHA-256' = '` + testHash + `']", "valid_until": "` + future + `"},
+				{"type": "indicator", "name": "Old", "pattern": "[file:hashes.'SHA-256' = '` + otherHash + `']", "valid_until": "` + past + `"},
+				{"type": "indicator", "name": "Revoked", "pattern": "[file:hashes.'SHA-256' = '` + otherHash + `']", "revoked": true},
+				{"type": "indicator", "name": "Cert", "pattern": "[x509-certificate:hashes.'SHA-256' = '` + otherHash + `']"},
+				{"type": "malware", "name": "Family"}
+			]}`,
+			entries: []feedEntry{{Identifier: testHash, Comment: "Dropper"}},
+		},
+		{
+			name: "STIX certificates", format: models.FeedFormatSTIX, ruleType: cert,
+			data:    `{"objects": [{"type": "indicator", "name": "Cert", "pattern": "[x509-certificate:hashes.'SHA-256' = '` + otherHash + `']"}]}`,
+			entries: []feedEntry{{Identifier: otherHash, Comment: "Cert"}},
+		},
+		{name: "invalid STIX", format: models.FeedFormatSTIX, ruleType: binary, data: "{", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, invalid, err := parseFeed(string(tt.format), tt.ruleType, []byte(tt.data))
+			if (err != nil) != tt.err {
+				t.Fatalf("parseFeed error = %v, want one: %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(entries, tt.entries) || invalid != tt.invalid {
+				t.Errorf("parseFeed = %+v with %d invalid, want %+v with %d", entries, invalid, tt.entries, tt.invalid)
+			}
+		})
+	}
+}
+
+// writeFeed replaces a text feed's contents
+func writeFeed(t *testing.T, path string, hashes ...string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(strings.Join(hashes, "\n")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunFeed(t *testing.T) {
+	openTestDB(t)
+	mustExec(t, `INSERT INTO users (id, username, role) VALUES (1, 'admin', 'ADMIN')`)
+	listedHash := strings.Repeat("b", 64)
+	allowedHash := strings.Repeat("c", 64)
+	signedHash := strings.Repeat("d", 64)
+	blockedHash := strings.Repeat("e", 64)
+
+	// One hash is allowlisted itself, another through the team events report
+	// for it, and a third already has a rule of its own
+	createTestRule(t, testRule(0, string(models.RuleTypeBinary), allowedHash, models.PolicyAllowlist))
+	createTestRule(t, testRule(0, string(models.RuleTypeTeamID), testTeamID, models.PolicyAllowlist))
+	createTestRule(t, testRule(0, string(models.RuleTypeBinary), blockedHash, models.PolicyBlocklist))
+	mustExec(t, `INSERT INTO events (machine_id, file_hash, decision, signing_id, team_id, execution_time)
+		VALUES ('m1', ?, 'ALLOW_TEAMID', 'com.example.tool', ?, ?)`, signedHash, testTeamID, time.Now())
+
+	path := filepath.Join(t.TempDir(), "feed.txt")
+	writeFeed(t, path, testHash+" # Dropper", listedHash, allowedHash, signedHash, blockedHash)
+	feedID, err := CreateFeed(FeedInput{Name: "feed", URL: path, Format: "TEXT", RuleType: "BINARY", IntervalMinutes: 60}, 1)
+	if err != nil {
+		t.Fatalf("CreateFeed failed: %v", err)
+	}
+	if _, err := CreateFeed(FeedInput{Name: "feed", URL: path, Format: "TEXT", RuleType: "BINARY", IntervalMinutes: 60}, 1); !errors.Is(err, ErrFeedNameTaken) {
+		t.Errorf("second feed with the name error = %v, want %v", err, ErrFeedNameTaken)
+	}
+
+	steps := []struct {
+		name   string
+		hashes []string // Feed contents; nil leaves them
+		status models.FeedRunStatus
+		counts []int // Added, refreshed, retired, refused as allowlisted, skipped as existing
+		rules  int   // Active feed rules afterwards
+	}{
+		{name: "first run", status: models.FeedRunStatusSuccess, counts: []int{2, 0, 0, 2, 1}, rules: 2},
+		{name: "hash delisted", hashes: []string{testHash}, status: models.FeedRunStatusSuccess, counts: []int{0, 1, 1, 0, 0}, rules: 1},
+		{name: "empty feed keeps its rules", hashes: []string{"# nothing"}, status: models.FeedRunStatusFailed, counts: []int{0, 0, 0, 0, 0}, rules: 1},
+	}
+	for _, step := range steps {
+		if step.hashes != nil {
+			writeFeed(t, path, step.hashes...)
+		}
+		run, err := RunFeed(feedID, models.FeedRunTriggerManual)
+		if err != nil {
+			t.Fatalf("%s: RunFeed failed: %v", step.name, err)
+		}
+		counts := []int{run.Added, run.Refreshed, run.Retired, run.SkippedAllowlisted, run.SkippedExisting}
+		if run.Status != string(step.status) || !reflect.DeepEqual(counts, step.counts) {
+			t.Errorf("%s: run is %s with counts %v, want %s with %v", step.name, run.Status, counts, step.status, step.counts)
+		}
+		feed, err := GetFeed(feedID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if feed.RuleCount != step.rules || feed.LastRun == nil || feed.LastRun.ID != run.ID {
+			t.Errorf("%s: feed has %d rules, last run %v, want %d rules, last run %d", step.name, feed.RuleCount, feed.LastRun, step.rules, run.ID)
+		}
+	}
+
+	// Deleting the feed retires its rules in one revision
+	var before int64
+	if err := database.DB.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM ruleset_revisions`).Scan(&before); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteFeed(feedID, 1); err != nil {
+		t.Fatalf("DeleteFeed failed: %v", err)
+	}
+	var feedRules, revisions int
+	err = database.DB.QueryRow(
+		`SELECT (SELECT COUNT(*) FROM rules WHERE feed_id = ? AND `+ActiveRule+`),
+		        (SELECT COUNT(*) FROM ruleset_revisions WHERE id > ?)`, feedID, before,
+	).Scan(&feedRules, &revisions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feedRules != 0 || revisions != 1 {
+		t.Errorf("after deletion %d feed rules remain in %d new revisions, want 0 in 1", feedRules, revisions)
+	}
+	if _, err := GetFeed(feedID); !errors.Is(err, ErrFeedNotFound) {
+		t.Errorf("deleted feed error = %v, want %v", err, ErrFeedNotFound)
+	}
+}
+
+func TestWatchFeedsDisabled(t *testing.T) {
+	// A zero or negative interval turns the scheduled runs off instead of
+	// panicking in the ticker
+	WatchFeeds(0)
+	WatchFeeds(-time.Minute)
+}
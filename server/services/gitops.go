@@ -0,0 +1,456 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"krampus/server/database"
+	"krampus/server/models"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// ErrReconcileNotFound is returned for an unknown reconcile ID
+var ErrReconcileNotFound = errors.New("reconcile not found")
+
+// reconcileMu serializes reconciles from the watcher and the API
+var reconcileMu sync.Mutex
+
+// gitOpsFile is the layout of a YAML or TOML file in the rules directory
+type gitOpsFile struct {
+	Rules []gitOpsRule `yaml:"rules" toml:"rules"`
+}
+
+// gitOpsRule is a rule declared in the rules directory. Files declare the
+// whole rule, so an omitted message, URL, owner team or comment clears it.
+type gitOpsRule struct {
+	Identifier    string `yaml:"identifier" toml:"identifier"`
+	RuleType      string `yaml:"rule_type" toml:"rule_type"`
+	Policy        string `yaml:"policy" toml:"policy"`
+	CELExpr       string `yaml:"cel_expr" toml:"cel_expr"`
+	CustomMessage string `yaml:"custom_message" toml:"custom_message"`
+	CustomURL     string `yaml:"custom_url" toml:"custom_url"`
+	OwnerTeam     string `yaml:"owner_team" toml:"owner_team"`
+	Comment       string `yaml:"comment" toml:"comment"`
+}
+
+// declaredRule is a validated rule and the file position that declared it
+type declaredRule struct {
+	gitOpsRule
+	File  string
+	Index int
+}
+
+// WatchRulesDir reconciles the rules directory on startup, then polls it and
+// reconciles again whenever the contents of its rule files change. A zero or
+// negative interval turns polling off, leaving reconciles to the API.
+func WatchRulesDir(dir string, interval time.Duration) {
+	last := ""
+	report, err := ReconcileRulesDir(dir, models.ReconcileTriggerStartup, false)
+	if err != nil {
+		log.Printf("Failed to reconcile rules directory %s: %v", dir, err)
+	} else {
+		logReconcile(dir, report)
+		last = report.Fingerprint
+	}
+	if interval <= 0 {
+		log.Printf("Rules directory polling is disabled (RULES_DIR_POLL_INTERVAL=%s)", interval)
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			_, fingerprint, err := readRulesDir(dir)
+			if err != nil {
+				log.Printf("Failed to read rules directory %s: %v", dir, err)
+				continue
+			}
+			if fingerprint == last {
+				continue
+			}
+
+			// A database error leaves the fingerprint unrecorded so the next
+			// poll retries. Files that fail to parse or validate are recorded
+			// so a broken commit is reported once rather than on every poll.
+			report, err := ReconcileRulesDir(dir, models.ReconcileTriggerChange, false)
+			if err != nil {
+				log.Printf("Failed to reconcile rules directory %s: %v", dir, err)
+				continue
+			}
+			last = report.Fingerprint
+			logReconcile(dir, report)
+		}
+	}()
+}
+
+func logReconcile(dir string, report *models.RuleReconcile) {
+	if report.Status == string(models.ReconcileStatusFailed) {
+		log.Printf("Rules directory %s is invalid (%d errors); rules left unchanged", dir, len(report.Errors))
+		for _, e := range report.Errors {
+			log.Printf("  %s: %s", e.File, e.Message)
+		}
+		return
+	}
+	log.Printf("Reconciled rules directory %s: %d added, %d updated, %d removed, %d unchanged",
+		dir, len(report.Added), len(report.Updated), len(report.Removed), report.Unchanged)
+}
+
+// ReconcileRulesDir makes the GITOPS rules in the database match the rules
+// directory. If any file fails to parse or validate nothing is changed and
+// the reconcile is recorded as FAILED. Database-managed rules for a declared
+// target are superseded by the file's rule. Dry runs are neither applied nor
+// recorded.
+func ReconcileRulesDir(dir string, trigger models.ReconcileTrigger, dryRun bool) (*models.RuleReconcile, error) {
+	reconcileMu.Lock()
+	defer reconcileMu.Unlock()
+
+	report := &models.RuleReconcile{
+		Trigger:   string(trigger),
+		Status:    string(models.ReconcileStatusApplied),
+		DryRun:    dryRun,
+		Added:     []models.ReconcileChange{},
+		Updated:   []models.ReconcileChange{},
+		Removed:   []models.ReconcileChange{},
+		Errors:    []models.ReconcileError{},
+		StartedAt: time.Now(),
+	}
+
+	files, fingerprint, err := readRulesDir(dir)
+	if err != nil {
+		report.Status = string(models.ReconcileStatusFailed)
+		report.Errors = append(report.Errors, models.ReconcileError{File: dir, Message: err.Error()})
+		return report, saveReconcile(report)
+	}
+	report.Fingerprint = fingerprint
+
+	declared, errs := parseRulesDir(files)
+	if len(errs) > 0 {
+		report.Status = string(models.ReconcileStatusFailed)
+		report.Errors = errs
+		return report, saveReconcile(report)
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Managed rules not declared by any file are removed at the end
+	managed := map[models.RuleTarget]models.Rule{}
+	rows, err := tx.Query(
+		`SELECT `+RuleColumns+` FROM rules WHERE source = ? AND `+ActiveRule, models.RuleSourceGitOps,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query managed rules: %w", err)
+	}
+	for rows.Next() {
+		var r models.Rule
+		if err := ScanRule(rows, &r); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan managed rule: %w", err)
+		}
+		managed[models.RuleTarget{Identifier: r.Identifier, RuleType: r.RuleType}] = r
+	}
+	rows.Close()
+
+	revisionReason := "Reconciled rules directory"
+	revision := newRulesetRevision(nil, &revisionReason)
+	for _, d := range declared {
+		reason := "Reconciled from " + d.File
+		change := models.ReconcileChange{
+			File:       d.File,
+			Identifier: d.Identifier,
+			RuleType:   d.RuleType,
+			Policy:     d.Policy,
+		}
+		target := models.RuleTarget{Identifier: d.Identifier, RuleType: d.RuleType}
+
+		existing, err := activeRule(tx, d.Identifier, d.RuleType, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if existing == nil || existing.Source != string(models.RuleSourceGitOps) {
+			file := d.File
+			change.RuleID, err = insertRule(tx, revision, models.Rule{
+				Identifier:    d.Identifier,
+				Policy:        d.Policy,
+				RuleType:      d.RuleType,
+				CELExpr:       nullIfEmpty(d.CELExpr),
+				CustomMessage: nullIfEmpty(d.CustomMessage),
+				CustomURL:     nullIfEmpty(d.CustomURL),
+				OwnerTeam:     nullIfEmpty(d.OwnerTeam),
+				Comment:       nullIfEmpty(d.Comment),
+				Source:        string(models.RuleSourceGitOps),
+				SourceFile:    &file,
+			}, nil, &reason, true)
+			if err != nil {
+				return nil, err
+			}
+			if existing != nil {
+				change.SupersededRuleID = existing.ID
+			}
+			report.Added = append(report.Added, change)
+			continue
+		}
+
+		delete(managed, target)
+		change.RuleID = existing.ID
+
+		updated, changed, err := updateRule(tx, revision, *existing, RuleUpdate{
+			Policy:        &d.Policy,
+			CELExpr:       &d.CELExpr,
+			CustomMessage: &d.CustomMessage,
+			CustomURL:     &d.CustomURL,
+			OwnerTeam:     &d.OwnerTeam,
+			Comment:       &d.Comment,
+		}, nil, &reason)
+		if err != nil {
+			return nil, err
+		}
+		if existing.SourceFile == nil || *existing.SourceFile != d.File {
+			if _, err := tx.Exec(`UPDATE rules SET source_file = ? WHERE id = ?`, d.File, existing.ID); err != nil {
+				return nil, fmt.Errorf("failed to update rule source file: %w", err)
+			}
+		}
+		if changed {
+			change.Diff = diffRules(*existing, *updated)
+			report.Updated = append(report.Updated, change)
+		} else {
+			report.Unchanged++
+		}
+	}
+
+	for _, r := range managed {
+		reason := "Removed from rules directory"
+		if err := deleteRule(tx, revision, r.ID, nil, &reason); err != nil {
+			return nil, err
+		}
+		report.Removed = append(report.Removed, models.ReconcileChange{
+			File:       deref(r.SourceFile),
+			Identifier: r.Identifier,
+			RuleType:   r.RuleType,
+			Policy:     r.Policy,
+			RuleID:     r.ID,
+		})
+	}
+	sort.Slice(report.Removed, func(i, j int) bool { return report.Removed[i].RuleID < report.Removed[j].RuleID })
+
+	if dryRun {
+		// IDs of rules created inside the rolled back transaction don't exist
+		for i := range report.Added {
+			report.Added[i].RuleID = 0
+		}
+		return report, saveReconcile(report)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return report, saveReconcile(report)
+}
+
+// readRulesDir returns the contents of every YAML and TOML file under dir,
+// keyed by path relative to dir, and a fingerprint of names and contents.
+// Hidden directories such as .git are skipped.
+func readRulesDir(dir string) (map[string][]byte, string, error) {
+	files := map[string][]byte{}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != dir && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml", ".toml":
+		default:
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = data
+		return nil
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read rules directory: %w", err)
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hash := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(hash, "%s\x00%d\x00", name, len(files[name]))
+		hash.Write(files[name])
+	}
+	return files, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// parseRulesDir decodes and validates rule files, in file name order. A
+// target may only be declared once across the directory.
+func parseRulesDir(files map[string][]byte) ([]declaredRule, []models.ReconcileError) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var declared []declaredRule
+	var errs []models.ReconcileError
+	seen := map[models.RuleTarget]string{}
+	for _, name := range names {
+		var file gitOpsFile
+		var err error
+		if strings.HasSuffix(strings.ToLower(name), ".toml") {
+			decoder := toml.NewDecoder(bytes.NewReader(files[name]))
+			decoder.DisallowUnknownFields()
+			err = decoder.Decode(&file)
+			var strict *toml.StrictMissingError
+			if errors.As(err, &strict) {
+				keys := make([]string, len(strict.Errors))
+				for i, e := range strict.Errors {
+					keys[i] = strings.Join(e.Key(), ".")
+				}
+				err = fmt.Errorf("unknown fields: %s", strings.Join(keys, ", "))
+			}
+		} else {
+			err = yaml.UnmarshalWithOptions(files[name], &file, yaml.Strict())
+		}
+		if err != nil {
+			errs = append(errs, models.ReconcileError{File: name, Message: err.Error()})
+			continue
+		}
+
+		for i, r := range file.Rules {
+			r.RuleType = strings.ToUpper(strings.TrimSpace(r.RuleType))
+			r.Policy = strings.ToUpper(strings.TrimSpace(r.Policy))
+			r.Identifier = NormalizeIdentifier(r.RuleType, r.Identifier)
+
+			position := fmt.Sprintf("%s#%d", name, i+1)
+			invalid := false
+			violations := ValidateRule(r.Identifier, r.RuleType, r.Policy, r.CELExpr, "policy")
+			violations = append(violations, ValidateMessageTemplates(&r.CustomMessage, &r.CustomURL)...)
+			for _, v := range violations {
+				errs = append(errs, models.ReconcileError{File: name, Index: i + 1, Field: v.Field, Message: v.Message})
+				invalid = true
+			}
+			if invalid {
+				continue
+			}
+
+			target := models.RuleTarget{Identifier: r.Identifier, RuleType: r.RuleType}
+			if previous, ok := seen[target]; ok {
+				errs = append(errs, models.ReconcileError{
+					File: name, Index: i + 1, Field: "identifier",
+					Message: "already declared at " + previous,
+				})
+				continue
+			}
+			seen[target] = position
+			declared = append(declared, declaredRule{gitOpsRule: r, File: name, Index: i + 1})
+		}
+	}
+	return declared, errs
+}
+
+// saveReconcile records a reconcile and its full report. Dry runs are only
+// timestamped.
+func saveReconcile(report *models.RuleReconcile) error {
+	report.FinishedAt = time.Now()
+	if report.DryRun {
+		return nil
+	}
+	encoded, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to encode reconcile report: %w", err)
+	}
+
+	result, err := database.DB.Exec(
+		`INSERT INTO rule_reconciles (trigger, status, fingerprint, added, updated, removed, unchanged,
+		                              error_count, report, started_at, finished_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		report.Trigger, report.Status, report.Fingerprint, len(report.Added), len(report.Updated),
+		len(report.Removed), report.Unchanged, len(report.Errors), string(encoded),
+		report.StartedAt, report.FinishedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record reconcile: %w", err)
+	}
+	report.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// ListRuleReconciles returns the most recent reconciles, newest first
+func ListRuleReconciles(limit int) ([]models.RuleReconcile, error) {
+	rows, err := database.DB.Query(
+		`SELECT id, report FROM rule_reconciles ORDER BY id DESC LIMIT ?`, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reconciles: %w", err)
+	}
+	defer rows.Close()
+
+	reconciles := []models.RuleReconcile{}
+	for rows.Next() {
+		var id int64
+		var encoded string
+		if err := rows.Scan(&id, &encoded); err != nil {
+			return nil, fmt.Errorf("failed to scan reconcile: %w", err)
+		}
+		var r models.RuleReconcile
+		if err := json.Unmarshal([]byte(encoded), &r); err != nil {
+			return nil, fmt.Errorf("failed to decode reconcile report: %w", err)
+		}
+		r.ID = id
+		reconciles = append(reconciles, r)
+	}
+	return reconciles, rows.Err()
+}
+
+// GetRuleReconcile returns one recorded reconcile with its full report
+func GetRuleReconcile(id int64) (*models.RuleReconcile, error) {
+	var encoded string
+	err := database.DB.QueryRow(`SELECT report FROM rule_reconciles WHERE id = ?`, id).Scan(&encoded)
+	if err == sql.ErrNoRows {
+		return nil, ErrReconcileNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch reconcile: %w", err)
+	}
+
+	var r models.RuleReconcile
+	if err := json.Unmarshal([]byte(encoded), &r); err != nil {
+		return nil, fmt.Errorf("failed to decode reconcile report: %w", err)
+	}
+	r.ID = id
+	return &r, nil
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"krampus/server/database"
+	"krampus/server/models"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeRulesFile replaces one file in a rules directory
+func writeRulesFile(t *testing.T, dir, name, contents string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReconcileRulesDir(t *testing.T) {
+	allowHash := "rules:\n  - identifier: " + testHash + "\n    rule_type: BINARY\n    policy: ALLOWLIST\n"
+	blockHash := "rules:\n  - identifier: " + testHash + "\n    rule_type: BINARY\n    policy: BLOCKLIST\n"
+	allowTeam := "[[rules]]\nidentifier = \"" + testTeamID + "\"\nrule_type = \"TEAMID\"\npolicy = \"ALLOWLIST\"\n"
+
+	type step struct {
+		files   map[string]string // Written before the reconcile; empty contents delete
+		dryRun  bool
+		status  models.ReconcileStatus
+		added   int
+		updated int
+		removed int
+	}
+
+	tests := []struct {
+		name     string
+		database bool // A database-managed BLOCKLIST rule for testHash exists
+		steps    []step
+		policy   string // Active policy for testHash afterwards, empty for none
+		source   models.RuleSource
+	}{
+		{
+			name:   "add",
+			steps:  []step{{files: map[string]string{"binaries.yaml": allowHash, "teams.toml": allowTeam}, status: models.ReconcileStatusApplied, added: 2}},
+			policy: string(models.PolicyAllowlist), source: models.RuleSourceGitOps,
+		},
+		{
+			name: "update and remove",
+			steps: []step{
+				{files: map[string]string{"binaries.yaml": allowHash, "teams.toml": allowTeam}, status: models.ReconcileStatusApplied, added: 2},
+				{files: map[string]string{"binaries.yaml": blockHash, "teams.toml": ""}, status: models.ReconcileStatusApplied, updated: 1, removed: 1},
+			},
+			policy: string(models.PolicyBlocklist), source: models.RuleSourceGitOps,
+		},
+		{
+			name:     "supersedes database rule",
+			database: true,
+			steps:    []step{{files: map[string]string{"binaries.yaml": allowHash}, status: models.ReconcileStatusApplied, added: 1}},
+			policy:   string(models.PolicyAllowlist), source: models.RuleSourceGitOps,
+		},
+		{
+			name: "invalid file leaves rules alone",
+			steps: []step{
+				{files: map[string]string{"binaries.yaml": allowHash}, status: models.ReconcileStatusApplied, added: 1},
+				{files: map[string]string{"binaries.yaml": blockHash, "broken.yaml": "rules:\n  - identifier: nope\n    rule_type: BINARY\n    policy: ALLOWLIST\n"}, status: models.ReconcileStatusFailed},
+			},
+			policy: string(models.PolicyAllowlist), source: models.RuleSourceGitOps,
+		},
+		{
+			name: "declared twice",
+			steps: []step{
+				{files: map[string]string{"a.yaml": allowHash, "b.yaml": blockHash}, status: models.ReconcileStatusFailed},
+			},
+		},
+		{
+			name:   "dry run",
+			steps:  []step{{files: map[string]string{"binaries.yaml": allowHash}, dryRun: true, status: models.ReconcileStatusApplied, added: 1}},
+			policy: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			dir := t.TempDir()
+			if tt.database {
+				createTestRule(t, testRule(0, string(models.RuleTypeBinary), testHash, models.PolicyBlocklist))
+			}
+
+			recorded := 0
+			for i, s := range tt.steps {
+				for name, contents := range s.files {
+					if contents == "" {
+						os.Remove(filepath.Join(dir, name))
+						continue
+					}
+					writeRulesFile(t, dir, name, contents)
+				}
+				report, err := ReconcileRulesDir(dir, models.ReconcileTriggerManual, s.dryRun)
+				if err != nil {
+					t.Fatalf("step %d: ReconcileRulesDir failed: %v", i, err)
+				}
+				if report.Status != string(s.status) {
+					t.Errorf("step %d: status = %s, want %s (errors %+v)", i, report.Status, s.status, report.Errors)
+				}
+				if len(report.Added) != s.added || len(report.Updated) != s.updated || len(report.Removed) != s.removed {
+					t.Errorf("step %d: added, updated, removed = %d, %d, %d, want %d, %d, %d", i,
+						len(report.Added), len(report.Updated), len(report.Removed), s.added, s.updated, s.removed)
+				}
+				if !s.dryRun {
+					recorded++
+				}
+			}
+
+			reconciles, err := ListRuleReconciles(10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(reconciles) != recorded {
+				t.Errorf("%d reconciles recorded, want %d", len(reconciles), recorded)
+			}
+
+			var policy, source string
+			err = database.DB.QueryRow(
+				`SELECT COALESCE(MAX(policy), ''), COALESCE(MAX(source), '') FROM rules
+				 WHERE identifier = ? AND rule_type = ? AND superseded_at IS NULL`,
+				testHash, string(models.RuleTypeBinary)).Scan(&policy, &source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if policy != tt.policy {
+				t.Errorf("active policy = %q, want %q", policy, tt.policy)
+			}
+			if tt.source != "" && source != string(tt.source) {
+				t.Errorf("active rule source = %q, want %q", source, tt.source)
+			}
+		})
+	}
+}
+
+func TestWatchRulesDirWithoutPolling(t *testing.T) {
+	openTestDB(t)
+	dir := t.TempDir()
+	writeRulesFile(t, dir, "binaries.yaml", "rules:\n  - identifier: "+testHash+"\n    rule_type: BINARY\n    policy: ALLOWLIST\n")
+
+	// A zero interval reconciles once instead of panicking in the ticker
+	WatchRulesDir(dir, 0)
+
+	reconciles, err := ListRuleReconciles(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reconciles) != 1 || reconciles[0].Trigger != string(models.ReconcileTriggerStartup) {
+		t.Fatalf("reconciles = %+v, want one startup reconcile", reconciles)
+	}
+}
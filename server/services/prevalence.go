@@ -0,0 +1,506 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"krampus/server/database"
+	"krampus/server/models"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// maxRelatedHashes caps how many sibling binaries are listed for a signing ID
+const maxRelatedHashes = 50
+
+// EnrichProposal fills in fleet prevalence, the signing identity, related
+// binary hashes, the current fleet-wide decision and, for binaries, the
+// cached reputation of a proposal's target
+func EnrichProposal(p *models.ProposalWithCreator) error {
+	target := models.RuleTarget{Identifier: p.Identifier, RuleType: p.RuleType}
+
+	prevalence, err := TargetPrevalence(target)
+	if err != nil {
+		return err
+	}
+	p.Prevalence = prevalence
+
+	p.Signing, err = targetSigningIdentity(target)
+	if err != nil {
+		return err
+	}
+
+	p.RelatedHashes = []string{}
+	if p.Signing != nil && p.Signing.SigningID != nil {
+		exclude := ""
+		if p.RuleType == string(models.RuleTypeBinary) {
+			exclude = p.Identifier
+		}
+		signingID := SigningIdentifier(*p.Signing.SigningID, deref(p.Signing.TeamID))
+		p.RelatedHashes, err = hashesForSigningID(signingID, exclude)
+		if err != nil {
+			return err
+		}
+	}
+
+	p.Evaluation, err = EvaluateBinary(proposalAttributes(target, p.Signing), "")
+	if err != nil {
+		return err
+	}
+
+	if p.RuleType == string(models.RuleTypeBinary) {
+		p.Reputation, err = cachedReputation(p.Identifier)
+	}
+	return err
+}
+
+// enrichBatch caps how many proposals one batched query covers, keeping its
+// compound SELECT within SQLite's limits
+const enrichBatch = 100
+
+// EnrichProposals enriches a list of proposals like EnrichProposal, reading
+// events, rules and reputations for a batch of proposals in a few grouped
+// queries instead of several per proposal
+func EnrichProposals(proposals []models.ProposalWithCreator) error {
+	for start := 0; start < len(proposals); start += enrichBatch {
+		end := start + enrichBatch
+		if end > len(proposals) {
+			end = len(proposals)
+		}
+		if err := enrichProposalBatch(proposals[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func enrichProposalBatch(proposals []models.ProposalWithCreator) error {
+	targets := make([]models.RuleTarget, len(proposals))
+	for i, p := range proposals {
+		targets[i] = models.RuleTarget{Identifier: p.Identifier, RuleType: p.RuleType}
+	}
+
+	prevalences, err := targetsPrevalence(targets)
+	if err != nil {
+		return err
+	}
+	signings, err := targetsSigningIdentity(targets)
+	if err != nil {
+		return err
+	}
+
+	// Related hashes are looked up for the proposals with a signing ID
+	var signingTargets []models.RuleTarget
+	var excludes []string
+	var signingProposals []int
+	for i, p := range proposals {
+		signing := signings[i]
+		if signing == nil || signing.SigningID == nil {
+			continue
+		}
+		exclude := ""
+		if p.RuleType == string(models.RuleTypeBinary) {
+			exclude = p.Identifier
+		}
+		signingTargets = append(signingTargets, models.RuleTarget{
+			Identifier: SigningIdentifier(*signing.SigningID, deref(signing.TeamID)),
+			RuleType:   string(models.RuleTypeSigningID),
+		})
+		excludes = append(excludes, exclude)
+		signingProposals = append(signingProposals, i)
+	}
+	related, err := targetsRelatedHashes(signingTargets, excludes)
+	if err != nil {
+		return err
+	}
+
+	// One query fetches the rules deciding every proposal's target
+	attrs := make([]models.BinaryAttributes, len(proposals))
+	seen := map[models.RuleTarget]bool{}
+	var ruleTargets []models.RuleTarget
+	for i := range proposals {
+		attrs[i] = proposalAttributes(targets[i], signings[i])
+		for _, t := range binaryTargets(attrs[i]) {
+			if !seen[t] {
+				seen[t] = true
+				ruleTargets = append(ruleTargets, t)
+			}
+		}
+	}
+	var rules []models.Rule
+	if len(ruleTargets) > 0 {
+		rules, err = rulesForTargets(ruleTargets)
+		if err != nil {
+			return err
+		}
+	}
+
+	var hashes []string
+	for _, p := range proposals {
+		if p.RuleType == string(models.RuleTypeBinary) {
+			hashes = append(hashes, p.Identifier)
+		}
+	}
+	reputations, err := CachedReputations(hashes)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i := range proposals {
+		p := &proposals[i]
+		p.Prevalence = prevalences[i]
+		p.Signing = signings[i]
+		p.RelatedHashes = []string{}
+		p.Evaluation = fleetEvaluation(attrs[i], rules, now)
+		if p.RuleType == string(models.RuleTypeBinary) {
+			r, ok := reputations[strings.ToLower(p.Identifier)]
+			if !ok || !reputationFresh(r) {
+				QueueReputationLookup(p.Identifier)
+			}
+			if ok {
+				p.Reputation = &r
+			}
+		}
+	}
+	for j, i := range signingProposals {
+		if hashes := related[j]; hashes != nil {
+			proposals[i].RelatedHashes = hashes
+		}
+	}
+	return nil
+}
+
+// proposalAttributes describes the binary a proposal targets, filling in
+// what the last signed execution reported
+func proposalAttributes(target models.RuleTarget, signing *models.SigningIdentity) models.BinaryAttributes {
+	var attrs models.BinaryAttributes
+	if signing != nil {
+		attrs.TeamID = deref(signing.TeamID)
+		attrs.SigningID = SigningIdentifier(deref(signing.SigningID), attrs.TeamID)
+		attrs.CertSHA256 = deref(signing.CertSHA256)
+	}
+	switch models.RuleType(target.RuleType) {
+	case models.RuleTypeBinary:
+		attrs.SHA256 = target.Identifier
+	case models.RuleTypeCDHash:
+		attrs.CDHash = target.Identifier
+	case models.RuleTypeSigningID:
+		attrs.SigningID = target.Identifier
+	case models.RuleTypeCertificate:
+		attrs.CertSHA256 = target.Identifier
+	case models.RuleTypeTeamID:
+		attrs.TeamID = target.Identifier
+	}
+	normalizeAttributes(&attrs)
+	return attrs
+}
+
+// TargetPrevalence counts the executions, machines and decisions recorded
+// for every binary a rule target matches
+func TargetPrevalence(target models.RuleTarget) (models.Prevalence, error) {
+	prevalence := models.Prevalence{Decisions: map[string]int{}}
+
+	clause, args := eventClause(target)
+	if clause == "" {
+		return prevalence, nil
+	}
+
+	err := database.DB.QueryRow(
+		`SELECT COUNT(*), COUNT(DISTINCT machine_id) FROM events WHERE `+clause, args...,
+	).Scan(&prevalence.ExecutionCount, &prevalence.MachineCount)
+	if err != nil {
+		return prevalence, fmt.Errorf("failed to count executions: %w", err)
+	}
+	if prevalence.ExecutionCount == 0 {
+		return prevalence, nil
+	}
+
+	rows, err := database.DB.Query(
+		`SELECT COALESCE(decision, ''), COUNT(*) FROM events WHERE `+clause+` GROUP BY decision`, args...,
+	)
+	if err != nil {
+		return prevalence, fmt.Errorf("failed to count decisions: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var decision string
+		var count int
+		if err := rows.Scan(&decision, &count); err != nil {
+			return prevalence, fmt.Errorf("failed to scan decision count: %w", err)
+		}
+		prevalence.Decisions[decision] = count
+	}
+	if err := rows.Err(); err != nil {
+		return prevalence, fmt.Errorf("failed to count decisions: %w", err)
+	}
+
+	// Scanning the column directly (not through MIN/MAX) keeps its DATETIME type
+	err = database.DB.QueryRow(
+		`SELECT execution_time FROM events WHERE `+clause+` ORDER BY execution_time ASC LIMIT 1`, args...,
+	).Scan(&prevalence.FirstSeen)
+	if err != nil {
+		return prevalence, fmt.Errorf("failed to fetch first execution: %w", err)
+	}
+	err = database.DB.QueryRow(
+		`SELECT execution_time FROM events WHERE `+clause+` ORDER BY execution_time DESC LIMIT 1`, args...,
+	).Scan(&prevalence.LastSeen)
+	if err != nil {
+		return prevalence, fmt.Errorf("failed to fetch last execution: %w", err)
+	}
+
+	return prevalence, nil
+}
+
+// matchedEvents builds a compound SELECT of columns over the events each
+// target matches, tagging every row with the target's index. A condition
+// further filters events, taking conditionArgs(i) for target i.
+func matchedEvents(targets []models.RuleTarget, columns, condition string, conditionArgs func(i int) []interface{}) (string, []interface{}) {
+	var selects []string
+	var args []interface{}
+	for i, t := range targets {
+		clause, clauseArgs := eventClause(t)
+		if clause == "" {
+			continue
+		}
+		if condition != "" {
+			clause += " AND " + condition
+		}
+		selects = append(selects, fmt.Sprintf("SELECT %d AS target, %s FROM events WHERE %s", i, columns, clause))
+		args = append(args, clauseArgs...)
+		if conditionArgs != nil {
+			args = append(args, conditionArgs(i)...)
+		}
+	}
+	return strings.Join(selects, " UNION ALL "), args
+}
+
+// targetsPrevalence computes TargetPrevalence for several targets in one
+// grouped query: a row per target and decision, plus a total row per target
+// with a NULL decision
+func targetsPrevalence(targets []models.RuleTarget) ([]models.Prevalence, error) {
+	prevalences := make([]models.Prevalence, len(targets))
+	for i := range prevalences {
+		prevalences[i].Decisions = map[string]int{}
+	}
+
+	matched, args := matchedEvents(targets, "machine_id, COALESCE(decision, '') AS decision, execution_time", "", nil)
+	if matched == "" {
+		return prevalences, nil
+	}
+	rows, err := database.DB.Query(
+		`WITH matched AS (`+matched+`)
+		 SELECT target, decision, COUNT(*), COUNT(DISTINCT machine_id), MIN(execution_time), MAX(execution_time)
+		 FROM matched GROUP BY target, decision
+		 UNION ALL
+		 SELECT target, NULL, COUNT(*), COUNT(DISTINCT machine_id), MIN(execution_time), MAX(execution_time)
+		 FROM matched GROUP BY target`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count executions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var target, executions, machines int
+		var decision, first, last sql.NullString
+		if err := rows.Scan(&target, &decision, &executions, &machines, &first, &last); err != nil {
+			return nil, fmt.Errorf("failed to scan execution count: %w", err)
+		}
+		p := &prevalences[target]
+		if decision.Valid {
+			p.Decisions[decision.String] = executions
+			continue
+		}
+		p.ExecutionCount = executions
+		p.MachineCount = machines
+		p.FirstSeen = parseEventTime(first)
+		p.LastSeen = parseEventTime(last)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to count executions: %w", err)
+	}
+	return prevalences, nil
+}
+
+// parseEventTime reads an execution time an aggregate returned as text;
+// only plain column reads keep the DATETIME type
+func parseEventTime(value sql.NullString) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	s := strings.TrimSuffix(value.String, "Z")
+	for _, layout := range sqlite3.SQLiteTimestampFormats {
+		if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
+
+// targetsSigningIdentity returns targetSigningIdentity for several targets
+// from one query
+func targetsSigningIdentity(targets []models.RuleTarget) ([]*models.SigningIdentity, error) {
+	identities := make([]*models.SigningIdentity, len(targets))
+
+	matched, args := matchedEvents(targets, "team_id, signing_id, cert_cn, cert_sha256, execution_time",
+		"(COALESCE(signing_id, '') != '' OR COALESCE(cert_sha256, '') != '')", nil)
+	if matched == "" {
+		return identities, nil
+	}
+	rows, err := database.DB.Query(
+		`WITH matched AS (`+matched+`)
+		 SELECT target, team_id, signing_id, cert_cn, cert_sha256 FROM (
+		   SELECT *, ROW_NUMBER() OVER (PARTITION BY target ORDER BY execution_time DESC) AS n FROM matched
+		 ) WHERE n = 1`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signing identities: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var target int
+		var identity models.SigningIdentity
+		if err := rows.Scan(&target, &identity.TeamID, &identity.SigningID, &identity.CertCN, &identity.CertSHA256); err != nil {
+			return nil, fmt.Errorf("failed to scan signing identity: %w", err)
+		}
+		clearEmptySigningFields(&identity)
+		identities[target] = &identity
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch signing identities: %w", err)
+	}
+	return identities, nil
+}
+
+// targetsRelatedHashes returns hashesForSigningID for several signing
+// identifier targets from one query, excluding excludes[i] from target i
+func targetsRelatedHashes(targets []models.RuleTarget, excludes []string) ([][]string, error) {
+	related := make([][]string, len(targets))
+
+	matched, args := matchedEvents(targets, "file_hash, execution_time", "file_hash != '' AND file_hash != ?",
+		func(i int) []interface{} { return []interface{}{excludes[i]} })
+	if matched == "" {
+		return related, nil
+	}
+	rows, err := database.DB.Query(
+		`WITH matched AS (`+matched+`)
+		 SELECT target, file_hash FROM (
+		   SELECT target, file_hash, ROW_NUMBER() OVER (PARTITION BY target ORDER BY MAX(execution_time) DESC) AS n
+		   FROM matched GROUP BY target, file_hash
+		 ) WHERE n <= ? ORDER BY target, n`,
+		append(args, maxRelatedHashes)...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query related hashes: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var target int
+		var hash string
+		if err := rows.Scan(&target, &hash); err != nil {
+			return nil, fmt.Errorf("failed to scan related hash: %w", err)
+		}
+		related[target] = append(related[target], hash)
+	}
+	return related, rows.Err()
+}
+
+// targetSigningIdentity returns the signature last reported for a target, or
+// nil when no signed execution has been seen
+func targetSigningIdentity(target models.RuleTarget) (*models.SigningIdentity, error) {
+	clause, args := eventClause(target)
+	if clause == "" {
+		return nil, nil
+	}
+
+	var identity models.SigningIdentity
+	err := database.DB.QueryRow(
+		`SELECT team_id, signing_id, cert_cn, cert_sha256 FROM events
+		 WHERE `+clause+` AND (COALESCE(signing_id, '') != '' OR COALESCE(cert_sha256, '') != '')
+		 ORDER BY execution_time DESC LIMIT 1`,
+		args...,
+	).Scan(&identity.TeamID, &identity.SigningID, &identity.CertCN, &identity.CertSHA256)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signing identity: %w", err)
+	}
+
+	clearEmptySigningFields(&identity)
+	return &identity, nil
+}
+
+// clearEmptySigningFields turns the empty strings events store for missing
+// signature fields into nil
+func clearEmptySigningFields(identity *models.SigningIdentity) {
+	for _, field := range []**string{&identity.TeamID, &identity.SigningID, &identity.CertCN, &identity.CertSHA256} {
+		if *field != nil && **field == "" {
+			*field = nil
+		}
+	}
+}
+
+// hashesForSigningID lists distinct binary hashes reported under a signing
+// identifier, most recently executed first
+func hashesForSigningID(signingID, exclude string) ([]string, error) {
+	clause, args := eventClause(models.RuleTarget{Identifier: signingID, RuleType: string(models.RuleTypeSigningID)})
+	args = append(args, exclude, maxRelatedHashes)
+
+	rows, err := database.DB.Query(
+		`SELECT file_hash FROM events
+		 WHERE `+clause+` AND file_hash != '' AND file_hash != ?
+		 GROUP BY file_hash
+		 ORDER BY MAX(execution_time) DESC
+		 LIMIT ?`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query related hashes: %w", err)
+	}
+	defer rows.Close()
+
+	hashes := []string{}
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, fmt.Errorf("failed to scan related hash: %w", err)
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
+
+// eventClause builds a WHERE fragment matching the events a rule target
+// applies to. Santa may report signing IDs with or without the team prefix,
+// so both forms are matched.
+func eventClause(target models.RuleTarget) (string, []interface{}) {
+	switch models.RuleType(target.RuleType) {
+	case models.RuleTypeBinary:
+		return "file_hash = ?", []interface{}{target.Identifier}
+	case models.RuleTypeCertificate:
+		return "cert_sha256 = ?", []interface{}{target.Identifier}
+	case models.RuleTypeTeamID:
+		return "team_id = ?", []interface{}{target.Identifier}
+	case models.RuleTypeCDHash:
+		return "cdhash = ?", []interface{}{target.Identifier}
+	case models.RuleTypeSigningID:
+		prefix, bare, found := strings.Cut(target.Identifier, ":")
+		if !found {
+			return "signing_id = ?", []interface{}{target.Identifier}
+		}
+		if prefix == "platform" {
+			return "(signing_id = ? OR (signing_id = ? AND COALESCE(team_id, '') = ''))",
+				[]interface{}{target.Identifier, bare}
+		}
+		return "(signing_id = ? OR (signing_id = ? AND team_id = ?))",
+			[]interface{}{target.Identifier, bare, prefix}
+	}
+	return "", nil
+}
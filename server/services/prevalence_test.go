@@ -0,0 +1,141 @@
+package services
+
+import (
+	"krampus/server/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTargetPrevalence(t *testing.T) {
+	const (
+		otherHash    = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+		platformHash = "cccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccc"
+		certHash     = "dddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddd"
+	)
+	first := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	last := first.Add(48 * time.Hour)
+
+	openTestDB(t)
+	mustExec(t, `INSERT INTO machines (machine_id) VALUES ('m1'), ('m2')`)
+	// Two builds of the same tool, one reported with a bare signing ID, and
+	// an unrelated platform binary
+	for _, e := range []struct {
+		machineID, hash, decision, signingID, teamID string
+		at                                           time.Time
+	}{
+		{"m1", testHash, "BLOCK_UNKNOWN", testSigning, testTeamID, first},
+		{"m1", testHash, "BLOCK_UNKNOWN", testSigning, testTeamID, first.Add(time.Hour)},
+		{"m2", testHash, "ALLOW_UNKNOWN", "com.example.tool", testTeamID, first.Add(24 * time.Hour)},
+		{"m2", otherHash, "ALLOW_UNKNOWN", testSigning, testTeamID, last},
+		{"m1", platformHash, "ALLOW_BINARY", "com.apple.ls", "", first},
+	} {
+		mustExec(t, `INSERT INTO events (machine_id, file_hash, decision, signing_id, team_id, cert_sha256, cert_cn, execution_time)
+			VALUES (?, ?, ?, ?, ?, ?, 'Developer ID Application: Example', ?)`,
+			e.machineID, e.hash, e.decision, e.signingID, e.teamID, certHash, e.at)
+	}
+
+	tests := []struct {
+		name       string
+		target     models.RuleTarget
+		machines   int
+		executions int
+		decisions  map[string]int
+		firstSeen  time.Time
+		lastSeen   time.Time
+		related    []string
+	}{
+		{
+			name:       "binary",
+			target:     models.RuleTarget{Identifier: testHash, RuleType: string(models.RuleTypeBinary)},
+			machines:   2,
+			executions: 3,
+			decisions:  map[string]int{"BLOCK_UNKNOWN": 2, "ALLOW_UNKNOWN": 1},
+			firstSeen:  first,
+			lastSeen:   first.Add(24 * time.Hour),
+			related:    []string{otherHash},
+		},
+		{
+			name:       "signing ID, with or without the team prefix",
+			target:     models.RuleTarget{Identifier: testSigning, RuleType: string(models.RuleTypeSigningID)},
+			machines:   2,
+			executions: 4,
+			decisions:  map[string]int{"BLOCK_UNKNOWN": 2, "ALLOW_UNKNOWN": 2},
+			firstSeen:  first,
+			lastSeen:   last,
+			related:    []string{otherHash, testHash},
+		},
+		{
+			name:       "platform signing ID",
+			target:     models.RuleTarget{Identifier: "platform:com.apple.ls", RuleType: string(models.RuleTypeSigningID)},
+			machines:   1,
+			executions: 1,
+			decisions:  map[string]int{"ALLOW_BINARY": 1},
+			firstSeen:  first,
+			lastSeen:   first,
+			related:    []string{platformHash},
+		},
+		{
+			name:       "team ID",
+			target:     models.RuleTarget{Identifier: testTeamID, RuleType: string(models.RuleTypeTeamID)},
+			machines:   2,
+			executions: 4,
+			decisions:  map[string]int{"BLOCK_UNKNOWN": 2, "ALLOW_UNKNOWN": 2},
+			firstSeen:  first,
+			lastSeen:   last,
+			related:    []string{otherHash, testHash},
+		},
+		{
+			name:      "never seen",
+			target:    models.RuleTarget{Identifier: "ZZZZZ99999", RuleType: string(models.RuleTypeTeamID)},
+			decisions: map[string]int{},
+			related:   []string{},
+		},
+	}
+
+	proposals := make([]models.ProposalWithCreator, len(tests))
+	for i, tt := range tests {
+		proposals[i].Identifier, proposals[i].RuleType = tt.target.Identifier, tt.target.RuleType
+	}
+	if err := EnrichProposals(proposals); err != nil {
+		t.Fatalf("EnrichProposals: %v", err)
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			single := models.ProposalWithCreator{Proposal: models.Proposal{Identifier: tt.target.Identifier, RuleType: tt.target.RuleType}}
+			if err := EnrichProposal(&single); err != nil {
+				t.Fatalf("EnrichProposal: %v", err)
+			}
+
+			// Proposals enriched one by one and in a batch agree
+			for _, p := range []models.ProposalWithCreator{single, proposals[i]} {
+				got := p.Prevalence
+				if got.MachineCount != tt.machines || got.ExecutionCount != tt.executions {
+					t.Errorf("%d machines and %d executions, want %d and %d",
+						got.MachineCount, got.ExecutionCount, tt.machines, tt.executions)
+				}
+				if !reflect.DeepEqual(got.Decisions, tt.decisions) {
+					t.Errorf("decisions = %v, want %v", got.Decisions, tt.decisions)
+				}
+				if !sameTime(got.FirstSeen, tt.firstSeen) || !sameTime(got.LastSeen, tt.lastSeen) {
+					t.Errorf("seen %v to %v, want %v to %v", got.FirstSeen, got.LastSeen, tt.firstSeen, tt.lastSeen)
+				}
+				if !reflect.DeepEqual(p.RelatedHashes, tt.related) {
+					t.Errorf("related hashes = %v, want %v", p.RelatedHashes, tt.related)
+				}
+				if (p.Signing == nil) != (tt.executions == 0) {
+					t.Errorf("signing = %+v, want one when seen", p.Signing)
+				}
+			}
+		})
+	}
+}
+
+// sameTime compares an optional time with one that is zero when absent
+func sameTime(got *time.Time, want time.Time) bool {
+	if got == nil {
+		return want.IsZero()
+	}
+	return got.Equal(want)
+}
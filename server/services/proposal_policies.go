@@ -0,0 +1,486 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"krampus/server/database"
+	"krampus/server/models"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/cel-go/cel"
+)
+
+var (
+	ErrProposalPolicyNotFound  = errors.New("proposal policy not found")
+	ErrProposalPolicyNameTaken = errors.New("a proposal policy with this name already exists")
+)
+
+// proposalPolicyEnv declares the variables of models.ProposalPolicyContext, so
+// policy expressions are type checked when they are saved
+var proposalPolicyEnv, _ = cel.NewEnv(
+	cel.Variable("identifier", cel.StringType),
+	cel.Variable("rule_type", cel.StringType),
+	cel.Variable("policy", cel.StringType),
+	cel.Variable("source", cel.StringType),
+	cel.Variable("team_id", cel.StringType),
+	cel.Variable("signing_id", cel.StringType),
+	cel.Variable("cert_sha256", cel.StringType),
+	cel.Variable("machine_count", cel.IntType),
+	cel.Variable("execution_count", cel.IntType),
+	cel.Variable("block_count", cel.IntType),
+	cel.Variable("requester", cel.StringType),
+	cel.Variable("requester_email", cel.StringType),
+	cel.Variable("requester_role", cel.StringType),
+	cel.Variable("detections", cel.IntType),
+	cel.Variable("known_good", cel.BoolType),
+)
+
+const proposalPolicyColumns = `p.id, p.name, p.description, p.expression, p.action, p.priority, p.enabled,
+	(SELECT COUNT(*) FROM proposals pr WHERE pr.auto_policy_id = p.id), p.created_by, p.created_at, p.updated_at`
+
+func scanProposalPolicy(row interface{ Scan(...interface{}) error }, p *models.ProposalPolicy) error {
+	return row.Scan(&p.ID, &p.Name, &p.Description, &p.Expression, &p.Action, &p.Priority, &p.Enabled,
+		&p.MatchCount, &p.CreatedBy, &p.CreatedAt, &p.UpdatedAt)
+}
+
+// ProposalPolicyInput describes a proposal policy to create
+type ProposalPolicyInput struct {
+	Name        string
+	Description *string
+	Expression  string
+	Action      string
+	Priority    int
+	Enabled     *bool // Defaults to true
+}
+
+// ProposalPolicyUpdate lists the policy fields an update may change; nil
+// fields are left alone
+type ProposalPolicyUpdate struct {
+	Name        *string
+	Description *string
+	Expression  *string
+	Action      *string
+	Priority    *int
+	Enabled     *bool
+}
+
+// ValidateProposalPolicy checks a policy's name, action and expression,
+// which must type check to a bool
+func ValidateProposalPolicy(name, expression, action string) ValidationErrors {
+	var errs ValidationErrors
+	if strings.TrimSpace(name) == "" {
+		errs = append(errs, ValidationError{Field: "name", Message: "must not be empty"})
+	}
+	switch models.AutoDecision(action) {
+	case models.AutoDecisionApprove, models.AutoDecisionReject, models.AutoDecisionEscalate:
+	default:
+		errs = append(errs, ValidationError{Field: "action", Message: "must be APPROVE, REJECT or ESCALATE"})
+	}
+	if _, err := compileProposalPolicy(expression); err != nil {
+		errs = append(errs, *err)
+	}
+	return errs
+}
+
+func compileProposalPolicy(expression string) (cel.Program, *ValidationError) {
+	if strings.TrimSpace(expression) == "" {
+		return nil, &ValidationError{Field: "expression", Message: "must not be empty"}
+	}
+	ast, issues := proposalPolicyEnv.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		message := issues.Err().Error()
+		if len(issues.Errors()) > 0 {
+			message = issues.Errors()[0].Message
+		}
+		return nil, &ValidationError{Field: "expression", Message: "invalid CEL expression: " + message}
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, &ValidationError{Field: "expression", Message: "must evaluate to a bool"}
+	}
+	program, err := proposalPolicyEnv.Program(ast)
+	if err != nil {
+		return nil, &ValidationError{Field: "expression", Message: "invalid CEL expression: " + err.Error()}
+	}
+	return program, nil
+}
+
+// ListProposalPolicies returns every proposal policy in evaluation order
+func ListProposalPolicies() ([]models.ProposalPolicy, error) {
+	rows, err := database.DB.Query(
+		`SELECT ` + proposalPolicyColumns + ` FROM proposal_policies p ORDER BY p.priority, p.id`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query proposal policies: %w", err)
+	}
+	defer rows.Close()
+
+	policies := []models.ProposalPolicy{}
+	for rows.Next() {
+		var p models.ProposalPolicy
+		if err := scanProposalPolicy(rows, &p); err != nil {
+			return nil, fmt.Errorf("failed to scan proposal policy: %w", err)
+		}
+		policies = append(policies, p)
+	}
+	return policies, rows.Err()
+}
+
+// GetProposalPolicy returns a proposal policy by ID
+func GetProposalPolicy(policyID int64) (*models.ProposalPolicy, error) {
+	var p models.ProposalPolicy
+	err := scanProposalPolicy(database.DB.QueryRow(
+		`SELECT `+proposalPolicyColumns+` FROM proposal_policies p WHERE p.id = ?`, policyID,
+	), &p)
+	if err == sql.ErrNoRows {
+		return nil, ErrProposalPolicyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch proposal policy: %w", err)
+	}
+	return &p, nil
+}
+
+// CreateProposalPolicy adds a proposal policy
+func CreateProposalPolicy(input ProposalPolicyInput, createdBy int64) (*models.ProposalPolicy, error) {
+	enabled := input.Enabled == nil || *input.Enabled
+	result, err := database.DB.Exec(
+		`INSERT INTO proposal_policies (name, description, expression, action, priority, enabled, created_by)
+		 SELECT ?, ?, ?, ?, ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM proposal_policies WHERE name = ?)`,
+		input.Name, input.Description, input.Expression, input.Action, input.Priority, enabled, createdBy, input.Name,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create proposal policy: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, ErrProposalPolicyNameTaken
+	}
+	id, _ := result.LastInsertId()
+	return GetProposalPolicy(id)
+}
+
+// UpdateProposalPolicy changes a proposal policy. Proposals it already
+// decided keep their decision.
+func UpdateProposalPolicy(policyID int64, update ProposalPolicyUpdate) (*models.ProposalPolicy, error) {
+	p, err := GetProposalPolicy(policyID)
+	if err != nil {
+		return nil, err
+	}
+	if update.Name != nil {
+		p.Name = strings.TrimSpace(*update.Name)
+	}
+	if update.Description != nil {
+		p.Description = nullIfEmpty(strings.TrimSpace(*update.Description))
+	}
+	if update.Expression != nil {
+		p.Expression = *update.Expression
+	}
+	if update.Action != nil {
+		p.Action = strings.ToUpper(*update.Action)
+	}
+	if update.Priority != nil {
+		p.Priority = *update.Priority
+	}
+	if update.Enabled != nil {
+		p.Enabled = *update.Enabled
+	}
+	if errs := ValidateProposalPolicy(p.Name, p.Expression, p.Action); len(errs) > 0 {
+		return nil, errs
+	}
+
+	var taken int
+	if err := database.DB.QueryRow(
+		`SELECT COUNT(*) FROM proposal_policies WHERE name = ? AND id != ?`, p.Name, policyID,
+	).Scan(&taken); err != nil {
+		return nil, fmt.Errorf("failed to check proposal policy name: %w", err)
+	}
+	if taken > 0 {
+		return nil, ErrProposalPolicyNameTaken
+	}
+
+	_, err = database.DB.Exec(
+		`UPDATE proposal_policies
+		 SET name = ?, description = ?, expression = ?, action = ?, priority = ?, enabled = ?, updated_at = ?
+		 WHERE id = ?`,
+		p.Name, p.Description, p.Expression, p.Action, p.Priority, p.Enabled, time.Now(), policyID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update proposal policy: %w", err)
+	}
+	return GetProposalPolicy(policyID)
+}
+
+// DeleteProposalPolicy deletes a proposal policy. Proposals it decided keep
+// the policy's name.
+func DeleteProposalPolicy(policyID int64) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM proposal_policies WHERE id = ?`, policyID)
+	if err != nil {
+		return fmt.Errorf("failed to delete proposal policy: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrProposalPolicyNotFound
+	}
+	if _, err := tx.Exec(`UPDATE proposals SET auto_policy_id = NULL WHERE auto_policy_id = ?`, policyID); err != nil {
+		return fmt.Errorf("failed to detach proposals from policy: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// ProposalPolicyContextFor gathers what is known about a target for policy
+// evaluation: the signing identity its identifier proves, its prevalence and
+// cached reputation. Signing details reported in events are left out, as
+// any client can upload events claiming them for a hash. The caller fills
+// in the requester.
+func ProposalPolicyContextFor(target models.RuleTarget, policy, source string) (models.ProposalPolicyContext, error) {
+	input := models.ProposalPolicyContext{
+		Identifier: target.Identifier,
+		RuleType:   target.RuleType,
+		Policy:     policy,
+		Source:     source,
+		Detections: -1,
+	}
+
+	switch models.RuleType(target.RuleType) {
+	case models.RuleTypeTeamID:
+		input.TeamID = target.Identifier
+	case models.RuleTypeSigningID:
+		input.SigningID = target.Identifier
+		if team, _, _ := strings.Cut(target.Identifier, ":"); team != "platform" {
+			input.TeamID = team
+		}
+	case models.RuleTypeCertificate:
+		input.CertSHA256 = target.Identifier
+	}
+
+	prevalence, err := TargetPrevalence(target)
+	if err != nil {
+		return input, err
+	}
+	input.MachineCount = prevalence.MachineCount
+	input.ExecutionCount = prevalence.ExecutionCount
+	for decision, count := range prevalence.Decisions {
+		if IsBlockDecision(decision) {
+			input.BlockCount += count
+		}
+	}
+
+	if target.RuleType == string(models.RuleTypeBinary) {
+		reputation, err := cachedReputation(target.Identifier)
+		if err != nil {
+			return input, err
+		}
+		if reputation != nil && reputation.Found {
+			input.Detections = reputation.Detections
+			input.KnownGood = reputation.KnownGood
+		}
+	}
+	return input, nil
+}
+
+// proposalPolicyInput gathers the policy variables for a stored proposal.
+// Blocked-event proposals name the first executing user that hit the block
+// as requester.
+func proposalPolicyContext(proposalID int64) (models.ProposalPolicyContext, error) {
+	var p models.Proposal
+	var requester, email, role sql.NullString
+	err := database.DB.QueryRow(
+		`SELECT p.identifier, p.rule_type, p.proposed_policy, p.source, u.username, u.email, u.role
+		 FROM proposals p LEFT JOIN users u ON u.id = p.created_by
+		 WHERE p.id = ?`,
+		proposalID,
+	).Scan(&p.Identifier, &p.RuleType, &p.ProposedPolicy, &p.Source, &requester, &email, &role)
+	if err != nil {
+		return models.ProposalPolicyContext{}, fmt.Errorf("failed to fetch proposal: %w", err)
+	}
+
+	input, err := ProposalPolicyContextFor(models.RuleTarget{Identifier: p.Identifier, RuleType: p.RuleType}, p.ProposedPolicy, p.Source)
+	if err != nil {
+		return input, err
+	}
+	input.Requester, input.RequesterEmail, input.RequesterRole = requester.String, email.String, role.String
+
+	if !requester.Valid {
+		err := database.DB.QueryRow(
+			`SELECT executing_user FROM proposal_requesters WHERE proposal_id = ? ORDER BY id LIMIT 1`, proposalID,
+		).Scan(&input.Requester)
+		if err != nil && err != sql.ErrNoRows {
+			return input, fmt.Errorf("failed to fetch proposal requester: %w", err)
+		}
+	}
+	return input, nil
+}
+
+// EvaluateProposalPolicies runs the enabled policies against the input in
+// priority order and reports the first that matches. Policies that fail to
+// compile or evaluate are skipped and listed in the result's errors.
+func EvaluateProposalPolicies(input models.ProposalPolicyContext) (*models.ProposalPolicyResult, error) {
+	result := &models.ProposalPolicyResult{Context: input}
+
+	policies, err := ListProposalPolicies()
+	if err != nil {
+		return nil, err
+	}
+	activation := map[string]interface{}{
+		"identifier":      input.Identifier,
+		"rule_type":       input.RuleType,
+		"policy":          input.Policy,
+		"source":          input.Source,
+		"team_id":         input.TeamID,
+		"signing_id":      input.SigningID,
+		"cert_sha256":     input.CertSHA256,
+		"machine_count":   input.MachineCount,
+		"execution_count": input.ExecutionCount,
+		"block_count":     input.BlockCount,
+		"requester":       input.Requester,
+		"requester_email": input.RequesterEmail,
+		"requester_role":  input.RequesterRole,
+		"detections":      input.Detections,
+		"known_good":      input.KnownGood,
+	}
+
+	for _, p := range policies {
+		if !p.Enabled {
+			continue
+		}
+		program, verr := compileProposalPolicy(p.Expression)
+		if verr != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("policy %s: %s", p.Name, verr.Message))
+			continue
+		}
+		out, _, err := program.Eval(activation)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("policy %s: %v", p.Name, err))
+			continue
+		}
+		if matched, ok := out.Value().(bool); ok && matched {
+			id, name, action := p.ID, p.Name, p.Action
+			result.PolicyID, result.PolicyName, result.Decision = &id, &name, &action
+			break
+		}
+	}
+	return result, nil
+}
+
+// eventReportedVariables are the policy variables computed from uploaded
+// events, which clients send unauthenticated
+var eventReportedVariables = []string{"machine_count", "execution_count", "block_count"}
+
+// proposalPolicyVariables lists the variables a policy expression reads
+func proposalPolicyVariables(expression string) map[string]bool {
+	variables := map[string]bool{}
+	ast, issues := proposalPolicyEnv.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return variables
+	}
+	for _, ref := range ast.NativeRep().ReferenceMap() {
+		if ref.Name != "" {
+			variables[ref.Name] = true
+		}
+	}
+	return variables
+}
+
+// eventReportedApproval names the event-reported variable a policy would
+// approve a BINARY or blocked-event proposal on, if any. A fake event could
+// otherwise get any hash allowlisted, so such approvals need an admin.
+func eventReportedApproval(input models.ProposalPolicyContext, expression string) string {
+	blockedEvent := input.Source == string(models.ProposalSourceBlockedEvent)
+	if input.RuleType != string(models.RuleTypeBinary) && !blockedEvent {
+		return ""
+	}
+	variables := proposalPolicyVariables(expression)
+	for _, name := range eventReportedVariables {
+		if variables[name] {
+			return name
+		}
+	}
+	// A blocked event names its own requester
+	if blockedEvent && variables["requester"] {
+		return "requester"
+	}
+	return ""
+}
+
+// ApplyProposalPolicies decides a newly opened proposal with the first
+// matching policy, if any, and records the decision and policy on it. An
+// approval that would remove a rule or replace a rule with another policy
+// is escalated instead, since those need an admin, as is one of a BINARY or
+// blocked-event proposal that rests on what events reported.
+func ApplyProposalPolicies(proposalID int64) (*models.ProposalPolicyResult, error) {
+	input, err := proposalPolicyContext(proposalID)
+	if err != nil {
+		return nil, err
+	}
+	result, err := EvaluateProposalPolicies(input)
+	if err != nil {
+		return nil, err
+	}
+	for _, message := range result.Errors {
+		log.Printf("Proposal %d: skipped %s", proposalID, message)
+	}
+	if result.Decision == nil {
+		return result, nil
+	}
+
+	escalate := func(note string) {
+		decision := string(models.AutoDecisionEscalate)
+		result.Decision, result.Note = &decision, &note
+	}
+
+	switch models.AutoDecision(*result.Decision) {
+	case models.AutoDecisionApprove:
+		if input.Policy == string(models.PolicyRemove) {
+			escalate("Removing a rule needs an admin")
+			break
+		}
+		matched, err := GetProposalPolicy(*result.PolicyID)
+		if err != nil {
+			return nil, err
+		}
+		if name := eventReportedApproval(input, matched.Expression); name != "" {
+			escalate(fmt.Sprintf("Approval relies on %s, which clients report unauthenticated", name))
+			break
+		}
+		reason := fmt.Sprintf("Proposal #%d approved by policy %s", proposalID, *result.PolicyName)
+		err = finalizeProposal(proposalID, input.Policy, false, nil, reason)
+		var conflict *RuleConflictError
+		if errors.As(err, &conflict) {
+			escalate(fmt.Sprintf("Approving would replace %s rule %d", conflict.Policy, conflict.RuleID))
+		} else if err != nil {
+			return nil, err
+		}
+
+	case models.AutoDecisionReject:
+		_, err := database.DB.Exec(
+			`UPDATE proposals SET status = ?, finalized_at = ? WHERE id = ? AND status = ?`,
+			models.ProposalStatusRejected, time.Now(), proposalID, models.ProposalStatusPending,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reject proposal: %w", err)
+		}
+	}
+
+	_, err = database.DB.Exec(
+		`UPDATE proposals SET auto_decision = ?, auto_policy_id = ?, auto_policy_name = ?, auto_decision_note = ?
+		 WHERE id = ?`,
+		result.Decision, result.PolicyID, result.PolicyName, result.Note, proposalID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record auto-decision: %w", err)
+	}
+
+	log.Printf("Proposal %d: %s by policy %s", proposalID, *result.Decision, *result.PolicyName)
+	return result, nil
+}
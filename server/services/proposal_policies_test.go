@@ -0,0 +1,196 @@
+package services
+
+import (
+	"krampus/server/database"
+	"krampus/server/models"
+	"reflect"
+	"testing"
+)
+
+func TestValidateProposalPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		action     string
+		fields     []string
+	}{
+		{name: "valid", expression: `team_id == "ABCDE12345" && policy == "ALLOWLIST"`, action: "APPROVE"},
+		{name: "not a bool", expression: `machine_count + 1`, action: "APPROVE", fields: []string{"expression"}},
+		{name: "unknown variable", expression: `file_path.startsWith("/Applications")`, action: "APPROVE", fields: []string{"expression"}},
+		// Nothing trusted proves a certificate's common name
+		{name: "certificate common name", expression: `cert_cn.startsWith("Apple ")`, action: "APPROVE", fields: []string{"expression"}},
+		{name: "unknown action", expression: `true`, action: "ALLOW", fields: []string{"action"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fields []string
+			for _, err := range ValidateProposalPolicy(tt.name, tt.expression, tt.action) {
+				fields = append(fields, err.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("errors on %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestApplyProposalPolicies(t *testing.T) {
+	allow := string(models.PolicyAllowlist)
+	userID := int64(1)
+	userProposal := func(ruleType, identifier, policy string) models.Proposal {
+		return models.Proposal{
+			Identifier:     identifier,
+			RuleType:       ruleType,
+			ProposedPolicy: policy,
+			CreatedBy:      &userID,
+			Source:         string(models.ProposalSourceUser),
+		}
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		action     models.AutoDecision
+		// setup runs before the proposal is opened
+		setup func(t *testing.T)
+		// requester, when set, is recorded as having hit the block
+		requester string
+		proposal  models.Proposal
+		decision  *string
+		note      *string
+		status    models.ProposalStatus
+	}{
+		{
+			name:       "no policy matches",
+			expression: `rule_type == "CERTIFICATE"`,
+			action:     models.AutoDecisionApprove,
+			proposal:   userProposal(string(models.RuleTypeTeamID), testTeamID, allow),
+			status:     models.ProposalStatusPending,
+		},
+		{
+			name:       "signing ID approved on its team",
+			expression: `team_id == "ABCDE12345"`,
+			action:     models.AutoDecisionApprove,
+			proposal:   userProposal(string(models.RuleTypeSigningID), testSigning, allow),
+			decision:   ptrTo("APPROVE"),
+			status:     models.ProposalStatusApproved,
+		},
+		{
+			name:       "platform binary approved on its signing ID",
+			expression: `signing_id.startsWith("platform:") && policy == "ALLOWLIST"`,
+			action:     models.AutoDecisionApprove,
+			proposal:   userProposal(string(models.RuleTypeSigningID), "platform:com.apple.ls", allow),
+			decision:   ptrTo("APPROVE"),
+			status:     models.ProposalStatusApproved,
+		},
+		{
+			name:       "binary approved on its machine count",
+			expression: `machine_count >= 0`,
+			action:     models.AutoDecisionApprove,
+			proposal:   userProposal(string(models.RuleTypeBinary), testHash, allow),
+			decision:   ptrTo("ESCALATE"),
+			note:       ptrTo("Approval relies on machine_count, which clients report unauthenticated"),
+			status:     models.ProposalStatusPending,
+		},
+		{
+			name:       "binary approved on its requester",
+			expression: `requester_role == "USER"`,
+			action:     models.AutoDecisionApprove,
+			proposal:   userProposal(string(models.RuleTypeBinary), testHash, allow),
+			decision:   ptrTo("APPROVE"),
+			status:     models.ProposalStatusApproved,
+		},
+		{
+			name:       "blocked event approved on its requester",
+			expression: `requester == "alice"`,
+			action:     models.AutoDecisionApprove,
+			requester:  "alice",
+			proposal: models.Proposal{
+				Identifier:     testTeamID,
+				RuleType:       string(models.RuleTypeTeamID),
+				ProposedPolicy: allow,
+				Source:         string(models.ProposalSourceBlockedEvent),
+			},
+			decision: ptrTo("ESCALATE"),
+			note:     ptrTo("Approval relies on requester, which clients report unauthenticated"),
+			status:   models.ProposalStatusPending,
+		},
+		{
+			name:       "removal approved",
+			expression: `true`,
+			action:     models.AutoDecisionApprove,
+			proposal:   userProposal(string(models.RuleTypeTeamID), testTeamID, string(models.PolicyRemove)),
+			decision:   ptrTo("ESCALATE"),
+			note:       ptrTo("Removing a rule needs an admin"),
+			status:     models.ProposalStatusPending,
+		},
+		{
+			name:       "approval would replace a rule",
+			expression: `true`,
+			action:     models.AutoDecisionApprove,
+			setup: func(t *testing.T) {
+				createTestRule(t, testRule(0, string(models.RuleTypeTeamID), testTeamID, models.PolicyBlocklist))
+			},
+			proposal: userProposal(string(models.RuleTypeTeamID), testTeamID, allow),
+			decision: ptrTo("ESCALATE"),
+			note:     ptrTo("Approving would replace BLOCKLIST rule 1"),
+			status:   models.ProposalStatusPending,
+		},
+		{
+			name:       "rejected",
+			expression: `policy == "ALLOWLIST"`,
+			action:     models.AutoDecisionReject,
+			proposal:   userProposal(string(models.RuleTypeTeamID), testTeamID, allow),
+			decision:   ptrTo("REJECT"),
+			status:     models.ProposalStatusRejected,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			mustExec(t, `INSERT INTO users (id, username, role, email) VALUES (1, 'bob', 'USER', 'bob@example.com')`)
+			if tt.setup != nil {
+				tt.setup(t)
+			}
+			_, err := CreateProposalPolicy(ProposalPolicyInput{
+				Name:       tt.name,
+				Expression: tt.expression,
+				Action:     string(tt.action),
+				Priority:   1,
+			}, userID)
+			if err != nil {
+				t.Fatalf("CreateProposalPolicy: %v", err)
+			}
+
+			proposalID, _, err := OpenProposal(tt.proposal)
+			if err != nil {
+				t.Fatalf("OpenProposal: %v", err)
+			}
+			if tt.requester != "" {
+				mustExec(t, `INSERT INTO proposal_requesters (proposal_id, machine_id, executing_user) VALUES (?, 'm1', ?)`,
+					proposalID, tt.requester)
+			}
+
+			result, err := ApplyProposalPolicies(proposalID)
+			if err != nil {
+				t.Fatalf("ApplyProposalPolicies: %v", err)
+			}
+			if !reflect.DeepEqual(result.Decision, tt.decision) {
+				t.Errorf("decision = %q, want %q", deref(result.Decision), deref(tt.decision))
+			}
+			if !reflect.DeepEqual(result.Note, tt.note) {
+				t.Errorf("note = %q, want %q", deref(result.Note), deref(tt.note))
+			}
+
+			var status string
+			if err := database.DB.QueryRow(`SELECT status FROM proposals WHERE id = ?`, proposalID).Scan(&status); err != nil {
+				t.Fatal(err)
+			}
+			if status != string(tt.status) {
+				t.Errorf("status = %s, want %s", status, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,377 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"krampus/server/database"
+	"krampus/server/models"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrGroupNotFound = errors.New("group not found")
+
+// ReadinessRequest selects the machines and time window a lockdown readiness
+// analysis replays. With no machines or groups the whole fleet is analyzed.
+type ReadinessRequest struct {
+	MachineIDs []string
+	GroupIDs   []int64
+	Since      time.Time
+	Until      time.Time
+	Limit      int // Maximum binaries and suggestions listed
+}
+
+// binaryImpact accumulates who ran a binary that would be blocked
+type binaryImpact struct {
+	binary   models.ReadinessBinary
+	machines map[string]bool
+	users    map[string]bool
+}
+
+// suggestionImpact accumulates the blocked binaries one rule would cover
+type suggestionImpact struct {
+	binaries   int
+	executions int
+	machines   map[string]bool
+	users      map[string]bool
+}
+
+// AnalyzeLockdownReadiness replays the executions recorded in a window
+// against the current ruleset as if the machines ran in LOCKDOWN, and ranks
+// the binaries that would be blocked by how many machines, users and
+// executions they affect, together with the rules that would cover them
+func AnalyzeLockdownReadiness(req ReadinessRequest) (*models.LockdownReadiness, error) {
+	report := &models.LockdownReadiness{
+		Since:       req.Since,
+		Until:       req.Until,
+		Machines:    []models.ReadinessMachine{},
+		Binaries:    []models.ReadinessBinary{},
+		Suggestions: []models.ReadinessSuggestion{},
+	}
+
+	machines, err := readinessMachines(req.MachineIDs, req.GroupIDs)
+	if err != nil {
+		return nil, err
+	}
+	report.MachineCount = len(machines)
+	if len(machines) == 0 {
+		report.Ready = true
+		return report, nil
+	}
+
+	memberships, err := groupMemberships()
+	if err != nil {
+		return nil, err
+	}
+	rules, err := activeRulesByTarget()
+	if err != nil {
+		return nil, err
+	}
+
+	// Aggregate executions per machine, binary and user; attributes rarely
+	// vary for one hash so the last reported values are used
+	query := `SELECT machine_id, file_hash, MAX(COALESCE(cdhash, '')), MAX(COALESCE(signing_id, '')),
+	                 MAX(COALESCE(team_id, '')), MAX(COALESCE(cert_sha256, '')), COALESCE(executing_user, ''),
+	                 COUNT(*), MAX(COALESCE(file_path, '')), MAX(COALESCE(bundle_name, ''))
+	          FROM events
+	          WHERE execution_time >= ? AND execution_time < ? AND file_hash != ''`
+	query += ` GROUP BY machine_id, file_hash, COALESCE(executing_user, '')`
+
+	// Event times are stored in local time, so the bounds must be too for the
+	// comparison to hold
+	rows, err := database.DB.Query(query, req.Since.Local(), req.Until.Local())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events: %w", err)
+	}
+	defer rows.Close()
+
+	machineIndex := map[string]int{}
+	for i, m := range machines {
+		machineIndex[m.MachineID] = i
+	}
+	impacts := map[string]*binaryImpact{}
+	machineBinaries := map[string]map[string]bool{}
+	now := time.Now()
+
+	for rows.Next() {
+		var machineID, hash, user, filePath, bundleName string
+		var attrs models.BinaryAttributes
+		var count int
+		if err := rows.Scan(&machineID, &hash, &attrs.CDHash, &attrs.SigningID, &attrs.TeamID,
+			&attrs.CertSHA256, &user, &count, &filePath, &bundleName); err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		i, ok := machineIndex[machineID]
+		if !ok {
+			continue // Not selected, or never enrolled
+		}
+		attrs.SHA256 = hash
+		normalizeAttributes(&attrs)
+
+		var matching []models.Rule
+		for _, target := range binaryTargets(attrs) {
+			matching = append(matching, rules[target]...)
+		}
+		eval := &models.Evaluation{ClientMode: string(models.ClientModeLockdown)}
+		decide(eval, matching, memberships[machineID], now)
+
+		report.Executions += count
+		machines[i].Executions += count
+		switch {
+		case eval.Rule == nil:
+			report.WouldBlock += count
+			machines[i].WouldBlock += count
+		case eval.Allowed:
+			report.AllowedByRule += count
+			continue
+		default:
+			report.BlockedByRule += count
+			continue
+		}
+
+		if machineBinaries[machineID] == nil {
+			machineBinaries[machineID] = map[string]bool{}
+		}
+		machineBinaries[machineID][hash] = true
+
+		impact := impacts[hash]
+		if impact == nil {
+			impact = &binaryImpact{
+				binary: models.ReadinessBinary{
+					SHA256:     hash,
+					FilePath:   nullIfEmpty(filePath),
+					BundleName: nullIfEmpty(bundleName),
+					SigningID:  nullIfEmpty(attrs.SigningID),
+					TeamID:     nullIfEmpty(attrs.TeamID),
+					Suggested:  SuggestRule(hash, attrs.SigningID, attrs.TeamID),
+				},
+				machines: map[string]bool{},
+				users:    map[string]bool{},
+			}
+			impacts[hash] = impact
+		}
+		impact.binary.Executions += count
+		impact.machines[machineID] = true
+		if user != "" {
+			impact.users[user] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read events: %w", err)
+	}
+
+	for i := range machines {
+		machines[i].Binaries = len(machineBinaries[machines[i].MachineID])
+		machines[i].Ready = machines[i].WouldBlock == 0
+	}
+	sort.SliceStable(machines, func(i, j int) bool { return machines[i].WouldBlock > machines[j].WouldBlock })
+	report.Machines = machines
+
+	// Suggestions merge binaries covered by the same rule, such as several
+	// versions sharing a signing ID
+	suggestions := map[models.RuleTarget]*suggestionImpact{}
+	for _, impact := range impacts {
+		impact.binary.Machines = len(impact.machines)
+		impact.binary.Users = len(impact.users)
+		report.Binaries = append(report.Binaries, impact.binary)
+
+		s := suggestions[impact.binary.Suggested]
+		if s == nil {
+			s = &suggestionImpact{machines: map[string]bool{}, users: map[string]bool{}}
+			suggestions[impact.binary.Suggested] = s
+		}
+		s.binaries++
+		s.executions += impact.binary.Executions
+		for m := range impact.machines {
+			s.machines[m] = true
+		}
+		for u := range impact.users {
+			s.users[u] = true
+		}
+	}
+	report.WouldBlockBinaries = len(report.Binaries)
+	report.Ready = report.WouldBlock == 0
+
+	for target, s := range suggestions {
+		report.Suggestions = append(report.Suggestions, models.ReadinessSuggestion{
+			RuleTarget: target,
+			Binaries:   s.binaries,
+			Executions: s.executions,
+			Machines:   len(s.machines),
+			Users:      len(s.users),
+		})
+	}
+
+	sort.Slice(report.Binaries, func(i, j int) bool {
+		a, b := report.Binaries[i], report.Binaries[j]
+		return rankImpact(a.Machines, a.Users, a.Executions, a.SHA256, b.Machines, b.Users, b.Executions, b.SHA256)
+	})
+	sort.Slice(report.Suggestions, func(i, j int) bool {
+		a, b := report.Suggestions[i], report.Suggestions[j]
+		return rankImpact(a.Machines, a.Users, a.Executions, a.Identifier, b.Machines, b.Users, b.Executions, b.Identifier)
+	})
+	if req.Limit > 0 && len(report.Binaries) > req.Limit {
+		report.Binaries = report.Binaries[:req.Limit]
+	}
+	if req.Limit > 0 && len(report.Suggestions) > req.Limit {
+		report.Suggestions = report.Suggestions[:req.Limit]
+	}
+
+	for i := range report.Suggestions {
+		s := &report.Suggestions[i]
+		proposalID, err := FindPendingProposal(s.Identifier, s.RuleType, string(models.PolicyAllowlist))
+		if err != nil {
+			return nil, err
+		}
+		if proposalID != 0 {
+			s.ProposalID = &proposalID
+		}
+	}
+
+	return report, nil
+}
+
+// rankImpact orders by machines affected, then users, then executions, with
+// the key as a stable tie-breaker
+func rankImpact(machinesA, usersA, execA int, keyA string, machinesB, usersB, execB int, keyB string) bool {
+	if machinesA != machinesB {
+		return machinesA > machinesB
+	}
+	if usersA != usersB {
+		return usersA > usersB
+	}
+	if execA != execB {
+		return execA > execB
+	}
+	return keyA < keyB
+}
+
+// readinessMachines resolves the selected machines and group members, or
+// every enrolled machine when nothing is selected
+func readinessMachines(machineIDs []string, groupIDs []int64) ([]models.ReadinessMachine, error) {
+	for _, id := range groupIDs {
+		exists, err := GroupExists(id)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("%w: %d", ErrGroupNotFound, id)
+		}
+	}
+
+	query := `SELECT machine_id, hostname, client_mode FROM machines`
+	var args []interface{}
+	if len(machineIDs) > 0 || len(groupIDs) > 0 {
+		var clauses []string
+		if len(machineIDs) > 0 {
+			clauses = append(clauses, `machine_id IN (`+strings.TrimSuffix(strings.Repeat("?, ", len(machineIDs)), ", ")+`)`)
+			for _, id := range machineIDs {
+				args = append(args, id)
+			}
+		}
+		if len(groupIDs) > 0 {
+			clauses = append(clauses, `machine_id IN (SELECT machine_id FROM machine_group_members
+			                                          WHERE group_id IN (`+strings.TrimSuffix(strings.Repeat("?, ", len(groupIDs)), ", ")+`))`)
+			for _, id := range groupIDs {
+				args = append(args, id)
+			}
+		}
+		query += ` WHERE ` + strings.Join(clauses, " OR ")
+	}
+	query += ` ORDER BY machine_id`
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query machines: %w", err)
+	}
+	defer rows.Close()
+
+	machines := []models.ReadinessMachine{}
+	found := map[string]bool{}
+	for rows.Next() {
+		var m models.ReadinessMachine
+		if err := rows.Scan(&m.MachineID, &m.Hostname, &m.ClientMode); err != nil {
+			return nil, fmt.Errorf("failed to scan machine: %w", err)
+		}
+		found[m.MachineID] = true
+		machines = append(machines, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read machines: %w", err)
+	}
+
+	for _, id := range machineIDs {
+		if !found[id] {
+			return nil, fmt.Errorf("%w: %s", ErrMachineNotFound, id)
+		}
+	}
+	return machines, nil
+}
+
+// groupMemberships maps every machine to the set of groups it belongs to
+func groupMemberships() (map[string]map[int64]bool, error) {
+	rows, err := database.DB.Query(`SELECT machine_id, group_id FROM machine_group_members`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query group members: %w", err)
+	}
+	defer rows.Close()
+
+	memberships := map[string]map[int64]bool{}
+	for rows.Next() {
+		var machineID string
+		var groupID int64
+		if err := rows.Scan(&machineID, &groupID); err != nil {
+			return nil, fmt.Errorf("failed to scan group member: %w", err)
+		}
+		if memberships[machineID] == nil {
+			memberships[machineID] = map[int64]bool{}
+		}
+		memberships[machineID][groupID] = true
+	}
+	return memberships, rows.Err()
+}
+
+// activeRulesByTarget loads the active ruleset keyed by target. A target
+// holds one rule per scope, group-scoped rules first as they outrank the
+// fleet-wide one.
+func activeRulesByTarget() (map[models.RuleTarget][]models.Rule, error) {
+	rows, err := database.DB.Query(`SELECT ` + RuleColumns + ` FROM rules WHERE ` + ActiveRule)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rules: %w", err)
+	}
+	defer rows.Close()
+
+	var active []models.Rule
+	for rows.Next() {
+		var r models.Rule
+		if err := ScanRule(rows, &r); err != nil {
+			return nil, fmt.Errorf("failed to scan rule: %w", err)
+		}
+		active = append(active, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rules: %w", err)
+	}
+	if err := AttachRollouts(active); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(active, func(i, j int) bool { return outranks(active[i], active[j]) })
+	rules := map[models.RuleTarget][]models.Rule{}
+	for _, r := range active {
+		target := models.RuleTarget{Identifier: r.Identifier, RuleType: r.RuleType}
+		rules[target] = append(rules[target], r)
+	}
+	return rules, nil
+}
+
+// fleetRule picks the fleet-wide rule out of a target's rules
+func fleetRule(rules []models.Rule) (models.Rule, bool) {
+	for _, r := range rules {
+		if r.GroupID == nil {
+			return r, true
+		}
+	}
+	return models.Rule{}, false
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"errors"
+	"krampus/server/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnalyzeLockdownReadiness(t *testing.T) {
+	otherHash := strings.Repeat("b", 64)
+	allowedHash := strings.Repeat("c", 64)
+	blockedHash := strings.Repeat("d", 64)
+	now := time.Now()
+
+	tests := []struct {
+		name          string
+		req           ReadinessRequest
+		err           error
+		machines      int
+		executions    int
+		allowedByRule int
+		blockedByRule int
+		wouldBlock    int
+		binaries      []string // Would-be-blocked hashes, most impactful first
+		ready         bool
+	}{
+		{
+			name:     "whole fleet",
+			machines: 3, executions: 7, allowedByRule: 3, blockedByRule: 1, wouldBlock: 3,
+			binaries: []string{testHash, otherHash},
+		},
+		{
+			name:     "group allowed by its own rule",
+			req:      ReadinessRequest{GroupIDs: []int64{7}},
+			machines: 1, executions: 2, allowedByRule: 2, ready: true,
+		},
+		{
+			name:     "one machine",
+			req:      ReadinessRequest{MachineIDs: []string{"m2"}},
+			machines: 1, executions: 2, blockedByRule: 1, wouldBlock: 1,
+			binaries: []string{otherHash},
+		},
+		{
+			name:     "limited",
+			req:      ReadinessRequest{Limit: 1},
+			machines: 3, executions: 7, allowedByRule: 3, blockedByRule: 1, wouldBlock: 3,
+			binaries: []string{testHash},
+		},
+		{name: "unknown machine", req: ReadinessRequest{MachineIDs: []string{"missing"}}, err: ErrMachineNotFound},
+		{name: "unknown group", req: ReadinessRequest{GroupIDs: []int64{99}}, err: ErrGroupNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			mustExec(t, `INSERT INTO users (id, username, role) VALUES (1, 'alice', 'USER')`)
+			mustExec(t, `INSERT INTO machines (machine_id) VALUES ('m1'), ('m2'), ('m3')`)
+			mustExec(t, `INSERT INTO machine_groups (id, name) VALUES (7, 'eng')`)
+			mustExec(t, `INSERT INTO machine_group_members (group_id, machine_id) VALUES (7, 'm3')`)
+			createTestRule(t, testRule(0, string(models.RuleTypeBinary), allowedHash, models.PolicyAllowlist))
+			createTestRule(t, testRule(0, string(models.RuleTypeBinary), blockedHash, models.PolicyBlocklist))
+			createTestRule(t, inGroup(testRule(0, string(models.RuleTypeSigningID), testSigning, models.PolicyAllowlist), 7))
+
+			within, before := now.Add(-time.Hour), now.Add(-48*time.Hour)
+			for _, e := range []struct {
+				machineID, hash, signingID, user string
+				at                               time.Time
+			}{
+				{"m1", testHash, "com.example.tool", "alice", within},
+				{"m1", testHash, "com.example.tool", "alice", within},
+				{"m1", testHash, "com.example.tool", "alice", before}, // Outside the window
+				{"m2", otherHash, "com.example.tool", "bob", within},
+				{"m1", allowedHash, "", "alice", within},
+				{"m2", blockedHash, "", "bob", within},
+				{"m3", allowedHash, "", "carol", within},
+				{"m3", testHash, "com.example.tool", "carol", within},
+				{"ghost", testHash, "com.example.tool", "dave", within}, // Never enrolled
+			} {
+				teamID := interface{}(nil)
+				if e.signingID != "" {
+					teamID = testTeamID
+				}
+				mustExec(t, `INSERT INTO events (machine_id, file_hash, decision, signing_id, team_id, executing_user, execution_time)
+					VALUES (?, ?, 'ALLOW_UNKNOWN', ?, ?, ?, ?)`, e.machineID, e.hash, nullIfEmpty(e.signingID), teamID, e.user, e.at)
+			}
+
+			// A pending request for the suggested rule is linked from the suggestion
+			userID := int64(1)
+			proposalID, _, err := OpenProposal(models.Proposal{
+				Identifier:     testSigning,
+				RuleType:       string(models.RuleTypeSigningID),
+				ProposedPolicy: string(models.PolicyAllowlist),
+				CreatedBy:      &userID,
+				Source:         string(models.ProposalSourceUser),
+			})
+			if err != nil {
+				t.Fatalf("failed to open proposal: %v", err)
+			}
+
+			tt.req.Since, tt.req.Until = now.Add(-24*time.Hour), now
+			report, err := AnalyzeLockdownReadiness(tt.req)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("AnalyzeLockdownReadiness error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+
+			got := []int{report.MachineCount, report.Executions, report.AllowedByRule, report.BlockedByRule, report.WouldBlock}
+			want := []int{tt.machines, tt.executions, tt.allowedByRule, tt.blockedByRule, tt.wouldBlock}
+			for i, field := range []string{"machines", "executions", "allowed by rule", "blocked by rule", "would block"} {
+				if got[i] != want[i] {
+					t.Errorf("%s = %d, want %d", field, got[i], want[i])
+				}
+			}
+			if report.Ready != tt.ready {
+				t.Errorf("ready = %v, want %v", report.Ready, tt.ready)
+			}
+
+			var hashes []string
+			for _, b := range report.Binaries {
+				hashes = append(hashes, b.SHA256)
+			}
+			if strings.Join(hashes, ",") != strings.Join(tt.binaries, ",") {
+				t.Errorf("binaries = %v, want %v", hashes, tt.binaries)
+			}
+			if len(tt.binaries) == 0 {
+				return
+			}
+
+			// Both signed binaries are covered by one signing ID rule
+			if len(report.Suggestions) != 1 {
+				t.Fatalf("%d suggestions, want 1", len(report.Suggestions))
+			}
+			s := report.Suggestions[0]
+			if s.Identifier != testSigning || s.RuleType != string(models.RuleTypeSigningID) {
+				t.Errorf("suggestion = %s %s, want %s SIGNINGID", s.RuleType, s.Identifier, testSigning)
+			}
+			if s.ProposalID == nil || *s.ProposalID != proposalID {
+				t.Errorf("suggestion proposal = %v, want %d", s.ProposalID, proposalID)
+			}
+			if tt.req.Limit == 0 && tt.req.MachineIDs == nil && (s.Binaries != 2 || s.Executions != 3 || s.Machines != 2 || s.Users != 2) {
+				t.Errorf("suggestion covers %d binaries, %d executions, %d machines, %d users, want 2, 3, 2, 2",
+					s.Binaries, s.Executions, s.Machines, s.Users)
+			}
+		})
+	}
+}
@@ -0,0 +1,411 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"krampus/server/config"
+	"krampus/server/database"
+	"krampus/server/models"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoReputationProvider = errors.New("no reputation provider is configured")
+	ErrReputationLookup     = errors.New("reputation lookup failed")
+	ErrInvalidHash          = errors.New("hash must be a SHA-256")
+)
+
+// ReputationProvider looks up what an outside source knows about a binary
+type ReputationProvider interface {
+	// Name identifies the provider in cached results
+	Name() string
+	// Lookup returns the provider's reputation for a lowercase SHA-256.
+	// Unknown hashes are reported with Found false rather than an error.
+	Lookup(hash string) (*models.Reputation, error)
+}
+
+var reputationProvider ReputationProvider
+
+// reputationQueue holds hashes waiting for a background lookup
+var reputationQueue = make(chan string, 1000)
+
+// InitializeReputation sets up the reputation provider named by
+// REPUTATION_PROVIDER ("http" or "file"); none is used when it is empty
+func InitializeReputation() error {
+	cfg := config.AppConfig
+	switch strings.ToLower(cfg.ReputationProvider) {
+	case "":
+		return nil
+	case "http":
+		if !strings.Contains(cfg.ReputationURL, "{sha256}") {
+			return fmt.Errorf("REPUTATION_URL must contain {sha256}")
+		}
+		reputationProvider = NewHTTPReputationProvider(cfg.ReputationURL, cfg.ReputationAPIKeyHeader, cfg.ReputationAPIKey)
+	case "file":
+		if cfg.ReputationFile == "" {
+			return fmt.Errorf("REPUTATION_FILE must be set")
+		}
+		reputationProvider = NewFileReputationProvider(cfg.ReputationFile)
+	default:
+		return fmt.Errorf("unknown reputation provider %q", cfg.ReputationProvider)
+	}
+	return nil
+}
+
+// SetReputationProvider replaces the reputation provider; nil disables
+// lookups
+func SetReputationProvider(provider ReputationProvider) {
+	reputationProvider = provider
+}
+
+// Reputation returns a binary's reputation, from the cache when it was
+// checked within REPUTATION_CACHE_TTL unless refresh is set
+func Reputation(hash string, refresh bool) (*models.Reputation, error) {
+	provider := reputationProvider
+	if provider == nil {
+		return nil, ErrNoReputationProvider
+	}
+	hash = strings.ToLower(hash)
+	if !sha256Pattern.MatchString(hash) {
+		return nil, ErrInvalidHash
+	}
+
+	if !refresh {
+		cached, err := CachedReputations([]string{hash})
+		if err != nil {
+			return nil, err
+		}
+		if r, ok := cached[hash]; ok && reputationFresh(r) {
+			return &r, nil
+		}
+	}
+
+	r, err := provider.Lookup(hash)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s provider: %v", ErrReputationLookup, provider.Name(), err)
+	}
+	r.Identifier = hash
+	r.Provider = provider.Name()
+	r.CheckedAt = time.Now().UTC()
+	if err := storeReputation(r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// CachedReputations returns the cached reputations the current provider gave
+// for the given hashes, however old. It never contacts the provider.
+func CachedReputations(hashes []string) (map[string]models.Reputation, error) {
+	reputations := map[string]models.Reputation{}
+	if reputationProvider == nil || len(hashes) == 0 {
+		return reputations, nil
+	}
+
+	args := []interface{}{reputationProvider.Name()}
+	placeholders := make([]string, 0, len(hashes))
+	for _, hash := range hashes {
+		args = append(args, strings.ToLower(hash))
+		placeholders = append(placeholders, "?")
+	}
+	rows, err := database.DB.Query(
+		`SELECT identifier, provider, found, detections, engines, first_seen, known_good, link, checked_at
+		 FROM binary_reputations WHERE provider = ? AND identifier IN (`+strings.Join(placeholders, ", ")+`)`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reputations: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.Reputation
+		if err := rows.Scan(&r.Identifier, &r.Provider, &r.Found, &r.Detections, &r.Engines, &r.FirstSeen,
+			&r.KnownGood, &r.Link, &r.CheckedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan reputation: %w", err)
+		}
+		reputations[r.Identifier] = r
+	}
+	return reputations, rows.Err()
+}
+
+// cachedReputation returns a hash's cached reputation, queueing a lookup when
+// it is missing or stale
+func cachedReputation(hash string) (*models.Reputation, error) {
+	cached, err := CachedReputations([]string{hash})
+	if err != nil {
+		return nil, err
+	}
+	r, ok := cached[strings.ToLower(hash)]
+	if !ok || !reputationFresh(r) {
+		QueueReputationLookup(hash)
+	}
+	if !ok {
+		return nil, nil
+	}
+	return &r, nil
+}
+
+// QueueReputationLookup asks the background worker to look up a hash that
+// isn't cached yet. Hashes are dropped while the queue is full, and so is
+// anything that isn't a SHA-256, as clients report it unchecked.
+func QueueReputationLookup(hash string) {
+	hash = strings.ToLower(hash)
+	if reputationProvider == nil || !sha256Pattern.MatchString(hash) {
+		return
+	}
+	select {
+	case reputationQueue <- hash:
+	default:
+	}
+}
+
+// WatchReputation looks up queued hashes in the background, waiting the
+// given interval between lookups to respect provider rate limits
+func WatchReputation(interval time.Duration) {
+	if reputationProvider == nil {
+		return
+	}
+	go func() {
+		for hash := range reputationQueue {
+			cached, err := CachedReputations([]string{hash})
+			if err != nil {
+				log.Printf("Failed to read cached reputation for %s: %v", hash, err)
+				continue
+			}
+			if r, ok := cached[hash]; ok && reputationFresh(r) {
+				continue
+			}
+			if _, err := Reputation(hash, true); err != nil {
+				log.Printf("Failed to look up reputation for %s: %v", hash, err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func reputationFresh(r models.Reputation) bool {
+	return time.Since(r.CheckedAt) < config.AppConfig.ReputationCacheTTL
+}
+
+func storeReputation(r *models.Reputation) error {
+	_, err := database.DB.Exec(
+		`INSERT INTO binary_reputations (identifier, provider, found, detections, engines, first_seen, known_good, link, checked_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(identifier, provider) DO UPDATE SET
+		     found = excluded.found, detections = excluded.detections, engines = excluded.engines,
+		     first_seen = excluded.first_seen, known_good = excluded.known_good, link = excluded.link,
+		     checked_at = excluded.checked_at`,
+		r.Identifier, r.Provider, r.Found, r.Detections, r.Engines, r.FirstSeen, r.KnownGood, r.Link, r.CheckedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to cache reputation: %w", err)
+	}
+	return nil
+}
+
+// reputationRecord is the JSON a provider returns for a hash, and the shape
+// of entries in a reputation file
+type reputationRecord struct {
+	SHA256     string `json:"sha256"`
+	Found      *bool  `json:"found"` // Defaults to true when a record is returned
+	Detections int    `json:"detections"`
+	Engines    int    `json:"engines"`
+	FirstSeen  string `json:"first_seen"` // RFC 3339 timestamp or YYYY-MM-DD date
+	KnownGood  bool   `json:"known_good"`
+	Link       string `json:"link"`
+}
+
+func (rec reputationRecord) reputation() (*models.Reputation, error) {
+	r := &models.Reputation{
+		Found:      rec.Found == nil || *rec.Found,
+		Detections: rec.Detections,
+		Engines:    rec.Engines,
+		KnownGood:  rec.KnownGood,
+		Link:       nullIfEmpty(rec.Link),
+	}
+	if rec.FirstSeen != "" {
+		firstSeen, err := time.Parse(time.RFC3339, rec.FirstSeen)
+		if err != nil {
+			if firstSeen, err = time.Parse("2006-01-02", rec.FirstSeen); err != nil {
+				return nil, fmt.Errorf("invalid first_seen %q", rec.FirstSeen)
+			}
+		}
+		firstSeen = firstSeen.UTC()
+		r.FirstSeen = &firstSeen
+	}
+	return r, nil
+}
+
+// HTTPReputationProvider queries a JSON API. The URL template's {sha256} is
+// replaced with the hash; a 404 means the hash is unknown.
+type HTTPReputationProvider struct {
+	URL    string
+	Header string // Header carrying the API key
+	Key    string
+	Client *http.Client
+}
+
+func NewHTTPReputationProvider(urlTemplate, header, key string) *HTTPReputationProvider {
+	return &HTTPReputationProvider{
+		URL:    urlTemplate,
+		Header: header,
+		Key:    key,
+		Client: &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (p *HTTPReputationProvider) Name() string { return "http" }
+
+func (p *HTTPReputationProvider) Lookup(hash string) (*models.Reputation, error) {
+	if !sha256Pattern.MatchString(hash) {
+		return nil, ErrInvalidHash
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.ReplaceAll(p.URL, "{sha256}", url.PathEscape(hash)), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if p.Key != "" {
+		req.Header.Set(p.Header, p.Key)
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return &models.Reputation{}, nil
+	default:
+		return nil, fmt.Errorf("unexpected response %s", resp.Status)
+	}
+
+	var rec reputationRecord
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&rec); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	return rec.reputation()
+}
+
+// FileReputationProvider answers from a local file, reloaded whenever it
+// changes. The file is a JSON array of records or a CSV file whose header
+// names the same fields. It also works as an offline stand-in for an API.
+type FileReputationProvider struct {
+	Path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	records map[string]reputationRecord
+}
+
+func NewFileReputationProvider(path string) *FileReputationProvider {
+	return &FileReputationProvider{Path: path}
+}
+
+func (p *FileReputationProvider) Name() string { return "file" }
+
+func (p *FileReputationProvider) Lookup(hash string) (*models.Reputation, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	info, err := os.Stat(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	if p.records == nil || !info.ModTime().Equal(p.modTime) {
+		records, err := readReputationFile(p.Path)
+		if err != nil {
+			return nil, err
+		}
+		p.records, p.modTime = records, info.ModTime()
+	}
+
+	rec, ok := p.records[hash]
+	if !ok {
+		return &models.Reputation{}, nil
+	}
+	return rec.reputation()
+}
+
+func readReputationFile(path string) (map[string]reputationRecord, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []reputationRecord
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, fmt.Errorf("invalid reputation file: %w", err)
+		}
+	} else if records, err = readReputationCSV(data); err != nil {
+		return nil, err
+	}
+
+	byHash := make(map[string]reputationRecord, len(records))
+	for _, rec := range records {
+		byHash[strings.ToLower(strings.TrimSpace(rec.SHA256))] = rec
+	}
+	return byHash, nil
+}
+
+func readReputationCSV(data []byte) ([]reputationRecord, error) {
+	rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid reputation file: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	columns := map[string]int{}
+	for i, name := range rows[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["sha256"]; !ok {
+		return nil, errors.New("invalid reputation file: header names no sha256 column")
+	}
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	var records []reputationRecord
+	for n, row := range rows[1:] {
+		rec := reputationRecord{
+			SHA256:    field(row, "sha256"),
+			FirstSeen: field(row, "first_seen"),
+			Link:      field(row, "link"),
+		}
+		for name, dest := range map[string]*int{"detections": &rec.Detections, "engines": &rec.Engines} {
+			if v := field(row, name); v != "" {
+				if *dest, err = strconv.Atoi(v); err != nil {
+					return nil, fmt.Errorf("invalid reputation file: line %d: invalid %s", n+2, name)
+				}
+			}
+		}
+		if v := field(row, "known_good"); v != "" {
+			if rec.KnownGood, err = strconv.ParseBool(v); err != nil {
+				return nil, fmt.Errorf("invalid reputation file: line %d: invalid known_good", n+2)
+			}
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
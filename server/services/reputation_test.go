@@ -0,0 +1,198 @@
+package services
+
+import (
+	"errors"
+	"krampus/server/config"
+	"krampus/server/models"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingProvider answers every lookup with a fixed reputation, counting
+// the lookups made
+type countingProvider struct {
+	lookups int
+	err     error
+}
+
+func (p *countingProvider) Name() string { return "test" }
+
+func (p *countingProvider) Lookup(hash string) (*models.Reputation, error) {
+	p.lookups++
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &models.Reputation{Found: true, Detections: 3, Engines: 70}, nil
+}
+
+// useReputationProvider installs a provider for one test
+func useReputationProvider(t *testing.T, provider ReputationProvider) {
+	t.Helper()
+	SetReputationProvider(provider)
+	t.Cleanup(func() { SetReputationProvider(nil) })
+}
+
+func TestReputation(t *testing.T) {
+	openTestDB(t)
+
+	if _, err := Reputation(testHash, false); !errors.Is(err, ErrNoReputationProvider) {
+		t.Errorf("lookup without a provider error = %v, want %v", err, ErrNoReputationProvider)
+	}
+
+	provider := &countingProvider{}
+	useReputationProvider(t, provider)
+	if _, err := Reputation("not-a-hash", false); !errors.Is(err, ErrInvalidHash) {
+		t.Errorf("lookup of a malformed hash error = %v, want %v", err, ErrInvalidHash)
+	}
+
+	// The first lookup is cached; a refresh or an expired entry looks it up
+	// again
+	steps := []struct {
+		name    string
+		refresh bool
+		ttl     time.Duration
+		lookups int
+	}{
+		{name: "first lookup", ttl: time.Hour, lookups: 1},
+		{name: "cached", ttl: time.Hour, lookups: 1},
+		{name: "refreshed", refresh: true, ttl: time.Hour, lookups: 2},
+		{name: "expired", ttl: time.Nanosecond, lookups: 3},
+	}
+	for _, step := range steps {
+		config.AppConfig.ReputationCacheTTL = step.ttl
+		r, err := Reputation(strings.ToUpper(testHash), step.refresh)
+		if err != nil {
+			t.Fatalf("%s: Reputation failed: %v", step.name, err)
+		}
+		if r.Identifier != testHash || r.Provider != "test" || r.Detections != 3 {
+			t.Errorf("%s: reputation = %+v", step.name, r)
+		}
+		if provider.lookups != step.lookups {
+			t.Errorf("%s: %d lookups, want %d", step.name, provider.lookups, step.lookups)
+		}
+	}
+
+	cached, err := CachedReputations([]string{testHash, strings.Repeat("b", 64)})
+	if err != nil {
+		t.Fatalf("CachedReputations failed: %v", err)
+	}
+	if len(cached) != 1 || cached[testHash].Engines != 70 {
+		t.Errorf("cached reputations = %+v, want the one for %s", cached, testHash)
+	}
+
+	provider.err = errors.New("rate limited")
+	if _, err := Reputation(testHash, true); !errors.Is(err, ErrReputationLookup) {
+		t.Errorf("failed lookup error = %v, want %v", err, ErrReputationLookup)
+	}
+}
+
+func TestHTTPReputationProvider(t *testing.T) {
+	otherHash := strings.Repeat("b", 64)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch strings.TrimPrefix(r.URL.Path, "/files/") {
+		case testHash:
+			w.Write([]byte(`{"detections": 5, "engines": 60, "first_seen": "2024-03-01", "link": "https://example.com/` + testHash + `"}`))
+		case otherHash:
+			w.Write([]byte(`{"first_seen": "March"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	provider := NewHTTPReputationProvider(server.URL+"/files/{sha256}", "X-API-Key", "secret")
+	r, err := provider.Lookup(testHash)
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if !r.Found || r.Detections != 5 || r.Engines != 60 || r.FirstSeen == nil || r.FirstSeen.Year() != 2024 || r.Link == nil {
+		t.Errorf("reputation = %+v", r)
+	}
+	if r, err := provider.Lookup(strings.Repeat("c", 64)); err != nil || r.Found {
+		t.Errorf("unknown hash = %+v, %v, want not found", r, err)
+	}
+	if _, err := provider.Lookup(otherHash); err == nil {
+		t.Error("lookup with an invalid first_seen succeeded")
+	}
+	if _, err := provider.Lookup("../admin"); !errors.Is(err, ErrInvalidHash) {
+		t.Errorf("lookup of a malformed hash error = %v, want %v", err, ErrInvalidHash)
+	}
+	if _, err := NewHTTPReputationProvider(server.URL+"/files/{sha256}", "X-API-Key", "wrong").Lookup(testHash); err == nil {
+		t.Error("lookup with a rejected key succeeded")
+	}
+}
+
+func TestFileReputationProvider(t *testing.T) {
+	otherHash := strings.Repeat("b", 64)
+
+	tests := []struct {
+		name string
+		data string
+		err  bool
+	}{
+		{
+			name: "JSON",
+			data: `[{"sha256": "` + strings.ToUpper(testHash) + `", "detections": 2, "engines": 10, "known_good": false},
+				{"sha256": "` + otherHash + `", "found": false}]`,
+		},
+		{
+			name: "CSV",
+			data: "SHA256,Detections,Engines,Known_Good\n" + testHash + ",2,10,false\n",
+		},
+		{name: "CSV without a hash column", data: "hash,detections\n" + testHash + ",2\n", err: true},
+		{name: "CSV with a bad count", data: "sha256,detections\n" + testHash + ",many\n", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "reputation")
+			if err := os.WriteFile(path, []byte(tt.data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			provider := NewFileReputationProvider(path)
+
+			r, err := provider.Lookup(testHash)
+			if (err != nil) != tt.err {
+				t.Fatalf("Lookup error = %v, want one: %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if !r.Found || r.Detections != 2 || r.Engines != 10 {
+				t.Errorf("reputation = %+v, want 2 of 10 detections", r)
+			}
+			if r, err := provider.Lookup(otherHash); err != nil || r.Found {
+				t.Errorf("%s = %+v, %v, want not found", otherHash, r, err)
+			}
+		})
+	}
+
+	// The file is read again once it changes
+	path := filepath.Join(t.TempDir(), "reputation.json")
+	if err := os.WriteFile(path, []byte(`[]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	provider := NewFileReputationProvider(path)
+	if r, err := provider.Lookup(testHash); err != nil || r.Found {
+		t.Fatalf("lookup in an empty file = %+v, %v", r, err)
+	}
+	if err := os.WriteFile(path, []byte(`[{"sha256": "`+testHash+`", "known_good": true}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := provider.Lookup(testHash); err != nil || !r.Found || !r.KnownGood {
+		t.Errorf("lookup after the file changed = %+v, %v, want known good", r, err)
+	}
+}
@@ -0,0 +1,834 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"krampus/server/config"
+	"krampus/server/database"
+	"krampus/server/models"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// retentionMu serializes retention runs so a scheduled and a manual run
+// can't delete the same batches
+var retentionMu sync.Mutex
+
+// retentionTarget is a table pruned by age and row count. Rows matching keep
+// are never pruned. archive copies a batch out of the database before it is
+// deleted, returning what to record in the deleting transaction, and
+// aggregate records what a batch is about to delete.
+type retentionTarget struct {
+	table      string
+	timeColumn string
+	days       int
+	maxRows    int
+	keep       string
+	archive    func(ids []interface{}) (func(tx *sql.Tx) error, error)
+	aggregate  func(tx *sql.Tx, ids string, args []interface{}) error
+}
+
+// RetentionPolicies lists the configured retention of each category
+func RetentionPolicies() []models.RetentionPolicy {
+	cfg := config.AppConfig
+	return []models.RetentionPolicy{
+		{Category: "EVENTS", Tables: []string{"events"}, Days: cfg.EventRetentionDays, MaxRows: cfg.EventRetentionMaxRows},
+		{Category: "SYNC_HISTORY", Tables: []string{"rule_reconciles", "threat_feed_runs", "retention_runs"},
+			Days: cfg.SyncHistoryRetentionDays, MaxRows: cfg.SyncHistoryRetentionMaxRows},
+		{Category: "AUDIT", Tables: []string{"rule_versions", "ruleset_revisions", "proposal_comment_edits"},
+			Days: cfg.AuditRetentionDays, MaxRows: cfg.AuditRetentionMaxRows},
+	}
+}
+
+// retentionTargets lists the tables pruned row by row; rule history is
+// pruned separately by pruneRuleHistory
+func retentionTargets() []retentionTarget {
+	cfg := config.AppConfig
+	return []retentionTarget{
+		{
+			table: "events", timeColumn: "execution_time",
+			days: cfg.EventRetentionDays, maxRows: cfg.EventRetentionMaxRows,
+			// Events attached to a proposal as evidence stay with it, and
+			// events restored from the archive stay for a while
+			keep: `id IN (SELECT CAST(reference AS INTEGER) FROM proposal_evidence WHERE evidence_type = 'EVENT')` +
+				fmt.Sprintf(` OR (restored_at IS NOT NULL AND datetime(restored_at) > datetime('now', '-%d days'))`, cfg.ArchiveRestoreDays),
+			archive:   archivePrunedEvents,
+			aggregate: aggregatePrunedEvents,
+		},
+		{
+			table: "rule_reconciles", timeColumn: "finished_at",
+			days: cfg.SyncHistoryRetentionDays, maxRows: cfg.SyncHistoryRetentionMaxRows,
+		},
+		{
+			table: "threat_feed_runs", timeColumn: "finished_at",
+			days: cfg.SyncHistoryRetentionDays, maxRows: cfg.SyncHistoryRetentionMaxRows,
+		},
+		{
+			table: "retention_runs", timeColumn: "finished_at",
+			days: cfg.SyncHistoryRetentionDays, maxRows: cfg.SyncHistoryRetentionMaxRows,
+		},
+		{
+			table: "proposal_comment_edits", timeColumn: "edited_at",
+			days: cfg.AuditRetentionDays, maxRows: cfg.AuditRetentionMaxRows,
+		},
+	}
+}
+
+// sessionCleanupInterval is how often expired sessions are cleaned up while
+// scheduled retention is off
+const sessionCleanupInterval = time.Hour
+
+// WatchRetention prunes old data and vacuums the database every interval. A
+// zero or negative interval turns scheduled retention off, leaving pruning
+// to the API; expired sessions are still cleaned up hourly.
+func WatchRetention(interval time.Duration) {
+	if interval <= 0 {
+		log.Printf("Scheduled retention is disabled (RETENTION_INTERVAL=%s); cleaning up expired sessions every %s",
+			interval, sessionCleanupInterval)
+		go func() {
+			ticker := time.NewTicker(sessionCleanupInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				if err := CleanupExpiredSessions(); err != nil {
+					log.Printf("Failed to cleanup expired sessions: %v", err)
+				}
+			}
+		}()
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			run, err := RunRetention(models.RetentionTriggerSchedule, false)
+			if err != nil {
+				log.Printf("Failed to run retention: %v", err)
+				continue
+			}
+			if run.Error != nil {
+				log.Printf("Retention run failed: %s", *run.Error)
+			}
+		}
+	}()
+}
+
+// RunRetention deletes expired sessions and the rows older or beyond the
+// configured retention, then returns free pages to the filesystem. A full
+// VACUUM runs when fullVacuum is set or VACUUM_INTERVAL has elapsed since
+// the last one. The run is recorded whether or not it succeeds; only a
+// failure to record it is returned as an error.
+func RunRetention(trigger models.RetentionTrigger, fullVacuum bool) (*models.RetentionRun, error) {
+	retentionMu.Lock()
+	defer retentionMu.Unlock()
+
+	run := &models.RetentionRun{
+		Trigger:   string(trigger),
+		Status:    "SUCCESS",
+		Pruned:    map[string]int64{},
+		Vacuum:    "NONE",
+		StartedAt: time.Now(),
+	}
+
+	if err := pruneAll(run); err != nil {
+		msg := err.Error()
+		run.Status, run.Error = "FAILED", &msg
+	}
+
+	if run.Error == nil {
+		if err := vacuumAfterRun(run, fullVacuum); err != nil {
+			msg := err.Error()
+			run.Status, run.Error = "FAILED", &msg
+		}
+	}
+
+	run.FinishedAt = time.Now()
+	if err := recordRetentionRun(run); err != nil {
+		return nil, err
+	}
+
+	total := int64(0)
+	for _, n := range run.Pruned {
+		total += n
+	}
+	if total > 0 || run.Vacuum != "NONE" {
+		log.Printf("Retention pruned %d rows, vacuum %s freed %d bytes", total, run.Vacuum, run.FreedBytes)
+	}
+	return run, nil
+}
+
+// pruneAll applies every retention policy, stopping at the first failure
+func pruneAll(run *models.RetentionRun) error {
+	if err := CleanupExpiredSessions(); err != nil {
+		return fmt.Errorf("failed to cleanup expired sessions: %w", err)
+	}
+
+	for _, target := range retentionTargets() {
+		n, err := pruneTable(target)
+		if n > 0 {
+			run.Pruned[target.table] = n
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	cfg := config.AppConfig
+	versions, revisions, err := pruneRuleHistory(cfg.AuditRetentionDays, cfg.AuditRetentionMaxRows)
+	if versions > 0 {
+		run.Pruned["rule_versions"] = versions
+	}
+	if revisions > 0 {
+		run.Pruned["ruleset_revisions"] = revisions
+	}
+	if err != nil {
+		return err
+	}
+
+	archives, err := pruneEventArchives()
+	if archives > 0 {
+		run.Pruned["event_archives"] = archives
+	}
+	return err
+}
+
+// retentionBatch returns the configured batch size and pause
+func retentionBatch() (int, time.Duration) {
+	size := config.AppConfig.RetentionBatchSize
+	if size <= 0 {
+		size = 1000
+	}
+	return size, config.AppConfig.RetentionBatchPause
+}
+
+// pruneTable deletes a target's rows older than its age limit or beyond its
+// newest maxRows, a batch per transaction with a pause between batches so
+// sync writes are never held up for long
+func pruneTable(t retentionTarget) (int64, error) {
+	if t.days <= 0 && t.maxRows <= 0 {
+		return 0, nil
+	}
+
+	var conds []string
+	var args []interface{}
+	if t.days > 0 {
+		conds = append(conds, "datetime("+t.timeColumn+") < datetime('now', ?)")
+		args = append(args, fmt.Sprintf("-%d days", t.days))
+	}
+	if t.maxRows > 0 {
+		var threshold int64
+		err := database.DB.QueryRow(
+			`SELECT id FROM `+t.table+` ORDER BY id DESC LIMIT 1 OFFSET ?`, t.maxRows,
+		).Scan(&threshold)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("failed to count %s: %w", t.table, err)
+		}
+		if err == nil {
+			conds = append(conds, "id <= ?")
+			args = append(args, threshold)
+		}
+	}
+	if len(conds) == 0 {
+		return 0, nil
+	}
+	where := "(" + strings.Join(conds, " OR ") + ")"
+	if t.keep != "" {
+		where += " AND NOT (" + t.keep + ")"
+	}
+
+	size, pause := retentionBatch()
+	var total int64
+	for {
+		n, err := pruneBatch(t, where, args, size)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n < int64(size) {
+			return total, nil
+		}
+		time.Sleep(pause)
+	}
+}
+
+// pruneBatch deletes up to size rows matching where in one transaction,
+// archiving and aggregating them first and adding them to the table's
+// retention stats. Archiving happens before the transaction begins so a
+// slow archive store doesn't hold the database.
+func pruneBatch(t retentionTarget, where string, args []interface{}, size int) (int64, error) {
+	rows, err := database.DB.Query(`SELECT id FROM `+t.table+` WHERE `+where+` ORDER BY id LIMIT ?`, append(args, size)...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to select %s to prune: %w", t.table, err)
+	}
+	var ids []interface{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan %s id: %w", t.table, err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to select %s to prune: %w", t.table, err)
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	var recordArchive func(tx *sql.Tx) error
+	if t.archive != nil {
+		if recordArchive, err = t.archive(ids); err != nil {
+			return 0, fmt.Errorf("failed to archive %s: %w", t.table, err)
+		}
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if recordArchive != nil {
+		if err := recordArchive(tx); err != nil {
+			return 0, err
+		}
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	if t.aggregate != nil {
+		if err := t.aggregate(tx, placeholders, ids); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM `+t.table+` WHERE id IN (`+placeholders+`)`, ids...); err != nil {
+		return 0, fmt.Errorf("failed to prune %s: %w", t.table, err)
+	}
+	if err := addRetentionStats(tx, t.table, int64(len(ids)), nil); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return int64(len(ids)), nil
+}
+
+// aggregatePrunedEvents adds events about to be pruned to the daily counts
+// per machine and decision. Restored events were counted when first pruned.
+func aggregatePrunedEvents(tx *sql.Tx, ids string, args []interface{}) error {
+	_, err := tx.Exec(
+		`INSERT INTO event_daily_stats (day, machine_id, decision, events)
+		 SELECT COALESCE(date(execution_time), substr(execution_time, 1, 10), ''), machine_id,
+		        COALESCE(decision, ''), COUNT(*)
+		 FROM events WHERE id IN (`+ids+`) AND restored_at IS NULL
+		 GROUP BY 1, 2, 3
+		 ON CONFLICT(day, machine_id, decision) DO UPDATE SET events = events + excluded.events`,
+		args...,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to aggregate pruned events: %w", err)
+	}
+	return nil
+}
+
+// addRetentionStats adds pruned rows to a table's retention stats, raising
+// its horizon when one is given
+func addRetentionStats(tx *sql.Tx, table string, pruned int64, horizon *int64) error {
+	_, err := tx.Exec(
+		`INSERT INTO retention_stats (table_name, pruned_rows, horizon, last_pruned_at)
+		 VALUES (?, ?, ?, ?)
+		 ON CONFLICT(table_name) DO UPDATE SET
+		   pruned_rows = pruned_rows + excluded.pruned_rows,
+		   horizon = CASE WHEN excluded.horizon IS NULL THEN horizon
+		                  ELSE MAX(COALESCE(horizon, 0), excluded.horizon) END,
+		   last_pruned_at = excluded.last_pruned_at`,
+		table, pruned, horizon, time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record retention stats: %w", err)
+	}
+	return nil
+}
+
+// pruneRuleHistory prunes ruleset revisions older than days or beyond the
+// newest maxRows. The oldest revision kept becomes the horizon: revisions
+// before it are deleted along with rule versions superseded before it, but
+// the version of each rule in force at the horizon is kept so the ruleset at
+// and after the horizon can still be rebuilt, diffed and rolled back to.
+func pruneRuleHistory(days, maxRows int) (int64, int64, error) {
+	if days <= 0 && maxRows <= 0 {
+		return 0, 0, nil
+	}
+
+	var horizon int64
+	if days > 0 {
+		err := database.DB.QueryRow(
+			`SELECT COALESCE(
+			   (SELECT MIN(id) FROM ruleset_revisions WHERE datetime(created_at) >= datetime('now', ?)),
+			   (SELECT MAX(id) FROM ruleset_revisions), 0)`,
+			fmt.Sprintf("-%d days", days),
+		).Scan(&horizon)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to find rule history horizon: %w", err)
+		}
+	}
+	if maxRows > 0 {
+		var byCount int64
+		err := database.DB.QueryRow(
+			`SELECT id FROM ruleset_revisions ORDER BY id DESC LIMIT 1 OFFSET ?`, maxRows-1,
+		).Scan(&byCount)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return 0, 0, fmt.Errorf("failed to find rule history horizon: %w", err)
+		}
+		if byCount > horizon {
+			horizon = byCount
+		}
+	}
+	if horizon <= 1 {
+		return 0, 0, nil
+	}
+
+	versions, err := pruneHistoryBatches("rule_versions", horizon,
+		`DELETE FROM rule_versions WHERE id IN (
+		   SELECT v.id FROM rule_versions v
+		   WHERE v.revision < ?
+		     AND EXISTS (SELECT 1 FROM rule_versions w
+		                 WHERE w.rule_id = v.rule_id AND w.revision < ? AND w.version > v.version)
+		   LIMIT ?)`,
+		horizon, horizon,
+	)
+	if err != nil {
+		return versions, 0, err
+	}
+
+	revisions, err := pruneHistoryBatches("ruleset_revisions", horizon,
+		`DELETE FROM ruleset_revisions WHERE id IN (
+		   SELECT id FROM ruleset_revisions WHERE id < ? ORDER BY id LIMIT ?)`,
+		horizon,
+	)
+	return versions, revisions, err
+}
+
+// pruneHistoryBatches runs a rule history delete, which takes its own
+// arguments followed by a batch size, until it deletes less than a batch
+func pruneHistoryBatches(table string, horizon int64, query string, args ...interface{}) (int64, error) {
+	size, pause := retentionBatch()
+	var total int64
+	for {
+		tx, err := database.DB.Begin()
+		if err != nil {
+			return total, fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		result, err := tx.Exec(query, append(args, size)...)
+		if err != nil {
+			tx.Rollback()
+			return total, fmt.Errorf("failed to prune %s: %w", table, err)
+		}
+		n, _ := result.RowsAffected()
+		if err := addRetentionStats(tx, table, n, &horizon); err != nil {
+			tx.Rollback()
+			return total, err
+		}
+		if err := tx.Commit(); err != nil {
+			return total, fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		total += n
+		if n < int64(size) {
+			return total, nil
+		}
+		time.Sleep(pause)
+	}
+}
+
+// EnableIncrementalVacuum switches the database to incremental auto-vacuum.
+// An existing database only changes mode through a full VACUUM, which
+// rewrites the file and holds the database while it runs, so this is done
+// once at startup.
+func EnableIncrementalVacuum() error {
+	ctx := context.Background()
+	conn, err := database.DB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open database connection: %w", err)
+	}
+	defer conn.Close()
+
+	var mode int
+	if err := conn.QueryRowContext(ctx, `PRAGMA auto_vacuum`).Scan(&mode); err != nil {
+		return fmt.Errorf("failed to read auto_vacuum mode: %w", err)
+	}
+	if mode == 2 {
+		return nil
+	}
+
+	log.Println("Switching database to incremental auto-vacuum; this rewrites the database file once")
+	if _, err := conn.ExecContext(ctx, `PRAGMA auto_vacuum = INCREMENTAL`); err != nil {
+		return fmt.Errorf("failed to set auto_vacuum mode: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, `VACUUM`); err != nil {
+		return fmt.Errorf("failed to vacuum database: %w", err)
+	}
+	return nil
+}
+
+// vacuumAfterRun runs a full VACUUM when requested or due, and otherwise
+// returns free pages with an incremental vacuum when the database is in
+// incremental mode
+func vacuumAfterRun(run *models.RetentionRun, fullVacuum bool) error {
+	before, err := freeBytes()
+	if err != nil {
+		return err
+	}
+
+	if !fullVacuum && config.AppConfig.VacuumInterval > 0 {
+		last, err := lastFullVacuum()
+		if err != nil {
+			return err
+		}
+		fullVacuum = last == nil || time.Since(*last) >= config.AppConfig.VacuumInterval
+	}
+
+	switch {
+	case fullVacuum:
+		if _, err := database.DB.Exec(`VACUUM`); err != nil {
+			return fmt.Errorf("failed to vacuum database: %w", err)
+		}
+		run.Vacuum = "FULL"
+	case before > 0:
+		var mode int
+		if err := database.DB.QueryRow(`PRAGMA auto_vacuum`).Scan(&mode); err != nil {
+			return fmt.Errorf("failed to read auto_vacuum mode: %w", err)
+		}
+		if mode != 2 {
+			return nil
+		}
+		// The pragma frees a page per step, so its rows are read to the end
+		rows, err := database.DB.Query(`PRAGMA incremental_vacuum`)
+		if err != nil {
+			return fmt.Errorf("failed to run incremental vacuum: %w", err)
+		}
+		for rows.Next() {
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to run incremental vacuum: %w", err)
+		}
+		run.Vacuum = "INCREMENTAL"
+	default:
+		return nil
+	}
+
+	after, err := freeBytes()
+	if err != nil {
+		return err
+	}
+	if before > after {
+		run.FreedBytes = before - after
+	}
+	return nil
+}
+
+// freeBytes returns the size of the pages on the database's freelist
+func freeBytes() (int64, error) {
+	var pageSize, freePages int64
+	if err := database.DB.QueryRow(`PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return 0, fmt.Errorf("failed to read page size: %w", err)
+	}
+	if err := database.DB.QueryRow(`PRAGMA freelist_count`).Scan(&freePages); err != nil {
+		return 0, fmt.Errorf("failed to read freelist count: %w", err)
+	}
+	return pageSize * freePages, nil
+}
+
+// lastFullVacuum returns when retention last ran a full VACUUM
+func lastFullVacuum() (*time.Time, error) {
+	var last sql.NullTime
+	err := database.DB.QueryRow(
+		`SELECT finished_at FROM retention_runs WHERE vacuum = 'FULL' AND status = 'SUCCESS'
+		 ORDER BY id DESC LIMIT 1`,
+	).Scan(&last)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to fetch last vacuum: %w", err)
+	}
+	if !last.Valid {
+		return nil, nil
+	}
+	return &last.Time, nil
+}
+
+// recordRetentionRun stores a retention run
+func recordRetentionRun(run *models.RetentionRun) error {
+	pruned, err := json.Marshal(run.Pruned)
+	if err != nil {
+		return fmt.Errorf("failed to encode pruned rows: %w", err)
+	}
+
+	result, err := database.DB.Exec(
+		`INSERT INTO retention_runs (trigger, status, pruned, vacuum, freed_bytes, error, started_at, finished_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		run.Trigger, run.Status, string(pruned), run.Vacuum, run.FreedBytes, run.Error, run.StartedAt, run.FinishedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record retention run: %w", err)
+	}
+	run.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// ListRetentionRuns returns the most recent retention runs
+func ListRetentionRuns(limit int) ([]models.RetentionRun, error) {
+	rows, err := database.DB.Query(
+		`SELECT id, trigger, status, pruned, vacuum, freed_bytes, error, started_at, finished_at
+		 FROM retention_runs ORDER BY id DESC LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query retention runs: %w", err)
+	}
+	defer rows.Close()
+
+	runs := []models.RetentionRun{}
+	for rows.Next() {
+		var run models.RetentionRun
+		var pruned string
+		if err := rows.Scan(&run.ID, &run.Trigger, &run.Status, &pruned, &run.Vacuum, &run.FreedBytes,
+			&run.Error, &run.StartedAt, &run.FinishedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan retention run: %w", err)
+		}
+		if err := json.Unmarshal([]byte(pruned), &run.Pruned); err != nil {
+			return nil, fmt.Errorf("failed to decode pruned rows: %w", err)
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// StorageUsage reports the database's size, each table's share of it and
+// the state of retention
+func StorageUsage() (*models.StorageReport, error) {
+	report := &models.StorageReport{
+		Path:     config.AppConfig.DatabasePath,
+		Tables:   []models.TableStorage{},
+		Policies: RetentionPolicies(),
+	}
+	if store := archiveStore; store != nil {
+		report.ArchiveStore = store.Name()
+	}
+
+	var mode int
+	for _, pragma := range []struct {
+		name string
+		dest interface{}
+	}{
+		{"page_size", &report.PageSize},
+		{"page_count", &report.PageCount},
+		{"freelist_count", &report.FreePages},
+		{"auto_vacuum", &mode},
+	} {
+		if err := database.DB.QueryRow(`PRAGMA ` + pragma.name).Scan(pragma.dest); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", pragma.name, err)
+		}
+	}
+	report.SizeBytes = report.PageSize * report.PageCount
+	report.FreeBytes = report.PageSize * report.FreePages
+	report.AutoVacuum = []string{"NONE", "FULL", "INCREMENTAL"}[mode%3]
+
+	tables, err := tableSizes()
+	if err != nil {
+		return nil, err
+	}
+	report.Tables = tables
+
+	if report.RecentRuns, err = ListRetentionRuns(10); err != nil {
+		return nil, err
+	}
+	if report.LastVacuum, err = lastFullVacuum(); err != nil {
+		return nil, err
+	}
+	if interval := config.AppConfig.VacuumInterval; interval > 0 {
+		next := time.Now()
+		if report.LastVacuum != nil && report.LastVacuum.Add(interval).After(next) {
+			next = report.LastVacuum.Add(interval)
+		}
+		report.NextVacuum = &next
+	}
+	return report, nil
+}
+
+// tableSizes counts the rows and bytes of every table, largest first. Bytes
+// come from SQLite's dbstat table when it is compiled in and are otherwise
+// estimated.
+func tableSizes() ([]models.TableStorage, error) {
+	rows, err := database.DB.Query(
+		`SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan table name: %w", err)
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+
+	stats, err := retentionStats()
+	if err != nil {
+		return nil, err
+	}
+	pages, indexPages, haveDBStat := dbstatSizes()
+
+	tables := make([]models.TableStorage, 0, len(names))
+	for _, name := range names {
+		t := stats[name]
+		t.Name = name
+		if err := database.DB.QueryRow(`SELECT COUNT(*) FROM "` + name + `"`).Scan(&t.Rows); err != nil {
+			return nil, fmt.Errorf("failed to count %s: %w", name, err)
+		}
+		if haveDBStat {
+			t.Bytes, t.IndexBytes = pages[name], indexPages[name]
+		} else if t.Bytes, err = estimateTableBytes(name, t.Rows); err != nil {
+			return nil, err
+		} else {
+			t.Estimated = true
+		}
+		tables = append(tables, t)
+	}
+
+	sort.SliceStable(tables, func(i, j int) bool {
+		return tables[i].Bytes+tables[i].IndexBytes > tables[j].Bytes+tables[j].IndexBytes
+	})
+	return tables, nil
+}
+
+// retentionStats returns what retention has pruned from each table
+func retentionStats() (map[string]models.TableStorage, error) {
+	rows, err := database.DB.Query(`SELECT table_name, pruned_rows, horizon, last_pruned_at FROM retention_stats`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query retention stats: %w", err)
+	}
+	defer rows.Close()
+
+	stats := map[string]models.TableStorage{}
+	for rows.Next() {
+		var name string
+		var t models.TableStorage
+		if err := rows.Scan(&name, &t.PrunedRows, &t.Horizon, &t.LastPrunedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan retention stats: %w", err)
+		}
+		stats[name] = t
+	}
+	return stats, rows.Err()
+}
+
+// dbstatSizes sums the bytes of each table and of its indexes from SQLite's
+// dbstat table, reporting false when it isn't available in this build
+func dbstatSizes() (map[string]int64, map[string]int64, bool) {
+	rows, err := database.DB.Query(
+		`SELECT m.tbl_name, m.type, SUM(s.pgsize)
+		 FROM dbstat s JOIN sqlite_master m ON m.name = s.name
+		 GROUP BY m.tbl_name, m.type`,
+	)
+	if err != nil {
+		return nil, nil, false
+	}
+	defer rows.Close()
+
+	tables, indexes := map[string]int64{}, map[string]int64{}
+	for rows.Next() {
+		var name, kind string
+		var bytes int64
+		if err := rows.Scan(&name, &kind, &bytes); err != nil {
+			return nil, nil, false
+		}
+		if kind == "index" {
+			indexes[name] += bytes
+		} else {
+			tables[name] += bytes
+		}
+	}
+	return tables, indexes, rows.Err() == nil
+}
+
+// estimateTableBytes estimates a table's size from the average payload of up
+// to 1000 of its rows
+func estimateTableBytes(table string, count int64) (int64, error) {
+	if count == 0 {
+		return 0, nil
+	}
+
+	rows, err := database.DB.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read columns of %s: %w", table, err)
+	}
+	var lengths []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan column of %s: %w", table, err)
+		}
+		lengths = append(lengths, `COALESCE(LENGTH("`+column+`"), 0)`)
+	}
+	rows.Close()
+	if len(lengths) == 0 {
+		return 0, nil
+	}
+
+	var average float64
+	err = database.DB.QueryRow(
+		`SELECT COALESCE(AVG(` + strings.Join(lengths, " + ") + `), 0) FROM (SELECT * FROM "` + table + `" LIMIT 1000)`,
+	).Scan(&average)
+	if err != nil {
+		return 0, fmt.Errorf("failed to estimate size of %s: %w", table, err)
+	}
+	return int64(average * float64(count)), nil
+}
+
+// ListEventDailyStats returns the daily counts of pruned events, newest
+// first, optionally for one machine and a range of days (YYYY-MM-DD)
+func ListEventDailyStats(machineID, since, until string) ([]models.EventDailyStat, error) {
+	query := `SELECT day, machine_id, decision, events FROM event_daily_stats WHERE 1 = 1`
+	var args []interface{}
+	if machineID != "" {
+		query += ` AND machine_id = ?`
+		args = append(args, machineID)
+	}
+	if since != "" {
+		query += ` AND day >= ?`
+		args = append(args, since)
+	}
+	if until != "" {
+		query += ` AND day <= ?`
+		args = append(args, until)
+	}
+	query += ` ORDER BY day DESC, machine_id, decision`
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query event daily stats: %w", err)
+	}
+	defer rows.Close()
+
+	stats := []models.EventDailyStat{}
+	for rows.Next() {
+		var s models.EventDailyStat
+		if err := rows.Scan(&s.Day, &s.MachineID, &s.Decision, &s.Events); err != nil {
+			return nil, fmt.Errorf("failed to scan event daily stat: %w", err)
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
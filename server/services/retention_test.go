@@ -0,0 +1,127 @@
+package services
+
+import (
+	"database/sql"
+	"krampus/server/config"
+	"krampus/server/database"
+	"krampus/server/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPruneRuleHistory(t *testing.T) {
+	const other = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+
+	tests := []struct {
+		name          string
+		days          int
+		maxRows       int
+		wantVersions  int64
+		wantRevisions int64
+		wantHorizon   int64
+	}{
+		{name: "disabled"},
+		{name: "fewer revisions than the limit", maxRows: 10},
+		{name: "nothing older than the age limit", days: 1},
+		{name: "keep the newest three", maxRows: 3, wantVersions: 1, wantRevisions: 2, wantHorizon: 3},
+		{name: "keep the newest two", maxRows: 2, wantVersions: 1, wantRevisions: 3, wantHorizon: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			mustExec(t, `INSERT INTO users (id, username, role) VALUES (1, 'admin', 'ADMIN')`)
+
+			// Revisions 1-5: A created, A updated, B created, A updated, B deleted
+			allow, block := string(models.PolicyAllowlist), string(models.PolicyBlocklist)
+			a := createTestRule(t, models.Rule{Identifier: testHash, RuleType: string(models.RuleTypeBinary), Policy: allow})
+			if _, _, err := UpdateRule(a, RuleUpdate{Policy: &block}, 1, nil); err != nil {
+				t.Fatal(err)
+			}
+			b := createTestRule(t, models.Rule{Identifier: other, RuleType: string(models.RuleTypeBinary), Policy: allow})
+			if _, _, err := UpdateRule(a, RuleUpdate{Policy: &allow}, 1, nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := DeleteRule(b, 1, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			before := make(map[int64][]models.RulesetEntry)
+			for rev := int64(1); rev <= 5; rev++ {
+				entries, err := rulesetAt(database.DB, rev)
+				if err != nil {
+					t.Fatal(err)
+				}
+				before[rev] = entries
+			}
+
+			versions, revisions, err := pruneRuleHistory(tt.days, tt.maxRows)
+			if err != nil {
+				t.Fatalf("pruneRuleHistory: %v", err)
+			}
+			if versions != tt.wantVersions || revisions != tt.wantRevisions {
+				t.Errorf("pruned %d versions and %d revisions, want %d and %d",
+					versions, revisions, tt.wantVersions, tt.wantRevisions)
+			}
+
+			var horizon sql.NullInt64
+			err = database.DB.QueryRow(
+				`SELECT horizon FROM retention_stats WHERE table_name = 'rule_versions'`,
+			).Scan(&horizon)
+			if err != nil && err != sql.ErrNoRows {
+				t.Fatal(err)
+			}
+			if horizon.Int64 != tt.wantHorizon {
+				t.Errorf("horizon = %d, want %d", horizon.Int64, tt.wantHorizon)
+			}
+
+			// The ruleset at and after the horizon must still rebuild as before
+			for rev := max(tt.wantHorizon, 1); rev <= 5; rev++ {
+				entries, err := rulesetAt(database.DB, rev)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(entries, before[rev]) {
+					t.Errorf("ruleset at revision %d = %+v, want %+v", rev, entries, before[rev])
+				}
+			}
+		})
+	}
+}
+
+func TestRunRetention(t *testing.T) {
+	openTestDB(t)
+	config.AppConfig.EventRetentionMaxRows = 3
+	mustExec(t, `INSERT INTO users (id, username, role) VALUES (1, 'admin', 'ADMIN')`)
+	mustExec(t, `INSERT INTO sessions (user_id, token_hash, expires_at) VALUES (1, 'expired', ?), (1, 'current', ?)`,
+		time.Now().UTC().Add(-time.Hour), time.Now().UTC().Add(time.Hour))
+	for i := 0; i < 5; i++ {
+		mustExec(t, `INSERT INTO events (machine_id, file_hash, decision, execution_time) VALUES ('m1', ?, 'ALLOW_UNKNOWN', ?)`,
+			testHash, time.Now().Add(-time.Duration(i)*time.Hour))
+	}
+
+	run, err := RunRetention(models.RetentionTriggerManual, false)
+	if err != nil {
+		t.Fatalf("RunRetention failed: %v", err)
+	}
+	if run.Status != "SUCCESS" || run.Pruned["events"] != 2 {
+		t.Errorf("run is %s, pruned %v, want SUCCESS with 2 events", run.Status, run.Pruned)
+	}
+
+	var events, sessions int
+	err = database.DB.QueryRow(`SELECT (SELECT COUNT(*) FROM events), (SELECT COUNT(*) FROM sessions)`).Scan(&events, &sessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events != 3 || sessions != 1 {
+		t.Errorf("%d events and %d sessions left, want 3 and 1", events, sessions)
+	}
+}
+
+func TestWatchRetentionDisabled(t *testing.T) {
+	// A zero or negative interval turns scheduled retention off instead of
+	// panicking in the ticker
+	WatchRetention(0)
+	WatchRetention(-time.Hour)
+}
@@ -0,0 +1,634 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"krampus/server/database"
+	"krampus/server/models"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrRevisionNotFound = errors.New("ruleset revision not found")
+	ErrRulesetUnchanged = errors.New("ruleset already matches the revision")
+)
+
+// rulesetRevision is the ruleset revision a transaction records its rule
+// versions under. Each transaction that changes rules starts its own; the
+// revision is only written on the first change, so a transaction that ends up
+// changing nothing records none.
+type rulesetRevision struct {
+	changedBy *int64
+	reason    *string
+	id        int64
+}
+
+// newRulesetRevision starts the revision for one transaction's rule changes
+func newRulesetRevision(changedBy *int64, reason *string) *rulesetRevision {
+	return &rulesetRevision{changedBy: changedBy, reason: reason}
+}
+
+// open returns the revision's ID, recording the revision in tx first if no
+// rule version has been recorded under it yet
+func (r *rulesetRevision) open(tx *sql.Tx) (int64, error) {
+	if r.id != 0 {
+		return r.id, nil
+	}
+	result, err := tx.Exec(
+		`INSERT INTO ruleset_revisions (changed_by, reason) VALUES (?, ?)`, r.changedBy, r.reason,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record ruleset revision: %w", err)
+	}
+	r.id, _ = result.LastInsertId()
+	return r.id, nil
+}
+
+// countRevisionChange adds a rule version to its revision's totals
+func countRevisionChange(tx *sql.Tx, revision int64) error {
+	_, err := tx.Exec(
+		`UPDATE ruleset_revisions
+		 SET changes = changes + 1, rule_count = (SELECT COUNT(*) FROM rules WHERE `+ActiveRule+`)
+		 WHERE id = ?`,
+		revision,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update ruleset revision: %w", err)
+	}
+	return nil
+}
+
+// CurrentRulesetRevision returns the latest ruleset revision, or 0 before
+// any rule has been written
+func CurrentRulesetRevision() (int64, error) {
+	var revision int64
+	err := database.DB.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM ruleset_revisions`).Scan(&revision)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch ruleset revision: %w", err)
+	}
+	return revision, nil
+}
+
+const revisionSelect = `
+	SELECT r.id, r.changed_by, u.username, r.reason, r.rolled_back_to, r.changes, r.rule_count, r.created_at
+	FROM ruleset_revisions r
+	LEFT JOIN users u ON r.changed_by = u.id
+`
+
+func scanRevision(row interface{ Scan(...interface{}) error }, r *models.RulesetRevision) error {
+	return row.Scan(&r.Revision, &r.ChangedBy, &r.ChangedByUsername, &r.Reason, &r.RolledBackTo,
+		&r.Changes, &r.RuleCount, &r.CreatedAt)
+}
+
+// ListRulesetRevisions returns recent ruleset revisions, newest first
+func ListRulesetRevisions(limit int) ([]models.RulesetRevision, error) {
+	rows, err := database.DB.Query(revisionSelect+` ORDER BY r.id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ruleset revisions: %w", err)
+	}
+	defer rows.Close()
+
+	revisions := []models.RulesetRevision{}
+	for rows.Next() {
+		var r models.RulesetRevision
+		if err := scanRevision(rows, &r); err != nil {
+			return nil, fmt.Errorf("failed to scan ruleset revision: %w", err)
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, rows.Err()
+}
+
+// GetRulesetRevision returns a revision with the ruleset as of it
+func GetRulesetRevision(revision int64) (*models.RulesetRevision, error) {
+	var r models.RulesetRevision
+	err := scanRevision(database.DB.QueryRow(revisionSelect+` WHERE r.id = ?`, revision), &r)
+	if err == sql.ErrNoRows {
+		return nil, ErrRevisionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch ruleset revision: %w", err)
+	}
+
+	r.Rules, err = rulesetAt(database.DB, revision)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// revisionExists reports whether a revision has been recorded
+func revisionExists(q queryer, revision int64) error {
+	var count int
+	if err := q.QueryRow(`SELECT COUNT(*) FROM ruleset_revisions WHERE id = ?`, revision).Scan(&count); err != nil {
+		return fmt.Errorf("failed to fetch ruleset revision: %w", err)
+	}
+	if count == 0 {
+		return ErrRevisionNotFound
+	}
+	return nil
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// rulesetAt rebuilds the active ruleset as of a revision from the latest
+// version of every rule recorded up to it
+func rulesetAt(q queryer, revision int64) ([]models.RulesetEntry, error) {
+	rows, err := q.Query(
+		`SELECT v.rule_id, v.identifier, v.policy, v.rule_type, v.cel_expr, v.custom_message, v.custom_url,
+		        v.owner_team, v.comment, v.proposal_id, v.group_id, v.expires_at
+		 FROM rule_versions v
+		 WHERE v.revision <= ? AND v.action NOT IN ('DELETE', 'SUPERSEDE')
+		   AND v.version = (SELECT MAX(w.version) FROM rule_versions w
+		                    WHERE w.rule_id = v.rule_id AND w.revision <= ?)
+		 ORDER BY v.rule_type, v.identifier`,
+		revision, revision,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rule versions: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []models.RulesetEntry{}
+	for rows.Next() {
+		var e models.RulesetEntry
+		if err := rows.Scan(&e.RuleID, &e.Identifier, &e.Policy, &e.RuleType, &e.CELExpr, &e.CustomMessage, &e.CustomURL,
+			&e.OwnerTeam, &e.Comment, &e.ProposalID, &e.GroupID, &e.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan rule version: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// entryRule converts a ruleset entry for comparison with diffRules
+func entryRule(e models.RulesetEntry) models.Rule {
+	return models.Rule{
+		ID:            e.RuleID,
+		Identifier:    e.Identifier,
+		Policy:        e.Policy,
+		RuleType:      e.RuleType,
+		CELExpr:       e.CELExpr,
+		CustomMessage: e.CustomMessage,
+		CustomURL:     e.CustomURL,
+		OwnerTeam:     e.OwnerTeam,
+		Comment:       e.Comment,
+		ProposalID:    e.ProposalID,
+		GroupID:       e.GroupID,
+		ExpiresAt:     e.ExpiresAt,
+	}
+}
+
+// ruleEntry converts an active rule to a ruleset entry
+func ruleEntry(r models.Rule) models.RulesetEntry {
+	return models.RulesetEntry{
+		RuleID:        r.ID,
+		Identifier:    r.Identifier,
+		Policy:        r.Policy,
+		RuleType:      r.RuleType,
+		CELExpr:       r.CELExpr,
+		CustomMessage: r.CustomMessage,
+		CustomURL:     r.CustomURL,
+		OwnerTeam:     r.OwnerTeam,
+		Comment:       r.Comment,
+		ProposalID:    r.ProposalID,
+		GroupID:       r.GroupID,
+		ExpiresAt:     r.ExpiresAt,
+	}
+}
+
+// settingsDiff lists the settings that differ between two rules for the same
+// target. Provenance such as the proposal is not part of the ruleset.
+func settingsDiff(from, to models.Rule) map[string]models.FieldChange {
+	diff := diffRules(from, to)
+	delete(diff, "proposal_id")
+	return diff
+}
+
+// ruleScope identifies a target within a scope: a group may hold its own
+// rule for a target alongside the fleet-wide one
+type ruleScope struct {
+	models.RuleTarget
+	GroupID int64 // 0 for fleet-wide rules
+}
+
+func scopeOf(identifier, ruleType string, groupID *int64) ruleScope {
+	scope := ruleScope{RuleTarget: models.RuleTarget{Identifier: identifier, RuleType: ruleType}}
+	if groupID != nil {
+		scope.GroupID = *groupID
+	}
+	return scope
+}
+
+// diffRulesets compares two rulesets by target and scope; a rule moved to
+// another scope is removed from one and added to the other
+func diffRulesets(from, to []models.RulesetEntry) models.RulesetDiff {
+	d := models.RulesetDiff{
+		Added:   []models.RulesetEntry{},
+		Removed: []models.RulesetEntry{},
+		Changed: []models.RulesetChange{},
+	}
+
+	before := map[ruleScope]models.RulesetEntry{}
+	for _, e := range from {
+		before[scopeOf(e.Identifier, e.RuleType, e.GroupID)] = e
+	}
+	for _, e := range to {
+		scope := scopeOf(e.Identifier, e.RuleType, e.GroupID)
+		old, ok := before[scope]
+		if !ok {
+			d.Added = append(d.Added, e)
+			continue
+		}
+		delete(before, scope)
+		if diff := settingsDiff(entryRule(old), entryRule(e)); len(diff) > 0 {
+			d.Changed = append(d.Changed, models.RulesetChange{
+				Identifier: e.Identifier, RuleType: e.RuleType, GroupID: e.GroupID, Diff: diff,
+			})
+		}
+	}
+	for _, e := range before {
+		d.Removed = append(d.Removed, e)
+	}
+	sort.Slice(d.Removed, func(i, j int) bool {
+		if d.Removed[i].RuleType != d.Removed[j].RuleType {
+			return d.Removed[i].RuleType < d.Removed[j].RuleType
+		}
+		return d.Removed[i].Identifier < d.Removed[j].Identifier
+	})
+	return d
+}
+
+// DiffRulesetRevisions compares the ruleset at two revisions. A zero to
+// compares against the current revision.
+func DiffRulesetRevisions(from, to int64) (*models.RulesetDiff, error) {
+	if to == 0 {
+		current, err := CurrentRulesetRevision()
+		if err != nil {
+			return nil, err
+		}
+		to = current
+	}
+	for _, revision := range []int64{from, to} {
+		if err := revisionExists(database.DB, revision); err != nil {
+			return nil, err
+		}
+	}
+
+	before, err := rulesetAt(database.DB, from)
+	if err != nil {
+		return nil, err
+	}
+	after, err := rulesetAt(database.DB, to)
+	if err != nil {
+		return nil, err
+	}
+
+	d := diffRulesets(before, after)
+	d.From, d.To = from, to
+	return &d, nil
+}
+
+// RollbackRuleset returns the ruleset to the state it had at an earlier
+// revision in one transaction, recorded as a new revision. Rules the rules
+// directory manages are left alone and reported as skipped.
+func RollbackRuleset(revision, changedBy int64, reason *string) (*models.RulesetRollback, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := revisionExists(tx, revision); err != nil {
+		return nil, err
+	}
+	var current int64
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM ruleset_revisions`).Scan(&current); err != nil {
+		return nil, fmt.Errorf("failed to fetch ruleset revision: %w", err)
+	}
+	target, err := rulesetAt(tx, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`SELECT ` + RuleColumns + ` FROM rules WHERE ` + ActiveRule)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rules: %w", err)
+	}
+	active := map[ruleScope]models.Rule{}
+	var entries []models.RulesetEntry
+	for rows.Next() {
+		var r models.Rule
+		if err := ScanRule(rows, &r); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan rule: %w", err)
+		}
+		active[scopeOf(r.Identifier, r.RuleType, r.GroupID)] = r
+		entries = append(entries, ruleEntry(r))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rules: %w", err)
+	}
+
+	diff := diffRulesets(entries, target)
+	if len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Changed) == 0 {
+		return nil, ErrRulesetUnchanged
+	}
+
+	note := fmt.Sprintf("Rolled back to revision %d", revision)
+	if reason != nil && *reason != "" {
+		note += ": " + *reason
+	}
+	rollback := newRulesetRevision(&changedBy, &note)
+	rollbackID, err := rollback.open(tx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`UPDATE ruleset_revisions SET rolled_back_to = ? WHERE id = ?`, revision, rollbackID); err != nil {
+		return nil, fmt.Errorf("failed to update ruleset revision: %w", err)
+	}
+
+	report := &models.RulesetRollback{
+		RulesetDiff: models.RulesetDiff{
+			From:    current,
+			To:      revision,
+			Added:   []models.RulesetEntry{},
+			Removed: []models.RulesetEntry{},
+			Changed: []models.RulesetChange{},
+		},
+		Revision: rollbackID,
+		Skipped:  []models.RulesetEntry{},
+	}
+	managed := func(scope ruleScope) bool {
+		r, ok := active[scope]
+		return ok && readOnlyRule(r)
+	}
+
+	for _, e := range diff.Removed {
+		if managed(scopeOf(e.Identifier, e.RuleType, e.GroupID)) {
+			report.Skipped = append(report.Skipped, e)
+			continue
+		}
+		if err := deleteRule(tx, rollback, e.RuleID, &changedBy, &note); err != nil {
+			return nil, err
+		}
+		report.Removed = append(report.Removed, e)
+	}
+
+	wanted := map[ruleScope]models.RulesetEntry{}
+	for _, e := range target {
+		wanted[scopeOf(e.Identifier, e.RuleType, e.GroupID)] = e
+	}
+	for _, change := range diff.Changed {
+		scope := scopeOf(change.Identifier, change.RuleType, change.GroupID)
+		e := wanted[scope]
+		if managed(scope) {
+			report.Skipped = append(report.Skipped, e)
+			continue
+		}
+		update := RuleUpdate{
+			Policy:        &e.Policy,
+			CELExpr:       clearableString(e.CELExpr),
+			CustomMessage: clearableString(e.CustomMessage),
+			CustomURL:     clearableString(e.CustomURL),
+			OwnerTeam:     clearableString(e.OwnerTeam),
+			Comment:       clearableString(e.Comment),
+			GroupID:       new(int64),
+			ExpiresAt:     &time.Time{},
+		}
+		if e.GroupID != nil {
+			update.GroupID = e.GroupID
+		}
+		if e.ExpiresAt != nil {
+			update.ExpiresAt = e.ExpiresAt
+		}
+		if _, _, err := updateRule(tx, rollback, active[scope], update, &changedBy, &note); err != nil {
+			return nil, err
+		}
+		report.Changed = append(report.Changed, change)
+	}
+
+	for _, e := range diff.Added {
+		rule := models.Rule{
+			Identifier:    e.Identifier,
+			Policy:        e.Policy,
+			RuleType:      e.RuleType,
+			CELExpr:       e.CELExpr,
+			CustomMessage: e.CustomMessage,
+			CustomURL:     e.CustomURL,
+			OwnerTeam:     e.OwnerTeam,
+			Comment:       e.Comment,
+			CreatedBy:     &changedBy,
+			ProposalID:    e.ProposalID,
+			GroupID:       e.GroupID,
+			ExpiresAt:     e.ExpiresAt,
+		}
+		if _, err := insertRule(tx, rollback, rule, &changedBy, &note, false); err != nil {
+			return nil, err
+		}
+		report.Added = append(report.Added, e)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return report, nil
+}
+
+// clearableString returns a value RuleUpdate treats as "set to s", using the
+// empty string to clear
+func clearableString(s *string) *string {
+	if s == nil {
+		return new(string)
+	}
+	return s
+}
+
+// NeedsCleanSync reports whether a machine must drop its rules and download
+// the ruleset afresh: Santa only adds and updates rules on a normal sync, so
+// a target the machine held when it last downloaded would otherwise linger
+// on it once no rule reaches it any more, whether the rule was deleted,
+// expired, moved out of the machine's groups, superseded by another group's
+// rule or the machine left the group. A machine that last downloaded a
+// revision before the rule history horizon always needs one, as the ruleset
+// it has can no longer be rebuilt, and so does one that synced before
+// downloads were recorded.
+func NeedsCleanSync(machineID string) (bool, error) {
+	var revision sql.NullInt64
+	var downloaded sql.NullTime
+	var groupList, lastSync sql.NullString
+	err := database.DB.QueryRow(
+		`SELECT last_rule_revision, last_rule_download, last_rule_groups, last_sync FROM machines WHERE machine_id = ?`,
+		machineID,
+	).Scan(&revision, &downloaded, &groupList, &lastSync)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch machine: %w", err)
+	}
+	if !revision.Valid {
+		return lastSync.Valid, nil
+	}
+
+	var pruned bool
+	err = database.DB.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM retention_stats WHERE table_name = 'rule_versions' AND horizon > ?)`,
+		revision.Int64,
+	).Scan(&pruned)
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch rule history horizon: %w", err)
+	}
+	if pruned {
+		return true, nil
+	}
+
+	groups, err := machineGroupIDs(machineID)
+	if err != nil {
+		return false, err
+	}
+	groupsThen := groups
+	if groupList.Valid {
+		groupsThen = parseGroupIDs(groupList.String)
+	}
+	now := time.Now()
+	downloadedAt := now
+	if downloaded.Valid {
+		downloadedAt = downloaded.Time
+	}
+
+	// Nothing can have left the machine while the ruleset, its groups and
+	// the rules in force are as they were
+	if formatGroupIDs(groups) == formatGroupIDs(groupsThen) {
+		var changed bool
+		err := database.DB.QueryRow(
+			`SELECT COALESCE((SELECT MAX(id) FROM ruleset_revisions), 0) != ?
+			     OR EXISTS (SELECT 1 FROM rules WHERE `+ActiveRule+` AND expires_at > ? AND expires_at <= ?)`,
+			revision.Int64, downloadedAt.UTC(), now.UTC(),
+		).Scan(&changed)
+		if err != nil {
+			return false, fmt.Errorf("failed to check for ruleset changes: %w", err)
+		}
+		if !changed {
+			return false, nil
+		}
+	}
+
+	entries, err := rulesetAt(database.DB, revision.Int64)
+	if err != nil {
+		return false, err
+	}
+	held := make([]models.Rule, 0, len(entries))
+	for _, e := range entries {
+		held = append(held, entryRule(e))
+	}
+	if err := AttachRollouts(held); err != nil {
+		return false, err
+	}
+
+	rows, err := database.DB.Query(`SELECT ` + RuleColumns + ` FROM rules WHERE ` + ActiveRule)
+	if err != nil {
+		return false, fmt.Errorf("failed to query rules: %w", err)
+	}
+	defer rows.Close()
+	var active []models.Rule
+	for rows.Next() {
+		var r models.Rule
+		if err := ScanRule(rows, &r); err != nil {
+			return false, fmt.Errorf("failed to scan rule: %w", err)
+		}
+		active = append(active, r)
+	}
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("failed to read rules: %w", err)
+	}
+	if err := AttachRollouts(active); err != nil {
+		return false, err
+	}
+
+	return len(lingeringTargets(
+		deliveredTargets(held, groupsThen, downloadedAt),
+		deliveredTargets(active, groups, now),
+	)) > 0, nil
+}
+
+// deliveredTargets lists the targets at least one of the rules reaches on a
+// machine in the given groups at a time. Which rule reaches a target does not
+// matter here, as the one delivered replaces whatever the machine held.
+func deliveredTargets(rules []models.Rule, groups map[int64]bool, at time.Time) map[models.RuleTarget]bool {
+	targets := map[models.RuleTarget]bool{}
+	for _, r := range rules {
+		if r.ExpiresAt != nil && !r.ExpiresAt.After(at) {
+			continue
+		}
+		if (r.GroupID != nil && !groups[*r.GroupID]) || !rolloutReaches(r, groups) {
+			continue
+		}
+		targets[models.RuleTarget{Identifier: r.Identifier, RuleType: r.RuleType}] = true
+	}
+	return targets
+}
+
+// lingeringTargets lists the targets a machine held that a normal sync no
+// longer delivers, sorted
+func lingeringTargets(held, delivered map[models.RuleTarget]bool) []models.RuleTarget {
+	var lingering []models.RuleTarget
+	for target := range held {
+		if !delivered[target] {
+			lingering = append(lingering, target)
+		}
+	}
+	sort.Slice(lingering, func(i, j int) bool {
+		if lingering[i].RuleType != lingering[j].RuleType {
+			return lingering[i].RuleType < lingering[j].RuleType
+		}
+		return lingering[i].Identifier < lingering[j].Identifier
+	})
+	return lingering
+}
+
+// formatGroupIDs stores a machine's groups as a sorted, comma-separated list
+func formatGroupIDs(groups map[int64]bool) string {
+	ids := make([]string, 0, len(groups))
+	for id := range groups {
+		ids = append(ids, strconv.FormatInt(id, 10))
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+func parseGroupIDs(list string) map[int64]bool {
+	groups := map[int64]bool{}
+	for _, field := range strings.Split(list, ",") {
+		if id, err := strconv.ParseInt(field, 10, 64); err == nil {
+			groups[id] = true
+		}
+	}
+	return groups
+}
+
+// RecordRuleDownload notes the revision a machine has downloaded in full,
+// when, and the groups it was in, which together decide the rules it holds
+func RecordRuleDownload(machineID string, revision int64) error {
+	groups, err := machineGroupIDs(machineID)
+	if err != nil {
+		return err
+	}
+	_, err = database.DB.Exec(
+		`UPDATE machines SET last_rule_revision = ?, last_rule_download = ?, last_rule_groups = ? WHERE machine_id = ?`,
+		revision, time.Now().UTC(), formatGroupIDs(groups), machineID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record rule download: %w", err)
+	}
+	return nil
+}
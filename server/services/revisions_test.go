@@ -0,0 +1,246 @@
+package services
+
+import (
+	"errors"
+	"krampus/server/models"
+	"testing"
+	"time"
+)
+
+func TestNeedsCleanSync(t *testing.T) {
+	const other = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+	group := int64(7)
+	binaryRule := func(identifier string, groupID *int64) models.Rule {
+		return models.Rule{
+			Identifier: identifier,
+			RuleType:   string(models.RuleTypeBinary),
+			Policy:     string(models.PolicyBlocklist),
+			GroupID:    groupID,
+		}
+	}
+
+	tests := []struct {
+		name string
+		// before runs ahead of the machine's download and after between it
+		// and the preflight; either may be nil
+		before   func(t *testing.T)
+		download bool
+		after    func(t *testing.T)
+		want     bool
+	}{
+		{
+			name: "never synced",
+			want: false,
+		},
+		{
+			name: "synced before downloads were recorded",
+			after: func(t *testing.T) {
+				mustExec(t, `UPDATE machines SET last_sync = datetime('now') WHERE machine_id = 'm1'`)
+			},
+			want: true,
+		},
+		{
+			name:     "nothing changed",
+			before:   func(t *testing.T) { createTestRule(t, binaryRule(testHash, nil)) },
+			download: true,
+			want:     false,
+		},
+		{
+			name:     "rule added",
+			before:   func(t *testing.T) { createTestRule(t, binaryRule(testHash, nil)) },
+			download: true,
+			after:    func(t *testing.T) { createTestRule(t, binaryRule(other, nil)) },
+			want:     false,
+		},
+		{
+			name:     "rule deleted",
+			before:   func(t *testing.T) { createTestRule(t, binaryRule(testHash, nil)) },
+			download: true,
+			after: func(t *testing.T) {
+				if err := DeleteRule(1, 1, nil); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: true,
+		},
+		{
+			name: "rule expired",
+			before: func(t *testing.T) {
+				r := binaryRule(testHash, nil)
+				expiresAt := time.Now().UTC().Add(-time.Hour)
+				r.ExpiresAt = &expiresAt
+				createTestRule(t, r)
+			},
+			download: true,
+			after: func(t *testing.T) {
+				mustExec(t, `UPDATE machines SET last_rule_download = ? WHERE machine_id = 'm1'`,
+					time.Now().UTC().Add(-2*time.Hour))
+			},
+			want: true,
+		},
+		{
+			name:     "rule moved to a group the machine isn't in",
+			before:   func(t *testing.T) { createTestRule(t, binaryRule(testHash, nil)) },
+			download: true,
+			after: func(t *testing.T) {
+				if _, _, err := UpdateRule(1, RuleUpdate{GroupID: &group}, 1, nil); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: true,
+		},
+		{
+			name: "machine left the group",
+			before: func(t *testing.T) {
+				mustExec(t, `INSERT INTO machine_group_members (group_id, machine_id) VALUES (7, 'm1')`)
+				createTestRule(t, binaryRule(testHash, &group))
+			},
+			download: true,
+			after: func(t *testing.T) {
+				mustExec(t, `DELETE FROM machine_group_members WHERE machine_id = 'm1'`)
+			},
+			want: true,
+		},
+		{
+			name: "group rule replaced by the fleet-wide rule",
+			before: func(t *testing.T) {
+				mustExec(t, `INSERT INTO machine_group_members (group_id, machine_id) VALUES (7, 'm1')`)
+				createTestRule(t, binaryRule(testHash, nil))
+				createTestRule(t, binaryRule(testHash, &group))
+			},
+			download: true,
+			after: func(t *testing.T) {
+				mustExec(t, `DELETE FROM machine_group_members WHERE machine_id = 'm1'`)
+			},
+			want: false,
+		},
+		{
+			name:     "download behind the history horizon",
+			before:   func(t *testing.T) { createTestRule(t, binaryRule(testHash, nil)) },
+			download: true,
+			after: func(t *testing.T) {
+				createTestRule(t, binaryRule(other, nil))
+				mustExec(t, `INSERT INTO retention_stats (table_name, pruned_rows, horizon) VALUES ('rule_versions', 1, 2)`)
+			},
+			want: true,
+		},
+		{
+			name:     "another group's rule added",
+			before:   func(t *testing.T) { createTestRule(t, binaryRule(testHash, nil)) },
+			download: true,
+			after:    func(t *testing.T) { createTestRule(t, binaryRule(other, &group)) },
+			want:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			mustExec(t, `INSERT INTO users (id, username, role) VALUES (1, 'admin', 'ADMIN')`)
+			mustExec(t, `INSERT INTO machines (machine_id) VALUES ('m1')`)
+			mustExec(t, `INSERT INTO machine_groups (id, name) VALUES (7, 'eng')`)
+
+			if tt.before != nil {
+				tt.before(t)
+			}
+			if tt.download {
+				revision, err := CurrentRulesetRevision()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := RecordRuleDownload("m1", revision); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.after != nil {
+				tt.after(t)
+			}
+
+			got, err := NeedsCleanSync("m1")
+			if err != nil {
+				t.Fatalf("NeedsCleanSync: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("NeedsCleanSync = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRulesetRevisions(t *testing.T) {
+	openTestDB(t)
+	mustExec(t, `INSERT INTO users (id, username, role) VALUES (1, 'admin', 'ADMIN')`)
+	binary := string(models.RuleTypeBinary)
+	other := "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+
+	// Every change of one transaction shares a revision
+	entries := []RuleImportEntry{
+		{Row: 1, Identifier: testHash, RuleType: binary, Policy: string(models.PolicyBlocklist)},
+		{Row: 2, Identifier: testTeamID, RuleType: string(models.RuleTypeTeamID), Policy: string(models.PolicyAllowlist)},
+	}
+	if _, err := ImportRules(RuleFormatJSON, entries, 1, false, false); err != nil {
+		t.Fatalf("ImportRules failed: %v", err)
+	}
+	imported, err := CurrentRulesetRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	revision, err := GetRulesetRevision(imported)
+	if err != nil {
+		t.Fatalf("GetRulesetRevision failed: %v", err)
+	}
+	if revision.Changes != 2 || revision.RuleCount != 2 || len(revision.Rules) != 2 {
+		t.Errorf("import revision has %d changes, %d rules and lists %d, want 2, 2 and 2",
+			revision.Changes, revision.RuleCount, len(revision.Rules))
+	}
+
+	// A transaction that changes nothing records no revision
+	if _, err := ImportRules(RuleFormatJSON, entries, 1, false, false); err != nil {
+		t.Fatalf("ImportRules failed: %v", err)
+	}
+	if current, _ := CurrentRulesetRevision(); current != imported {
+		t.Errorf("unchanged import recorded revision %d", current)
+	}
+
+	added := createTestRule(t, testRule(0, binary, other, models.PolicyBlocklist))
+	if err := DeleteRule(1, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	latest, _ := CurrentRulesetRevision()
+	diff, err := DiffRulesetRevisions(imported, latest)
+	if err != nil {
+		t.Fatalf("DiffRulesetRevisions failed: %v", err)
+	}
+	if len(diff.Added) != 1 || diff.Added[0].RuleID != added || len(diff.Removed) != 1 || diff.Removed[0].RuleID != 1 {
+		t.Errorf("diff = %+v, want rule %d added and rule 1 removed", diff, added)
+	}
+
+	rollback, err := RollbackRuleset(imported, 1, nil)
+	if err != nil {
+		t.Fatalf("RollbackRuleset failed: %v", err)
+	}
+	if len(rollback.Added) != 1 || len(rollback.Removed) != 1 || rollback.Revision != latest+1 {
+		t.Errorf("rollback = %+v, want one rule added and one removed in revision %d", rollback, latest+1)
+	}
+	revision, err = GetRulesetRevision(rollback.Revision)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision.RolledBackTo == nil || *revision.RolledBackTo != imported || revision.Changes != 2 {
+		t.Errorf("rollback revision rolled back to %v with %d changes, want %d with 2", revision.RolledBackTo, revision.Changes, imported)
+	}
+	diff, err = DiffRulesetRevisions(imported, rollback.Revision)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Added) != 0 || len(diff.Removed) != 0 || len(diff.Changed) != 0 {
+		t.Errorf("ruleset after rollback differs from revision %d: %+v", imported, diff)
+	}
+
+	if _, err := RollbackRuleset(imported, 1, nil); !errors.Is(err, ErrRulesetUnchanged) {
+		t.Errorf("second rollback error = %v, want %v", err, ErrRulesetUnchanged)
+	}
+	if _, err := RollbackRuleset(rollback.Revision+1, 1, nil); !errors.Is(err, ErrRevisionNotFound) {
+		t.Errorf("rollback to unknown revision error = %v, want %v", err, ErrRevisionNotFound)
+	}
+}
@@ -0,0 +1,553 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"krampus/server/database"
+	"krampus/server/models"
+	"log"
+	"time"
+)
+
+var (
+	ErrRolloutNotFound  = errors.New("rollout not found")
+	ErrRolloutFinished  = errors.New("rollout has finished")
+	ErrRolloutHalted    = errors.New("rollout is halted")
+	ErrRolloutNotHalted = errors.New("rollout is not halted")
+)
+
+// RolloutPlan lists the stages a new rule is rolled out through. Each stage
+// adds one machine group; after the last stage the rule reaches the whole
+// fleet (or the group it is scoped to).
+type RolloutPlan struct {
+	Stages        []RolloutStagePlan `json:"stages"`
+	HaltThreshold *int               `json:"halt_threshold"` // Block events that halt a stage; 0 never halts
+}
+
+// RolloutStagePlan is one stage of a RolloutPlan. Without a hold time the
+// stage waits for manual promotion.
+type RolloutStagePlan struct {
+	GroupID     int64 `json:"group_id"`
+	HoldMinutes *int  `json:"hold_minutes"`
+}
+
+// rolloutPending returns the WHERE condition excluding rules of the named
+// table or alias whose unfinished rollout has not yet reached any of the
+// machine's groups. It takes the machine ID as its argument.
+func rolloutPending(rules string) string {
+	return rules + `.id NOT IN (
+		SELECT o.rule_id FROM rule_rollouts o
+		WHERE o.status IN ('ACTIVE', 'HALTED') AND NOT EXISTS (
+			SELECT 1 FROM rule_rollout_stages s
+			JOIN machine_group_members m ON m.group_id = s.group_id
+			WHERE s.rollout_id = o.id AND s.position <= o.current_stage AND m.machine_id = ?))`
+}
+
+// rolloutRunning reports whether a rollout still limits who receives its rule
+func rolloutRunning(status string) bool {
+	return status == string(models.RolloutStatusActive) || status == string(models.RolloutStatusHalted)
+}
+
+// ValidateRolloutPlan checks a rollout's stages and halt threshold. Field
+// names are those of the create rule request body.
+func ValidateRolloutPlan(plan RolloutPlan) (ValidationErrors, error) {
+	var errs ValidationErrors
+	if len(plan.Stages) == 0 {
+		errs = append(errs, ValidationError{Field: "rollout.stages", Message: "at least one stage is required"})
+	}
+	if plan.HaltThreshold != nil && *plan.HaltThreshold < 0 {
+		errs = append(errs, ValidationError{Field: "rollout.halt_threshold", Message: "must not be negative"})
+	}
+
+	seen := map[int64]bool{}
+	for i, stage := range plan.Stages {
+		field := fmt.Sprintf("rollout.stages[%d]", i)
+		if stage.HoldMinutes != nil && *stage.HoldMinutes < 1 {
+			errs = append(errs, ValidationError{Field: field + ".hold_minutes", Message: "must be at least 1; omit it to promote manually"})
+		}
+		if seen[stage.GroupID] {
+			errs = append(errs, ValidationError{Field: field + ".group_id", Message: "group already used by an earlier stage"})
+			continue
+		}
+		seen[stage.GroupID] = true
+
+		exists, err := GroupExists(stage.GroupID)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			errs = append(errs, ValidationError{Field: field + ".group_id", Message: "no such machine group"})
+		}
+	}
+	return errs, nil
+}
+
+// startRollout creates a rule's rollout within a transaction, reaching the
+// first stage straight away
+func startRollout(tx *sql.Tx, ruleID int64, plan RolloutPlan, createdBy *int64) error {
+	now := time.Now().UTC()
+	haltThreshold := 0
+	if plan.HaltThreshold != nil {
+		haltThreshold = *plan.HaltThreshold
+	}
+
+	result, err := tx.Exec(
+		`INSERT INTO rule_rollouts (rule_id, halt_threshold, stage_started_at, created_by)
+		 VALUES (?, ?, ?, ?)`,
+		ruleID, haltThreshold, now, createdBy,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create rollout: %w", err)
+	}
+	rolloutID, _ := result.LastInsertId()
+
+	for i, stage := range plan.Stages {
+		var reachedAt *time.Time
+		if i == 0 {
+			reachedAt = &now
+		}
+		_, err := tx.Exec(
+			`INSERT INTO rule_rollout_stages (rollout_id, position, group_id, hold_minutes, reached_at)
+			 VALUES (?, ?, ?, ?, ?)`,
+			rolloutID, i+1, stage.GroupID, stage.HoldMinutes, reachedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create rollout stage: %w", err)
+		}
+	}
+	return nil
+}
+
+// endRollout aborts a rule's unfinished rollout within a transaction, for
+// rules that are deleted or replaced while rolling out
+func endRollout(tx *sql.Tx, ruleID int64, reason string) error {
+	_, err := tx.Exec(
+		`UPDATE rule_rollouts SET status = ?, halt_reason = ?, completed_at = ?
+		 WHERE rule_id = ? AND status IN ('ACTIVE', 'HALTED')`,
+		models.RolloutStatusAborted, reason, time.Now().UTC(), ruleID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to end rollout: %w", err)
+	}
+	return nil
+}
+
+// AttachRollouts fills in the rollout of every rule created with one
+func AttachRollouts(rules []models.Rule) error {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	rows, err := database.DB.Query(
+		`SELECT o.rule_id, o.id, o.status, o.current_stage, s.position, s.group_id
+		 FROM rule_rollouts o
+		 JOIN rule_rollout_stages s ON s.rollout_id = o.id
+		 ORDER BY o.id, s.position`,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to query rollouts: %w", err)
+	}
+	defer rows.Close()
+
+	summaries := map[int64]*models.RuleRolloutSummary{}
+	for rows.Next() {
+		var ruleID, groupID int64
+		var position int
+		var summary models.RuleRolloutSummary
+		err := rows.Scan(&ruleID, &summary.ID, &summary.Status, &summary.CurrentStage, &position, &groupID)
+		if err != nil {
+			return fmt.Errorf("failed to scan rollout: %w", err)
+		}
+		if summaries[ruleID] == nil {
+			summary.GroupIDs = []int64{}
+			summaries[ruleID] = &summary
+		}
+		summaries[ruleID].StageCount++
+		if position <= summaries[ruleID].CurrentStage {
+			summaries[ruleID].GroupIDs = append(summaries[ruleID].GroupIDs, groupID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read rollouts: %w", err)
+	}
+
+	for i := range rules {
+		rules[i].Rollout = summaries[rules[i].ID]
+	}
+	return nil
+}
+
+// rolloutReaches reports whether a rule's rollout has reached any of the
+// groups a machine belongs to. Rules without an unfinished rollout reach
+// every machine.
+func rolloutReaches(r models.Rule, groups map[int64]bool) bool {
+	if r.Rollout == nil || !rolloutRunning(r.Rollout.Status) {
+		return true
+	}
+	for _, id := range r.Rollout.GroupIDs {
+		if groups[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// ListRollouts returns rollouts, unfinished ones first, optionally filtered
+// by status
+func ListRollouts(status string) ([]models.RuleRollout, error) {
+	where, args := "1=1", []interface{}{}
+	if status != "" {
+		where, args = "o.status = ?", append(args, status)
+	}
+	return queryRollouts(where+` ORDER BY o.status NOT IN ('ACTIVE', 'HALTED'), o.id DESC`, args...)
+}
+
+// GetRuleRollout returns a rule's rollout with the progress of each stage
+func GetRuleRollout(ruleID int64) (*models.RuleRollout, error) {
+	rollouts, err := queryRollouts(`o.rule_id = ?`, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	if len(rollouts) == 0 {
+		return nil, ErrRolloutNotFound
+	}
+	return &rollouts[0], nil
+}
+
+// queryRollouts loads the rollouts matching a condition on rule_rollouts o,
+// naming each rule from its latest version so aborted rollouts of deleted
+// rules can still be listed
+func queryRollouts(condition string, args ...interface{}) ([]models.RuleRollout, error) {
+	rows, err := database.DB.Query(
+		`SELECT o.id, o.rule_id, COALESCE(v.identifier, ''), COALESCE(v.rule_type, ''), COALESCE(v.policy, ''),
+		        o.status, o.current_stage, o.halt_threshold, o.halt_reason, o.stage_started_at,
+		        o.created_by, o.created_at, o.completed_at
+		 FROM rule_rollouts o
+		 LEFT JOIN rule_versions v ON v.rule_id = o.rule_id
+		   AND v.version = (SELECT MAX(version) FROM rule_versions WHERE rule_id = o.rule_id)
+		 WHERE `+condition,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rollouts: %w", err)
+	}
+
+	rollouts := []models.RuleRollout{}
+	for rows.Next() {
+		var o models.RuleRollout
+		err := rows.Scan(&o.ID, &o.RuleID, &o.Identifier, &o.RuleType, &o.Policy,
+			&o.Status, &o.CurrentStage, &o.HaltThreshold, &o.HaltReason, &o.StageStartedAt,
+			&o.CreatedBy, &o.CreatedAt, &o.CompletedAt)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan rollout: %w", err)
+		}
+		rollouts = append(rollouts, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rollouts: %w", err)
+	}
+
+	for i := range rollouts {
+		if rollouts[i].Stages, err = rolloutStages(rollouts[i].ID, rollouts[i].RuleID); err != nil {
+			return nil, err
+		}
+		if rollouts[i].Status != string(models.RolloutStatusActive) {
+			continue
+		}
+		for _, stage := range rollouts[i].Stages {
+			if stage.Position == rollouts[i].CurrentStage && stage.HoldMinutes != nil {
+				next := rollouts[i].StageStartedAt.Add(time.Duration(*stage.HoldMinutes) * time.Minute)
+				rollouts[i].NextPromotionAt = &next
+			}
+		}
+	}
+	return rollouts, nil
+}
+
+// rolloutStages returns a rollout's stages with how far each has got: the
+// members that downloaded rules and the blocks attributed to the rule since
+// the stage was reached
+func rolloutStages(rolloutID, ruleID int64) ([]models.RolloutStage, error) {
+	rows, err := database.DB.Query(
+		`SELECT s.position, s.group_id, COALESCE(g.name, ''), s.hold_minutes, s.reached_at,
+		        (SELECT COUNT(*) FROM machine_group_members m WHERE m.group_id = s.group_id),
+		        (SELECT COUNT(*) FROM machine_group_members m
+		         JOIN machines mc ON mc.machine_id = m.machine_id
+		         WHERE m.group_id = s.group_id AND s.reached_at IS NOT NULL
+		           AND datetime(mc.last_sync) >= datetime(s.reached_at)),
+		        (SELECT COUNT(*) FROM events e
+		         JOIN machine_group_members m ON m.machine_id = e.machine_id
+		         WHERE m.group_id = s.group_id AND e.rule_id = ? AND e.decision LIKE 'BLOCK%'
+		           AND s.reached_at IS NOT NULL AND datetime(e.execution_time) >= datetime(s.reached_at))
+		 FROM rule_rollout_stages s
+		 LEFT JOIN machine_groups g ON g.id = s.group_id
+		 WHERE s.rollout_id = ?
+		 ORDER BY s.position`,
+		ruleID, rolloutID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rollout stages: %w", err)
+	}
+	defer rows.Close()
+
+	stages := []models.RolloutStage{}
+	for rows.Next() {
+		var s models.RolloutStage
+		err := rows.Scan(&s.Position, &s.GroupID, &s.GroupName, &s.HoldMinutes, &s.ReachedAt,
+			&s.MachineCount, &s.SyncedMachines, &s.BlockEvents)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan rollout stage: %w", err)
+		}
+		s.Reached = s.ReachedAt != nil
+		stages = append(stages, s)
+	}
+	return stages, rows.Err()
+}
+
+// rolloutState is the part of a rollout its transitions work from
+type rolloutState struct {
+	id           int64
+	status       string
+	currentStage int
+	stageCount   int
+}
+
+// getRolloutState reads a rule's rollout within a transaction
+func getRolloutState(tx *sql.Tx, ruleID int64) (rolloutState, error) {
+	var s rolloutState
+	err := tx.QueryRow(
+		`SELECT o.id, o.status, o.current_stage,
+		        (SELECT COUNT(*) FROM rule_rollout_stages WHERE rollout_id = o.id)
+		 FROM rule_rollouts o WHERE o.rule_id = ?`,
+		ruleID,
+	).Scan(&s.id, &s.status, &s.currentStage, &s.stageCount)
+	if err == sql.ErrNoRows {
+		return s, ErrRolloutNotFound
+	}
+	if err != nil {
+		return s, fmt.Errorf("failed to fetch rollout: %w", err)
+	}
+	if !rolloutRunning(s.status) {
+		return s, ErrRolloutFinished
+	}
+	return s, nil
+}
+
+// updateRollout runs a transition on a rule's unfinished rollout in its own
+// transaction and returns the rollout as it now stands
+func updateRollout(ruleID int64, apply func(tx *sql.Tx, s rolloutState) error) (*models.RuleRollout, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	s, err := getRolloutState(tx, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	if err := apply(tx, s); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return GetRuleRollout(ruleID)
+}
+
+// promote moves a rollout to its next stage, or completes it after the last
+func promote(tx *sql.Tx, s rolloutState) error {
+	now := time.Now().UTC()
+	if s.currentStage >= s.stageCount {
+		_, err := tx.Exec(
+			`UPDATE rule_rollouts SET status = ?, completed_at = ? WHERE id = ?`,
+			models.RolloutStatusCompleted, now, s.id,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to complete rollout: %w", err)
+		}
+		return nil
+	}
+
+	next := s.currentStage + 1
+	_, err := tx.Exec(
+		`UPDATE rule_rollouts SET current_stage = ?, stage_started_at = ? WHERE id = ?`, next, now, s.id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to promote rollout: %w", err)
+	}
+	_, err = tx.Exec(
+		`UPDATE rule_rollout_stages SET reached_at = ? WHERE rollout_id = ? AND position = ?`, now, s.id, next,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to promote rollout: %w", err)
+	}
+	return nil
+}
+
+// halt stops a rollout where it is; the rule keeps being served to the
+// stages already reached
+func halt(tx *sql.Tx, s rolloutState, reason string) error {
+	_, err := tx.Exec(
+		`UPDATE rule_rollouts SET status = ?, halt_reason = ? WHERE id = ?`,
+		models.RolloutStatusHalted, reason, s.id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to halt rollout: %w", err)
+	}
+	return nil
+}
+
+// PromoteRollout moves a rule's rollout to its next stage ahead of its hold
+// time, or to the whole fleet from the last stage
+func PromoteRollout(ruleID int64) (*models.RuleRollout, error) {
+	return updateRollout(ruleID, func(tx *sql.Tx, s rolloutState) error {
+		if s.status == string(models.RolloutStatusHalted) {
+			return ErrRolloutHalted
+		}
+		return promote(tx, s)
+	})
+}
+
+// HaltRollout stops a rule's rollout at its current stage
+func HaltRollout(ruleID int64, reason *string) (*models.RuleRollout, error) {
+	return updateRollout(ruleID, func(tx *sql.Tx, s rolloutState) error {
+		if s.status == string(models.RolloutStatusHalted) {
+			return ErrRolloutHalted
+		}
+		haltReason := "Halted manually"
+		if reason != nil {
+			haltReason = *reason
+		}
+		return halt(tx, s, haltReason)
+	})
+}
+
+// ResumeRollout continues a halted rollout. The current stage starts over,
+// so its hold time and block count are measured from now.
+func ResumeRollout(ruleID int64) (*models.RuleRollout, error) {
+	return updateRollout(ruleID, func(tx *sql.Tx, s rolloutState) error {
+		if s.status != string(models.RolloutStatusHalted) {
+			return ErrRolloutNotHalted
+		}
+		_, err := tx.Exec(
+			`UPDATE rule_rollouts SET status = ?, halt_reason = NULL, stage_started_at = ? WHERE id = ?`,
+			models.RolloutStatusActive, time.Now().UTC(), s.id,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to resume rollout: %w", err)
+		}
+		return nil
+	})
+}
+
+// AbortRollout ends a rule's rollout and deletes the rule, so machines that
+// already received it drop it through a clean sync
+func AbortRollout(ruleID, changedBy int64, reason *string) (*models.RuleRollout, error) {
+	return updateRollout(ruleID, func(tx *sql.Tx, s rolloutState) error {
+		if reason == nil {
+			defaultReason := "Rollout aborted"
+			reason = &defaultReason
+		}
+		if err := endRollout(tx, ruleID, *reason); err != nil {
+			return err
+		}
+
+		rule, err := getRule(tx, ruleID)
+		if err == ErrRuleNotFound || (err == nil && rule.SupersededAt != nil) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return deleteRule(tx, newRulesetRevision(&changedBy, reason), ruleID, &changedBy, reason)
+	})
+}
+
+// AdvanceRollouts checks every active rollout: stages whose canary machines
+// reported as many blocks by the rule as the halt threshold are halted, and
+// stages held long enough are promoted
+func AdvanceRollouts() error {
+	rollouts, err := queryRollouts(`o.status = ?`, models.RolloutStatusActive)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, o := range rollouts {
+		blocks, err := rolloutBlocks(o)
+		if err != nil {
+			log.Printf("Failed to count blocks for rollout of rule %d: %v", o.RuleID, err)
+			continue
+		}
+
+		var apply func(tx *sql.Tx, s rolloutState) error
+		switch {
+		case o.HaltThreshold > 0 && blocks >= o.HaltThreshold:
+			reason := fmt.Sprintf("%d block events on canary machines during stage %d", blocks, o.CurrentStage)
+			apply = func(tx *sql.Tx, s rolloutState) error { return halt(tx, s, reason) }
+			log.Printf("Halting rollout of rule %d: %s", o.RuleID, reason)
+		case o.NextPromotionAt != nil && !now.Before(*o.NextPromotionAt):
+			apply = promote
+			log.Printf("Promoting rollout of rule %d past stage %d", o.RuleID, o.CurrentStage)
+		default:
+			continue
+		}
+
+		// The rollout may have changed since it was read; only act on the
+		// stage it was checked at
+		_, err = updateRollout(o.RuleID, func(tx *sql.Tx, s rolloutState) error {
+			if s.status != string(models.RolloutStatusActive) || s.currentStage != o.CurrentStage {
+				return nil
+			}
+			return apply(tx, s)
+		})
+		if err != nil && !errors.Is(err, ErrRolloutFinished) {
+			log.Printf("Failed to advance rollout of rule %d: %v", o.RuleID, err)
+		}
+	}
+	return nil
+}
+
+// rolloutBlocks counts the blocks attributed to a rollout's rule on the
+// machines it has reached since the current stage started
+func rolloutBlocks(o models.RuleRollout) (int, error) {
+	var count int
+	err := database.DB.QueryRow(
+		`SELECT COUNT(*) FROM events e
+		 WHERE e.rule_id = ? AND e.decision LIKE 'BLOCK%'
+		   AND datetime(e.execution_time) >= datetime(?)
+		   AND e.machine_id IN (
+		     SELECT m.machine_id FROM machine_group_members m
+		     JOIN rule_rollout_stages s ON s.group_id = m.group_id
+		     WHERE s.rollout_id = ? AND s.position <= ?)`,
+		o.RuleID, o.StageStartedAt.UTC(), o.ID, o.CurrentStage,
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count rollout blocks: %w", err)
+	}
+	return count, nil
+}
+
+// WatchRollouts advances rollouts every interval in the background. A zero
+// or negative interval turns the checks off, leaving rollouts to be promoted
+// and halted by hand.
+func WatchRollouts(interval time.Duration) {
+	if interval <= 0 {
+		log.Printf("Automatic rollout checks are disabled (ROLLOUT_CHECK_INTERVAL=%s)", interval)
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := AdvanceRollouts(); err != nil {
+				log.Printf("Failed to advance rollouts: %v", err)
+			}
+		}
+	}()
+}
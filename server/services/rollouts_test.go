@@ -0,0 +1,217 @@
+package services
+
+import (
+	"errors"
+	"krampus/server/database"
+	"krampus/server/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateRolloutPlan(t *testing.T) {
+	hold, noHold, negative := 60, 0, -1
+
+	tests := []struct {
+		name   string
+		plan   RolloutPlan
+		fields []string
+	}{
+		{name: "valid", plan: RolloutPlan{Stages: []RolloutStagePlan{{GroupID: 1, HoldMinutes: &hold}, {GroupID: 2}}}},
+		{name: "no stages", plan: RolloutPlan{}, fields: []string{"rollout.stages"}},
+		{name: "negative threshold", plan: RolloutPlan{Stages: []RolloutStagePlan{{GroupID: 1}}, HaltThreshold: &negative}, fields: []string{"rollout.halt_threshold"}},
+		{name: "zero hold", plan: RolloutPlan{Stages: []RolloutStagePlan{{GroupID: 1, HoldMinutes: &noHold}}}, fields: []string{"rollout.stages[0].hold_minutes"}},
+		{name: "group repeated", plan: RolloutPlan{Stages: []RolloutStagePlan{{GroupID: 1}, {GroupID: 1}}}, fields: []string{"rollout.stages[1].group_id"}},
+		{name: "unknown group", plan: RolloutPlan{Stages: []RolloutStagePlan{{GroupID: 9}}}, fields: []string{"rollout.stages[0].group_id"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			mustExec(t, `INSERT INTO machine_groups (id, name) VALUES (1, 'canary'), (2, 'eng')`)
+
+			errs, err := ValidateRolloutPlan(tt.plan)
+			if err != nil {
+				t.Fatalf("ValidateRolloutPlan failed: %v", err)
+			}
+			var fields []string
+			for _, e := range errs {
+				fields = append(fields, e.Field)
+			}
+			if len(fields) != len(tt.fields) || (len(fields) > 0 && fields[0] != tt.fields[0]) {
+				t.Errorf("errors on %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
+
+// seedRollout creates a blocklist rule rolling out to group 1 and then group
+// 2, with m1 in group 1, m2 in group 2 and m3 in neither
+func seedRollout(t *testing.T, plan RolloutPlan) int64 {
+	t.Helper()
+	mustExec(t, `INSERT INTO users (id, username, role) VALUES (1, 'admin', 'ADMIN')`)
+	mustExec(t, `INSERT INTO machines (machine_id) VALUES ('m1'), ('m2'), ('m3')`)
+	mustExec(t, `INSERT INTO machine_groups (id, name) VALUES (1, 'canary'), (2, 'eng')`)
+	mustExec(t, `INSERT INTO machine_group_members (group_id, machine_id) VALUES (1, 'm1'), (2, 'm2')`)
+	ruleID, err := CreateRule(testRule(0, string(models.RuleTypeBinary), testHash, models.PolicyBlocklist), nil, false, &plan)
+	if err != nil {
+		t.Fatalf("failed to create rule: %v", err)
+	}
+	return ruleID
+}
+
+// reachedMachines lists which of m1, m2 and m3 receive a rule
+func reachedMachines(t *testing.T, ruleID int64) []string {
+	t.Helper()
+	var reached []string
+	for _, machineID := range []string{"m1", "m2", "m3"} {
+		inEffect, args := RuleInEffect(machineID)
+		var count int
+		err := database.DB.QueryRow(`SELECT COUNT(*) FROM rules WHERE id = ? AND `+inEffect,
+			append([]interface{}{ruleID}, args...)...).Scan(&count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count > 0 {
+			reached = append(reached, machineID)
+		}
+	}
+	return reached
+}
+
+func TestRolloutStages(t *testing.T) {
+	openTestDB(t)
+	ruleID := seedRollout(t, RolloutPlan{Stages: []RolloutStagePlan{{GroupID: 1}, {GroupID: 2}}})
+
+	steps := []struct {
+		status  models.RolloutStatus
+		reached string
+	}{
+		{models.RolloutStatusActive, "m1"},
+		{models.RolloutStatusActive, "m1,m2"},
+		{models.RolloutStatusCompleted, "m1,m2,m3"},
+	}
+	for i, step := range steps {
+		if i > 0 {
+			if _, err := PromoteRollout(ruleID); err != nil {
+				t.Fatalf("promotion %d failed: %v", i, err)
+			}
+		}
+		rollout, err := GetRuleRollout(ruleID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rollout.Status != string(step.status) || rollout.CurrentStage != min(i+1, 2) {
+			t.Errorf("after %d promotions rollout is %s at stage %d, want %s at %d", i, rollout.Status, rollout.CurrentStage, step.status, min(i+1, 2))
+		}
+		if got := strings.Join(reachedMachines(t, ruleID), ","); got != step.reached {
+			t.Errorf("after %d promotions rule reaches %s, want %s", i, got, step.reached)
+		}
+	}
+
+	if _, err := PromoteRollout(ruleID); !errors.Is(err, ErrRolloutFinished) {
+		t.Errorf("promoting a completed rollout error = %v, want %v", err, ErrRolloutFinished)
+	}
+}
+
+func TestHaltResumeAbortRollout(t *testing.T) {
+	openTestDB(t)
+	ruleID := seedRollout(t, RolloutPlan{Stages: []RolloutStagePlan{{GroupID: 1}, {GroupID: 2}}})
+
+	if _, err := ResumeRollout(ruleID); !errors.Is(err, ErrRolloutNotHalted) {
+		t.Errorf("resuming an active rollout error = %v, want %v", err, ErrRolloutNotHalted)
+	}
+	rollout, err := HaltRollout(ruleID, nil)
+	if err != nil {
+		t.Fatalf("HaltRollout failed: %v", err)
+	}
+	if rollout.Status != string(models.RolloutStatusHalted) || rollout.HaltReason == nil {
+		t.Errorf("halted rollout is %s with reason %v", rollout.Status, rollout.HaltReason)
+	}
+	// A halted rollout keeps serving the stages already reached
+	if got := strings.Join(reachedMachines(t, ruleID), ","); got != "m1" {
+		t.Errorf("halted rule reaches %s, want m1", got)
+	}
+	if _, err := PromoteRollout(ruleID); !errors.Is(err, ErrRolloutHalted) {
+		t.Errorf("promoting a halted rollout error = %v, want %v", err, ErrRolloutHalted)
+	}
+	if rollout, err = ResumeRollout(ruleID); err != nil || rollout.Status != string(models.RolloutStatusActive) {
+		t.Fatalf("ResumeRollout = %v, %v, want an active rollout", rollout, err)
+	}
+
+	if rollout, err = AbortRollout(ruleID, 1, nil); err != nil {
+		t.Fatalf("AbortRollout failed: %v", err)
+	}
+	if rollout.Status != string(models.RolloutStatusAborted) {
+		t.Errorf("aborted rollout status = %s", rollout.Status)
+	}
+	tx, err := database.DB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err := getRule(tx, ruleID); !errors.Is(err, ErrRuleNotFound) {
+		t.Errorf("rule after abort error = %v, want %v", err, ErrRuleNotFound)
+	}
+}
+
+func TestAdvanceRollouts(t *testing.T) {
+	hold, threshold := 60, 2
+
+	tests := []struct {
+		name       string
+		plan       RolloutPlan
+		heldFor    time.Duration // Time since the current stage started
+		blocks     int           // Block events on m1 since then
+		status     models.RolloutStatus
+		stage      int
+		haltReason bool
+	}{
+		{name: "hold not over", plan: RolloutPlan{Stages: []RolloutStagePlan{{GroupID: 1, HoldMinutes: &hold}, {GroupID: 2}}}, heldFor: 30 * time.Minute, status: models.RolloutStatusActive, stage: 1},
+		{name: "hold over", plan: RolloutPlan{Stages: []RolloutStagePlan{{GroupID: 1, HoldMinutes: &hold}, {GroupID: 2}}}, heldFor: 2 * time.Hour, status: models.RolloutStatusActive, stage: 2},
+		{name: "manual stage", plan: RolloutPlan{Stages: []RolloutStagePlan{{GroupID: 1}, {GroupID: 2}}}, heldFor: 48 * time.Hour, status: models.RolloutStatusActive, stage: 1},
+		{name: "last stage held", plan: RolloutPlan{Stages: []RolloutStagePlan{{GroupID: 1, HoldMinutes: &hold}}}, heldFor: 2 * time.Hour, status: models.RolloutStatusCompleted, stage: 1},
+		{
+			name: "blocks below threshold", plan: RolloutPlan{Stages: []RolloutStagePlan{{GroupID: 1}, {GroupID: 2}}, HaltThreshold: &threshold},
+			heldFor: time.Hour, blocks: 1, status: models.RolloutStatusActive, stage: 1,
+		},
+		{
+			name: "blocks reach threshold", plan: RolloutPlan{Stages: []RolloutStagePlan{{GroupID: 1, HoldMinutes: &hold}, {GroupID: 2}}, HaltThreshold: &threshold},
+			heldFor: 2 * time.Hour, blocks: 2, status: models.RolloutStatusHalted, stage: 1, haltReason: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			ruleID := seedRollout(t, tt.plan)
+			started := time.Now().UTC().Add(-tt.heldFor)
+			mustExec(t, `UPDATE rule_rollouts SET stage_started_at = ? WHERE rule_id = ?`, started, ruleID)
+			for i := 0; i < tt.blocks; i++ {
+				mustExec(t, `INSERT INTO events (machine_id, file_hash, decision, execution_time, rule_id)
+					VALUES ('m1', ?, 'BLOCK_BINARY', ?, ?)`, testHash, time.Now(), ruleID)
+			}
+
+			if err := AdvanceRollouts(); err != nil {
+				t.Fatalf("AdvanceRollouts failed: %v", err)
+			}
+			rollout, err := GetRuleRollout(ruleID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rollout.Status != string(tt.status) || rollout.CurrentStage != tt.stage {
+				t.Errorf("rollout is %s at stage %d, want %s at %d", rollout.Status, rollout.CurrentStage, tt.status, tt.stage)
+			}
+			if (rollout.HaltReason != nil) != tt.haltReason {
+				t.Errorf("halt reason = %v, want one: %v", rollout.HaltReason, tt.haltReason)
+			}
+		})
+	}
+}
+
+func TestWatchRolloutsDisabled(t *testing.T) {
+	// A zero or negative interval turns the checks off instead of panicking
+	// in the ticker
+	WatchRollouts(0)
+	WatchRollouts(-time.Hour)
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"krampus/server/models"
+)
+
+// ruleCSVColumns is the column order used for CSV exports
+var ruleCSVColumns = []string{"identifier", "rule_type", "policy", "custom_message", "custom_url", "owner_team", "comment", "cel_expr"}
+
+// santactlRule is a rule as written by santactl rule --export
+type santactlRule struct {
+	Identifier string  `json:"identifier"`
+	Policy     string  `json:"policy"`
+	RuleType   string  `json:"rule_type"`
+	CELExpr    *string `json:"cel_expr,omitempty"`
+	CustomMsg  *string `json:"custom_msg,omitempty"`
+	CustomURL  *string `json:"custom_url,omitempty"`
+	Comment    *string `json:"comment,omitempty"`
+}
+
+// WriteRules encodes rules in one of the import formats, so an export can be
+// imported again into this or another server
+func WriteRules(w io.Writer, format string, rules []models.Rule) error {
+	switch format {
+	case RuleFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(rules)
+
+	case RuleFormatSantactl:
+		export := struct {
+			Rules []santactlRule `json:"rules"`
+		}{Rules: make([]santactlRule, len(rules))}
+		for i, r := range rules {
+			export.Rules[i] = santactlRule{
+				Identifier: r.Identifier,
+				Policy:     r.Policy,
+				RuleType:   r.RuleType,
+				CELExpr:    r.CELExpr,
+				CustomMsg:  r.CustomMessage,
+				CustomURL:  r.CustomURL,
+				Comment:    r.Comment,
+			}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(export)
+
+	case RuleFormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(ruleCSVColumns); err != nil {
+			return err
+		}
+		for _, r := range rules {
+			record := []string{
+				r.Identifier, r.RuleType, r.Policy, deref(r.CustomMessage), deref(r.CustomURL),
+				deref(r.OwnerTeam), deref(r.Comment), deref(r.CELExpr),
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+	return fmt.Errorf("unsupported format %q", format)
+}
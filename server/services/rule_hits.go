@@ -0,0 +1,169 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"krampus/server/database"
+	"krampus/server/models"
+	"sort"
+	"strings"
+	"time"
+)
+
+// decisionPolicies lists the rule policies behind each Santa decision
+// action. CEL rules allow or block depending on their expression.
+var decisionPolicies = map[string][]interface{}{
+	"ALLOW": {models.PolicyAllowlist, models.PolicyAllowlistCompiler, models.PolicyCEL},
+	"BLOCK": {models.PolicyBlocklist, models.PolicySilentBlocklist, models.PolicyCEL},
+}
+
+// decisionRule reads the rule type and the policies that can produce a Santa
+// decision such as "BLOCK_SIGNINGID". Decisions not made by a rule (UNKNOWN,
+// COMPILER, TRANSITIVE, ...) report false.
+func decisionRule(decision string) (ruleType string, policies []interface{}, ok bool) {
+	action, kind, found := strings.Cut(strings.ToUpper(decision), "_")
+	if !found || ValidateRuleType(kind) != nil {
+		return "", nil, false
+	}
+	policies, ok = decisionPolicies[action]
+	if !ok {
+		return "", nil, false
+	}
+	return kind, policies, true
+}
+
+// EventRule finds the rule that produced an event's decision on a machine,
+// using the decision reason to pick the rule type and the event's matching
+// identifier. The rule the machine currently receives for the target is
+// preferred. An event uploaded after its rule was superseded is attributed to
+// the rule that would have taken the target on that machine: one of its
+// groups' rules before the fleet-wide rule, newest first.
+// It returns nil when the decision was not made by a known rule.
+func EventRule(machineID string, event models.SantaEvent) (*int64, error) {
+	ruleType, policies, ok := decisionRule(event.Decision)
+	if !ok {
+		return nil, nil
+	}
+
+	var identifier string
+	switch models.RuleType(ruleType) {
+	case models.RuleTypeBinary:
+		identifier = event.FileSHA256
+	case models.RuleTypeCertificate:
+		identifier = event.CertificateSHA256
+	case models.RuleTypeCDHash:
+		identifier = event.CDHash
+	case models.RuleTypeTeamID:
+		identifier = event.TeamID
+	case models.RuleTypeSigningID:
+		identifier = SigningIdentifier(event.SigningID, event.TeamID)
+	}
+	identifier = NormalizeIdentifier(ruleType, identifier)
+	if identifier == "" {
+		return nil, nil
+	}
+
+	matches := `identifier = ? AND rule_type = ? AND policy IN (?` + strings.Repeat(", ?", len(policies)-1) + `)`
+	matchArgs := append([]interface{}{identifier, ruleType}, policies...)
+
+	inEffect, inEffectArgs := RuleInEffect(machineID)
+	var ruleID int64
+	err := database.DB.QueryRow(
+		`SELECT id FROM rules WHERE `+matches+` AND `+inEffect,
+		append(matchArgs, inEffectArgs...)...,
+	).Scan(&ruleID)
+	if err == nil {
+		return &ruleID, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to find rule for event: %w", err)
+	}
+
+	groups, err := machineGroupIDs(machineID)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := database.DB.Query(`SELECT `+RuleColumns+` FROM rules WHERE `+matches, matchArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find rule for event: %w", err)
+	}
+	defer rows.Close()
+
+	var best *models.Rule
+	for rows.Next() {
+		var r models.Rule
+		if err := ScanRule(rows, &r); err != nil {
+			return nil, fmt.Errorf("failed to scan rule: %w", err)
+		}
+		if r.GroupID != nil && !groups[*r.GroupID] {
+			continue
+		}
+		if best == nil || outranks(r, *best) {
+			best = &r
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rules: %w", err)
+	}
+	if best == nil {
+		return nil, nil
+	}
+	return &best.ID, nil
+}
+
+// RecordRuleHit counts an event against the rule that decided it
+func RecordRuleHit(ruleID int64, executedAt time.Time) error {
+	_, err := database.DB.Exec(
+		`UPDATE rules SET hit_count = hit_count + 1,
+		                  last_hit_at = CASE WHEN last_hit_at IS NULL OR last_hit_at < ? THEN ? ELSE last_hit_at END
+		 WHERE id = ?`,
+		executedAt, executedAt, ruleID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record rule hit: %w", err)
+	}
+	return nil
+}
+
+// StaleRules lists active rules that no event has been attributed to in the
+// last days days. Rules created within that window have not had the chance
+// to be hit and are left out, as are REMOVE rules, which never decide an
+// execution. Rules never hit come first, then the longest unused.
+func StaleRules(days int) ([]models.Rule, error) {
+	rows, err := database.DB.Query(
+		`SELECT `+RuleColumns+` FROM rules WHERE `+ActiveRule+` AND policy != ?`,
+		models.PolicyRemove,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rules: %w", err)
+	}
+	defer rows.Close()
+
+	cutoff := time.Now().AddDate(0, 0, -days)
+	stale := []models.Rule{}
+	for rows.Next() {
+		var r models.Rule
+		if err := ScanRule(rows, &r); err != nil {
+			return nil, fmt.Errorf("failed to scan rule: %w", err)
+		}
+		if !r.CreatedAt.Before(cutoff) || (r.LastHitAt != nil && r.LastHitAt.After(cutoff)) {
+			continue
+		}
+		stale = append(stale, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rules: %w", err)
+	}
+
+	sort.Slice(stale, func(i, j int) bool {
+		a, b := stale[i].LastHitAt, stale[j].LastHitAt
+		switch {
+		case a == nil && b == nil:
+			return stale[i].CreatedAt.Before(stale[j].CreatedAt)
+		case a == nil || b == nil:
+			return a == nil
+		}
+		return a.Before(*b)
+	})
+	return stale, nil
+}
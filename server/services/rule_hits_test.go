@@ -0,0 +1,111 @@
+package services
+
+import (
+	"krampus/server/database"
+	"krampus/server/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEventRule(t *testing.T) {
+	binary, signingID := string(models.RuleTypeBinary), string(models.RuleTypeSigningID)
+
+	tests := []struct {
+		name      string
+		machineID string
+		event     models.SantaEvent
+		want      string // Rule the event is attributed to, empty for none
+	}{
+		{name: "group rule on a member", machineID: "grouped", event: models.SantaEvent{FileSHA256: testHash, Decision: "ALLOW_BINARY"}, want: "group"},
+		{name: "fleet rule on another machine", machineID: "plain", event: models.SantaEvent{FileSHA256: testHash, Decision: "BLOCK_BINARY"}, want: "fleet"},
+		{name: "fleet rule on a member before it joined", machineID: "grouped", event: models.SantaEvent{FileSHA256: testHash, Decision: "BLOCK_BINARY"}, want: "fleet"},
+		{name: "group rule out of scope", machineID: "plain", event: models.SantaEvent{FileSHA256: testHash, Decision: "ALLOW_BINARY"}},
+		{name: "superseded rule", machineID: "plain", event: models.SantaEvent{SigningID: "com.example.tool", TeamID: testTeamID, Decision: "ALLOW_SIGNINGID"}, want: "superseded"},
+		{name: "active rule", machineID: "plain", event: models.SantaEvent{SigningID: "com.example.tool", TeamID: testTeamID, Decision: "BLOCK_SIGNINGID"}, want: "superseding"},
+		{name: "not decided by a rule", machineID: "plain", event: models.SantaEvent{FileSHA256: testHash, Decision: "ALLOW_UNKNOWN"}},
+		{name: "no matching identifier", machineID: "plain", event: models.SantaEvent{Decision: "BLOCK_CDHASH"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			mustExec(t, `INSERT INTO machines (machine_id) VALUES ('grouped'), ('plain')`)
+			mustExec(t, `INSERT INTO machine_groups (id, name) VALUES (7, 'eng')`)
+			mustExec(t, `INSERT INTO machine_group_members (group_id, machine_id) VALUES (7, 'grouped')`)
+			rules := map[string]int64{
+				"fleet":      createTestRule(t, testRule(0, binary, testHash, models.PolicyBlocklist)),
+				"group":      createTestRule(t, inGroup(testRule(0, binary, testHash, models.PolicyAllowlist), 7)),
+				"superseded": createTestRule(t, testRule(0, signingID, testSigning, models.PolicyAllowlist)),
+			}
+			superseding, err := CreateRule(testRule(0, signingID, testSigning, models.PolicyBlocklist), nil, true, nil)
+			if err != nil {
+				t.Fatalf("failed to supersede rule: %v", err)
+			}
+			rules["superseding"] = superseding
+
+			ruleID, err := EventRule(tt.machineID, tt.event)
+			if err != nil {
+				t.Fatalf("EventRule failed: %v", err)
+			}
+			var got string
+			for name, id := range rules {
+				if ruleID != nil && *ruleID == id {
+					got = name
+				}
+			}
+			if got != tt.want || (ruleID != nil && got == "") {
+				t.Errorf("EventRule = %v (%q), want %q", ruleID, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStaleRules(t *testing.T) {
+	openTestDB(t)
+	now := time.Now()
+	old := now.AddDate(0, 0, -200)
+	binary := string(models.RuleTypeBinary)
+
+	neverHit := createTestRule(t, testRule(0, binary, "1111111111111111111111111111111111111111111111111111111111111111", models.PolicyAllowlist))
+	hitLongAgo := createTestRule(t, testRule(0, binary, "2222222222222222222222222222222222222222222222222222222222222222", models.PolicyAllowlist))
+	hitRecently := createTestRule(t, testRule(0, binary, "3333333333333333333333333333333333333333333333333333333333333333", models.PolicyAllowlist))
+	removal := createTestRule(t, testRule(0, binary, "4444444444444444444444444444444444444444444444444444444444444444", models.PolicyRemove))
+	mustExec(t, `UPDATE rules SET created_at = ?`, old)
+	createTestRule(t, testRule(0, binary, "5555555555555555555555555555555555555555555555555555555555555555", models.PolicyAllowlist)) // Too new to judge
+
+	// Hits arriving out of order keep the latest execution
+	for _, hit := range []struct {
+		ruleID int64
+		at     time.Time
+	}{
+		{hitLongAgo, old.AddDate(0, 0, 10)},
+		{hitRecently, now.AddDate(0, 0, -1)},
+		{hitRecently, now.AddDate(0, 0, -150)},
+	} {
+		if err := RecordRuleHit(hit.ruleID, hit.at); err != nil {
+			t.Fatalf("RecordRuleHit failed: %v", err)
+		}
+	}
+
+	var hits int
+	var lastHit time.Time
+	if err := database.DB.QueryRow(`SELECT hit_count, last_hit_at FROM rules WHERE id = ?`, hitRecently).Scan(&hits, &lastHit); err != nil {
+		t.Fatal(err)
+	}
+	if hits != 2 || lastHit.Before(now.AddDate(0, 0, -2)) {
+		t.Errorf("recently hit rule has %d hits, last at %v, want 2 within the last day", hits, lastHit)
+	}
+
+	stale, err := StaleRules(90)
+	if err != nil {
+		t.Fatalf("StaleRules failed: %v", err)
+	}
+	var ids []int64
+	for _, r := range stale {
+		ids = append(ids, r.ID)
+	}
+	if want := []int64{neverHit, hitLongAgo}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("stale rules = %v, want %v (removal rule %d is never stale)", ids, want, removal)
+	}
+}
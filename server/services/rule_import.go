@@ -0,0 +1,322 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"krampus/server/database"
+	"krampus/server/models"
+	"strings"
+)
+
+// Rule import and export formats
+const (
+	RuleFormatJSON     = "json"     // Array of rule objects, as returned by the rules API
+	RuleFormatCSV      = "csv"      // Header row naming identifier, rule_type, policy, custom_message, custom_url, owner_team, comment, cel_expr
+	RuleFormatSantactl = "santactl" // {"rules": [...]} as read by santactl rule --import
+)
+
+// RuleImportEntry is one rule read from an import file. Row is the 1-based
+// record number (the line number for CSV). Nil messages, URLs, owner teams
+// and comments leave an existing rule's value alone.
+type RuleImportEntry struct {
+	Row           int
+	Identifier    string
+	RuleType      string
+	Policy        string
+	CELExpr       *string
+	CustomMessage *string
+	CustomURL     *string
+	OwnerTeam     *string
+	Comment       *string
+}
+
+// RuleImportRow reports what an import did, or would do, with one entry
+type RuleImportRow struct {
+	Row            int              `json:"row"`
+	Identifier     string           `json:"identifier"`
+	RuleType       string           `json:"rule_type"`
+	Policy         string           `json:"policy"`
+	RuleID         int64            `json:"rule_id,omitempty"`
+	ExistingRuleID int64            `json:"existing_rule_id,omitempty"`
+	ExistingPolicy string           `json:"existing_policy,omitempty"`
+	Superseded     bool             `json:"superseded,omitempty"` // Conflict resolved by superseding the existing rule
+	Managed        bool             `json:"managed,omitempty"`    // Existing rule is read-only, owned by the rules directory or a threat feed
+	Errors         ValidationErrors `json:"errors,omitempty"`
+}
+
+// RuleImportReport summarizes an import. Nothing is committed for a dry run
+// or when any entry is invalid or conflicts with an active rule.
+type RuleImportReport struct {
+	Format    string          `json:"format"`
+	DryRun    bool            `json:"dry_run"`
+	Committed bool            `json:"committed"`
+	Total     int             `json:"total"`
+	Added     []RuleImportRow `json:"added"`
+	Updated   []RuleImportRow `json:"updated"`
+	Unchanged int             `json:"unchanged"`
+	Conflicts []RuleImportRow `json:"conflicts"`
+	Invalid   []RuleImportRow `json:"invalid"`
+}
+
+// ParseRuleImport reads rules in one of the supported formats
+func ParseRuleImport(format string, data []byte) ([]RuleImportEntry, error) {
+	switch format {
+	case RuleFormatJSON:
+		var records []struct {
+			Identifier    string  `json:"identifier"`
+			RuleType      string  `json:"rule_type"`
+			Policy        string  `json:"policy"`
+			CELExpr       *string `json:"cel_expr"`
+			CustomMessage *string `json:"custom_message"`
+			CustomURL     *string `json:"custom_url"`
+			OwnerTeam     *string `json:"owner_team"`
+			Comment       *string `json:"comment"`
+		}
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		entries := make([]RuleImportEntry, len(records))
+		for i, r := range records {
+			entries[i] = RuleImportEntry{
+				Row: i + 1, Identifier: r.Identifier, RuleType: r.RuleType, Policy: r.Policy,
+				CELExpr: r.CELExpr, CustomMessage: r.CustomMessage, CustomURL: r.CustomURL,
+				OwnerTeam: r.OwnerTeam, Comment: r.Comment,
+			}
+		}
+		return entries, nil
+
+	case RuleFormatSantactl:
+		var export struct {
+			Rules []struct {
+				Identifier string  `json:"identifier"`
+				SHA256     string  `json:"sha256"` // Older santactl versions
+				RuleType   string  `json:"rule_type"`
+				Policy     string  `json:"policy"`
+				CELExpr    *string `json:"cel_expr"`
+				CustomMsg  *string `json:"custom_msg"`
+				CustomURL  *string `json:"custom_url"`
+				Comment    *string `json:"comment"`
+			} `json:"rules"`
+		}
+		if err := json.Unmarshal(data, &export); err != nil {
+			return nil, fmt.Errorf("invalid santactl JSON: %w", err)
+		}
+		entries := make([]RuleImportEntry, len(export.Rules))
+		for i, r := range export.Rules {
+			identifier := r.Identifier
+			if identifier == "" {
+				identifier = r.SHA256
+			}
+			entries[i] = RuleImportEntry{
+				Row: i + 1, Identifier: identifier, RuleType: r.RuleType, Policy: r.Policy,
+				CELExpr: r.CELExpr, CustomMessage: r.CustomMsg, CustomURL: r.CustomURL, Comment: r.Comment,
+			}
+		}
+		return entries, nil
+
+	case RuleFormatCSV:
+		return parseRuleCSV(data)
+	}
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
+
+// parseRuleCSV reads a CSV file whose header row names the columns, in any
+// order. Empty message, URL, owner team and comment cells are treated as not
+// provided.
+func parseRuleCSV(data []byte) ([]RuleImportEntry, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"identifier", "rule_type", "policy"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header is missing the %s column", required)
+		}
+	}
+
+	var entries []RuleImportEntry
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+		cell := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		entries = append(entries, RuleImportEntry{
+			Row:           line,
+			Identifier:    cell("identifier"),
+			RuleType:      cell("rule_type"),
+			Policy:        cell("policy"),
+			CELExpr:       nullIfEmpty(cell("cel_expr")),
+			CustomMessage: nullIfEmpty(cell("custom_message")),
+			CustomURL:     nullIfEmpty(cell("custom_url")),
+			OwnerTeam:     nullIfEmpty(cell("owner_team")),
+			Comment:       nullIfEmpty(cell("comment")),
+		})
+	}
+	return entries, nil
+}
+
+// ImportRules validates entries against the active ruleset and applies them
+// in a single transaction: new targets are added, matching rules have their
+// expression, message, URL, owner team and comment updated, and rules with the opposite policy are
+// conflicts unless supersede is set. Rules managed by the rules directory
+// are always conflicts. The transaction is rolled back for a dry
+// run or if any entry is invalid or left in conflict.
+func ImportRules(format string, entries []RuleImportEntry, userID int64, dryRun, supersede bool) (*RuleImportReport, error) {
+	report := &RuleImportReport{
+		Format:    format,
+		DryRun:    dryRun,
+		Total:     len(entries),
+		Added:     []RuleImportRow{},
+		Updated:   []RuleImportRow{},
+		Conflicts: []RuleImportRow{},
+		Invalid:   []RuleImportRow{},
+	}
+	reason := fmt.Sprintf("Imported from %s", format)
+	revision := newRulesetRevision(&userID, &reason)
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	unresolved := 0
+	seen := map[models.RuleTarget]int{}
+	for _, entry := range entries {
+		// Every format may spell rule types and policies in any case
+		entry.RuleType = strings.ToUpper(strings.TrimSpace(entry.RuleType))
+		entry.Policy = strings.ToUpper(strings.TrimSpace(entry.Policy))
+		entry.Identifier = NormalizeIdentifier(entry.RuleType, entry.Identifier)
+		row := RuleImportRow{
+			Row:        entry.Row,
+			Identifier: entry.Identifier,
+			RuleType:   entry.RuleType,
+			Policy:     entry.Policy,
+		}
+
+		row.Errors = ValidateRule(entry.Identifier, entry.RuleType, entry.Policy, deref(entry.CELExpr), "policy")
+		row.Errors = append(row.Errors, ValidateMessageTemplates(entry.CustomMessage, entry.CustomURL)...)
+		target := models.RuleTarget{Identifier: entry.Identifier, RuleType: entry.RuleType}
+		if previous, ok := seen[target]; ok {
+			row.Errors = append(row.Errors, ValidationError{
+				Field:   "identifier",
+				Message: fmt.Sprintf("duplicates row %d", previous),
+			})
+		} else {
+			seen[target] = entry.Row
+		}
+		if len(row.Errors) > 0 {
+			report.Invalid = append(report.Invalid, row)
+			continue
+		}
+
+		existing, err := activeRule(tx, entry.Identifier, entry.RuleType, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if existing != nil {
+			row.ExistingRuleID = existing.ID
+			row.ExistingPolicy = existing.Policy
+		}
+
+		// Rules from the rules directory can only be changed there, and a
+		// threat feed rule only by superseding it
+		if existing != nil && (existing.Source == string(models.RuleSourceGitOps) ||
+			(existing.Source == string(models.RuleSourceFeed) && existing.Policy == entry.Policy)) {
+			row.Managed = true
+			report.Conflicts = append(report.Conflicts, row)
+			unresolved++
+			continue
+		}
+
+		if existing != nil && existing.Policy != entry.Policy && !supersede {
+			report.Conflicts = append(report.Conflicts, row)
+			unresolved++
+			continue
+		}
+
+		if existing == nil || existing.Policy != entry.Policy {
+			row.RuleID, err = insertRule(tx, revision, models.Rule{
+				Identifier:    entry.Identifier,
+				Policy:        entry.Policy,
+				RuleType:      entry.RuleType,
+				CELExpr:       entry.CELExpr,
+				CustomMessage: entry.CustomMessage,
+				CustomURL:     entry.CustomURL,
+				OwnerTeam:     entry.OwnerTeam,
+				Comment:       entry.Comment,
+				CreatedBy:     &userID,
+			}, &userID, &reason, supersede)
+			if err != nil {
+				return nil, err
+			}
+			if existing == nil {
+				report.Added = append(report.Added, row)
+			} else {
+				row.Superseded = true
+				report.Conflicts = append(report.Conflicts, row)
+			}
+			continue
+		}
+
+		row.RuleID = existing.ID
+		_, changed, err := updateRule(tx, revision, *existing, RuleUpdate{
+			CELExpr:       entry.CELExpr,
+			CustomMessage: entry.CustomMessage,
+			CustomURL:     entry.CustomURL,
+			OwnerTeam:     entry.OwnerTeam,
+			Comment:       entry.Comment,
+		}, &userID, &reason)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			report.Updated = append(report.Updated, row)
+		} else {
+			report.Unchanged++
+		}
+	}
+
+	if dryRun || len(report.Invalid) > 0 || unresolved > 0 {
+		// IDs of rules created inside the rolled back transaction don't exist
+		for _, rows := range [][]RuleImportRow{report.Added, report.Conflicts} {
+			for i := range rows {
+				if rows[i].RuleID != rows[i].ExistingRuleID {
+					rows[i].RuleID = 0
+				}
+			}
+		}
+		return report, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	report.Committed = true
+	return report, nil
+}
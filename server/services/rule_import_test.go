@@ -0,0 +1,212 @@
+package services
+
+import (
+	"bytes"
+	"krampus/server/database"
+	"krampus/server/models"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRuleImport(t *testing.T) {
+	comment := "build tool"
+
+	tests := []struct {
+		name    string
+		format  string
+		data    string
+		want    []RuleImportEntry
+		wantErr bool
+	}{
+		{
+			name:   "json",
+			format: RuleFormatJSON,
+			data:   `[{"identifier": "` + testTeamID + `", "rule_type": "TEAMID", "policy": "ALLOWLIST", "comment": "build tool"}]`,
+			want:   []RuleImportEntry{{Row: 1, Identifier: testTeamID, RuleType: "TEAMID", Policy: "ALLOWLIST", Comment: &comment}},
+		},
+		{
+			name:   "santactl sha256",
+			format: RuleFormatSantactl,
+			data:   `{"rules": [{"sha256": "` + testHash + `", "rule_type": "BINARY", "policy": "BLOCKLIST", "custom_msg": "build tool"}]}`,
+			want:   []RuleImportEntry{{Row: 1, Identifier: testHash, RuleType: "BINARY", Policy: "BLOCKLIST", CustomMessage: &comment}},
+		},
+		{
+			name:   "csv columns in any order",
+			format: RuleFormatCSV,
+			data:   "policy,identifier,rule_type,comment\nALLOWLIST," + testTeamID + ",TEAMID,\nBLOCKLIST," + testHash + ",BINARY,build tool\n",
+			want: []RuleImportEntry{
+				{Row: 2, Identifier: testTeamID, RuleType: "TEAMID", Policy: "ALLOWLIST"},
+				{Row: 3, Identifier: testHash, RuleType: "BINARY", Policy: "BLOCKLIST", Comment: &comment},
+			},
+		},
+		{name: "csv header only", format: RuleFormatCSV, data: "identifier,rule_type,policy\n"},
+		{name: "csv missing column", format: RuleFormatCSV, data: "identifier,policy\n" + testHash + ",ALLOWLIST\n", wantErr: true},
+		{name: "invalid json", format: RuleFormatJSON, data: `{"rules": []}`, wantErr: true},
+		{name: "unsupported format", format: "plist", data: `[]`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := ParseRuleImport(tt.format, []byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRuleImport error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(entries, tt.want) {
+				t.Errorf("entries = %+v, want %+v", entries, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportRules(t *testing.T) {
+	allow, block := string(models.PolicyAllowlist), string(models.PolicyBlocklist)
+	binary, teamID := string(models.RuleTypeBinary), string(models.RuleTypeTeamID)
+	comment := "build tool"
+
+	tests := []struct {
+		name      string
+		existing  *models.Rule // Active rule for testHash before the import
+		entries   []RuleImportEntry
+		dryRun    bool
+		supersede bool
+		committed bool
+		added     int
+		updated   int
+		unchanged int
+		conflicts int
+		invalid   int
+		policy    string // Active policy for testHash afterwards, empty for none
+	}{
+		{
+			name:      "add",
+			entries:   []RuleImportEntry{{Row: 1, Identifier: strings.ToUpper(testHash), RuleType: "binary", Policy: "allowlist"}},
+			committed: true, added: 1, policy: allow,
+		},
+		{
+			name:    "dry run",
+			entries: []RuleImportEntry{{Row: 1, Identifier: testHash, RuleType: binary, Policy: allow}},
+			dryRun:  true, added: 1,
+		},
+		{
+			name:      "update comment",
+			existing:  &models.Rule{Identifier: testHash, RuleType: binary, Policy: allow},
+			entries:   []RuleImportEntry{{Row: 1, Identifier: testHash, RuleType: binary, Policy: allow, Comment: &comment}},
+			committed: true, updated: 1, policy: allow,
+		},
+		{
+			name:      "unchanged",
+			existing:  &models.Rule{Identifier: testHash, RuleType: binary, Policy: allow},
+			entries:   []RuleImportEntry{{Row: 1, Identifier: testHash, RuleType: binary, Policy: allow}},
+			committed: true, unchanged: 1, policy: allow,
+		},
+		{
+			name:     "conflict rolls back the whole file",
+			existing: &models.Rule{Identifier: testHash, RuleType: binary, Policy: allow},
+			entries: []RuleImportEntry{
+				{Row: 1, Identifier: testTeamID, RuleType: teamID, Policy: allow},
+				{Row: 2, Identifier: testHash, RuleType: binary, Policy: block},
+			},
+			added: 1, conflicts: 1, policy: allow,
+		},
+		{
+			name:      "conflict superseded",
+			existing:  &models.Rule{Identifier: testHash, RuleType: binary, Policy: allow},
+			entries:   []RuleImportEntry{{Row: 1, Identifier: testHash, RuleType: binary, Policy: block}},
+			supersede: true, committed: true, conflicts: 1, policy: block,
+		},
+		{
+			name:      "rules directory rule",
+			existing:  &models.Rule{Identifier: testHash, RuleType: binary, Policy: allow, Source: string(models.RuleSourceGitOps)},
+			entries:   []RuleImportEntry{{Row: 1, Identifier: testHash, RuleType: binary, Policy: block}},
+			supersede: true, conflicts: 1, policy: allow,
+		},
+		{
+			name: "invalid and duplicate rows",
+			entries: []RuleImportEntry{
+				{Row: 1, Identifier: testHash, RuleType: binary, Policy: allow},
+				{Row: 2, Identifier: testHash, RuleType: binary, Policy: allow},
+				{Row: 3, Identifier: "not-a-hash", RuleType: binary, Policy: allow},
+			},
+			added: 1, invalid: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			mustExec(t, `INSERT INTO users (id, username, role) VALUES (1, 'admin', 'ADMIN')`)
+			if tt.existing != nil {
+				createTestRule(t, *tt.existing)
+			}
+
+			report, err := ImportRules(RuleFormatJSON, tt.entries, 1, tt.dryRun, tt.supersede)
+			if err != nil {
+				t.Fatalf("ImportRules failed: %v", err)
+			}
+			if report.Committed != tt.committed {
+				t.Errorf("committed = %v, want %v", report.Committed, tt.committed)
+			}
+			got := []int{len(report.Added), len(report.Updated), report.Unchanged, len(report.Conflicts), len(report.Invalid)}
+			want := []int{tt.added, tt.updated, tt.unchanged, tt.conflicts, tt.invalid}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("added, updated, unchanged, conflicts, invalid = %v, want %v", got, want)
+			}
+			if !report.Committed {
+				for _, row := range append(report.Added, report.Conflicts...) {
+					if row.RuleID != 0 && row.RuleID != row.ExistingRuleID {
+						t.Errorf("row %d reports rule %d from a rolled back import", row.Row, row.RuleID)
+					}
+				}
+			}
+
+			var policy string
+			err = database.DB.QueryRow(
+				`SELECT COALESCE(MAX(policy), '') FROM rules WHERE identifier = ? AND rule_type = ? AND superseded_at IS NULL`,
+				testHash, binary).Scan(&policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if policy != tt.policy {
+				t.Errorf("active policy = %q, want %q", policy, tt.policy)
+			}
+		})
+	}
+}
+
+func TestWriteRulesRoundTrip(t *testing.T) {
+	message, owner := "Ask #it for access", "platform"
+	rules := []models.Rule{
+		{Identifier: testHash, RuleType: string(models.RuleTypeBinary), Policy: string(models.PolicyBlocklist), CustomMessage: &message},
+		{Identifier: testTeamID, RuleType: string(models.RuleTypeTeamID), Policy: string(models.PolicyAllowlist), OwnerTeam: &owner},
+	}
+
+	for _, format := range []string{RuleFormatJSON, RuleFormatCSV, RuleFormatSantactl} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteRules(&buf, format, rules); err != nil {
+				t.Fatalf("WriteRules failed: %v", err)
+			}
+			entries, err := ParseRuleImport(format, buf.Bytes())
+			if err != nil {
+				t.Fatalf("ParseRuleImport failed: %v", err)
+			}
+			if len(entries) != len(rules) {
+				t.Fatalf("%d entries, want %d", len(entries), len(rules))
+			}
+			for i, entry := range entries {
+				r := rules[i]
+				if entry.Identifier != r.Identifier || entry.RuleType != r.RuleType || entry.Policy != r.Policy {
+					t.Errorf("entry %d = %s %s %s, want %s %s %s", i, entry.Identifier, entry.RuleType, entry.Policy, r.Identifier, r.RuleType, r.Policy)
+				}
+				if !reflect.DeepEqual(entry.CustomMessage, r.CustomMessage) {
+					t.Errorf("entry %d custom message = %v, want %v", i, entry.CustomMessage, r.CustomMessage)
+				}
+				// santactl has no owner team field
+				if format != RuleFormatSantactl && !reflect.DeepEqual(entry.OwnerTeam, r.OwnerTeam) {
+					t.Errorf("entry %d owner team = %v, want %v", i, entry.OwnerTeam, r.OwnerTeam)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package services
+
+import (
+	"fmt"
+	"krampus/server/config"
+	"krampus/server/database"
+	"krampus/server/models"
+	"sort"
+	"time"
+)
+
+// lintRule accumulates what a lint replay found for one rule
+type lintRule struct {
+	rule      models.Rule
+	matched   int  // Observed binaries the rule matches
+	covered   int  // Of those, binaries the next applicable rule gives the same policy
+	seen      bool // Matched at least one binary, observed or implied
+	uncovered bool // Decides at least one binary differently than the rules below it
+	coverers  map[int64]bool
+}
+
+// lintPair is a rule overridden by a higher precedence rule with the
+// opposite effect
+type lintPair struct {
+	winner, loser int64
+}
+
+// ruleEffect groups policies by what they do to an execution. CEL rules
+// depend on the execution and have no fixed effect.
+func ruleEffect(policy string) string {
+	switch {
+	case policy == string(models.PolicyCEL), policy == string(models.PolicyRemove):
+		return ""
+	case isBlockPolicy(policy):
+		return "BLOCK"
+	}
+	return "ALLOW"
+}
+
+// scopesOverlap reports whether two rules can apply on the same machine. Rules
+// scoped to different groups are assumed not to, although groups may share
+// members.
+func scopesOverlap(a, b *int64) bool {
+	return a == nil || b == nil || *a == *b
+}
+
+// scopeContains reports whether a rule with the outer scope applies wherever
+// one with the inner scope does
+func scopeContains(outer, inner *int64) bool {
+	return outer == nil || (inner != nil && *outer == *inner)
+}
+
+// LintRuleset analyzes the active, unexpired ruleset. It replays every binary
+// seen in events against the rules matching it in Santa's precedence order
+// and reports rules overridden by a more specific rule with the opposite
+// effect, and rules whose binaries would get the same policy from the next
+// rule down without them. Signing ID rules no event has matched are checked
+// against the Team ID they name. It also reports Team ID and certificate
+// allowlists covering at least broadThreshold distinct binaries (the
+// configured default when zero), and rules whose identifier no event has
+// reported. REMOVE rules are left out.
+func LintRuleset(broadThreshold int) (*models.RulesetLint, error) {
+	if broadThreshold <= 0 {
+		broadThreshold = config.AppConfig.LintBroadThreshold
+	}
+	report := &models.RulesetLint{
+		GeneratedAt:    time.Now(),
+		BroadThreshold: broadThreshold,
+		Counts:         map[string]int{},
+		Findings:       []models.LintFinding{},
+	}
+	for _, kind := range []models.LintKind{
+		models.LintKindConflict, models.LintKindRedundant, models.LintKindBroadAllowlist, models.LintKindUnseen,
+	} {
+		report.Counts[string(kind)] = 0
+	}
+
+	active, err := activeRulesByTarget()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	rules := map[models.RuleTarget][]*lintRule{}
+	for target, scoped := range active {
+		for _, r := range scoped {
+			if r.Policy == string(models.PolicyRemove) || (r.ExpiresAt != nil && !r.ExpiresAt.After(now)) {
+				continue
+			}
+			rules[target] = append(rules[target], &lintRule{rule: r, coverers: map[int64]bool{}})
+			report.RuleCount++
+		}
+	}
+
+	// Attributes rarely vary for one hash, so the last reported values are used
+	rows, err := database.DB.Query(
+		`SELECT file_hash, MAX(COALESCE(cdhash, '')), MAX(COALESCE(signing_id, '')),
+		        MAX(COALESCE(team_id, '')), MAX(COALESCE(cert_sha256, ''))
+		 FROM events WHERE file_hash != '' GROUP BY file_hash`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events: %w", err)
+	}
+	defer rows.Close()
+
+	conflicts := map[lintPair]int{}
+	for rows.Next() {
+		var attrs models.BinaryAttributes
+		if err := rows.Scan(&attrs.SHA256, &attrs.CDHash, &attrs.SigningID, &attrs.TeamID, &attrs.CertSHA256); err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		normalizeAttributes(&attrs)
+		replayLint(lintChain(rules, attrs), conflicts, 1, nil)
+		report.BinaryCount++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read events: %w", err)
+	}
+
+	// A signing ID rule applies to binaries of the team it names, whether or
+	// not any have run yet. These implied binaries only count for that rule.
+	for _, scoped := range rules {
+		for _, lr := range scoped {
+			if lr.seen || lr.rule.RuleType != string(models.RuleTypeSigningID) {
+				continue
+			}
+			attrs := models.BinaryAttributes{SigningID: lr.rule.Identifier}
+			normalizeAttributes(&attrs)
+			replayLint(lintChain(rules, attrs), conflicts, 0, lr)
+		}
+	}
+
+	byID := map[int64]*lintRule{}
+	for _, scoped := range rules {
+		for _, lr := range scoped {
+			byID[lr.rule.ID] = lr
+		}
+	}
+
+	for pair, binaries := range conflicts {
+		winner, loser := byID[pair.winner].rule, byID[pair.loser].rule
+		report.Findings = append(report.Findings, lintFinding(loser, models.LintKindConflict, models.LintSeverityWarning,
+			binaries, []int64{winner.ID},
+			fmt.Sprintf("Overridden by %s %s rule %d (%s)%s",
+				winner.Policy, winner.RuleType, winner.ID, winner.Identifier, observedBinaries(binaries))))
+	}
+
+	for _, lr := range byID {
+		if !lr.seen || lr.uncovered {
+			continue
+		}
+		related := make([]int64, 0, len(lr.coverers))
+		for id := range lr.coverers {
+			related = append(related, id)
+		}
+		sort.Slice(related, func(i, j int) bool { return related[i] < related[j] })
+
+		message := fmt.Sprintf("Every binary it matches gets %s from %d lower precedence rules", lr.rule.Policy, len(related))
+		if len(related) == 1 {
+			coverer := byID[related[0]].rule
+			message = fmt.Sprintf("Every binary it matches gets %s from %s rule %d (%s)",
+				lr.rule.Policy, coverer.RuleType, coverer.ID, coverer.Identifier)
+		}
+		report.Findings = append(report.Findings, lintFinding(lr.rule, models.LintKindRedundant, models.LintSeverityInfo,
+			lr.matched, related, message+observedBinaries(lr.matched)))
+	}
+
+	for target, scoped := range rules {
+		clause, args := eventClause(target)
+		var executions, binaries int
+		err := database.DB.QueryRow(
+			`SELECT COUNT(*), COUNT(DISTINCT NULLIF(file_hash, '')) FROM events WHERE `+clause, args...,
+		).Scan(&executions, &binaries)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count rule executions: %w", err)
+		}
+
+		for _, lr := range scoped {
+			if executions == 0 {
+				report.Findings = append(report.Findings, lintFinding(lr.rule, models.LintKindUnseen, models.LintSeverityInfo,
+					0, nil, "No event has reported this identifier"))
+				continue
+			}
+
+			publisher := target.RuleType == string(models.RuleTypeTeamID) || target.RuleType == string(models.RuleTypeCertificate)
+			if publisher && ruleEffect(lr.rule.Policy) == "ALLOW" && binaries >= broadThreshold {
+				scope := "fleet-wide"
+				if lr.rule.GroupID != nil {
+					scope = fmt.Sprintf("in group %d", *lr.rule.GroupID)
+				}
+				report.Findings = append(report.Findings, lintFinding(lr.rule, models.LintKindBroadAllowlist, models.LintSeverityWarning,
+					binaries, nil,
+					fmt.Sprintf("Allows everything this publisher signs %s; %d distinct binaries seen", scope, binaries)))
+			}
+		}
+	}
+
+	kindOrder := map[string]int{
+		string(models.LintKindConflict):       0,
+		string(models.LintKindBroadAllowlist): 1,
+		string(models.LintKindRedundant):      2,
+		string(models.LintKindUnseen):         3,
+	}
+	sort.Slice(report.Findings, func(i, j int) bool {
+		a, b := report.Findings[i], report.Findings[j]
+		if a.Severity != b.Severity {
+			return a.Severity == string(models.LintSeverityWarning)
+		}
+		if a.Kind != b.Kind {
+			return kindOrder[a.Kind] < kindOrder[b.Kind]
+		}
+		if a.RuleID != b.RuleID {
+			return a.RuleID < b.RuleID
+		}
+		return fmt.Sprint(a.RelatedRuleIDs) < fmt.Sprint(b.RelatedRuleIDs)
+	})
+	for _, f := range report.Findings {
+		report.Counts[f.Kind]++
+	}
+	return report, nil
+}
+
+// lintChain lists the linted rules matching a binary in precedence order,
+// a target's group-scoped rules before its fleet-wide one
+func lintChain(rules map[models.RuleTarget][]*lintRule, attrs models.BinaryAttributes) []*lintRule {
+	var chain []*lintRule
+	for _, target := range binaryTargets(attrs) {
+		chain = append(chain, rules[target]...)
+	}
+	return chain
+}
+
+// replayLint records, for one binary, which rules in its chain are covered
+// by the next applicable rule and which are overridden by a higher
+// precedence rule with the opposite effect. Counts are added with weight.
+// When only is set, just the findings involving that rule are recorded.
+func replayLint(chain []*lintRule, conflicts map[lintPair]int, weight int, only *lintRule) {
+	for i, lr := range chain {
+		effect := ruleEffect(lr.rule.Policy)
+		if effect == "" {
+			continue
+		}
+
+		for _, lower := range chain[i+1:] {
+			lowerEffect := ruleEffect(lower.rule.Policy)
+			if lowerEffect == "" || lowerEffect == effect || !scopesOverlap(lr.rule.GroupID, lower.rule.GroupID) {
+				continue
+			}
+			if only == nil || only == lr || only == lower {
+				conflicts[lintPair{winner: lr.rule.ID, loser: lower.rule.ID}] += weight
+			}
+		}
+
+		if only != nil && only != lr {
+			continue
+		}
+		lr.seen = true
+		lr.matched += weight
+
+		var next *lintRule
+		for _, lower := range chain[i+1:] {
+			if scopesOverlap(lr.rule.GroupID, lower.rule.GroupID) {
+				next = lower
+				break
+			}
+		}
+		if next != nil && next.rule.Policy == lr.rule.Policy && scopeContains(next.rule.GroupID, lr.rule.GroupID) {
+			lr.covered += weight
+			lr.coverers[next.rule.ID] = true
+		} else {
+			lr.uncovered = true
+		}
+	}
+}
+
+func lintFinding(r models.Rule, kind models.LintKind, severity models.LintSeverity, binaries int, related []int64, message string) models.LintFinding {
+	return models.LintFinding{
+		Kind:           string(kind),
+		Severity:       string(severity),
+		RuleID:         r.ID,
+		Identifier:     r.Identifier,
+		RuleType:       r.RuleType,
+		Policy:         r.Policy,
+		GroupID:        r.GroupID,
+		RelatedRuleIDs: related,
+		Binaries:       binaries,
+		Message:        message,
+	}
+}
+
+// observedBinaries describes how many observed binaries a finding covers;
+// findings implied by a signing ID alone have none
+func observedBinaries(n int) string {
+	switch n {
+	case 0:
+		return ""
+	case 1:
+		return " for 1 observed binary"
+	}
+	return fmt.Sprintf(" for %d observed binaries", n)
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"krampus/server/config"
+	"krampus/server/models"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLintRuleset(t *testing.T) {
+	otherHash := strings.Repeat("b", 64)
+	vendorHash := strings.Repeat("c", 64)
+	unseenHash := strings.Repeat("d", 64)
+	binary, signingID, teamID := string(models.RuleTypeBinary), string(models.RuleTypeSigningID), string(models.RuleTypeTeamID)
+
+	tests := []struct {
+		name      string
+		threshold int      // Passed to LintRuleset; the configured default is 2
+		findings  []string // "KIND rule related...", in report order
+	}{
+		{
+			name:      "configured threshold",
+			threshold: 0,
+			findings: []string{
+				"CONFLICT team tool",
+				"BROAD_ALLOWLIST team",
+				"REDUNDANT vendor-binary vendor",
+				"REDUNDANT unseen-signing team",
+				"UNSEEN unseen-binary",
+				"UNSEEN unseen-signing",
+			},
+		},
+		{
+			name:      "higher threshold",
+			threshold: 3,
+			findings: []string{
+				"CONFLICT team tool",
+				"REDUNDANT vendor-binary vendor",
+				"REDUNDANT unseen-signing team",
+				"UNSEEN unseen-binary",
+				"UNSEEN unseen-signing",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			config.AppConfig.LintBroadThreshold = 2
+
+			rules := map[string]int64{
+				"team":           createTestRule(t, testRule(0, teamID, testTeamID, models.PolicyAllowlist)),
+				"tool":           createTestRule(t, testRule(0, signingID, testSigning, models.PolicyBlocklist)),
+				"vendor":         createTestRule(t, testRule(0, teamID, "ZZZZZ99999", models.PolicyAllowlist)),
+				"vendor-binary":  createTestRule(t, testRule(0, binary, vendorHash, models.PolicyAllowlist)),
+				"unseen-binary":  createTestRule(t, testRule(0, binary, unseenHash, models.PolicyBlocklist)),
+				"unseen-signing": createTestRule(t, testRule(0, signingID, "ABCDE12345:com.example.new", models.PolicyAllowlist)),
+			}
+			// Removals and expired rules are not linted
+			createTestRule(t, testRule(0, binary, otherHash, models.PolicyRemove))
+			expired := createTestRule(t, testRule(0, binary, strings.Repeat("e", 64), models.PolicyBlocklist))
+			mustExec(t, `UPDATE rules SET expires_at = ? WHERE id = ?`, time.Now().Add(-time.Hour), expired)
+
+			for _, e := range []struct{ hash, signingID, teamID string }{
+				{testHash, "com.example.tool", testTeamID},
+				{otherHash, "com.example.other", testTeamID},
+				{vendorHash, "com.vendor.app", "ZZZZZ99999"},
+			} {
+				mustExec(t, `INSERT INTO events (machine_id, file_hash, decision, signing_id, team_id, execution_time)
+					VALUES ('m1', ?, 'ALLOW_UNKNOWN', ?, ?, ?)`, e.hash, e.signingID, e.teamID, time.Now())
+			}
+
+			report, err := LintRuleset(tt.threshold)
+			if err != nil {
+				t.Fatalf("LintRuleset failed: %v", err)
+			}
+			if report.RuleCount != 6 || report.BinaryCount != 3 {
+				t.Errorf("linted %d rules against %d binaries, want 6 against 3", report.RuleCount, report.BinaryCount)
+			}
+
+			names := map[int64]string{}
+			for name, id := range rules {
+				names[id] = name
+			}
+			var findings []string
+			for _, f := range report.Findings {
+				finding := f.Kind + " " + names[f.RuleID]
+				for _, id := range f.RelatedRuleIDs {
+					finding += " " + names[id]
+				}
+				findings = append(findings, finding)
+			}
+			if !reflect.DeepEqual(findings, tt.findings) {
+				t.Errorf("findings = %q, want %q", findings, tt.findings)
+			}
+			if report.Counts[string(models.LintKindUnseen)] != 2 {
+				t.Errorf("counts = %v, want 2 unseen", report.Counts)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"krampus/server/config"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Placeholders a rule's or group's block message and URL templates may use.
+// The server fills them in per rule as clients download rules; Santa's own
+// %placeholders% are passed through for the client to fill at block time.
+const (
+	placeholderReason      = "reason"       // Why the rule exists: the reason given with its latest change, else its comment
+	placeholderProposalURL = "proposal_url" // The proposal behind the rule, or the block page for rules without one
+	placeholderOwnerTeam   = "owner_team"   // The rule's owner team, else its group's
+)
+
+var (
+	placeholderPattern      = regexp.MustCompile(`\{([a-z_]+)\}`)
+	santaPlaceholderPattern = regexp.MustCompile(`%[a-z_]+%`)
+)
+
+// RuleMessageColumns selects the fields a downloaded rule's block message
+// and URL are rendered from, in RuleMessage order. A rule without its own
+// template or owner team takes its group's.
+const RuleMessageColumns = `
+	COALESCE(custom_message, (SELECT g.custom_message FROM machine_groups g WHERE g.id = rules.group_id)),
+	COALESCE(custom_url, (SELECT g.custom_url FROM machine_groups g WHERE g.id = rules.group_id)),
+	COALESCE((SELECT v.reason FROM rule_versions v WHERE v.rule_id = rules.id AND v.reason IS NOT NULL
+	          ORDER BY v.version DESC LIMIT 1), comment),
+	(SELECT p.identifier FROM proposals p WHERE p.id = rules.proposal_id),
+	COALESCE(owner_team, (SELECT g.owner_team FROM machine_groups g WHERE g.id = rules.group_id))`
+
+// RuleMessage holds a rule's block message and URL templates and the
+// values filled into them
+type RuleMessage struct {
+	MessageTemplate    *string
+	URLTemplate        *string
+	Reason             *string
+	ProposalIdentifier *string
+	OwnerTeam          *string
+}
+
+// RenderRuleMessage fills the placeholders in a rule's templates. Values
+// placed in the URL are query-escaped, except the proposal URL, which is a
+// URL itself. Templates that render empty are left unset.
+func RenderRuleMessage(m RuleMessage) (message, customURL *string) {
+	proposalURL := config.AppConfig.SyncBaseURL + "/blocked?hash=%file_identifier%&machine=%machine_id%"
+	if m.ProposalIdentifier != nil {
+		proposalURL = config.AppConfig.SyncBaseURL + "/proposals?hash=" + url.QueryEscape(*m.ProposalIdentifier)
+	}
+	values := map[string]string{
+		placeholderReason:      deref(m.Reason),
+		placeholderProposalURL: proposalURL,
+		placeholderOwnerTeam:   deref(m.OwnerTeam),
+	}
+
+	if m.MessageTemplate != nil {
+		message = nullIfEmpty(fillPlaceholders(*m.MessageTemplate, func(name string) string {
+			return values[name]
+		}))
+	}
+	if m.URLTemplate != nil {
+		customURL = nullIfEmpty(fillPlaceholders(*m.URLTemplate, func(name string) string {
+			if name == placeholderProposalURL {
+				return values[name]
+			}
+			return url.QueryEscape(values[name])
+		}))
+	}
+	return message, customURL
+}
+
+// fillPlaceholders replaces each known {placeholder} in a template
+func fillPlaceholders(template string, value func(name string) string) string {
+	return strings.TrimSpace(placeholderPattern.ReplaceAllStringFunc(template, func(match string) string {
+		name := match[1 : len(match)-1]
+		if !knownPlaceholder(name) {
+			return match
+		}
+		return value(name)
+	}))
+}
+
+func knownPlaceholder(name string) bool {
+	switch name {
+	case placeholderReason, placeholderProposalURL, placeholderOwnerTeam:
+		return true
+	}
+	return false
+}
+
+// ValidateMessageTemplates checks a block message and URL template: both may
+// only use known placeholders, and the URL must be an absolute http(s) URL
+// once filled in. Missing or empty templates are valid.
+func ValidateMessageTemplates(message, customURL *string) ValidationErrors {
+	var errs ValidationErrors
+	if err := validatePlaceholders("custom_message", deref(message)); err != nil {
+		errs = append(errs, *err)
+	}
+	if deref(customURL) == "" {
+		return errs
+	}
+	if err := validatePlaceholders("custom_url", *customURL); err != nil {
+		errs = append(errs, *err)
+		return errs
+	}
+	sample := fillPlaceholders(*customURL, func(name string) string {
+		if name == placeholderProposalURL {
+			return "https://example.com/proposals"
+		}
+		return "x"
+	})
+	sample = santaPlaceholderPattern.ReplaceAllString(sample, "x")
+	if u, err := url.Parse(sample); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, ValidationError{Field: "custom_url", Message: "must be an absolute http or https URL"})
+	}
+	return errs
+}
+
+func validatePlaceholders(field, template string) *ValidationError {
+	for _, match := range placeholderPattern.FindAllStringSubmatch(template, -1) {
+		if !knownPlaceholder(match[1]) {
+			return &ValidationError{
+				Field:   field,
+				Message: "unknown placeholder {" + match[1] + "}; use {reason}, {proposal_url} or {owner_team}",
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"krampus/server/config"
+	"krampus/server/database"
+	"krampus/server/models"
+	"reflect"
+	"testing"
+)
+
+func TestValidateMessageTemplates(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		url     string
+		fields  []string
+	}{
+		{name: "no templates"},
+		{name: "known placeholders", message: "Blocked: {reason}. Ask {owner_team}.", url: "{proposal_url}&team={owner_team}"},
+		{name: "Santa placeholders", url: "https://help.example.com/?hash=%file_sha%&machine=%machine_id%"},
+		{name: "unknown message placeholder", message: "Blocked by {owner}", fields: []string{"custom_message"}},
+		{name: "unknown URL placeholder", url: "https://example.com/{rule}", fields: []string{"custom_url"}},
+		{name: "relative URL", url: "/proposals?reason={reason}", fields: []string{"custom_url"}},
+		{name: "other scheme", url: "ftp://example.com/", fields: []string{"custom_url"}},
+		{name: "both invalid", message: "{owner}", url: "example.com", fields: []string{"custom_message", "custom_url"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fields []string
+			for _, err := range ValidateMessageTemplates(&tt.message, &tt.url) {
+				fields = append(fields, err.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("errors on %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestRenderRuleMessage(t *testing.T) {
+	config.AppConfig = &config.Config{SyncBaseURL: "https://krampus.example.com"}
+	reason, team, proposal := "Approved for the build team", "Platform & Tools", testHash
+
+	tests := []struct {
+		name    string
+		msg     RuleMessage
+		message *string
+		url     *string
+	}{
+		{name: "no templates", msg: RuleMessage{Reason: &reason}},
+		{
+			name:    "message",
+			msg:     RuleMessage{MessageTemplate: ptrTo("{reason} ({owner_team}, {unknown})"), Reason: &reason, OwnerTeam: &team},
+			message: ptrTo("Approved for the build team (Platform & Tools, {unknown})"),
+		},
+		{
+			name: "URL values are escaped",
+			msg:  RuleMessage{URLTemplate: ptrTo("https://help.example.com/?team={owner_team}"), OwnerTeam: &team},
+			url:  ptrTo("https://help.example.com/?team=Platform+%26+Tools"),
+		},
+		{
+			name: "proposal URL",
+			msg:  RuleMessage{URLTemplate: ptrTo("{proposal_url}"), ProposalIdentifier: &proposal},
+			url:  ptrTo("https://krampus.example.com/proposals?hash=" + testHash),
+		},
+		{
+			name: "block page without a proposal",
+			msg:  RuleMessage{URLTemplate: ptrTo("{proposal_url}")},
+			url:  ptrTo("https://krampus.example.com/blocked?hash=%file_identifier%&machine=%machine_id%"),
+		},
+		{name: "rendered empty", msg: RuleMessage{MessageTemplate: ptrTo(" {reason} ")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, url := RenderRuleMessage(tt.msg)
+			if !reflect.DeepEqual(message, tt.message) {
+				t.Errorf("message = %q, want %q", deref(message), deref(tt.message))
+			}
+			if !reflect.DeepEqual(url, tt.url) {
+				t.Errorf("URL = %q, want %q", deref(url), deref(tt.url))
+			}
+		})
+	}
+}
+
+func TestRuleMessageColumns(t *testing.T) {
+	openTestDB(t)
+	mustExec(t, `INSERT INTO machine_groups (id, name, custom_message, custom_url, owner_team)
+		VALUES (7, 'eng', 'Group message', 'https://eng.example.com/', 'Engineering')`)
+
+	// A rule without its own templates takes its group's
+	grouped := inGroup(testRule(0, string(models.RuleTypeBinary), testHash, models.PolicyBlocklist), 7)
+	grouped.Comment = ptrTo("Old build tool")
+	groupedID := createTestRule(t, grouped)
+
+	own := testRule(0, string(models.RuleTypeTeamID), testTeamID, models.PolicyBlocklist)
+	own.CustomMessage, own.OwnerTeam = ptrTo("Rule message"), ptrTo("Security")
+	ownID, err := CreateRule(own, ptrTo("Known malware vendor"), false, nil)
+	if err != nil {
+		t.Fatalf("failed to create rule: %v", err)
+	}
+
+	tests := []struct {
+		ruleID int64
+		want   RuleMessage
+	}{
+		{groupedID, RuleMessage{
+			MessageTemplate: ptrTo("Group message"), URLTemplate: ptrTo("https://eng.example.com/"),
+			Reason: ptrTo("Old build tool"), OwnerTeam: ptrTo("Engineering"),
+		}},
+		{ownID, RuleMessage{MessageTemplate: ptrTo("Rule message"), Reason: ptrTo("Known malware vendor"), OwnerTeam: ptrTo("Security")}},
+	}
+	for _, tt := range tests {
+		var m RuleMessage
+		err := database.DB.QueryRow(`SELECT `+RuleMessageColumns+` FROM rules WHERE id = ?`, tt.ruleID).
+			Scan(&m.MessageTemplate, &m.URLTemplate, &m.Reason, &m.ProposalIdentifier, &m.OwnerTeam)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(m, tt.want) {
+			t.Errorf("rule %d message fields = %+v, want %+v", tt.ruleID, m, tt.want)
+		}
+	}
+}
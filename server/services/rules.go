@@ -0,0 +1,589 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"krampus/server/database"
+	"krampus/server/models"
+	"time"
+)
+
+var (
+	ErrRuleNotFound        = errors.New("rule not found")
+	ErrRuleVersionNotFound = errors.New("rule version not found")
+	ErrRuleSuperseded      = errors.New("rule has been superseded")
+	ErrRuleManaged         = errors.New("rule is managed by the rules directory or a threat feed")
+	ErrPolicyRuleType      = errors.New("policy does not apply to the rule's type")
+)
+
+// RuleColumns is the shared projection for rules, read by ScanRule
+const RuleColumns = `id, identifier, policy, rule_type, cel_expr, custom_message, custom_url, owner_team, comment,
+	created_by, proposal_id, created_at, updated_at, superseded_by, superseded_at,
+	source, source_file, feed_id, group_id, expires_at, hit_count, last_hit_at`
+
+// ActiveRule is the WHERE condition selecting rules that have not been
+// superseded. At most one active rule exists per identifier and rule type.
+const ActiveRule = `superseded_at IS NULL`
+
+// readOnlyRule reports whether a rule is managed outside the API, by the
+// rules directory or a threat feed, so edits through the API would be undone
+func readOnlyRule(r models.Rule) bool {
+	return r.Source == string(models.RuleSourceGitOps) || r.Source == string(models.RuleSourceFeed)
+}
+
+// ScanRule scans a row produced by selecting RuleColumns
+func ScanRule(row interface{ Scan(...interface{}) error }, r *models.Rule) error {
+	return row.Scan(
+		&r.ID, &r.Identifier, &r.Policy, &r.RuleType, &r.CELExpr, &r.CustomMessage, &r.CustomURL, &r.OwnerTeam, &r.Comment,
+		&r.CreatedBy, &r.ProposalID, &r.CreatedAt, &r.UpdatedAt, &r.SupersededBy, &r.SupersededAt,
+		&r.Source, &r.SourceFile, &r.FeedID, &r.GroupID, &r.ExpiresAt, &r.HitCount, &r.LastHitAt,
+	)
+}
+
+// RuleUpdate lists the rule fields an update may change; nil fields are left
+// alone. Empty strings, a zero group ID and a zero expiry clear the field.
+type RuleUpdate struct {
+	Policy        *string
+	CELExpr       *string
+	CustomMessage *string
+	CustomURL     *string
+	OwnerTeam     *string
+	Comment       *string
+	GroupID       *int64
+	ExpiresAt     *time.Time
+}
+
+// getRule fetches a rule within a transaction
+func getRule(tx *sql.Tx, ruleID int64) (models.Rule, error) {
+	var r models.Rule
+	err := ScanRule(tx.QueryRow(`SELECT `+RuleColumns+` FROM rules WHERE id = ?`, ruleID), &r)
+	if err == sql.ErrNoRows {
+		return r, ErrRuleNotFound
+	}
+	if err != nil {
+		return r, fmt.Errorf("failed to fetch rule: %w", err)
+	}
+	return r, nil
+}
+
+// activeRule returns the active rule for an identifier and rule type in a
+// scope (a group, or fleet-wide when groupID is nil), or nil
+func activeRule(tx *sql.Tx, identifier, ruleType string, groupID *int64) (*models.Rule, error) {
+	var r models.Rule
+	err := ScanRule(tx.QueryRow(
+		`SELECT `+RuleColumns+` FROM rules WHERE identifier = ? AND rule_type = ? AND group_id IS ? AND `+ActiveRule,
+		identifier, ruleType, groupID,
+	), &r)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch active rule: %w", err)
+	}
+	return &r, nil
+}
+
+// checkScopeFree returns a *RuleConflictError when another active rule holds
+// a rule's target in the scope it is moving to
+func checkScopeFree(tx *sql.Tx, rule models.Rule) error {
+	existing, err := activeRule(tx, rule.Identifier, rule.RuleType, rule.GroupID)
+	if err != nil {
+		return err
+	}
+	if existing != nil && existing.ID != rule.ID {
+		return &RuleConflictError{
+			RuleID:     existing.ID,
+			Identifier: existing.Identifier,
+			RuleType:   existing.RuleType,
+			Policy:     existing.Policy,
+		}
+	}
+	return nil
+}
+
+// CreateRule inserts a rule and records its first version. An active rule
+// for the same identifier and rule type is only replaced when supersede is
+// set; otherwise a *RuleConflictError is returned. With a rollout plan the
+// rule starts out served to the first stage's group only.
+func CreateRule(rule models.Rule, reason *string, supersede bool, rollout *RolloutPlan) (int64, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	ruleID, err := insertRule(tx, newRulesetRevision(rule.CreatedBy, reason), rule, rule.CreatedBy, reason, supersede)
+	if err != nil {
+		return 0, err
+	}
+	if rollout != nil {
+		if err := startRollout(tx, ruleID, *rollout, rule.CreatedBy); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return ruleID, nil
+}
+
+// insertRule inserts a rule within a transaction and records its first
+// version under the transaction's revision, superseding the active rule for the same target if allowed. Only
+// the rules directory may supersede a rule it manages; threat feed rules can
+// be superseded like database rules, which the feed then leaves alone.
+func insertRule(tx *sql.Tx, revision *rulesetRevision, rule models.Rule, changedBy *int64, reason *string, supersede bool) (int64, error) {
+	if rule.Source == "" {
+		rule.Source = string(models.RuleSourceDatabase)
+	}
+
+	existing, err := activeRule(tx, rule.Identifier, rule.RuleType, rule.GroupID)
+	if err != nil {
+		return 0, err
+	}
+	if existing != nil {
+		if supersede && existing.Source == string(models.RuleSourceGitOps) && rule.Source != existing.Source {
+			return 0, ErrRuleManaged
+		}
+		if !supersede {
+			return 0, &RuleConflictError{
+				RuleID:     existing.ID,
+				Identifier: existing.Identifier,
+				RuleType:   existing.RuleType,
+				Policy:     existing.Policy,
+			}
+		}
+		// Retire the old rule first so the new one can take its place in
+		// the active-rule unique index
+		if _, err := tx.Exec(`UPDATE rules SET superseded_at = ? WHERE id = ?`, time.Now(), existing.ID); err != nil {
+			return 0, fmt.Errorf("failed to supersede rule: %w", err)
+		}
+		if err := endRollout(tx, existing.ID, "Rule superseded"); err != nil {
+			return 0, err
+		}
+	}
+
+	result, err := tx.Exec(
+		`INSERT INTO rules (identifier, policy, rule_type, cel_expr, custom_message, custom_url, owner_team, comment,
+		                    created_by, proposal_id, source, source_file, feed_id, group_id, expires_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rule.Identifier, rule.Policy, rule.RuleType, rule.CELExpr, rule.CustomMessage, rule.CustomURL, rule.OwnerTeam, rule.Comment,
+		rule.CreatedBy, rule.ProposalID, rule.Source, rule.SourceFile, rule.FeedID, rule.GroupID, rule.ExpiresAt,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create rule: %w", err)
+	}
+	rule.ID, _ = result.LastInsertId()
+
+	diff := diffRules(models.Rule{}, rule)
+	if err := recordRuleVersion(tx, revision, models.RuleVersionCreate, rule, changedBy, reason, diff); err != nil {
+		return 0, err
+	}
+
+	if existing != nil {
+		if _, err := tx.Exec(`UPDATE rules SET superseded_by = ? WHERE id = ?`, rule.ID, existing.ID); err != nil {
+			return 0, fmt.Errorf("failed to supersede rule: %w", err)
+		}
+		diff := map[string]models.FieldChange{"superseded_by": {From: nil, To: rule.ID}}
+		if err := recordRuleVersion(tx, revision, models.RuleVersionSupersede, *existing, changedBy, reason, diff); err != nil {
+			return 0, err
+		}
+	}
+
+	return rule.ID, nil
+}
+
+// UpdateRule applies an update to a rule and records the change. It reports
+// false without writing anything when the update changes nothing.
+func UpdateRule(ruleID int64, update RuleUpdate, changedBy int64, reason *string) (*models.Rule, bool, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	old, err := getRule(tx, ruleID)
+	if err != nil {
+		return nil, false, err
+	}
+	if old.SupersededAt != nil {
+		return nil, false, ErrRuleSuperseded
+	}
+	if readOnlyRule(old) {
+		return nil, false, ErrRuleManaged
+	}
+
+	updated, changed, err := updateRule(tx, newRulesetRevision(&changedBy, reason), old, update, &changedBy, reason)
+	if err != nil || !changed {
+		return updated, changed, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return updated, true, nil
+}
+
+// updateRule applies an update to a rule within a transaction and records
+// the change under the transaction's revision, writing nothing when the
+// update changes nothing
+func updateRule(tx *sql.Tx, revision *rulesetRevision, old models.Rule, update RuleUpdate, changedBy *int64, reason *string) (*models.Rule, bool, error) {
+	updated := old
+	if update.Policy != nil {
+		updated.Policy = *update.Policy
+		if ValidatePolicyRuleType("policy", updated.Policy, updated.RuleType) != nil {
+			return nil, false, ErrPolicyRuleType
+		}
+	}
+	if update.CELExpr != nil {
+		updated.CELExpr = nullIfEmpty(*update.CELExpr)
+	}
+	if updated.Policy != string(models.PolicyCEL) {
+		// Moving away from CEL drops the expression unless one was given
+		if update.CELExpr != nil && updated.CELExpr != nil {
+			return nil, false, ErrCELExprMismatch
+		}
+		updated.CELExpr = nil
+	} else if updated.CELExpr == nil {
+		return nil, false, ErrCELExprMismatch
+	}
+	if update.CustomMessage != nil {
+		updated.CustomMessage = nullIfEmpty(*update.CustomMessage)
+	}
+	if update.CustomURL != nil {
+		updated.CustomURL = nullIfEmpty(*update.CustomURL)
+	}
+	if update.OwnerTeam != nil {
+		updated.OwnerTeam = nullIfEmpty(*update.OwnerTeam)
+	}
+	if update.Comment != nil {
+		updated.Comment = nullIfEmpty(*update.Comment)
+	}
+	if update.GroupID != nil {
+		updated.GroupID = nil
+		if *update.GroupID != 0 {
+			updated.GroupID = update.GroupID
+		}
+	}
+	if update.ExpiresAt != nil {
+		updated.ExpiresAt = nil
+		if !update.ExpiresAt.IsZero() {
+			expiresAt := update.ExpiresAt.UTC()
+			updated.ExpiresAt = &expiresAt
+		}
+	}
+
+	diff := diffRules(old, updated)
+	if len(diff) == 0 {
+		return &old, false, nil
+	}
+	if !sameGroup(old.GroupID, updated.GroupID) {
+		if err := checkScopeFree(tx, updated); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if err := writeRule(tx, &updated); err != nil {
+		return nil, false, err
+	}
+	if err := recordRuleVersion(tx, revision, models.RuleVersionUpdate, updated, changedBy, reason, diff); err != nil {
+		return nil, false, err
+	}
+	return &updated, true, nil
+}
+
+// DeleteRule removes a rule, keeping its history so it can be restored
+func DeleteRule(ruleID, changedBy int64, reason *string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rule, err := getRule(tx, ruleID)
+	if err != nil {
+		return err
+	}
+	if readOnlyRule(rule) {
+		return ErrRuleManaged
+	}
+
+	if err := deleteRule(tx, newRulesetRevision(&changedBy, reason), ruleID, &changedBy, reason); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// deleteRule removes a rule within a transaction and records the deletion
+// under the transaction's revision
+func deleteRule(tx *sql.Tx, revision *rulesetRevision, ruleID int64, changedBy *int64, reason *string) error {
+	rule, err := getRule(tx, ruleID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM rules WHERE id = ?`, ruleID); err != nil {
+		return fmt.Errorf("failed to delete rule: %w", err)
+	}
+	if err := endRollout(tx, ruleID, "Rule deleted"); err != nil {
+		return err
+	}
+	return recordRuleVersion(tx, revision, models.RuleVersionDelete, rule, changedBy, reason, map[string]models.FieldChange{})
+}
+
+// RestoreRuleVersion returns a rule to the state captured in one of its
+// versions. A deleted rule is recreated under its original ID unless another
+// rule has since become active for the same target. Superseded rules cannot
+// be restored; restore or update the rule that replaced them instead. Rules
+// managed by the rules directory or a threat feed, deleted or not, can't be
+// restored through the API.
+func RestoreRuleVersion(ruleID int64, version int, changedBy int64, reason *string) (*models.Rule, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var snapshot models.Rule
+	var source sql.NullString
+	err = tx.QueryRow(
+		`SELECT identifier, policy, rule_type, cel_expr, custom_message, custom_url, owner_team, comment,
+		        proposal_id, group_id, expires_at, source, source_file, feed_id
+		 FROM rule_versions WHERE rule_id = ? AND version = ?`,
+		ruleID, version,
+	).Scan(&snapshot.Identifier, &snapshot.Policy, &snapshot.RuleType, &snapshot.CELExpr,
+		&snapshot.CustomMessage, &snapshot.CustomURL, &snapshot.OwnerTeam, &snapshot.Comment, &snapshot.ProposalID, &snapshot.GroupID, &snapshot.ExpiresAt,
+		&source, &snapshot.SourceFile, &snapshot.FeedID)
+	if err == sql.ErrNoRows {
+		return nil, ErrRuleVersionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch rule version: %w", err)
+	}
+	// Versions of rules deleted before sources were recorded don't know theirs
+	snapshot.Source = string(models.RuleSourceDatabase)
+	if source.Valid {
+		snapshot.Source = source.String
+	}
+
+	if reason == nil {
+		defaultReason := fmt.Sprintf("Restored version %d", version)
+		reason = &defaultReason
+	}
+
+	old, err := getRule(tx, ruleID)
+	deleted := err == ErrRuleNotFound
+	if err != nil && !deleted {
+		return nil, err
+	}
+	if old.SupersededAt != nil {
+		return nil, ErrRuleSuperseded
+	}
+	if readOnlyRule(old) || (deleted && readOnlyRule(snapshot)) {
+		return nil, ErrRuleManaged
+	}
+
+	restored := old
+	if deleted {
+		restored.Source, restored.SourceFile, restored.FeedID = snapshot.Source, snapshot.SourceFile, snapshot.FeedID
+	}
+	restored.ID = ruleID
+	restored.Identifier = snapshot.Identifier
+	restored.Policy = snapshot.Policy
+	restored.RuleType = snapshot.RuleType
+	restored.CELExpr = snapshot.CELExpr
+	restored.CustomMessage = snapshot.CustomMessage
+	restored.CustomURL = snapshot.CustomURL
+	restored.OwnerTeam = snapshot.OwnerTeam
+	restored.Comment = snapshot.Comment
+	restored.ProposalID = snapshot.ProposalID
+	restored.GroupID = snapshot.GroupID
+	restored.ExpiresAt = snapshot.ExpiresAt
+
+	if deleted || !sameGroup(old.GroupID, restored.GroupID) {
+		if err := checkScopeFree(tx, restored); err != nil {
+			return nil, err
+		}
+	}
+
+	if deleted {
+		// Recreate the rule, attributed to whoever created it originally
+		err = tx.QueryRow(
+			`SELECT changed_by FROM rule_versions WHERE rule_id = ? ORDER BY version ASC LIMIT 1`, ruleID,
+		).Scan(&restored.CreatedBy)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch rule creator: %w", err)
+		}
+		_, err = tx.Exec(
+			`INSERT INTO rules (id, identifier, policy, rule_type, cel_expr, custom_message, custom_url, owner_team,
+			                    comment, created_by, proposal_id, source, source_file, feed_id, group_id, expires_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			restored.ID, restored.Identifier, restored.Policy, restored.RuleType, restored.CELExpr,
+			restored.CustomMessage, restored.CustomURL, restored.OwnerTeam, restored.Comment, restored.CreatedBy, restored.ProposalID,
+			restored.Source, restored.SourceFile, restored.FeedID, restored.GroupID, restored.ExpiresAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to recreate rule: %w", err)
+		}
+	} else if err := writeRule(tx, &restored); err != nil {
+		return nil, err
+	}
+
+	if err := recordRuleVersion(tx, newRulesetRevision(&changedBy, reason), models.RuleVersionRestore, restored, &changedBy, reason, diffRules(old, restored)); err != nil {
+		return nil, err
+	}
+
+	result, err := getRule(tx, ruleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return &result, nil
+}
+
+// writeRule saves a rule's mutable fields and stamps updated_at
+func writeRule(tx *sql.Tx, rule *models.Rule) error {
+	now := time.Now()
+	_, err := tx.Exec(
+		`UPDATE rules SET identifier = ?, policy = ?, rule_type = ?, cel_expr = ?, custom_message = ?, custom_url = ?,
+		                  owner_team = ?, comment = ?, proposal_id = ?, group_id = ?, expires_at = ?, updated_at = ?
+		 WHERE id = ?`,
+		rule.Identifier, rule.Policy, rule.RuleType, rule.CELExpr, rule.CustomMessage, rule.CustomURL,
+		rule.OwnerTeam, rule.Comment,
+		rule.ProposalID, rule.GroupID, rule.ExpiresAt, now, rule.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update rule: %w", err)
+	}
+	rule.UpdatedAt = &now
+	return nil
+}
+
+// ListRuleVersions returns a rule's history, newest first. History is kept
+// after the rule itself is deleted.
+func ListRuleVersions(ruleID int64) ([]models.RuleVersion, error) {
+	rows, err := database.DB.Query(
+		`SELECT v.id, v.rule_id, v.version, v.action, v.identifier, v.policy, v.rule_type, v.cel_expr,
+		        v.custom_message, v.custom_url, v.owner_team, v.comment, v.proposal_id, v.group_id, v.expires_at,
+		        v.revision, v.changed_by, u.username,
+		        v.reason, v.diff, v.created_at
+		 FROM rule_versions v
+		 LEFT JOIN users u ON v.changed_by = u.id
+		 WHERE v.rule_id = ?
+		 ORDER BY v.version DESC`,
+		ruleID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rule versions: %w", err)
+	}
+	defer rows.Close()
+
+	versions := []models.RuleVersion{}
+	for rows.Next() {
+		var v models.RuleVersion
+		var diff string
+		err := rows.Scan(&v.ID, &v.RuleID, &v.Version, &v.Action, &v.Identifier, &v.Policy, &v.RuleType, &v.CELExpr,
+			&v.CustomMessage, &v.CustomURL, &v.OwnerTeam, &v.Comment, &v.ProposalID, &v.GroupID, &v.ExpiresAt,
+			&v.Revision, &v.ChangedBy, &v.ChangedByUsername,
+			&v.Reason, &diff, &v.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan rule version: %w", err)
+		}
+		if err := json.Unmarshal([]byte(diff), &v.Diff); err != nil {
+			return nil, fmt.Errorf("failed to decode rule version diff: %w", err)
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// recordRuleVersion appends a snapshot of a rule to its history, as part of
+// the transaction's ruleset revision
+func recordRuleVersion(tx *sql.Tx, revision *rulesetRevision, action models.RuleVersionAction, rule models.Rule, changedBy *int64, reason *string, diff map[string]models.FieldChange) error {
+	encoded, err := json.Marshal(diff)
+	if err != nil {
+		return fmt.Errorf("failed to encode rule diff: %w", err)
+	}
+
+	revisionID, err := revision.open(tx)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO rule_versions (rule_id, version, action, identifier, policy, rule_type, cel_expr,
+		                            custom_message, custom_url, owner_team, comment, proposal_id, group_id,
+		                            expires_at, source, source_file, feed_id, revision, changed_by, reason, diff)
+		 SELECT ?, COALESCE(MAX(version), 0) + 1, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+		 FROM rule_versions WHERE rule_id = ?`,
+		rule.ID, action, rule.Identifier, rule.Policy, rule.RuleType, rule.CELExpr,
+		rule.CustomMessage, rule.CustomURL, rule.OwnerTeam, rule.Comment, rule.ProposalID, rule.GroupID, rule.ExpiresAt,
+		nullIfEmpty(rule.Source), rule.SourceFile, rule.FeedID, revisionID, changedBy, reason, string(encoded),
+		rule.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record rule version: %w", err)
+	}
+	return countRevisionChange(tx, revisionID)
+}
+
+// diffRules lists the versioned fields that differ between two rules. Unset
+// fields are reported as null.
+func diffRules(old, updated models.Rule) map[string]models.FieldChange {
+	diff := map[string]models.FieldChange{}
+	compare := func(field string, from, to interface{}) {
+		if from != to {
+			diff[field] = models.FieldChange{From: from, To: to}
+		}
+	}
+
+	compare("identifier", diffString(&old.Identifier), diffString(&updated.Identifier))
+	compare("policy", diffString(&old.Policy), diffString(&updated.Policy))
+	compare("rule_type", diffString(&old.RuleType), diffString(&updated.RuleType))
+	compare("cel_expr", diffString(old.CELExpr), diffString(updated.CELExpr))
+	compare("custom_message", diffString(old.CustomMessage), diffString(updated.CustomMessage))
+	compare("custom_url", diffString(old.CustomURL), diffString(updated.CustomURL))
+	compare("owner_team", diffString(old.OwnerTeam), diffString(updated.OwnerTeam))
+	compare("comment", diffString(old.Comment), diffString(updated.Comment))
+	compare("proposal_id", diffID(old.ProposalID), diffID(updated.ProposalID))
+	compare("group_id", diffID(old.GroupID), diffID(updated.GroupID))
+	compare("expires_at", diffTime(old.ExpiresAt), diffTime(updated.ExpiresAt))
+	return diff
+}
+
+func diffString(s *string) interface{} {
+	if s == nil || *s == "" {
+		return nil
+	}
+	return *s
+}
+
+func diffID(id *int64) interface{} {
+	if id == nil {
+		return nil
+	}
+	return *id
+}
+
+func diffTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,267 @@
+package services
+
+import (
+	"errors"
+	"krampus/server/database"
+	"krampus/server/models"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestUpdateRule(t *testing.T) {
+	comment, block := "build tool", string(models.PolicyBlocklist)
+
+	tests := []struct {
+		name    string
+		source  models.RuleSource
+		update  RuleUpdate
+		changed bool
+		err     error
+		diff    []string // Fields recorded as changed in the new version
+	}{
+		{name: "comment", update: RuleUpdate{Comment: &comment}, changed: true, diff: []string{"comment"}},
+		{name: "policy and comment", update: RuleUpdate{Policy: &block, Comment: &comment}, changed: true, diff: []string{"comment", "policy"}},
+		{name: "silent block", update: RuleUpdate{Policy: ptrTo(string(models.PolicySilentBlocklist))}, changed: true, diff: []string{"policy"}},
+		{name: "nothing", update: RuleUpdate{}},
+		{name: "same policy", update: RuleUpdate{Policy: ptrTo(string(models.PolicyAllowlist))}},
+		{name: "feed rule", source: models.RuleSourceFeed, update: RuleUpdate{Comment: &comment}, err: ErrRuleManaged},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			mustExec(t, `INSERT INTO users (id, username, role) VALUES (1, 'admin', 'ADMIN')`)
+			rule := testRule(0, string(models.RuleTypeBinary), testHash, models.PolicyAllowlist)
+			rule.Source = string(tt.source)
+			ruleID := createTestRule(t, rule)
+
+			_, changed, err := UpdateRule(ruleID, tt.update, 1, nil)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("UpdateRule error = %v, want %v", err, tt.err)
+			}
+			if changed != tt.changed {
+				t.Errorf("changed = %v, want %v", changed, tt.changed)
+			}
+
+			versions, err := ListRuleVersions(ruleID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantVersions := 1
+			if tt.changed {
+				wantVersions = 2
+			}
+			if len(versions) != wantVersions {
+				t.Fatalf("%d versions, want %d", len(versions), wantVersions)
+			}
+			if !tt.changed {
+				return
+			}
+			latest := versions[0]
+			if latest.Action != string(models.RuleVersionUpdate) || latest.ChangedBy == nil || *latest.ChangedBy != 1 {
+				t.Errorf("latest version %s by %v, want UPDATE by 1", latest.Action, latest.ChangedBy)
+			}
+			var fields []string
+			for field := range latest.Diff {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+			if !reflect.DeepEqual(fields, tt.diff) {
+				t.Errorf("diff fields = %v, want %v", fields, tt.diff)
+			}
+		})
+	}
+}
+
+func TestUpdateRulePolicyRuleType(t *testing.T) {
+	openTestDB(t)
+	mustExec(t, `INSERT INTO users (id, username, role) VALUES (1, 'admin', 'ADMIN')`)
+	compiler := string(models.PolicyAllowlistCompiler)
+	teamRule := createTestRule(t, testRule(0, string(models.RuleTypeTeamID), testTeamID, models.PolicyAllowlist))
+	binaryRule := createTestRule(t, testRule(0, string(models.RuleTypeBinary), testHash, models.PolicyAllowlist))
+
+	if _, _, err := UpdateRule(teamRule, RuleUpdate{Policy: &compiler}, 1, nil); !errors.Is(err, ErrPolicyRuleType) {
+		t.Errorf("compiler policy on a TEAMID rule error = %v, want %v", err, ErrPolicyRuleType)
+	}
+	if updated, _, err := UpdateRule(binaryRule, RuleUpdate{Policy: &compiler}, 1, nil); err != nil || updated.Policy != compiler {
+		t.Errorf("compiler policy on a BINARY rule = %v, %v", updated, err)
+	}
+}
+
+func TestRestoreRuleVersion(t *testing.T) {
+	first, second := "first", "second"
+	binary := string(models.RuleTypeBinary)
+
+	tests := []struct {
+		name    string
+		source  models.RuleSource
+		deleted bool
+		// setup runs after the rule is created, updated and maybe deleted
+		setup    func(t *testing.T, ruleID int64)
+		version  int
+		err      error
+		conflict bool // Whether a *RuleConflictError is returned
+		comment  string
+	}{
+		{name: "earlier version", version: 1, comment: first},
+		{name: "deleted rule", deleted: true, version: 2, comment: second},
+		{
+			name: "deleted rule whose target was taken", deleted: true, version: 2,
+			setup: func(t *testing.T, ruleID int64) {
+				createTestRule(t, testRule(0, binary, testHash, models.PolicyBlocklist))
+			},
+			conflict: true,
+		},
+		{
+			name: "superseded rule", version: 1,
+			setup: func(t *testing.T, ruleID int64) {
+				if _, err := CreateRule(testRule(0, binary, testHash, models.PolicyBlocklist), nil, true, nil); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err: ErrRuleSuperseded,
+		},
+		{name: "unknown version", version: 9, err: ErrRuleVersionNotFound},
+		{name: "feed rule", source: models.RuleSourceFeed, version: 1, err: ErrRuleManaged},
+		{name: "deleted feed rule", source: models.RuleSourceFeed, deleted: true, version: 2, err: ErrRuleManaged},
+		{name: "deleted rules directory rule", source: models.RuleSourceGitOps, deleted: true, version: 2, err: ErrRuleManaged},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			mustExec(t, `INSERT INTO users (id, username, role) VALUES (1, 'admin', 'ADMIN')`)
+			rule := testRule(0, binary, testHash, models.PolicyAllowlist)
+			rule.Comment, rule.Source = &first, string(tt.source)
+			ruleID := createTestRule(t, rule)
+
+			// Managed rules are changed the way their reconcile does,
+			// bypassing the API's read-only check
+			tx, err := database.DB.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			old, err := getRule(tx, ruleID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			revision := newRulesetRevision(nil, nil)
+			if _, _, err := updateRule(tx, revision, old, RuleUpdate{Comment: &second}, nil, nil); err != nil {
+				t.Fatal(err)
+			}
+			if tt.deleted {
+				if err := deleteRule(tx, revision, ruleID, nil, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			if tt.setup != nil {
+				tt.setup(t, ruleID)
+			}
+
+			restored, err := RestoreRuleVersion(ruleID, tt.version, 1, nil)
+			var conflict *RuleConflictError
+			if errors.As(err, &conflict) != tt.conflict {
+				t.Fatalf("RestoreRuleVersion error = %v, want a rule conflict: %v", err, tt.conflict)
+			}
+			if tt.conflict {
+				return
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("RestoreRuleVersion error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if restored.ID != ruleID || deref(restored.Comment) != tt.comment {
+				t.Errorf("restored rule %d with comment %q, want rule %d with %q", restored.ID, deref(restored.Comment), ruleID, tt.comment)
+			}
+			if restored.Source != string(models.RuleSourceDatabase) {
+				t.Errorf("restored rule source = %s, want DATABASE", restored.Source)
+			}
+			if restored.CreatedBy != nil {
+				t.Errorf("restored rule created by %v, want its original creator", *restored.CreatedBy)
+			}
+		})
+	}
+}
+
+func ptrTo[T any](v T) *T {
+	return &v
+}
+
+func TestCreateRuleSupersedes(t *testing.T) {
+	binary, teamID := string(models.RuleTypeBinary), string(models.RuleTypeTeamID)
+
+	tests := []struct {
+		name      string
+		existing  models.RuleSource // Source of the rule already active for testHash
+		rule      models.Rule
+		supersede bool
+		err       error
+		conflict  bool
+		replaced  bool // Whether the existing rule was superseded by the new one
+	}{
+		{name: "conflict", existing: models.RuleSourceDatabase, rule: testRule(0, binary, testHash, models.PolicyBlocklist), conflict: true},
+		{name: "same policy conflicts too", existing: models.RuleSourceDatabase, rule: testRule(0, binary, testHash, models.PolicyAllowlist), conflict: true},
+		{name: "supersede", existing: models.RuleSourceDatabase, rule: testRule(0, binary, testHash, models.PolicyBlocklist), supersede: true, replaced: true},
+		{name: "supersede a feed rule", existing: models.RuleSourceFeed, rule: testRule(0, binary, testHash, models.PolicyBlocklist), supersede: true, replaced: true},
+		{name: "supersede a rules directory rule", existing: models.RuleSourceGitOps, rule: testRule(0, binary, testHash, models.PolicyBlocklist), supersede: true, err: ErrRuleManaged},
+		{name: "other rule type", existing: models.RuleSourceDatabase, rule: testRule(0, teamID, testTeamID, models.PolicyBlocklist)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			existing := testRule(0, binary, testHash, models.PolicyAllowlist)
+			existing.Source = string(tt.existing)
+			existingID := createTestRule(t, existing)
+
+			ruleID, err := CreateRule(tt.rule, nil, tt.supersede, nil)
+			var conflict *RuleConflictError
+			if errors.As(err, &conflict) != tt.conflict {
+				t.Fatalf("CreateRule error = %v, want a rule conflict: %v", err, tt.conflict)
+			}
+			if tt.conflict {
+				if conflict.RuleID != existingID {
+					t.Errorf("conflict names rule %d, want %d", conflict.RuleID, existingID)
+				}
+				return
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("CreateRule error = %v, want %v", err, tt.err)
+			}
+
+			var supersededBy *int64
+			var versions int
+			err = database.DB.QueryRow(
+				`SELECT superseded_by, (SELECT COUNT(*) FROM rule_versions WHERE rule_id = ? AND action = 'SUPERSEDE')
+				 FROM rules WHERE id = ?`,
+				existingID, existingID,
+			).Scan(&supersededBy, &versions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			replaced := supersededBy != nil && *supersededBy == ruleID && ruleID != 0
+			if replaced != tt.replaced || (versions == 1) != tt.replaced {
+				t.Errorf("existing rule superseded by %v with %d SUPERSEDE versions, want replaced by %d: %v",
+					supersededBy, versions, ruleID, tt.replaced)
+			}
+
+			var active int
+			if err := database.DB.QueryRow(`SELECT COUNT(*) FROM rules WHERE ` + ActiveRule).Scan(&active); err != nil {
+				t.Fatal(err)
+			}
+			want := 2
+			if tt.replaced || tt.err != nil {
+				want = 1
+			}
+			if active != want {
+				t.Errorf("%d active rules, want %d", active, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"krampus/server/database"
+	"krampus/server/models"
+	"strconv"
+	"time"
+)
+
+// Setting keys in the settings table
+const (
+	settingEnableTransitiveRules = "enable_transitive_rules"
+)
+
+// SettingsUpdate lists the settings an update may change; nil fields are
+// left alone
+type SettingsUpdate struct {
+	EnableTransitiveRules *bool
+}
+
+// GetSettings returns the current settings, with defaults for those never set
+func GetSettings() (models.Settings, error) {
+	var settings models.Settings
+	rows, err := database.DB.Query(`SELECT key, value, updated_by, updated_at FROM settings`)
+	if err != nil {
+		return settings, fmt.Errorf("failed to query settings: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key, value string
+		var updatedBy sql.NullInt64
+		var updatedAt time.Time
+		if err := rows.Scan(&key, &value, &updatedBy, &updatedAt); err != nil {
+			return settings, fmt.Errorf("failed to scan setting: %w", err)
+		}
+		switch key {
+		case settingEnableTransitiveRules:
+			settings.EnableTransitiveRules, _ = strconv.ParseBool(value)
+		default:
+			continue
+		}
+		if settings.UpdatedAt == nil || updatedAt.After(*settings.UpdatedAt) {
+			settings.UpdatedAt = &updatedAt
+			settings.UpdatedBy = nil
+			if updatedBy.Valid {
+				settings.UpdatedBy = &updatedBy.Int64
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return settings, fmt.Errorf("failed to read settings: %w", err)
+	}
+	return settings, nil
+}
+
+// UpdateSettings applies an update and returns the resulting settings
+func UpdateSettings(update SettingsUpdate, userID int64) (models.Settings, error) {
+	values := map[string]string{}
+	if update.EnableTransitiveRules != nil {
+		values[settingEnableTransitiveRules] = strconv.FormatBool(*update.EnableTransitiveRules)
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return models.Settings{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	for key, value := range values {
+		_, err := tx.Exec(
+			`INSERT INTO settings (key, value, updated_by, updated_at) VALUES (?, ?, ?, ?)
+			 ON CONFLICT(key) DO UPDATE SET value = excluded.value,
+			   updated_by = excluded.updated_by, updated_at = excluded.updated_at`,
+			key, value, userID, now,
+		)
+		if err != nil {
+			return models.Settings{}, fmt.Errorf("failed to save setting %s: %w", key, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.Settings{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return GetSettings()
+}
@@ -0,0 +1,36 @@
+package services
+
+import (
+	"testing"
+)
+
+func TestUpdateSettings(t *testing.T) {
+	openTestDB(t)
+	mustExec(t, `INSERT INTO users (id, username, role) VALUES (1, 'admin', 'ADMIN')`)
+
+	settings, err := GetSettings()
+	if err != nil {
+		t.Fatalf("GetSettings failed: %v", err)
+	}
+	if settings.EnableTransitiveRules || settings.UpdatedAt != nil || settings.UpdatedBy != nil {
+		t.Errorf("defaults = %+v, want transitive rules off and never updated", settings)
+	}
+
+	enable := true
+	if settings, err = UpdateSettings(SettingsUpdate{EnableTransitiveRules: &enable}, 1); err != nil {
+		t.Fatalf("UpdateSettings failed: %v", err)
+	}
+	if !settings.EnableTransitiveRules || settings.UpdatedAt == nil || settings.UpdatedBy == nil || *settings.UpdatedBy != 1 {
+		t.Errorf("settings after update = %+v, want transitive rules on, updated by 1", settings)
+	}
+
+	// An empty update leaves the settings alone
+	if settings, err = UpdateSettings(SettingsUpdate{}, 1); err != nil || !settings.EnableTransitiveRules {
+		t.Errorf("settings after an empty update = %+v, %v, want transitive rules still on", settings, err)
+	}
+
+	disable := false
+	if settings, err = UpdateSettings(SettingsUpdate{EnableTransitiveRules: &disable}, 1); err != nil || settings.EnableTransitiveRules {
+		t.Errorf("settings after disabling = %+v, %v, want transitive rules off", settings, err)
+	}
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"fmt"
+	"krampus/server/models"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	sha256Pattern    = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	cdhashPattern    = regexp.MustCompile(`^[0-9a-f]{40}$`)
+	teamIDPattern    = regexp.MustCompile(`^[A-Z0-9]{10}$`)
+	signingIDPattern = regexp.MustCompile(`^([A-Z0-9]{10}|platform):[^\s:]+$`)
+)
+
+// ValidationError points at the request field that failed validation
+type ValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationErrors collects every failed field of a request
+type ValidationErrors []ValidationError
+
+func (v ValidationErrors) Error() string {
+	messages := make([]string, len(v))
+	for i, e := range v {
+		messages[i] = e.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// NormalizeIdentifier trims an identifier and puts hashes and team IDs in the
+// case Santa reports them in
+func NormalizeIdentifier(ruleType, identifier string) string {
+	identifier = strings.TrimSpace(identifier)
+	switch models.RuleType(ruleType) {
+	case models.RuleTypeBinary, models.RuleTypeCertificate, models.RuleTypeCDHash:
+		return strings.ToLower(identifier)
+	case models.RuleTypeTeamID:
+		return strings.ToUpper(identifier)
+	}
+	return identifier
+}
+
+// ValidateRule checks the identifier, rule type, policy and CEL expression
+// of a rule or proposal. Field names are those of the request body;
+// policyField differs between rules ("policy") and proposals
+// ("proposed_policy").
+func ValidateRule(identifier, ruleType, policy, celExpr, policyField string) ValidationErrors {
+	var errs ValidationErrors
+	if err := ValidateRuleType(ruleType); err != nil {
+		errs = append(errs, *err)
+	} else if err := ValidateIdentifier(ruleType, identifier); err != nil {
+		errs = append(errs, *err)
+	}
+	if err := ValidatePolicy(policyField, policy); err != nil {
+		errs = append(errs, *err)
+	} else if err := ValidateCELExpr(policy, celExpr); err != nil {
+		errs = append(errs, *err)
+	} else if err := ValidatePolicyRuleType(policyField, policy, ruleType); err != nil {
+		errs = append(errs, *err)
+	}
+	return errs
+}
+
+// ValidateRuleType checks that a rule type is one Santa understands
+func ValidateRuleType(ruleType string) *ValidationError {
+	switch models.RuleType(ruleType) {
+	case models.RuleTypeBinary, models.RuleTypeCertificate, models.RuleTypeSigningID,
+		models.RuleTypeTeamID, models.RuleTypeCDHash:
+		return nil
+	}
+	return &ValidationError{
+		Field:   "rule_type",
+		Message: "must be one of BINARY, CERTIFICATE, SIGNINGID, TEAMID or CDHASH",
+	}
+}
+
+// ValidatePolicy checks that a policy is one Santa syncs
+func ValidatePolicy(field, policy string) *ValidationError {
+	switch models.Policy(policy) {
+	case models.PolicyAllowlist, models.PolicyAllowlistCompiler, models.PolicyBlocklist,
+		models.PolicySilentBlocklist, models.PolicyRemove, models.PolicyCEL:
+		return nil
+	}
+	return &ValidationError{
+		Field:   field,
+		Message: "must be one of ALLOWLIST, ALLOWLIST_COMPILER, BLOCKLIST, SILENT_BLOCKLIST, REMOVE or CEL",
+	}
+}
+
+// ValidatePolicyRuleType checks that a policy applies to the rule type.
+// Santa only marks binaries as compilers, by hash or signing ID.
+func ValidatePolicyRuleType(field, policy, ruleType string) *ValidationError {
+	if policy != string(models.PolicyAllowlistCompiler) {
+		return nil
+	}
+	switch models.RuleType(ruleType) {
+	case models.RuleTypeBinary, models.RuleTypeSigningID:
+		return nil
+	}
+	return &ValidationError{Field: field, Message: "ALLOWLIST_COMPILER only applies to BINARY and SIGNINGID rules"}
+}
+
+// isBlockPolicy reports whether rules with a policy block the executions
+// they match
+func isBlockPolicy(policy string) bool {
+	return policy == string(models.PolicyBlocklist) || policy == string(models.PolicySilentBlocklist)
+}
+
+// ValidateIdentifier checks that an identifier has the format its rule type
+// requires. Santa silently ignores rules with malformed identifiers.
+func ValidateIdentifier(ruleType, identifier string) *ValidationError {
+	var valid bool
+	var expected string
+	switch models.RuleType(ruleType) {
+	case models.RuleTypeBinary, models.RuleTypeCertificate:
+		valid, expected = sha256Pattern.MatchString(identifier), "a SHA-256 hash (64 hex characters)"
+	case models.RuleTypeCDHash:
+		valid, expected = cdhashPattern.MatchString(identifier), "a CDHash (40 hex characters)"
+	case models.RuleTypeTeamID:
+		valid, expected = teamIDPattern.MatchString(identifier), "a 10-character Team ID"
+	case models.RuleTypeSigningID:
+		valid, expected = signingIDPattern.MatchString(identifier), `"TEAMID:bundle.id" or "platform:bundle.id"`
+	default:
+		return nil
+	}
+	if valid {
+		return nil
+	}
+	return &ValidationError{
+		Field:   "identifier",
+		Message: fmt.Sprintf("%s identifier must be %s", ruleType, expected),
+	}
+}
+
+// ParseExpiry reads a rule's RFC 3339 expires_at. An empty value means the
+// rule never expires; times in the past are rejected.
+func ParseExpiry(value string) (*time.Time, *ValidationError) {
+	if value == "" {
+		return nil, nil
+	}
+	expiresAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, &ValidationError{Field: "expires_at", Message: "must be an RFC 3339 timestamp"}
+	}
+	if !expiresAt.After(time.Now()) {
+		return nil, &ValidationError{Field: "expires_at", Message: "must be in the future"}
+	}
+	expiresAt = expiresAt.UTC()
+	return &expiresAt, nil
+}
+
+// ValidateGroup checks that the group a rule is scoped to exists
+func ValidateGroup(groupID int64) (*ValidationError, error) {
+	exists, err := GroupExists(groupID)
+	if err != nil || exists {
+		return nil, err
+	}
+	return &ValidationError{Field: "group_id", Message: "no such machine group"}, nil
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"krampus/server/models"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateIdentifier(t *testing.T) {
+	binary, cert, cdhash := string(models.RuleTypeBinary), string(models.RuleTypeCertificate), string(models.RuleTypeCDHash)
+	teamID, signingID := string(models.RuleTypeTeamID), string(models.RuleTypeSigningID)
+
+	tests := []struct {
+		ruleType   string
+		identifier string
+		valid      bool
+	}{
+		{binary, testHash, true},
+		{binary, strings.ToUpper(testHash), false},
+		{binary, testHash[:63], false},
+		{binary, testHash[:63] + "g", false},
+		{cert, testHash, true},
+		{cert, "", false},
+		{cdhash, strings.Repeat("0f", 20), true},
+		{cdhash, testHash, false},
+		{teamID, testTeamID, true},
+		{teamID, "abcde12345", false},
+		{teamID, "ABCDE1234", false},
+		{signingID, testSigning, true},
+		{signingID, "platform:com.apple.ls", true},
+		{signingID, "com.example.tool", false},
+		{signingID, "abcde12345:com.example.tool", false},
+		{signingID, "ABCDE12345:com.example tool", false},
+		{signingID, "ABCDE12345:", false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateIdentifier(tt.ruleType, tt.identifier)
+		if (err == nil) != tt.valid {
+			t.Errorf("ValidateIdentifier(%s, %q) = %v, want valid: %v", tt.ruleType, tt.identifier, err, tt.valid)
+		}
+		if err != nil && err.Field != "identifier" {
+			t.Errorf("ValidateIdentifier(%s, %q) points at %s, want identifier", tt.ruleType, tt.identifier, err.Field)
+		}
+	}
+}
+
+func TestNormalizeIdentifier(t *testing.T) {
+	tests := []struct {
+		ruleType   models.RuleType
+		identifier string
+		want       string
+	}{
+		{models.RuleTypeBinary, " " + strings.ToUpper(testHash) + "\n", testHash},
+		{models.RuleTypeCertificate, strings.ToUpper(testHash), testHash},
+		{models.RuleTypeCDHash, "0F0F", "0f0f"},
+		{models.RuleTypeTeamID, "abcde12345 ", testTeamID},
+		{models.RuleTypeSigningID, " ABCDE12345:com.Example.Tool", "ABCDE12345:com.Example.Tool"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeIdentifier(string(tt.ruleType), tt.identifier); got != tt.want {
+			t.Errorf("NormalizeIdentifier(%s, %q) = %q, want %q", tt.ruleType, tt.identifier, got, tt.want)
+		}
+	}
+}
+
+func TestValidateRule(t *testing.T) {
+	tests := []struct {
+		name        string
+		identifier  string
+		ruleType    string
+		policy      string
+		policyField string
+		fields      []string // Fields reported, in order
+	}{
+		{
+			name: "valid", identifier: testHash, ruleType: "BINARY", policy: "ALLOWLIST", policyField: "policy",
+		},
+		{
+			name: "unknown rule type skips the identifier", identifier: "x", ruleType: "HASH", policy: "ALLOWLIST",
+			policyField: "policy", fields: []string{"rule_type"},
+		},
+		{
+			name: "bad identifier and policy", identifier: "x", ruleType: "TEAMID", policy: "ALLOW",
+			policyField: "proposed_policy", fields: []string{"identifier", "proposed_policy"},
+		},
+		{
+			name: "silent block", identifier: testTeamID, ruleType: "TEAMID", policy: "SILENT_BLOCKLIST", policyField: "policy",
+		},
+		{
+			name: "removal", identifier: testTeamID, ruleType: "TEAMID", policy: "REMOVE", policyField: "policy",
+		},
+		{
+			name: "compiler signing ID", identifier: testSigning, ruleType: "SIGNINGID", policy: "ALLOWLIST_COMPILER", policyField: "policy",
+		},
+		{
+			name: "compiler team ID", identifier: testTeamID, ruleType: "TEAMID", policy: "ALLOWLIST_COMPILER",
+			policyField: "policy", fields: []string{"policy"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fields []string
+			for _, err := range ValidateRule(tt.identifier, tt.ruleType, tt.policy, "", tt.policyField) {
+				fields = append(fields, err.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("fields = %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
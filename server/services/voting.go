@@ -2,6 +2,7 @@ package services
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"krampus/server/config"
 	"krampus/server/database"
@@ -52,7 +53,7 @@ func SubmitVote(userID, proposalID int64, voteType string) error {
 	}
 
 	// Check if threshold is met
-	if err := checkAndFinalizeProposal(proposalID); err != nil {
+	if err := checkAndFinalizeProposal(proposalID, userID); err != nil {
 		log.Printf("Failed to finalize proposal %d: %v", proposalID, err)
 		// Don't return error, as vote was recorded successfully
 	}
@@ -90,18 +91,19 @@ func recalculateVotes(proposalID int64) error {
 	return err
 }
 
-// checkAndFinalizeProposal checks if a proposal has reached the vote threshold
-func checkAndFinalizeProposal(proposalID int64) error {
+// checkAndFinalizeProposal checks if a proposal has reached the vote
+// threshold. The voter whose vote reached it is recorded as approving it.
+func checkAndFinalizeProposal(proposalID, voterID int64) error {
 	var proposal models.Proposal
 	err := database.DB.QueryRow(
 		`SELECT id, identifier, rule_type, proposed_policy, custom_message,
-		 created_by, status, allowlist_votes, blocklist_votes
+		 created_by, status, allowlist_votes, blocklist_votes, auto_decision
 		 FROM proposals WHERE id = ?`,
 		proposalID,
 	).Scan(
 		&proposal.ID, &proposal.Identifier, &proposal.RuleType, &proposal.ProposedPolicy,
 		&proposal.CustomMessage, &proposal.CreatedBy, &proposal.Status,
-		&proposal.AllowlistVotes, &proposal.BlocklistVotes,
+		&proposal.AllowlistVotes, &proposal.BlocklistVotes, &proposal.AutoDecision,
 	)
 	if err != nil {
 		return err
@@ -114,34 +116,80 @@ func checkAndFinalizeProposal(proposalID int64) error {
 
 	threshold := config.AppConfig.VoteThreshold
 
-	// Check if allowlist threshold is met
-	if proposal.AllowlistVotes >= threshold {
-		return FinalizeProposal(proposalID, string(models.PolicyAllowlist))
+	// Votes never replace an existing rule on their own; a conflicting
+	// proposal stays pending until an admin approves it with supersede.
+	// Removing a rule from clients is left to an admin as well.
+	if proposal.ProposedPolicy == string(models.PolicyRemove) {
+		return nil
 	}
 
-	// Check if blocklist threshold is met
-	if proposal.BlocklistVotes >= threshold {
-		return FinalizeProposal(proposalID, string(models.PolicyBlocklist))
+	// A proposal a policy escalated waits for an admin
+	if deref(proposal.AutoDecision) == string(models.AutoDecisionEscalate) {
+		return nil
 	}
 
-	return nil
+	// Check if allowlist threshold is met; a proposal for a compiler or CEL
+	// rule gets the allowing policy it asked for. Otherwise check if the
+	// blocklist threshold is met; a proposal for a silent block keeps it.
+	var policy string
+	switch {
+	case proposal.AllowlistVotes >= threshold:
+		policy = string(models.PolicyAllowlist)
+		if !isBlockPolicy(proposal.ProposedPolicy) {
+			policy = proposal.ProposedPolicy
+		}
+	case proposal.BlocklistVotes >= threshold:
+		policy = string(models.PolicyBlocklist)
+		if isBlockPolicy(proposal.ProposedPolicy) {
+			policy = proposal.ProposedPolicy
+		}
+	default:
+		return nil
+	}
+
+	// A contradicting rule keeps the proposal pending; record it so voters
+	// can see the proposal now waits for an admin
+	err = FinalizeProposal(proposalID, policy, false, &voterID)
+	var conflict *RuleConflictError
+	if errors.As(err, &conflict) {
+		_, err = database.DB.Exec(
+			`UPDATE proposals SET conflict_rule_id = ? WHERE id = ?`, conflict.RuleID, proposalID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to record rule conflict: %w", err)
+		}
+		log.Printf("Proposal %d reached the vote threshold but conflicts with rule %d; waiting for an admin", proposalID, conflict.RuleID)
+		return nil
+	}
+	return err
 }
 
-// FinalizeProposal finalizes a proposal and creates a rule
-func FinalizeProposal(proposalID int64, policy string) error {
+// FinalizeProposal finalizes a proposal and creates a rule. If the active rule
+// for the same identifier and rule type applies the opposite policy, it is
+// only superseded when supersede is set; otherwise a *RuleConflictError is
+// returned and the proposal stays pending. The CEL policy is only accepted
+// for proposals that carry an expression. The rule is credited to the
+// proposal's creator and its version to approvedBy.
+func FinalizeProposal(proposalID int64, policy string, supersede bool, approvedBy *int64) error {
+	return finalizeProposal(proposalID, policy, supersede, approvedBy, fmt.Sprintf("Approved proposal #%d", proposalID))
+}
+
+// finalizeProposal implements FinalizeProposal, recording reason on the
+// ruleset revision
+func finalizeProposal(proposalID int64, policy string, supersede bool, approvedBy *int64, reason string) error {
 	// Validate policy
-	if policy != string(models.PolicyAllowlist) && policy != string(models.PolicyBlocklist) {
+	if ValidatePolicy("policy", policy) != nil {
 		return fmt.Errorf("invalid policy: %s", policy)
 	}
 
 	// Fetch proposal
 	var proposal models.Proposal
 	err := database.DB.QueryRow(
-		`SELECT id, identifier, rule_type, custom_message, created_by, status
+		`SELECT id, identifier, rule_type, cel_expr, custom_message, created_by, status
 		 FROM proposals WHERE id = ?`,
 		proposalID,
 	).Scan(
-		&proposal.ID, &proposal.Identifier, &proposal.RuleType,
+		&proposal.ID, &proposal.Identifier, &proposal.RuleType, &proposal.CELExpr,
 		&proposal.CustomMessage, &proposal.CreatedBy, &proposal.Status,
 	)
 	if err != nil {
@@ -153,6 +201,19 @@ func FinalizeProposal(proposalID int64, policy string) error {
 		return fmt.Errorf("proposal already finalized with status: %s", proposal.Status)
 	}
 
+	if err := ValidatePolicyRuleType("policy", policy, proposal.RuleType); err != nil {
+		return fmt.Errorf("invalid policy: %s", err.Message)
+	}
+
+	// Only CEL rules carry the proposal's expression
+	var celExpr *string
+	if policy == string(models.PolicyCEL) {
+		if proposal.CELExpr == nil {
+			return fmt.Errorf("invalid policy: proposal has no CEL expression")
+		}
+		celExpr = proposal.CELExpr
+	}
+
 	// Begin transaction
 	tx, err := database.DB.Begin()
 	if err != nil {
@@ -160,26 +221,54 @@ func FinalizeProposal(proposalID int64, policy string) error {
 	}
 	defer tx.Rollback()
 
-	// Update proposal status
+	// An active rule with the same policy already covers the proposal; one
+	// with the opposite policy is only replaced when superseding
+	existing, err := activeRule(tx, proposal.Identifier, proposal.RuleType, nil)
+	if err != nil {
+		return err
+	}
+	alreadyCovered := existing != nil && existing.Policy == policy && deref(existing.CELExpr) == deref(celExpr)
+	if existing != nil && !alreadyCovered {
+		if !supersede {
+			return &RuleConflictError{
+				RuleID:     existing.ID,
+				Identifier: existing.Identifier,
+				RuleType:   existing.RuleType,
+				Policy:     existing.Policy,
+			}
+		}
+		log.Printf("Proposal %d supersedes rule %d", proposalID, existing.ID)
+	}
+
+	// Update proposal status, unless a concurrent vote or approval finalized
+	// it since it was fetched
 	now := time.Now()
-	_, err = tx.Exec(
-		`UPDATE proposals SET status = ?, finalized_at = ? WHERE id = ?`,
-		models.ProposalStatusApproved, now, proposalID,
+	result, err := tx.Exec(
+		`UPDATE proposals SET status = ?, finalized_at = ?, conflict_rule_id = NULL WHERE id = ? AND status = ?`,
+		models.ProposalStatusApproved, now, proposalID, models.ProposalStatusPending,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to update proposal: %w", err)
 	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("proposal already finalized")
+	}
 
-	// Create rule from proposal
+	// Create rule from proposal unless an identical one already exists
 	// Use custom_message as the comment to identify the application
-	_, err = tx.Exec(
-		`INSERT INTO rules (identifier, policy, rule_type, comment, created_by, proposal_id)
-		 VALUES (?, ?, ?, ?, ?, ?)`,
-		proposal.Identifier, policy, proposal.RuleType,
-		proposal.CustomMessage, proposal.CreatedBy, proposalID,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to create rule: %w", err)
+	if !alreadyCovered {
+		rule := models.Rule{
+			Identifier: proposal.Identifier,
+			Policy:     policy,
+			RuleType:   proposal.RuleType,
+			CELExpr:    celExpr,
+			Comment:    proposal.CustomMessage,
+			CreatedBy:  proposal.CreatedBy,
+			ProposalID: &proposalID,
+		}
+		if _, err := insertRule(tx, newRulesetRevision(approvedBy, &reason), rule, approvedBy, &reason, supersede); err != nil {
+			return err
+		}
 	}
 
 	// Commit transaction
@@ -191,9 +280,38 @@ func FinalizeProposal(proposalID int64, policy string) error {
 	return nil
 }
 
-// AdminApproveProposal allows admin to bypass voting and directly approve a proposal
-func AdminApproveProposal(proposalID int64, policy string) error {
-	return FinalizeProposal(proposalID, policy)
+// AdminApproveProposal allows admin to bypass voting and directly approve a
+// proposal, optionally superseding a contradicting rule
+func AdminApproveProposal(proposalID int64, policy string, supersede bool, adminID int64) error {
+	return FinalizeProposal(proposalID, policy, supersede, &adminID)
+}
+
+// DeleteProposal deletes a proposal with its votes, requesters, discussion
+// and evidence. Foreign keys are not enforced, so nothing cascades on its own.
+func DeleteProposal(proposalID int64) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		`DELETE FROM proposal_comment_edits WHERE comment_id IN (SELECT id FROM proposal_comments WHERE proposal_id = ?)`,
+		`DELETE FROM proposal_comments WHERE proposal_id = ?`,
+		`DELETE FROM proposal_evidence WHERE proposal_id = ?`,
+		`DELETE FROM proposal_requesters WHERE proposal_id = ?`,
+		`DELETE FROM votes WHERE proposal_id = ?`,
+		`DELETE FROM proposals WHERE id = ?`,
+	} {
+		if _, err := tx.Exec(query, proposalID); err != nil {
+			return fmt.Errorf("failed to delete proposal: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
 }
 
 // GetUserVote retrieves a user's vote for a specific proposal
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"database/sql"
+	"krampus/server/database"
+	"krampus/server/models"
+	"testing"
+)
+
+func TestSubmitVote(t *testing.T) {
+	allow, block := string(models.VoteTypeAllowlist), string(models.VoteTypeBlocklist)
+
+	tests := []struct {
+		name     string
+		policy   models.Policy
+		setup    func(t *testing.T)
+		votes    []string // One per user, in order; the threshold is 3
+		status   models.ProposalStatus
+		rule     string // Policy of the rule created from the proposal
+		conflict bool   // Whether a contradicting rule was recorded
+	}{
+		{
+			name:   "below the threshold",
+			policy: models.PolicyAllowlist,
+			votes:  []string{allow, allow, block},
+			status: models.ProposalStatusPending,
+		},
+		{
+			name:   "allowlist threshold",
+			policy: models.PolicyAllowlist,
+			votes:  []string{allow, allow, allow},
+			status: models.ProposalStatusApproved,
+			rule:   string(models.PolicyAllowlist),
+		},
+		{
+			name:   "blocklist threshold on an allowlist proposal",
+			policy: models.PolicyAllowlist,
+			votes:  []string{block, block, block},
+			status: models.ProposalStatusApproved,
+			rule:   string(models.PolicyBlocklist),
+		},
+		{
+			name:   "compiler allowlist keeps its policy",
+			policy: models.PolicyAllowlistCompiler,
+			votes:  []string{allow, allow, allow},
+			status: models.ProposalStatusApproved,
+			rule:   string(models.PolicyAllowlistCompiler),
+		},
+		{
+			name:   "silent block keeps its policy",
+			policy: models.PolicySilentBlocklist,
+			votes:  []string{block, block, block},
+			status: models.ProposalStatusApproved,
+			rule:   string(models.PolicySilentBlocklist),
+		},
+		{
+			name:   "removal waits for an admin",
+			policy: models.PolicyRemove,
+			votes:  []string{allow, allow, allow},
+			status: models.ProposalStatusPending,
+		},
+		{
+			name:   "contradicting rule waits for an admin",
+			policy: models.PolicyAllowlist,
+			setup: func(t *testing.T) {
+				createTestRule(t, testRule(0, string(models.RuleTypeBinary), testHash, models.PolicyBlocklist))
+			},
+			votes:    []string{allow, allow, allow},
+			status:   models.ProposalStatusPending,
+			rule:     string(models.PolicyBlocklist),
+			conflict: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			mustExec(t, `INSERT INTO users (id, username, role) VALUES (1, 'a', 'USER'), (2, 'b', 'USER'), (3, 'c', 'USER')`)
+			if tt.setup != nil {
+				tt.setup(t)
+			}
+			proposalID := openTestProposal(t, tt.policy)
+
+			for i, vote := range tt.votes {
+				if err := SubmitVote(int64(i+1), proposalID, vote); err != nil {
+					t.Fatalf("SubmitVote: %v", err)
+				}
+			}
+
+			var status string
+			var conflictRuleID sql.NullInt64
+			err := database.DB.QueryRow(
+				`SELECT status, conflict_rule_id FROM proposals WHERE id = ?`, proposalID,
+			).Scan(&status, &conflictRuleID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status != string(tt.status) {
+				t.Errorf("status = %s, want %s", status, tt.status)
+			}
+			if conflictRuleID.Valid != tt.conflict {
+				t.Errorf("conflict rule = %v, want one: %v", conflictRuleID, tt.conflict)
+			}
+
+			var policy string
+			err = database.DB.QueryRow(`SELECT policy FROM rules WHERE ` + ActiveRule).Scan(&policy)
+			if err != nil && err != sql.ErrNoRows {
+				t.Fatal(err)
+			}
+			if policy != tt.rule {
+				t.Errorf("active rule policy = %q, want %q", policy, tt.rule)
+			}
+		})
+	}
+}
+
+func TestFinalizeProposalOnce(t *testing.T) {
+	openTestDB(t)
+	mustExec(t, `INSERT INTO users (id, username, role) VALUES (1, 'admin', 'ADMIN')`)
+	proposalID := openTestProposal(t, models.PolicyAllowlist)
+
+	if err := AdminApproveProposal(proposalID, string(models.PolicyAllowlist), false, 1); err != nil {
+		t.Fatalf("AdminApproveProposal: %v", err)
+	}
+	if err := AdminApproveProposal(proposalID, string(models.PolicyBlocklist), true, 1); err == nil {
+		t.Error("approving a finalized proposal again succeeded")
+	}
+
+	var policy string
+	var versions int
+	err := database.DB.QueryRow(
+		`SELECT (SELECT policy FROM rules WHERE `+ActiveRule+`), (SELECT COUNT(*) FROM rule_versions)`,
+	).Scan(&policy, &versions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy != string(models.PolicyAllowlist) || versions != 1 {
+		t.Errorf("active %s rule with %d versions, want the ALLOWLIST rule with 1", policy, versions)
+	}
+}
+
+// openTestProposal opens a user proposal for testHash
+func openTestProposal(t *testing.T, policy models.Policy) int64 {
+	t.Helper()
+	userID := int64(1)
+	proposalID, _, err := OpenProposal(models.Proposal{
+		Identifier:     testHash,
+		RuleType:       string(models.RuleTypeBinary),
+		ProposedPolicy: string(policy),
+		CreatedBy:      &userID,
+		Source:         string(models.ProposalSourceUser),
+	})
+	if err != nil {
+		t.Fatalf("failed to open proposal: %v", err)
+	}
+	return proposalID
+}